@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/alerting"
+	"github.com/mjrtuhin/argus/pkg/api"
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+const usage = `Usage:
+  argus                                      run the detection system
+                                             (applies migrations unless ARGUS_AUTO_MIGRATE=false)
+  argus validate-templates [config]          check alerting templates
+  argus migrate [up]                         apply pending schema migrations
+  argus migrate down [n]                     revert the last n migrations (default 1)
+  argus migrate status                       list migrations and when they were applied
+  argus migrate check                        verify the schema matches the storage queries
+                                             (migrate only applies to ARGUS_STORAGE=postgres)
+  argus apikey create [flags] <name>         create an API key
+      -role responder                        viewer, responder or admin (default viewer)
+      -teams payments,checkout               teams a responder may act for ("*" for all)
+      -expires 720h                          lifetime (default: never expires)
+  argus apikey list                          list API keys
+  argus apikey revoke <id>                   revoke an API key
+`
+
+func runCommand(name string, args []string) {
+	switch name {
+	case "validate-templates":
+		validateTemplates(args)
+	case "migrate":
+		migrateCommand(args)
+	case "apikey":
+		apiKeyCommand(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+}
+
+func validateTemplates(args []string) {
+	path := os.Getenv("ARGUS_ALERTING_CONFIG")
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	var cfg *alerting.Config
+	var err error
+	if path == "" {
+		cfg = alerting.DefaultConfig()
+		err = cfg.Validate()
+	} else {
+		cfg, err = alerting.LoadConfig(path)
+	}
+	if err != nil {
+		log.Fatalf("❌ Template validation failed: %v", err)
+	}
+
+	for _, r := range cfg.Receivers {
+		log.Printf("✅ %s (%s)", r.Name, r.Type)
+	}
+	for _, p := range cfg.EscalationPolicies {
+		log.Printf("✅ escalation policy %s (%d steps)", p.Name, len(p.Steps))
+	}
+	log.Printf("✅ All %d receiver templates are valid", len(cfg.Receivers))
+}
+
+func loadAlertingConfig() (*alerting.Config, error) {
+	if path := os.Getenv("ARGUS_ALERTING_CONFIG"); path != "" {
+		return alerting.LoadConfig(path)
+	}
+	return alerting.DefaultConfig(), nil
+}
+
+func migrateCommand(args []string) {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		log.Fatalf("❌ Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	switch action {
+	case "up":
+		migrate(ctx, db)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("❌ Invalid migration count %q", args[1])
+			}
+		}
+		reverted, err := db.MigrateDown(ctx, steps)
+		for _, m := range reverted {
+			log.Printf("⏪ Reverted migration %03d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("❌ Migration failed: %v", err)
+		}
+		log.Printf("✅ Reverted %d migrations", len(reverted))
+	case "status":
+		states, err := db.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatalf("❌ Failed to read migration status: %v", err)
+		}
+		fmt.Printf("%-8s %-30s %s\n", "VERSION", "NAME", "APPLIED")
+		for _, m := range states {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%03d      %-30s %s\n", m.Version, m.Name, applied)
+		}
+	case "check":
+		if err := db.CheckSchema(ctx); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		log.Println("✅ Schema matches the storage queries")
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n\n%s", action, usage)
+		os.Exit(2)
+	}
+}
+
+// migrate applies pending migrations and checks the result, exiting on
+// failure. It runs on startup and for `argus migrate`.
+func migrate(ctx context.Context, db *storage.DB) {
+	ran, err := db.Migrate(ctx)
+	for _, m := range ran {
+		log.Printf("⏩ Applied migration %03d_%s", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatalf("❌ Migration failed: %v", err)
+	}
+	if err := db.CheckSchema(ctx); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	log.Printf("✅ Schema up to date (%d migrations applied)", len(ran))
+}
+
+func apiKeyCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	db, err := openStore()
+	if err != nil {
+		log.Fatalf("❌ Failed to open storage: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		createAPIKey(ctx, db, args[1:])
+	case "list":
+		listAPIKeys(ctx, db)
+	case "revoke":
+		if len(args) != 2 {
+			log.Fatal("❌ Usage: argus apikey revoke <id>")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("❌ Invalid API key ID %q", args[1])
+		}
+		err = db.RevokeAPIKey(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Fatalf("❌ API key %d not found or already revoked", id)
+		}
+		if err != nil {
+			log.Fatalf("❌ Failed to revoke API key: %v", err)
+		}
+		log.Printf("✅ Revoked API key %d", id)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown apikey command %q\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+}
+
+func createAPIKey(ctx context.Context, db storage.APIKeyStore, args []string) {
+	fs := flag.NewFlagSet("apikey create", flag.ExitOnError)
+	role := fs.String("role", api.RoleViewer, "viewer, responder or admin")
+	teams := fs.String("teams", "", `comma-separated teams a responder may act for ("*" for all)`)
+	expires := fs.Duration("expires", 0, "key lifetime, e.g. 720h (default: never expires)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("❌ Usage: argus apikey create [-role responder] [-teams payments] [-expires 720h] <name>")
+	}
+
+	k := storage.APIKey{Name: fs.Arg(0), Scopes: []string{*role}}
+	for _, team := range strings.Split(*teams, ",") {
+		if team = strings.TrimSpace(team); team != "" {
+			k.Scopes = append(k.Scopes, "team:"+team)
+		}
+	}
+	for _, grant := range k.Scopes {
+		if !api.ValidGrant(grant) {
+			log.Fatalf("❌ Unknown role or team %q", grant)
+		}
+	}
+	if *expires > 0 {
+		at := time.Now().Add(*expires)
+		k.ExpiresAt = &at
+	}
+
+	key, prefix, hash, err := api.GenerateAPIKey()
+	if err != nil {
+		log.Fatalf("❌ Failed to generate API key: %v", err)
+	}
+	k.Prefix, k.KeyHash = prefix, hash
+	if err := db.CreateAPIKey(ctx, &k); err != nil {
+		log.Fatalf("❌ Failed to store API key: %v", err)
+	}
+
+	log.Printf("✅ Created API key %d (%s): %s", k.ID, k.Name, strings.Join(k.Scopes, ", "))
+	log.Println("   Store it now, it won't be shown again:")
+	fmt.Println(key)
+}
+
+func listAPIKeys(ctx context.Context, db storage.APIKeyStore) {
+	keys, err := db.ListAPIKeys(ctx)
+	if err != nil {
+		log.Fatalf("❌ Failed to list API keys: %v", err)
+	}
+
+	fmt.Printf("%-4s %-24s %-10s %-30s %-20s %s\n", "ID", "NAME", "PREFIX", "GRANTS", "EXPIRES", "STATUS")
+	for _, k := range keys {
+		expires, status := "never", "active"
+		if k.ExpiresAt != nil {
+			expires = k.ExpiresAt.Format("2006-01-02 15:04")
+			if time.Now().After(*k.ExpiresAt) {
+				status = "expired"
+			}
+		}
+		if k.RevokedAt != nil {
+			status = "revoked"
+		}
+		fmt.Printf("%-4d %-24s %-10s %-30s %-20s %s\n", k.ID, k.Name, k.Prefix, strings.Join(k.Scopes, ","), expires, status)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/api"
+	"github.com/mjrtuhin/argus/pkg/cluster"
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/storage/embedded"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
+	"github.com/mjrtuhin/argus/pkg/worker"
+)
+
+// connectDB opens the PostgreSQL database described by the environment:
+//
+//	ARGUS_DB_DSN                  full connection string; overrides the settings below
+//	ARGUS_DB_HOST                 server host (default localhost)
+//	ARGUS_DB_PORT                 server port (default 5432)
+//	ARGUS_DB_USER                 user (default argus)
+//	ARGUS_DB_PASSWORD             password
+//	ARGUS_DB_NAME                 database (default argus)
+//	ARGUS_DB_SSLMODE              disable (default), require, verify-ca or verify-full
+//	ARGUS_DB_SSLROOTCERT          CA bundle for verify-ca and verify-full
+//	ARGUS_DB_SSLCERT              client certificate
+//	ARGUS_DB_SSLKEY               client certificate key
+//	ARGUS_DB_MAX_OPEN_CONNS       pool size (default 20)
+//	ARGUS_DB_MAX_IDLE_CONNS       idle connections kept (default 10)
+//	ARGUS_DB_CONN_MAX_LIFETIME    recycle connections after this long (default 30m)
+//	ARGUS_DB_CONN_MAX_IDLE_TIME   close idle connections after this long (default 5m)
+func connectDB() (*storage.DB, error) {
+	return storage.OpenDB(storage.DBConfig{
+		DSN:             os.Getenv("ARGUS_DB_DSN"),
+		Host:            envString("ARGUS_DB_HOST", "localhost"),
+		Port:            envString("ARGUS_DB_PORT", "5432"),
+		User:            envString("ARGUS_DB_USER", "argus"),
+		Password:        envString("ARGUS_DB_PASSWORD", "argus_dev_2025"),
+		Name:            envString("ARGUS_DB_NAME", "argus"),
+		SSLMode:         os.Getenv("ARGUS_DB_SSLMODE"),
+		SSLRootCert:     os.Getenv("ARGUS_DB_SSLROOTCERT"),
+		SSLCert:         os.Getenv("ARGUS_DB_SSLCERT"),
+		SSLKey:          os.Getenv("ARGUS_DB_SSLKEY"),
+		MaxOpenConns:    envInt("ARGUS_DB_MAX_OPEN_CONNS"),
+		MaxIdleConns:    envInt("ARGUS_DB_MAX_IDLE_CONNS"),
+		ConnMaxLifetime: envDuration("ARGUS_DB_CONN_MAX_LIFETIME"),
+		ConnMaxIdleTime: envDuration("ARGUS_DB_CONN_MAX_IDLE_TIME"),
+	})
+}
+
+// openStore opens the storage backend chosen by the environment:
+//
+//	ARGUS_STORAGE    postgres (default) or embedded
+//	ARGUS_DATA_DIR   where the embedded backend keeps its journal (default data)
+//
+// The embedded backend has no rollups; it keeps raw points for
+// ARGUS_RETENTION_RAW.
+func openStore() (storage.Store, error) {
+	switch backend := os.Getenv("ARGUS_STORAGE"); backend {
+	case "", "postgres":
+		return connectDB()
+	case "embedded":
+		dir := os.Getenv("ARGUS_DATA_DIR")
+		if dir == "" {
+			dir = "data"
+		}
+		return embedded.Open(dir, embedded.Options{Retention: retentionConfig().Raw})
+	default:
+		return nil, fmt.Errorf("unknown ARGUS_STORAGE %q (want postgres or embedded)", backend)
+	}
+}
+
+// apiConfig reads the API server settings from the environment:
+//
+//	ARGUS_AUTH_DISABLED=true   serve the API without authentication (development only)
+//	ARGUS_TEAMLESS_OPEN=true   let any responder act on metrics without a team label
+//	ARGUS_SCRAPE_TOKEN         bearer token Prometheus may use to read /metrics/anomalies
+//	ARGUS_SLACK_USERS          grants for Slack alert buttons, e.g. "U024BE7LH=responder team:payments,U0G9QF9C6=admin"
+//	ARGUS_CORS_ORIGINS         comma-separated browser origins (default http://localhost:3000)
+//	ARGUS_OIDC_ISSUER          accept bearer JWTs from this issuer
+//	ARGUS_OIDC_AUDIENCE        required "aud" claim
+//	ARGUS_OIDC_JWKS_URL        signing keys (discovered from the issuer when unset)
+//	ARGUS_OIDC_SCOPE_CLAIM     claim holding role and team:<name> grants (default "scope")
+//	ARGUS_OIDC_TEAMS_CLAIM     claim listing team names, e.g. "groups"
+//	ARGUS_EVENTS_SLOW_CLIENTS  disconnect (default), drop_oldest or coalesce
+func apiConfig() api.Config {
+	cfg := api.Config{
+		Port:               "8080",
+		SlackSigningSecret: os.Getenv("SLACK_SIGNING_SECRET"),
+		SlackUsers:         slackUsers(),
+		Auth: api.AuthConfig{
+			Disabled:     os.Getenv("ARGUS_AUTH_DISABLED") == "true",
+			OpenTeamless: os.Getenv("ARGUS_TEAMLESS_OPEN") == "true",
+			ScrapeToken:  os.Getenv("ARGUS_SCRAPE_TOKEN"),
+		},
+		AllowedOrigins: splitEnv("ARGUS_CORS_ORIGINS", "http://localhost:3000"),
+	}
+
+	policy, err := api.ParseSlowClientPolicy(os.Getenv("ARGUS_EVENTS_SLOW_CLIENTS"))
+	if err != nil {
+		log.Fatalf("❌ Invalid ARGUS_EVENTS_SLOW_CLIENTS: %v", err)
+	}
+	cfg.SlowClients = policy
+
+	if issuer := os.Getenv("ARGUS_OIDC_ISSUER"); issuer != "" {
+		cfg.Auth.OIDC = &api.OIDCConfig{
+			Issuer:     issuer,
+			Audience:   os.Getenv("ARGUS_OIDC_AUDIENCE"),
+			JWKSURL:    os.Getenv("ARGUS_OIDC_JWKS_URL"),
+			ScopeClaim: os.Getenv("ARGUS_OIDC_SCOPE_CLAIM"),
+			TeamsClaim: os.Getenv("ARGUS_OIDC_TEAMS_CLAIM"),
+		}
+	}
+	return cfg
+}
+
+// slackUsers reads ARGUS_SLACK_USERS: comma-separated Slack user IDs, each
+// followed by = and its space-separated grants.
+func slackUsers() map[string][]string {
+	users := make(map[string][]string)
+	for _, entry := range splitEnv("ARGUS_SLACK_USERS", "") {
+		id, grants, ok := strings.Cut(entry, "=")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			log.Fatalf("❌ Invalid ARGUS_SLACK_USERS entry %q (want <user id>=<grants>)", entry)
+		}
+		for _, g := range strings.Fields(grants) {
+			if !api.ValidGrant(g) {
+				log.Fatalf("❌ Invalid grant %q for Slack user %s in ARGUS_SLACK_USERS", g, id)
+			}
+			users[id] = append(users[id], g)
+		}
+	}
+	return users
+}
+
+// exportConfig reads the /metrics/anomalies cardinality controls:
+//
+//	ARGUS_EXPORT_MAX_SERIES    most source series exported (default 10000)
+//	ARGUS_EXPORT_LABELS        only copy these source labels (comma-separated)
+//	ARGUS_EXPORT_DROP_LABELS   never copy these source labels
+//	ARGUS_EXPORT_TTL           drop series not updated for this long (default 30m)
+func exportConfig() telemetry.ExportConfig {
+	cfg := telemetry.ExportConfig{
+		IncludeLabels: splitEnv("ARGUS_EXPORT_LABELS", ""),
+		ExcludeLabels: splitEnv("ARGUS_EXPORT_DROP_LABELS", ""),
+	}
+	if v := os.Getenv("ARGUS_EXPORT_MAX_SERIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("❌ Invalid ARGUS_EXPORT_MAX_SERIES %q", v)
+		}
+		cfg.MaxSeries = n
+	}
+	if v := os.Getenv("ARGUS_EXPORT_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("❌ Invalid ARGUS_EXPORT_TTL %q", v)
+		}
+		cfg.TTL = d
+	}
+	return cfg
+}
+
+// ingestConfig reads how collected points are batched before they are
+// written:
+//
+//	ARGUS_INGEST_BATCH         points per write (default 5000)
+//	ARGUS_INGEST_INTERVAL      longest a point waits to be written (default 5s)
+//	ARGUS_INGEST_MAX_PENDING   unwritten points held before the collector waits (default 100000)
+func ingestConfig() worker.BufferConfig {
+	cfg := worker.BufferConfig{
+		BatchSize:  envInt("ARGUS_INGEST_BATCH"),
+		MaxPending: envInt("ARGUS_INGEST_MAX_PENDING"),
+	}
+	cfg.FlushInterval = envDuration("ARGUS_INGEST_INTERVAL")
+	return cfg
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("❌ Invalid %s %q", key, v)
+	}
+	return d
+}
+
+func envInt(key string) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("❌ Invalid %s %q", key, v)
+	}
+	return n
+}
+
+// clusterConfig reads the settings used with ARGUS_CLUSTER=true, when
+// several replicas share one database:
+//
+//	ARGUS_REPLICA_ID           unique name of this replica (default: hostname)
+//	ARGUS_CLUSTER_HEARTBEAT    how often replicas report in (default 10s)
+func clusterConfig() cluster.Config {
+	id := os.Getenv("ARGUS_REPLICA_ID")
+	if id == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("❌ Set ARGUS_REPLICA_ID: %v", err)
+		}
+		id = host
+	}
+	return cluster.Config{
+		ReplicaID:         id,
+		HeartbeatInterval: envDuration("ARGUS_CLUSTER_HEARTBEAT"),
+	}
+}
+
+// retentionConfig reads how long metric data is kept at each resolution.
+// Values are durations such as 720h or a number of days such as 30d; 0 keeps
+// data forever.
+//
+//	ARGUS_RETENTION_RAW   raw 60s points (default 7d, at least 2d)
+//	ARGUS_RETENTION_5M    5 minute rollups (default 90d)
+//	ARGUS_RETENTION_1H    1 hour rollups (default 730d)
+func retentionConfig() storage.RetentionConfig {
+	return storage.RetentionConfig{
+		Raw:      envDays("ARGUS_RETENTION_RAW", 7),
+		Rollup5m: envDays("ARGUS_RETENTION_5M", 90),
+		Rollup1h: envDays("ARGUS_RETENTION_1H", 730),
+	}
+}
+
+func envDays(key string, fallback int) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return time.Duration(fallback) * 24 * time.Hour
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			log.Fatalf("❌ Invalid %s %q", key, v)
+		}
+		return time.Duration(n) * 24 * time.Hour
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("❌ Invalid %s %q", key, v)
+	}
+	return d
+}
+
+func splitEnv(key, fallback string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		value = fallback
+	}
+
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
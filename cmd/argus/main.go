@@ -10,23 +10,50 @@ import (
 
 	"github.com/mjrtuhin/argus/pkg/alerting"
 	"github.com/mjrtuhin/argus/pkg/api"
+	"github.com/mjrtuhin/argus/pkg/cluster"
 	"github.com/mjrtuhin/argus/pkg/detector"
 	"github.com/mjrtuhin/argus/pkg/prometheus"
 	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
 	"github.com/mjrtuhin/argus/pkg/worker"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	log.Println("🚀 ARGUS - Autonomous Anomaly Detection System")
 	log.Println("===============================================")
 
-	// Connect to database
-	db, err := storage.NewDB("localhost", "5432", "argus", "argus_dev_2025", "argus")
+	// Open storage
+	store, err := openStore()
 	if err != nil {
-		log.Fatalf("❌ Failed to connect to database: %v", err)
+		log.Fatalf("❌ Failed to open storage: %v", err)
+	}
+	defer store.Close()
+
+	var rollupMode string
+	db, postgres := store.(*storage.DB)
+	if postgres {
+		log.Println("✅ Connected to PostgreSQL")
+		if os.Getenv("ARGUS_AUTO_MIGRATE") != "false" {
+			migrate(context.Background(), db)
+		}
+		telemetry.RegisterDBStats(db.Stats)
+
+		retention := retentionConfig()
+		rollupMode, err = db.ConfigureRetention(context.Background(), retention)
+		if err != nil {
+			log.Fatalf("❌ Failed to configure retention: %v", err)
+		}
+		log.Printf("✅ Retention: raw %v, 5m rollups %v, 1h rollups %v (%s)",
+			retention.Raw, retention.Rollup5m, retention.Rollup1h, rollupMode)
+	} else {
+		log.Printf("✅ Using embedded storage (raw retention %v)", retentionConfig().Raw)
 	}
-	defer db.Close()
-	log.Println("✅ Connected to PostgreSQL")
+	telemetry.Exports.Configure(exportConfig(), store)
 
 	// Create Prometheus client
 	promClient := prometheus.NewClient("http://localhost:9090")
@@ -36,16 +63,62 @@ func main() {
 	mlClient := detector.NewMLClient("http://localhost:5001")
 	log.Println("✅ Connected to ML service")
 
-	// Create Slack alerting
-	slackSender := alerting.NewSlackSender("")
-	log.Println("✅ Alerting initialized (console mode)")
+	// Create alerting
+	alertConfig, err := loadAlertingConfig()
+	if err != nil {
+		log.Fatalf("❌ Invalid alerting config: %v", err)
+	}
+	notifier, err := alerting.NewNotifier(alertConfig, store)
+	if err != nil {
+		log.Fatalf("❌ Failed to initialize alerting: %v", err)
+	}
+	log.Printf("✅ Alerting initialized (%d receivers)", len(notifier.Receivers()))
 
 	// Create API server
-	apiServer := api.NewServer(db, "8080")
+	serverConfig := apiConfig()
+	apiServer := api.NewServer(store, notifier, serverConfig)
+	switch {
+	case serverConfig.Auth.Disabled:
+		log.Println("⚠️  API authentication is disabled (ARGUS_AUTH_DISABLED=true)")
+	case serverConfig.Auth.OIDC != nil:
+		log.Printf("✅ API authentication: API keys and OIDC (%s)", serverConfig.Auth.OIDC.Issuer)
+	default:
+		log.Println("✅ API authentication: API keys (create one with: argus apikey create <name>)")
+	}
 
 	// Create workers
-	collector := worker.NewMetricCollector(promClient, db, 60*time.Second)
-	detectorWorker := worker.NewAnomalyDetector(mlClient, db, slackSender, apiServer.GetHub(), 5*time.Minute)
+	buffer := worker.NewMetricBuffer(store, ingestConfig())
+	// Join the cluster when running several replicas
+	var members *cluster.Cluster
+	var shard interface{ Owns(string) bool } = cluster.Standalone
+	if os.Getenv("ARGUS_CLUSTER") == "true" {
+		if !postgres {
+			log.Fatal("❌ ARGUS_CLUSTER requires ARGUS_STORAGE=postgres")
+		}
+		members = cluster.New(db, clusterConfig())
+		if err := members.Join(context.Background()); err != nil {
+			log.Fatalf("❌ Failed to join the cluster: %v", err)
+		}
+		shard = members
+		log.Printf("✅ Joined the cluster as %s (%d replicas)", members.ID(), len(members.Replicas()))
+	}
+
+	collectInterval, detectInterval := 60*time.Second, 5*time.Minute
+	collector := worker.NewMetricCollector(promClient, store, buffer, apiServer.GetHub(), shard, collectInterval)
+	detectorWorker := worker.NewAnomalyDetector(mlClient, store, notifier, apiServer.GetHub(), shard, detectInterval)
+
+	// Report dependencies, workers and queues on /readyz and /api/status
+	apiServer.AddDependency("prometheus", false, promClient.Ping)
+	apiServer.AddDependency("ml_service", false, mlClient.Ping)
+	apiServer.AddWorker("collector", collectInterval, func() api.WorkerState {
+		return api.WorkerState(collector.Status())
+	})
+	apiServer.AddWorker("detector", detectInterval, func() api.WorkerState {
+		return api.WorkerState(detectorWorker.Status())
+	})
+	apiServer.AddQueue("ingest_buffer", func(context.Context) (int, error) {
+		return buffer.Pending(), nil
+	})
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -58,12 +131,37 @@ func main() {
 		}
 	}()
 
-	// Start WebSocket hub
-	go apiServer.GetHub().Run(ctx)
+	// Start WebSocket hub, relaying events between replicas in a cluster
+	hub := apiServer.GetHub()
+	if members != nil {
+		hub.SetRelay(func(event []byte) error { return members.Publish(ctx, event) })
+		go members.Subscribe(ctx, hub.Deliver)
+		go members.Run(ctx)
+	}
+	go hub.Run(ctx)
+
+	// Work that must not run twice goes to the leader in a cluster
+	singleton := func(name string, run func(context.Context)) {
+		if members == nil {
+			go run(ctx)
+		} else {
+			go members.RunAsLeader(ctx, name, run)
+		}
+	}
 
 	// Start workers
+	flushed := make(chan struct{})
+	go func() {
+		buffer.Run(ctx)
+		close(flushed)
+	}()
 	go collector.Start(ctx)
 	go detectorWorker.Start(ctx)
+	singleton("escalations", notifier.RunEscalations)
+	go notifier.RunDeliveries(ctx)
+	if postgres {
+		singleton("rollup compactor", worker.NewRollupCompactor(db, 5*time.Minute).Start)
+	}
 
 	log.Println("")
 	log.Println("🔄 Metric Collector: Running every 60 seconds")
@@ -78,6 +176,7 @@ func main() {
 	<-sigChan
 	log.Println("\n🛑 Shutting down gracefully...")
 	cancel()
+	<-flushed
 	time.Sleep(2 * time.Second)
 	log.Println("👋 Goodbye!")
 }
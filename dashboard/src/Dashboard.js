@@ -1,14 +1,25 @@
-import React, { useState, useEffect } from 'react';
+import React, { useState, useEffect, useRef } from 'react';
 import axios from 'axios';
 import './Dashboard.css';
 
 const API_URL = 'http://localhost:8080';
+// Create a key with `argus apikey create dashboard` (viewer role) and set it in .env
+const API_KEY = process.env.REACT_APP_ARGUS_API_KEY || '';
+
+if (API_KEY) {
+  axios.defaults.headers.common['X-API-Key'] = API_KEY;
+}
 
 function Dashboard() {
   const [metrics, setMetrics] = useState([]);
   const [anomalies, setAnomalies] = useState([]);
   const [loading, setLoading] = useState(true);
   const [wsConnected, setWsConnected] = useState(false);
+  // Live system state from hub events: dependency status and last worker cycles
+  const [dependencies, setDependencies] = useState({});
+  const [cycles, setCycles] = useState({});
+  // Seq of the last WebSocket event received, to catch up after reconnecting
+  const lastSeq = useRef(0);
 
   useEffect(() => {
     fetchMetrics();
@@ -27,7 +38,7 @@ function Dashboard() {
 
   const fetchAnomalies = async () => {
     try {
-      const response = await axios.get(`${API_URL}/api/anomalies?limit=20`);
+      const response = await axios.get(`${API_URL}/api/anomalies?limit=20&status=open`);
       console.log('Fetched anomalies:', response.data.anomalies); // Debug log
       setAnomalies(response.data.anomalies || []);
       setLoading(false);
@@ -38,7 +49,8 @@ function Dashboard() {
   };
 
   const connectWebSocket = () => {
-    const ws = new WebSocket(`ws://localhost:8080/ws/anomalies`);
+    const resume = lastSeq.current > 0 ? `&last_seq=${lastSeq.current}` : '';
+    const ws = new WebSocket(`ws://localhost:8080/ws/anomalies?access_token=${encodeURIComponent(API_KEY)}${resume}`);
     
     ws.onopen = () => {
       console.log('✅ WebSocket connected');
@@ -47,9 +59,45 @@ function Dashboard() {
     
     ws.onmessage = (event) => {
       const data = JSON.parse(event.data);
-      if (data.type === 'anomaly_detected') {
-        console.log('📡 New anomaly:', data.anomaly);
-        setAnomalies(prev => [data.anomaly, ...prev].slice(0, 20));
+      if (data.seq) {
+        lastSeq.current = Math.max(lastSeq.current, data.seq);
+      }
+      if (data.type === 'resumed') {
+        lastSeq.current = data.last_seq;
+        if (data.more) {
+          ws.send(JSON.stringify({ action: 'resume', last_seq: data.last_seq }));
+        } else if (!data.complete) {
+          fetchAnomalies();
+        }
+        return;
+      }
+      switch (data.type) {
+        case 'anomaly_detected':
+          console.log('📡 New anomaly:', data.anomaly);
+          setAnomalies(prev => [data.anomaly, ...prev].slice(0, 20));
+          break;
+        case 'anomaly_status_changed':
+          setAnomalies(prev => {
+            const rest = prev.filter(a => a.id !== data.anomaly.id);
+            return data.anomaly.status === 'open' ? [data.anomaly, ...rest].slice(0, 20) : rest;
+          });
+          break;
+        case 'metric_discovered':
+          setMetrics(prev => [...prev, data.metric]);
+          break;
+        case 'cycle_completed':
+          setCycles(prev => ({ ...prev, [data.cycle.worker]: { ...data.cycle, at: data.timestamp } }));
+          break;
+        case 'dependency_changed':
+          setDependencies(prev => ({ ...prev, [data.dependency.name]: data.dependency }));
+          break;
+        case 'incident_opened':
+        case 'incident_updated':
+        case 'incident_resolved':
+          console.log(`📡 Incident #${data.incident.id} ${data.incident.status}`);
+          break;
+        default:
+          // Newer event types are ignored
       }
     };
     
@@ -105,6 +153,16 @@ function Dashboard() {
           <span className={`status-indicator ${wsConnected ? 'connected' : 'disconnected'}`}>
             {wsConnected ? '🟢 Live' : '🔴 Disconnected'}
           </span>
+          {Object.values(dependencies).map(dep => (
+            <span key={dep.name} className={`status-indicator ${dep.status === 'up' ? 'connected' : 'disconnected'}`} title={dep.error}>
+              {dep.status === 'up' ? '🟢' : '🔴'} {dep.name}
+            </span>
+          ))}
+          {Object.values(cycles).map(cycle => (
+            <span key={cycle.worker} className="status-indicator" title={`Last cycle at ${new Date(cycle.at).toLocaleTimeString()}`}>
+              🔄 {cycle.worker}: {cycle.stats.metrics} metrics in {(cycle.duration_ms / 1000).toFixed(1)}s
+            </span>
+          ))}
         </div>
       </header>
 
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS anomalies;
+DROP TABLE IF EXISTS metric_data;
+DROP TABLE IF EXISTS metrics;
@@ -2,7 +2,7 @@
 CREATE EXTENSION IF NOT EXISTS timescaledb;
 
 -- METRICS TABLE
-CREATE TABLE metrics (
+CREATE TABLE IF NOT EXISTS metrics (
     id SERIAL PRIMARY KEY,
     metric_name VARCHAR(255) NOT NULL UNIQUE,
     labels JSONB,
@@ -11,10 +11,10 @@ CREATE TABLE metrics (
     last_collected_at TIMESTAMPTZ
 );
 
-CREATE INDEX idx_metrics_active ON metrics(is_active);
+CREATE INDEX IF NOT EXISTS idx_metrics_active ON metrics(is_active);
 
 -- METRIC_DATA TABLE (time-series)
-CREATE TABLE metric_data (
+CREATE TABLE IF NOT EXISTS metric_data (
     metric_id INT NOT NULL REFERENCES metrics(id) ON DELETE CASCADE,
     timestamp TIMESTAMPTZ NOT NULL,
     value DOUBLE PRECISION NOT NULL,
@@ -22,10 +22,10 @@ CREATE TABLE metric_data (
 );
 
 -- Convert to TimescaleDB hypertable
-SELECT create_hypertable('metric_data', 'timestamp');
+SELECT create_hypertable('metric_data', 'timestamp', if_not_exists => TRUE);
 
 -- ANOMALIES TABLE
-CREATE TABLE anomalies (
+CREATE TABLE IF NOT EXISTS anomalies (
     id SERIAL PRIMARY KEY,
     metric_id INT NOT NULL REFERENCES metrics(id),
     timestamp TIMESTAMPTZ NOT NULL,
@@ -37,5 +37,5 @@ CREATE TABLE anomalies (
     created_at TIMESTAMPTZ DEFAULT NOW()
 );
 
-CREATE INDEX idx_anomalies_metric ON anomalies(metric_id);
-CREATE INDEX idx_anomalies_status ON anomalies(status);
+CREATE INDEX IF NOT EXISTS idx_anomalies_metric ON anomalies(metric_id);
+CREATE INDEX IF NOT EXISTS idx_anomalies_status ON anomalies(status);
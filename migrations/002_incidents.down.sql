@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_anomalies_incident;
+ALTER TABLE anomalies DROP COLUMN IF EXISTS incident_id;
+DROP TABLE IF EXISTS incidents;
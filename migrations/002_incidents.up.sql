@@ -0,0 +1,16 @@
+-- INCIDENTS TABLE (groups related anomalies of one metric)
+CREATE TABLE IF NOT EXISTS incidents (
+    id SERIAL PRIMARY KEY,
+    metric_id INT NOT NULL REFERENCES metrics(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    severity VARCHAR(20) NOT NULL DEFAULT 'medium',
+    anomaly_count INT NOT NULL DEFAULT 0,
+    opened_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_incidents_metric_status ON incidents(metric_id, status);
+
+ALTER TABLE anomalies ADD COLUMN IF NOT EXISTS incident_id INT REFERENCES incidents(id);
+CREATE INDEX IF NOT EXISTS idx_anomalies_incident ON anomalies(incident_id);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS escalation_events;
+DROP TABLE IF EXISTS escalations;
+
+ALTER TABLE incidents DROP COLUMN IF EXISTS acknowledged_by;
+ALTER TABLE incidents DROP COLUMN IF EXISTS acknowledged_at;
+ALTER TABLE anomalies DROP COLUMN IF EXISTS acknowledged_by;
+ALTER TABLE anomalies DROP COLUMN IF EXISTS acknowledged_at;
@@ -0,0 +1,34 @@
+-- ACKNOWLEDGEMENT TRACKING
+ALTER TABLE anomalies ADD COLUMN IF NOT EXISTS acknowledged_at TIMESTAMPTZ;
+ALTER TABLE anomalies ADD COLUMN IF NOT EXISTS acknowledged_by VARCHAR(255);
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS acknowledged_at TIMESTAMPTZ;
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS acknowledged_by VARCHAR(255);
+
+-- ESCALATIONS TABLE (current position of an anomaly in its policy)
+CREATE TABLE IF NOT EXISTS escalations (
+    id SERIAL PRIMARY KEY,
+    anomaly_id INT NOT NULL REFERENCES anomalies(id) ON DELETE CASCADE,
+    policy VARCHAR(255) NOT NULL,
+    next_step INT NOT NULL DEFAULT 0,
+    next_at TIMESTAMPTZ,
+    state VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_escalations_due ON escalations(state, next_at);
+CREATE INDEX IF NOT EXISTS idx_escalations_anomaly ON escalations(anomaly_id);
+
+-- ESCALATION_EVENTS TABLE (timeline shown on the anomaly detail)
+CREATE TABLE IF NOT EXISTS escalation_events (
+    id SERIAL PRIMARY KEY,
+    escalation_id INT NOT NULL REFERENCES escalations(id) ON DELETE CASCADE,
+    anomaly_id INT NOT NULL REFERENCES anomalies(id) ON DELETE CASCADE,
+    step INT NOT NULL,
+    receiver VARCHAR(255) NOT NULL DEFAULT '',
+    event VARCHAR(20) NOT NULL,
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_escalation_events_anomaly ON escalation_events(anomaly_id);
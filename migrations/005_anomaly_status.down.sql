@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_anomalies_snoozed;
+ALTER TABLE anomalies DROP COLUMN IF EXISTS snoozed_until;
+ALTER TABLE anomalies DROP COLUMN IF EXISTS resolved_at;
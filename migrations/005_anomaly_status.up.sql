@@ -0,0 +1,5 @@
+-- ANOMALY LIFECYCLE (resolve, snooze, false positive)
+ALTER TABLE anomalies ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMPTZ;
+ALTER TABLE anomalies ADD COLUMN IF NOT EXISTS snoozed_until TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_anomalies_snoozed ON anomalies(snoozed_until) WHERE status = 'snoozed';
@@ -0,0 +1,9 @@
+-- METRIC_BASELINES TABLE (expected value and band from the detector)
+CREATE TABLE IF NOT EXISTS metric_baselines (
+    metric_id INT NOT NULL REFERENCES metrics(id) ON DELETE CASCADE,
+    timestamp TIMESTAMPTZ NOT NULL,
+    expected DOUBLE PRECISION NOT NULL,
+    lower_bound DOUBLE PRECISION NOT NULL,
+    upper_bound DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (metric_id, timestamp)
+);
@@ -0,0 +1,5 @@
+-- Back to read/write scopes. Admin keys become write keys and team grants
+-- are dropped, so every write key can act for all teams again.
+UPDATE api_keys SET scopes = ARRAY(SELECT s FROM unnest(scopes) s WHERE s NOT LIKE 'team:%');
+UPDATE api_keys SET scopes = array_replace(array_replace(array_replace(scopes,
+    'viewer', 'read'), 'responder', 'write'), 'admin', 'write');
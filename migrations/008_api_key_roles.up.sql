@@ -0,0 +1,4 @@
+-- API keys now carry roles (viewer, responder, admin) and team:<name> grants
+-- instead of read/write scopes. Existing write keys keep access to every team.
+UPDATE api_keys SET scopes = array_append(scopes, 'team:*') WHERE 'write' = ANY(scopes);
+UPDATE api_keys SET scopes = array_replace(array_replace(scopes, 'read', 'viewer'), 'write', 'responder');
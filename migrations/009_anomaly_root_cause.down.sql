@@ -0,0 +1,2 @@
+ALTER TABLE anomalies DROP COLUMN IF EXISTS impact;
+ALTER TABLE anomalies DROP COLUMN IF EXISTS root_cause;
@@ -0,0 +1,3 @@
+-- ROOT CAUSE AND IMPACT (written by the detector, missing from 001)
+ALTER TABLE anomalies ADD COLUMN IF NOT EXISTS root_cause TEXT NOT NULL DEFAULT '';
+ALTER TABLE anomalies ADD COLUMN IF NOT EXISTS impact TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_tables WHERE tablename = 'metric_rollup_5m') THEN
+        DROP TABLE IF EXISTS metric_rollup_1h;
+        DROP TABLE IF EXISTS metric_rollup_5m;
+    ELSE
+        DROP MATERIALIZED VIEW IF EXISTS metric_rollup_1h;
+        DROP MATERIALIZED VIEW IF EXISTS metric_rollup_5m;
+    END IF;
+END
+$$;
@@ -0,0 +1,52 @@
+-- METRIC ROLLUPS (5 minute and 1 hour min/max/avg/count per metric)
+-- Continuous aggregates when TimescaleDB's community features are available.
+-- Otherwise plain tables that argus fills with its own compaction job.
+DO $$
+BEGIN
+    IF current_setting('timescaledb.license', true) = 'timescale' THEN
+        CREATE MATERIALIZED VIEW IF NOT EXISTS metric_rollup_5m
+        WITH (timescaledb.continuous, timescaledb.materialized_only = true) AS
+        SELECT metric_id,
+               time_bucket(INTERVAL '5 minutes', timestamp) AS bucket,
+               AVG(value) AS avg_value,
+               MIN(value) AS min_value,
+               MAX(value) AS max_value,
+               COUNT(*) AS sample_count
+        FROM metric_data
+        GROUP BY metric_id, bucket
+        WITH NO DATA;
+
+        CREATE MATERIALIZED VIEW IF NOT EXISTS metric_rollup_1h
+        WITH (timescaledb.continuous, timescaledb.materialized_only = true) AS
+        SELECT metric_id,
+               time_bucket(INTERVAL '1 hour', timestamp) AS bucket,
+               AVG(value) AS avg_value,
+               MIN(value) AS min_value,
+               MAX(value) AS max_value,
+               COUNT(*) AS sample_count
+        FROM metric_data
+        GROUP BY metric_id, bucket
+        WITH NO DATA;
+    ELSE
+        CREATE TABLE IF NOT EXISTS metric_rollup_5m (
+            metric_id INT NOT NULL REFERENCES metrics(id) ON DELETE CASCADE,
+            bucket TIMESTAMPTZ NOT NULL,
+            avg_value DOUBLE PRECISION NOT NULL,
+            min_value DOUBLE PRECISION NOT NULL,
+            max_value DOUBLE PRECISION NOT NULL,
+            sample_count BIGINT NOT NULL,
+            PRIMARY KEY (metric_id, bucket)
+        );
+
+        CREATE TABLE IF NOT EXISTS metric_rollup_1h (
+            metric_id INT NOT NULL REFERENCES metrics(id) ON DELETE CASCADE,
+            bucket TIMESTAMPTZ NOT NULL,
+            avg_value DOUBLE PRECISION NOT NULL,
+            min_value DOUBLE PRECISION NOT NULL,
+            max_value DOUBLE PRECISION NOT NULL,
+            sample_count BIGINT NOT NULL,
+            PRIMARY KEY (metric_id, bucket)
+        );
+    END IF;
+END
+$$;
@@ -0,0 +1,6 @@
+-- REPLICAS TABLE (Argus instances sharing this database and their heartbeats)
+CREATE TABLE IF NOT EXISTS replicas (
+    id VARCHAR(255) PRIMARY KEY,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
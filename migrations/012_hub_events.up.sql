@@ -0,0 +1,12 @@
+-- HUB EVENTS TABLE (recent WebSocket events, replayed to clients that reconnect)
+CREATE TABLE IF NOT EXISTS hub_events (
+    seq BIGSERIAL PRIMARY KEY,
+    type VARCHAR(50) NOT NULL,
+    metric VARCHAR(255) NOT NULL DEFAULT '',
+    severity VARCHAR(20) NOT NULL DEFAULT '',
+    team VARCHAR(255) NOT NULL DEFAULT '',
+    data JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_hub_events_created_at ON hub_events(created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE incidents DROP COLUMN IF EXISTS acknowledged_on_behalf_of;
+ALTER TABLE anomalies DROP COLUMN IF EXISTS acknowledged_on_behalf_of;
@@ -0,0 +1,3 @@
+-- ACKNOWLEDGEMENTS MADE ON SOMEONE ELSE'S BEHALF (acknowledged_by is always the authenticated caller)
+ALTER TABLE anomalies ADD COLUMN IF NOT EXISTS acknowledged_on_behalf_of VARCHAR(255);
+ALTER TABLE incidents ADD COLUMN IF NOT EXISTS acknowledged_on_behalf_of VARCHAR(255);
@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL schema migrations so the argus binary
+// can apply them itself. Files are named NNN_name.up.sql and NNN_name.down.sql
+// and run in version order.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -33,7 +33,7 @@ def detect_anomalies():
             }), 400
         
         # Run ensemble detection
-        anomalies = detect_ensemble(timestamps, values)
+        anomalies, expected = detect_ensemble(timestamps, values)
         
         logging.info(f"✅ Detected {len(anomalies)} anomalies for {metric_name}")
         
@@ -41,6 +41,7 @@ def detect_anomalies():
             'metric_id': metric_id,
             'metric_name': metric_name,
             'anomalies': anomalies,
+            'expected': expected,
             'total_points': len(values),
             'anomaly_count': len(anomalies)
         })
@@ -52,8 +53,10 @@ def detect_anomalies():
 def detect_ensemble(timestamps, values):
     """Ensemble detection with root cause analysis"""
     
+    prophet_anomalies, expected = detect_prophet(timestamps, values)
+
     results = {
-        'prophet': detect_prophet(timestamps, values),
+        'prophet': prophet_anomalies,
         'stl': detect_stl(values),
         'isolation_forest': detect_isolation_forest(values)
     }
@@ -97,7 +100,7 @@ def detect_ensemble(timestamps, values):
                 'impact': impact
             })
     
-    return anomalies
+    return anomalies, expected
 
 def generate_root_cause(value, mean_value, deviation, methods):
     """Generate human-readable root cause"""
@@ -122,7 +125,7 @@ def generate_impact(deviation, methods):
         return "LOW: Minor anomaly - routine monitoring recommended"
 
 def detect_prophet(timestamps, values):
-    """Prophet-based anomaly detection"""
+    """Prophet-based anomaly detection, also returning the forecast band"""
     try:
         df = pd.DataFrame({
             'ds': pd.to_datetime(timestamps, unit='s'),
@@ -140,11 +143,22 @@ def detect_prophet(timestamps, values):
         forecast = model.predict(df)
         
         is_anomaly = (df['y'] < forecast['yhat_lower']) | (df['y'] > forecast['yhat_upper'])
-        return is_anomaly.values
+        
+        # Expected value and band, returned so the UI can draw the baseline
+        expected = [
+            {
+                'timestamp': int(ts),
+                'value': float(row.yhat),
+                'lower': float(row.yhat_lower),
+                'upper': float(row.yhat_upper)
+            }
+            for ts, row in zip(timestamps, forecast.itertuples())
+        ]
+        return is_anomaly.values, expected
         
     except Exception as e:
         logging.warning(f"Prophet failed: {e}, using fallback")
-        return np.zeros(len(values), dtype=bool)
+        return np.zeros(len(values), dtype=bool), []
 
 def detect_stl(values):
     """STL decomposition-based detection"""
@@ -0,0 +1,128 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// Alert is everything a receiver template can reference when rendering a
+// notification for one anomaly.
+type Alert struct {
+	Anomaly      storage.Anomaly
+	Metric       storage.Metric
+	Recent       []storage.MetricDataPoint
+	Incident     *storage.Incident
+	DashboardURL string
+	AnomalyURL   string
+	IncidentURL  string
+	DetectedAt   time.Time
+}
+
+// Window of data loaded to show recent values on re-sent alerts.
+const recentWindow = time.Hour
+
+// LoadAlert rebuilds the alert for a stored anomaly, e.g. for an escalation
+// step that fires long after detection.
+func LoadAlert(ctx context.Context, db storage.Store, anomalyID int) (*Alert, error) {
+	anomaly, err := db.GetAnomaly(ctx, anomalyID)
+	if err != nil {
+		return nil, err
+	}
+
+	metric, err := db.GetMetric(ctx, anomaly.MetricID)
+	if err != nil {
+		return nil, err
+	}
+
+	alert := &Alert{
+		Anomaly:    *anomaly,
+		Metric:     *metric,
+		DetectedAt: anomaly.CreatedAt,
+	}
+
+	points, err := db.GetMetricData(ctx, metric.ID, time.Now().Add(-recentWindow))
+	if err != nil {
+		return nil, err
+	}
+	if len(points) > 10 {
+		points = points[len(points)-10:]
+	}
+	alert.Recent = points
+
+	if anomaly.IncidentID != nil {
+		if alert.Incident, err = db.GetIncident(ctx, *anomaly.IncidentID); err != nil {
+			return nil, err
+		}
+	}
+
+	return alert, nil
+}
+
+func (a *Alert) withLinks(dashboardURL string) {
+	if dashboardURL == "" || a.DashboardURL != "" {
+		return
+	}
+
+	base := strings.TrimRight(dashboardURL, "/")
+	a.DashboardURL = base
+	a.AnomalyURL = fmt.Sprintf("%s/anomalies/%d", base, a.Anomaly.ID)
+	if a.Incident != nil {
+		a.IncidentURL = fmt.Sprintf("%s/incidents/%d", base, a.Incident.ID)
+	}
+}
+
+// sampleAlert is used to validate templates without touching the database.
+func sampleAlert() *Alert {
+	now := time.Now()
+	incidentID := 7
+
+	recent := make([]storage.MetricDataPoint, 10)
+	for i := range recent {
+		recent[i] = storage.MetricDataPoint{
+			MetricID:  1,
+			Timestamp: now.Add(time.Duration(i-len(recent)) * time.Minute),
+			Value:     40 + float64(i%3),
+		}
+	}
+	recent[len(recent)-1].Value = 97.5
+
+	alert := &Alert{
+		Anomaly: storage.Anomaly{
+			ID:               42,
+			MetricID:         1,
+			Timestamp:        now,
+			Value:            97.5,
+			AnomalyScore:     0.91,
+			DetectionMethods: []string{"prophet", "stl", "isolation_forest"},
+			Severity:         "critical",
+			Status:           "open",
+			RootCause:        "Extreme spike detected - value is 4.2 standard deviations from normal baseline (avg: 41.00)",
+			Impact:           "CRITICAL: Immediate investigation required - may indicate system failure or resource exhaustion",
+			IncidentID:       &incidentID,
+			CreatedAt:        now,
+		},
+		Metric: storage.Metric{
+			ID:         1,
+			MetricName: "http_request_duration_seconds",
+			Labels:     map[string]string{"job": "api", "team": "payments"},
+			IsActive:   true,
+		},
+		Recent: recent,
+		Incident: &storage.Incident{
+			ID:           incidentID,
+			MetricID:     1,
+			Status:       "open",
+			Severity:     "critical",
+			AnomalyCount: 3,
+			OpenedAt:     now.Add(-20 * time.Minute),
+			UpdatedAt:    now,
+		},
+		DetectedAt: now,
+	}
+	alert.withLinks("http://localhost:3000")
+	return alert
+}
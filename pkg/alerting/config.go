@@ -0,0 +1,124 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// Config describes where alerts are delivered and how they are rendered.
+type Config struct {
+	DashboardURL       string             `yaml:"dashboard_url"`
+	Receivers          []ReceiverConfig   `yaml:"receivers"`
+	EscalationPolicies []EscalationPolicy `yaml:"escalation_policies"`
+	Delivery           DeliveryConfig     `yaml:"delivery"`
+	RateLimit          RateLimitConfig    `yaml:"rate_limit"`
+}
+
+type ReceiverConfig struct {
+	Name       string    `yaml:"name"`
+	Type       string    `yaml:"type"`
+	WebhookURL string    `yaml:"webhook_url"`
+	Template   *Template `yaml:"template"`
+	// RateLimit overrides rate_limit.per_receiver; -1 disables the limit
+	RateLimit int `yaml:"rate_limit"`
+}
+
+// DefaultConfig mirrors the historical behaviour: a single Slack receiver
+// that logs to the console when no webhook is configured.
+func DefaultConfig() *Config {
+	return &Config{
+		DashboardURL: os.Getenv("ARGUS_DASHBOARD_URL"),
+		Receivers: []ReceiverConfig{
+			{
+				Name:       "slack",
+				Type:       "slack",
+				WebhookURL: os.Getenv("SLACK_WEBHOOK_URL"),
+			},
+		},
+	}
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := yaml.UnmarshalStrict([]byte(os.ExpandEnv(string(data))), &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return &cfg, cfg.Validate()
+}
+
+// Validate checks receiver definitions and renders every template against a
+// sample alert.
+func (c *Config) Validate() error {
+	if len(c.Receivers) == 0 {
+		return errors.New("at least one receiver is required")
+	}
+
+	var errs []error
+	seen := make(map[string]bool)
+	for _, r := range c.Receivers {
+		if r.Name == "" {
+			errs = append(errs, errors.New("receiver name is required"))
+			continue
+		}
+		if seen[r.Name] {
+			errs = append(errs, fmt.Errorf("receiver %q: duplicate name", r.Name))
+		}
+		seen[r.Name] = true
+
+		tmpl, err := r.template()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("receiver %q: %w", r.Name, err))
+			continue
+		}
+		if r.Type == "webhook" && r.WebhookURL == "" {
+			errs = append(errs, fmt.Errorf("receiver %q: webhook_url is required", r.Name))
+		}
+		if err := tmpl.Validate(r.Name); err != nil {
+			errs = append(errs, fmt.Errorf("receiver %q: %w", r.Name, err))
+		}
+	}
+
+	policies := make(map[string]bool)
+	for _, p := range c.EscalationPolicies {
+		if policies[p.Name] {
+			errs = append(errs, fmt.Errorf("escalation policy %q: duplicate name", p.Name))
+		}
+		policies[p.Name] = true
+
+		if err := p.validate(seen); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// template returns the receiver's template, filling any empty field from the
+// channel default.
+func (r ReceiverConfig) template() (Template, error) {
+	def, ok := DefaultTemplate(r.Type)
+	if !ok {
+		return Template{}, fmt.Errorf("unknown receiver type %q", r.Type)
+	}
+	if r.Template == nil {
+		return def, nil
+	}
+
+	t := *r.Template
+	if t.Title == "" {
+		t.Title = def.Title
+	}
+	if t.Text == "" {
+		t.Text = def.Text
+	}
+	return t, nil
+}
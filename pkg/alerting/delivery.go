@@ -0,0 +1,189 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
+)
+
+// DeliveryConfig controls how the outbox worker retries failed deliveries.
+type DeliveryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+const (
+	deliveryPollInterval = 5 * time.Second
+	deliveryBatchSize    = 50
+	deliveryLease        = 2 * time.Minute
+)
+
+func (c DeliveryConfig) withDefaults() DeliveryConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 8
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = 30 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = time.Hour
+	}
+	return c
+}
+
+// backoff returns the delay before the next attempt, doubling per failed
+// attempt with up to 20% jitter so retries from one outage spread out.
+func (c DeliveryConfig) backoff(attempts int) time.Duration {
+	d := c.InitialBackoff
+	for i := 1; i < attempts && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// retryAt returns when to try again after the given number of failed
+// attempts, or nil once they have used up MaxAttempts and the notification
+// should be dead-lettered.
+func (c DeliveryConfig) retryAt(attempts int, now time.Time) *time.Time {
+	if attempts >= c.MaxAttempts {
+		return nil
+	}
+	t := now.Add(c.backoff(attempts))
+	return &t
+}
+
+// enqueue renders the alert for one receiver and stores it in the outbox,
+// unless the receiver's rate limit folds it into a storm digest.
+func (n *Notifier) enqueue(ctx context.Context, receiver Receiver, alert *Alert) (*storage.Notification, error) {
+	allowed, d := n.limiter.admit(receiver.Name(), alert, time.Now())
+	n.enqueueDigest(ctx, d)
+	if !allowed {
+		return nil, errRateLimited
+	}
+
+	msg, err := receiver.Render(alert)
+	if err != nil {
+		return nil, err
+	}
+
+	var anomalyID *int
+	if alert.Anomaly.ID != 0 {
+		id := alert.Anomaly.ID
+		anomalyID = &id
+	}
+	return n.enqueueMessage(ctx, receiver.Name(), msg, anomalyID)
+}
+
+// enqueueMessage stores an already rendered message in the outbox and wakes
+// the delivery worker.
+func (n *Notifier) enqueueMessage(ctx context.Context, receiver string, msg *Message, anomalyID *int) (*storage.Notification, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	notification := &storage.Notification{
+		Receiver:  receiver,
+		AnomalyID: anomalyID,
+		Payload:   payload,
+	}
+	if err := n.db.EnqueueNotification(ctx, notification); err != nil {
+		return nil, err
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return notification, nil
+}
+
+// RunDeliveries drains the notification outbox until the context is
+// cancelled. New notifications wake it immediately; otherwise it polls for
+// retries that have become due.
+func (n *Notifier) RunDeliveries(ctx context.Context) {
+	ticker := time.NewTicker(deliveryPollInterval)
+	defer ticker.Stop()
+
+	log.Printf("📬 Notification delivery worker started (max attempts: %d)", n.delivery.MaxAttempts)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("🛑 Notification delivery worker stopped")
+			return
+		case <-ticker.C:
+			n.flushDigests(ctx)
+		case <-n.wake:
+		}
+
+		// Keep going while batches come back full
+		for n.deliverBatch(ctx) == deliveryBatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+func (n *Notifier) deliverBatch(ctx context.Context) int {
+	batch, err := n.db.ClaimNotifications(ctx, deliveryBatchSize, deliveryLease)
+	if err != nil {
+		log.Printf("❌ Failed to claim notifications: %v", err)
+		return 0
+	}
+
+	for _, notification := range batch {
+		n.deliver(ctx, notification)
+	}
+	return len(batch)
+}
+
+func (n *Notifier) deliver(ctx context.Context, notification storage.Notification) {
+	err := n.deliverPayload(ctx, notification)
+	if err == nil {
+		telemetry.NotificationsSent.WithLabelValues(notification.Receiver).Inc()
+		if err := n.db.MarkNotificationSent(ctx, notification.ID); err != nil {
+			log.Printf("❌ Failed to mark notification %d sent: %v", notification.ID, err)
+		}
+		return
+	}
+
+	telemetry.NotificationsFailed.WithLabelValues(notification.Receiver).Inc()
+	attempts := notification.Attempts + 1
+	retryAt := n.delivery.retryAt(attempts, time.Now())
+	if retryAt != nil {
+		log.Printf("⚠️  Failed to send notification %d to %s (attempt %d/%d, retry at %s): %v",
+			notification.ID, notification.Receiver, attempts, n.delivery.MaxAttempts, retryAt.Format("15:04:05"), err)
+	} else {
+		telemetry.NotificationsDead.WithLabelValues(notification.Receiver).Inc()
+		log.Printf("💀 Notification %d to %s dead-lettered after %d attempts: %v",
+			notification.ID, notification.Receiver, attempts, err)
+	}
+
+	if err := n.db.MarkNotificationFailed(ctx, notification.ID, err.Error(), retryAt); err != nil {
+		log.Printf("❌ Failed to record delivery failure for notification %d: %v", notification.ID, err)
+	}
+}
+
+func (n *Notifier) deliverPayload(ctx context.Context, notification storage.Notification) error {
+	receiver, err := n.receiver(notification.Receiver)
+	if err != nil {
+		return err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(notification.Payload, &msg); err != nil {
+		return err
+	}
+	return receiver.Deliver(ctx, &msg)
+}
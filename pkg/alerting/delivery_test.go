@@ -0,0 +1,215 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/storage/embedded"
+)
+
+func TestDeliveryConfigDefaults(t *testing.T) {
+	got := DeliveryConfig{}.withDefaults()
+	want := DeliveryConfig{MaxAttempts: 8, InitialBackoff: 30 * time.Second, MaxBackoff: time.Hour}
+	if got != want {
+		t.Fatalf("withDefaults() = %+v, want %+v", got, want)
+	}
+	custom := DeliveryConfig{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Minute}
+	if got := custom.withDefaults(); got != custom {
+		t.Fatalf("withDefaults() = %+v, want %+v", got, custom)
+	}
+}
+
+func TestDeliveryBackoff(t *testing.T) {
+	c := DeliveryConfig{InitialBackoff: 30 * time.Second, MaxBackoff: 10 * time.Minute}.withDefaults()
+
+	tests := []struct {
+		attempts int
+		want     time.Duration // before jitter
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{6, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := c.backoff(tt.attempts); got < tt.want || got > tt.want+tt.want/5 {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempts, got, tt.want, tt.want+tt.want/5)
+			}
+		}
+	}
+}
+
+func TestDeliveryRetryAt(t *testing.T) {
+	c := DeliveryConfig{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Hour}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		attempts int
+		wantDead bool
+		wantMin  time.Duration
+	}{
+		{1, false, time.Minute},
+		{2, false, 2 * time.Minute},
+		{3, true, 0},
+		{4, true, 0},
+	}
+	for _, tt := range tests {
+		got := c.retryAt(tt.attempts, now)
+		if (got == nil) != tt.wantDead {
+			t.Fatalf("retryAt(%d) = %v, want dead %v", tt.attempts, got, tt.wantDead)
+		}
+		if got != nil && (got.Before(now.Add(tt.wantMin)) || got.After(now.Add(tt.wantMin+tt.wantMin/5))) {
+			t.Fatalf("retryAt(%d) = %v, want %v after %v", tt.attempts, got, tt.wantMin, now)
+		}
+	}
+}
+
+// fakeReceiver fails every delivery while err is set and counts the rest.
+type fakeReceiver struct {
+	name      string
+	err       error
+	delivered []Message
+}
+
+func (r *fakeReceiver) Name() string { return r.name }
+
+func (r *fakeReceiver) Render(alert *Alert) (*Message, error) {
+	return &Message{Title: alert.Anomaly.Severity}, nil
+}
+
+func (r *fakeReceiver) Deliver(ctx context.Context, msg *Message) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.delivered = append(r.delivered, *msg)
+	return nil
+}
+
+// testNotifier returns a notifier over an empty embedded store that sends
+// to receivers without rate limits.
+func testNotifier(t *testing.T, cfg *Config, receivers ...Receiver) (*Notifier, *embedded.Store) {
+	t.Helper()
+	db, err := embedded.Open(t.TempDir(), embedded.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &Notifier{
+		db:        db,
+		receivers: receivers,
+		policies:  cfg.EscalationPolicies,
+		delivery:  cfg.Delivery.withDefaults(),
+		limiter:   newRateLimiter(RateLimitConfig{PerReceiver: -1}, nil),
+		wake:      make(chan struct{}, 1),
+		clock:     systemClock{},
+	}, db
+}
+
+func enqueueTest(t *testing.T, n *Notifier, receiver string) *storage.Notification {
+	t.Helper()
+	notification, err := n.enqueueMessage(context.Background(), receiver, &Message{Title: "latency"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notification
+}
+
+func getNotification(t *testing.T, db storage.Store, id int) *storage.Notification {
+	t.Helper()
+	notification, err := db.GetNotification(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notification
+}
+
+// TestDeliverDeadLetter fails a notification until it runs out of attempts,
+// checking that each failure schedules a later retry and the last one
+// dead-letters it.
+func TestDeliverDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	receiver := &fakeReceiver{name: "ops", err: errors.New("503 Service Unavailable")}
+	n, db := testNotifier(t, &Config{Delivery: DeliveryConfig{MaxAttempts: 3, InitialBackoff: time.Minute}}, receiver)
+	id := enqueueTest(t, n, "ops").ID
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		before := time.Now()
+		n.deliver(ctx, *getNotification(t, db, id))
+
+		got := getNotification(t, db, id)
+		if got.Attempts != attempt || got.LastError != "503 Service Unavailable" {
+			t.Fatalf("attempt %d: attempts = %d, last error = %q", attempt, got.Attempts, got.LastError)
+		}
+		if attempt < 3 {
+			if got.Status != "failed" || got.NextAttemptAt.Before(before.Add(n.delivery.backoff(attempt)/2)) {
+				t.Fatalf("attempt %d: status %s, next attempt at %v, want failed with a backoff", attempt, got.Status, got.NextAttemptAt)
+			}
+		} else if got.Status != "dead" {
+			t.Fatalf("attempt %d: status = %s, want dead", attempt, got.Status)
+		}
+	}
+
+	// Dead notifications are not claimed again until retried by hand.
+	if claimed := n.deliverBatch(ctx); claimed != 0 {
+		t.Fatalf("deliverBatch() claimed %d dead notifications", claimed)
+	}
+	receiver.err = nil
+	if _, err := db.RetryNotification(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if claimed := n.deliverBatch(ctx); claimed != 1 {
+		t.Fatalf("deliverBatch() claimed %d after a retry, want 1", claimed)
+	}
+	if got := getNotification(t, db, id); got.Status != "sent" || got.Attempts != 1 || len(receiver.delivered) != 1 {
+		t.Fatalf("after retry: %+v, %d delivered", got, len(receiver.delivered))
+	}
+}
+
+func TestDeliverUnknownReceiver(t *testing.T) {
+	ctx := context.Background()
+	n, db := testNotifier(t, &Config{Delivery: DeliveryConfig{MaxAttempts: 1}}, &fakeReceiver{name: "ops"})
+	id := enqueueTest(t, n, "removed").ID
+
+	n.deliverBatch(ctx)
+	if got := getNotification(t, db, id); got.Status != "dead" || got.LastError != `unknown receiver "removed"` {
+		t.Fatalf("notification for a removed receiver = %+v, want dead", got)
+	}
+}
+
+// TestDeliverReclaimsAfterCrash leaves a notification claimed but never
+// acknowledged, as a worker that crashed mid-delivery would, and checks
+// that it is delivered once the lease runs out.
+func TestDeliverReclaimsAfterCrash(t *testing.T) {
+	ctx := context.Background()
+	receiver := &fakeReceiver{name: "ops"}
+	n, db := testNotifier(t, &Config{}, receiver)
+	id := enqueueTest(t, n, "ops").ID
+
+	claimed, err := db.ClaimNotifications(ctx, deliveryBatchSize, 50*time.Millisecond)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("ClaimNotifications() = %d, %v, want 1", len(claimed), err)
+	}
+	if got := n.deliverBatch(ctx); got != 0 {
+		t.Fatalf("deliverBatch() claimed %d leased notifications", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := n.deliverBatch(ctx); got != 1 {
+		t.Fatalf("deliverBatch() after the lease = %d, want 1", got)
+	}
+	got := getNotification(t, db, id)
+	if got.Status != "sent" || got.SentAt == nil || len(receiver.delivered) != 1 {
+		t.Fatalf("reclaimed notification = %+v, %d delivered", got, len(receiver.delivered))
+	}
+	var msg Message
+	if err := json.Unmarshal(got.Payload, &msg); err != nil || msg.Title != receiver.delivered[0].Title {
+		t.Fatalf("delivered %+v, stored payload %s", receiver.delivered[0], got.Payload)
+	}
+}
@@ -0,0 +1,218 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// EscalationPolicy notifies its steps in order until the anomaly is
+// acknowledged. A policy applies to an alert when the anomaly severity is
+// listed (or no severities are given) and every label matches the metric.
+type EscalationPolicy struct {
+	Name       string            `yaml:"name"`
+	Severities []string          `yaml:"severities"`
+	Labels     map[string]string `yaml:"labels"`
+	Steps      []EscalationStep  `yaml:"steps"`
+	// Repeat, when set, notifies the final step again at this interval
+	// until the anomaly is acknowledged or resolved.
+	Repeat time.Duration `yaml:"repeat"`
+}
+
+// EscalationStep notifies Receiver once After has passed since the previous
+// step without an acknowledgement.
+type EscalationStep struct {
+	Receiver string        `yaml:"receiver"`
+	After    time.Duration `yaml:"after"`
+}
+
+const escalationCheckInterval = 30 * time.Second
+
+func (p *EscalationPolicy) Matches(alert *Alert) bool {
+	if len(p.Severities) > 0 {
+		found := false
+		for _, s := range p.Severities {
+			if s == alert.Anomaly.Severity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for k, v := range p.Labels {
+		if alert.Metric.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *EscalationPolicy) validate(receivers map[string]bool) error {
+	if p.Name == "" {
+		return errors.New("escalation policy name is required")
+	}
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("escalation policy %q: at least one step is required", p.Name)
+	}
+
+	var errs []error
+	for i, step := range p.Steps {
+		if !receivers[step.Receiver] {
+			errs = append(errs, fmt.Errorf("escalation policy %q: step %d: unknown receiver %q", p.Name, i+1, step.Receiver))
+		}
+		if step.After < 0 {
+			errs = append(errs, fmt.Errorf("escalation policy %q: step %d: negative delay", p.Name, i+1))
+		}
+	}
+	if p.Repeat < 0 {
+		errs = append(errs, fmt.Errorf("escalation policy %q: negative repeat interval", p.Name))
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) matchPolicy(alert *Alert) *EscalationPolicy {
+	for i := range n.policies {
+		if n.policies[i].Matches(alert) {
+			return &n.policies[i]
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) policy(name string) *EscalationPolicy {
+	for i := range n.policies {
+		if n.policies[i].Name == name {
+			return &n.policies[i]
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) startEscalation(ctx context.Context, policy *EscalationPolicy, alert *Alert) error {
+	esc, err := n.db.CreateEscalation(ctx, alert.Anomaly.ID, policy.Name, n.clock.Now().Add(policy.Steps[0].After))
+	if err != nil {
+		return err
+	}
+	log.Printf("📟 Escalation started for anomaly %d (policy: %s)", alert.Anomaly.ID, policy.Name)
+
+	if policy.Steps[0].After > 0 {
+		return nil
+	}
+	return n.escalate(ctx, *esc, alert)
+}
+
+// RunEscalations reopens anomalies whose snooze has ended and advances due
+// escalations until the context is cancelled.
+func (n *Notifier) RunEscalations(ctx context.Context) {
+	tick, stop := n.clock.Ticker(escalationCheckInterval)
+	defer stop()
+
+	log.Printf("📟 Escalation worker started (%d policies)", len(n.policies))
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("🛑 Escalation worker stopped")
+			return
+		case <-tick:
+			n.processDueEscalations(ctx)
+		}
+	}
+}
+
+func (n *Notifier) processDueEscalations(ctx context.Context) {
+	if reopened, err := n.db.ReopenExpiredSnoozes(ctx); err != nil {
+		log.Printf("❌ Failed to reopen snoozed anomalies: %v", err)
+	} else if len(reopened) > 0 {
+		log.Printf("⏰ Reopened %d anomalies after snooze", len(reopened))
+		if n.onStatusChange != nil {
+			for _, a := range reopened {
+				n.onStatusChange(ctx, a, "snoozed")
+			}
+		}
+	}
+
+	due, err := n.db.GetDueEscalations(ctx, n.clock.Now())
+	if err != nil {
+		log.Printf("❌ Failed to load due escalations: %v", err)
+		return
+	}
+
+	for _, esc := range due {
+		alert, err := LoadAlert(ctx, n.db, esc.AnomalyID)
+		if err != nil {
+			log.Printf("❌ Failed to load anomaly %d for escalation: %v", esc.AnomalyID, err)
+			continue
+		}
+		alert.withLinks(n.dashboardURL)
+
+		if err := n.escalate(ctx, esc, alert); err != nil {
+			log.Printf("❌ Escalation %d failed: %v", esc.ID, err)
+		}
+	}
+}
+
+// escalate sends the escalation's current step, or stops the escalation if
+// the anomaly no longer needs attention.
+func (n *Notifier) escalate(ctx context.Context, esc storage.Escalation, alert *Alert) error {
+	// A snoozed anomaly keeps its place in the policy and resumes once the
+	// snooze ends
+	if alert.Anomaly.Status == "snoozed" && alert.Anomaly.SnoozedUntil != nil {
+		return n.db.UpdateEscalation(ctx, esc.ID, esc.NextStep, alert.Anomaly.SnoozedUntil, "active")
+	}
+	if alert.Anomaly.Status != "open" {
+		return n.db.StopEscalations(ctx, esc.AnomalyID, "cancelled", "anomaly is "+alert.Anomaly.Status)
+	}
+
+	policy := n.policy(esc.Policy)
+	if policy == nil || esc.NextStep >= len(policy.Steps) {
+		return n.db.StopEscalations(ctx, esc.AnomalyID, "cancelled", fmt.Sprintf("policy %q no longer has step %d", esc.Policy, esc.NextStep+1))
+	}
+
+	step := policy.Steps[esc.NextStep]
+	event := &storage.EscalationEvent{
+		EscalationID: esc.ID,
+		AnomalyID:    esc.AnomalyID,
+		Step:         esc.NextStep,
+		Receiver:     step.Receiver,
+		Event:        "notified",
+	}
+	receiver, err := n.receiver(step.Receiver)
+	if err == nil {
+		var notification *storage.Notification
+		if notification, err = n.enqueue(ctx, receiver, alert); err == nil {
+			event.Detail = fmt.Sprintf("notification #%d", notification.ID)
+		}
+	}
+	if errors.Is(err, errRateLimited) {
+		event.Event = "suppressed"
+		event.Detail = "rate limited; included in the storm digest"
+	} else if err != nil {
+		event.Event = "failed"
+		event.Detail = err.Error()
+	}
+	if err := n.db.AddEscalationEvent(ctx, event); err != nil {
+		return err
+	}
+
+	next := esc.NextStep + 1
+	var delay time.Duration
+	switch {
+	case next < len(policy.Steps):
+		delay = policy.Steps[next].After
+	case policy.Repeat > 0:
+		// Keep paging the final step until someone responds
+		next, delay = esc.NextStep, policy.Repeat
+	default:
+		return n.db.UpdateEscalation(ctx, esc.ID, next, nil, "completed")
+	}
+	nextAt := n.clock.Now().Add(delay)
+	return n.db.UpdateEscalation(ctx, esc.ID, next, &nextAt, "active")
+}
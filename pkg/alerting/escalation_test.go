@@ -0,0 +1,233 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// fakeClock stands still until advanced; its ticker fires only when the
+// test advances it.
+type fakeClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	tick chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	return c.tick, func() {}
+}
+
+// advance moves the clock on by d and ticks. The tick is sent twice: the
+// worker takes the second only once it has processed the first.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.mu.Unlock()
+	c.tick <- now
+	c.tick <- now
+}
+
+type escalationTest struct {
+	t     *testing.T
+	n     *Notifier
+	db    storage.Store
+	clock *fakeClock
+	alert *Alert
+}
+
+// startEscalation runs RunEscalations on a fake clock and sends policy an
+// alert for a new critical anomaly.
+func startEscalation(t *testing.T, policy EscalationPolicy) *escalationTest {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var receivers []Receiver
+	for _, name := range []string{"team-slack", "oncall-slack", "incident-webhook"} {
+		receivers = append(receivers, &fakeReceiver{name: name})
+	}
+	n, db := testNotifier(t, &Config{EscalationPolicies: []EscalationPolicy{policy}}, receivers...)
+	clock := &fakeClock{now: time.Now(), tick: make(chan time.Time)}
+	n.clock = clock
+	go n.RunEscalations(ctx)
+
+	metric, _, err := db.CreateMetric(ctx, "http_request_duration_seconds", map[string]string{"team": "payments"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anomaly := &storage.Anomaly{MetricID: metric.ID, Timestamp: clock.Now(), Value: 2.5, AnomalyScore: 0.95, Severity: "critical", Status: "open"}
+	if err := db.CreateAnomaly(ctx, anomaly); err != nil {
+		t.Fatal(err)
+	}
+	alert := &Alert{Anomaly: *anomaly, Metric: *metric}
+	if err := n.Notify(ctx, alert); err != nil {
+		t.Fatal(err)
+	}
+	return &escalationTest{t: t, n: n, db: db, clock: clock, alert: alert}
+}
+
+// timeline returns the anomaly's escalation events as "event receiver",
+// or "event: detail" for events without a receiver.
+func (e *escalationTest) timeline() []string {
+	e.t.Helper()
+	events, err := e.db.GetEscalationTimeline(context.Background(), e.alert.Anomaly.ID)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range events {
+		if ev.Receiver != "" {
+			got = append(got, fmt.Sprintf("%s %s", ev.Event, ev.Receiver))
+		} else {
+			got = append(got, fmt.Sprintf("%s: %s", ev.Event, ev.Detail))
+		}
+	}
+	return got
+}
+
+func (e *escalationTest) expect(when string, want ...string) {
+	e.t.Helper()
+	if got := e.timeline(); !reflect.DeepEqual(got, want) {
+		e.t.Fatalf("%s: timeline = %q, want %q", when, got, want)
+	}
+}
+
+func (e *escalationTest) setStatus(update storage.StatusUpdate) {
+	e.t.Helper()
+	if _, err := e.n.ChangeAnomalyStatus(context.Background(), e.alert.Anomaly.ID, update); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+var threeSteps = []EscalationStep{
+	{Receiver: "team-slack"},
+	{Receiver: "oncall-slack", After: 15 * time.Minute},
+	{Receiver: "incident-webhook", After: 30 * time.Minute},
+}
+
+func TestEscalationAdvancesSteps(t *testing.T) {
+	e := startEscalation(t, EscalationPolicy{Name: "payments", Steps: threeSteps})
+	e.expect("on the alert", "notified team-slack")
+
+	e.clock.advance(14 * time.Minute)
+	e.expect("before the second step is due", "notified team-slack")
+	e.clock.advance(time.Minute)
+	e.expect("second step", "notified team-slack", "notified oncall-slack")
+	e.clock.advance(30 * time.Minute)
+	e.expect("third step", "notified team-slack", "notified oncall-slack", "notified incident-webhook")
+
+	// Without repeat the policy ends at its final step.
+	e.clock.advance(24 * time.Hour)
+	e.expect("after the final step", "notified team-slack", "notified oncall-slack", "notified incident-webhook")
+
+	notifications, err := e.db.ListNotifications(context.Background(), storage.NotificationFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 3 {
+		t.Fatalf("%d notifications enqueued, want one per step", len(notifications))
+	}
+}
+
+func TestEscalationRepeatsFinalStep(t *testing.T) {
+	e := startEscalation(t, EscalationPolicy{Name: "payments", Steps: threeSteps, Repeat: time.Hour})
+	e.clock.advance(15 * time.Minute)
+	e.clock.advance(30 * time.Minute)
+	want := []string{"notified team-slack", "notified oncall-slack", "notified incident-webhook"}
+	e.expect("third step", want...)
+
+	e.clock.advance(59 * time.Minute)
+	e.expect("before the repeat is due", want...)
+	for i := 0; i < 2; i++ {
+		e.clock.advance(time.Hour)
+		want = append(want, "notified incident-webhook")
+		e.expect(fmt.Sprintf("repeat %d", i+1), want...)
+	}
+
+	e.setStatus(storage.StatusUpdate{Status: "acknowledged", By: "ana"})
+	want = append(want, "acknowledged: acknowledged by ana")
+	e.clock.advance(3 * time.Hour)
+	e.expect("after the acknowledgement", want...)
+}
+
+func TestEscalationStops(t *testing.T) {
+	tests := []struct {
+		name   string
+		update storage.StatusUpdate
+		// viaStore changes the status without the notifier, as an incident
+		// resolving on its own does; the worker notices at the next step.
+		viaStore bool
+		want     string
+	}{
+		{"acknowledged", storage.StatusUpdate{Status: "acknowledged", By: "ana"}, false, "acknowledged: acknowledged by ana"},
+		{"resolved", storage.StatusUpdate{Status: "resolved", By: "ana"}, false, "resolved: resolved by ana"},
+		{"false positive", storage.StatusUpdate{Status: "false_positive"}, false, "false_positive: marked false positive via API"},
+		{"resolved elsewhere", storage.StatusUpdate{Status: "resolved"}, true, "cancelled: anomaly is resolved"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := startEscalation(t, EscalationPolicy{Name: "payments", Steps: threeSteps, Repeat: time.Hour})
+			e.clock.advance(10 * time.Minute)
+
+			if tt.viaStore {
+				if _, err := e.db.UpdateAnomalyStatus(context.Background(), e.alert.Anomaly.ID, tt.update); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				e.setStatus(tt.update)
+			}
+			for i := 0; i < 4; i++ {
+				e.clock.advance(time.Hour)
+			}
+			e.expect("after "+tt.name, "notified team-slack", tt.want)
+		})
+	}
+}
+
+// TestEscalationSnooze checks that a snoozed anomaly keeps its place in the
+// policy and carries on once the snooze ends.
+func TestEscalationSnooze(t *testing.T) {
+	e := startEscalation(t, EscalationPolicy{Name: "payments", Steps: threeSteps})
+	until := e.clock.Now().Add(time.Hour)
+	e.setStatus(storage.StatusUpdate{Status: "snoozed", SnoozedUntil: &until})
+
+	e.clock.advance(30 * time.Minute)
+	e.expect("while snoozed", "notified team-slack")
+
+	e.setStatus(storage.StatusUpdate{Status: "open"})
+	e.clock.advance(30 * time.Minute)
+	e.expect("after the snooze", "notified team-slack", "notified oncall-slack")
+}
+
+func TestEscalationPolicyValidate(t *testing.T) {
+	receivers := map[string]bool{"team-slack": true}
+	tests := []struct {
+		policy  EscalationPolicy
+		wantErr bool
+	}{
+		{EscalationPolicy{Name: "p", Steps: []EscalationStep{{Receiver: "team-slack"}}, Repeat: time.Hour}, false},
+		{EscalationPolicy{Steps: []EscalationStep{{Receiver: "team-slack"}}}, true},
+		{EscalationPolicy{Name: "p"}, true},
+		{EscalationPolicy{Name: "p", Steps: []EscalationStep{{Receiver: "pager"}}}, true},
+		{EscalationPolicy{Name: "p", Steps: []EscalationStep{{Receiver: "team-slack", After: -time.Minute}}}, true},
+		{EscalationPolicy{Name: "p", Steps: []EscalationStep{{Receiver: "team-slack"}}, Repeat: -time.Hour}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.validate(receivers); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) = %v, want error %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// Receiver renders alerts with its template and delivers the resulting
+// messages to one destination.
+type Receiver interface {
+	Name() string
+	Render(alert *Alert) (*Message, error)
+	Deliver(ctx context.Context, msg *Message) error
+}
+
+// Notifier routes an alert through the first matching escalation policy, or
+// fans it out to every configured receiver when no policy applies. Messages
+// go through the notification outbox and are sent by RunDeliveries.
+type Notifier struct {
+	db           storage.Store
+	receivers    []Receiver
+	policies     []EscalationPolicy
+	delivery     DeliveryConfig
+	limiter      *rateLimiter
+	dashboardURL string
+	wake         chan struct{}
+	clock        clock
+
+	// onStatusChange, when set, is told about every status change made
+	// here, with the status before it.
+	onStatusChange func(ctx context.Context, anomaly storage.Anomaly, previous string)
+}
+
+func NewNotifier(cfg *Config, db storage.Store) (*Notifier, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	n := &Notifier{
+		db:           db,
+		policies:     cfg.EscalationPolicies,
+		delivery:     cfg.Delivery.withDefaults(),
+		limiter:      newRateLimiter(cfg.RateLimit, cfg.Receivers),
+		dashboardURL: cfg.DashboardURL,
+		wake:         make(chan struct{}, 1),
+		clock:        systemClock{},
+	}
+	for _, rc := range cfg.Receivers {
+		tmpl, err := rc.template()
+		if err != nil {
+			return nil, err
+		}
+		mt, err := tmpl.Compile(rc.Name)
+		if err != nil {
+			return nil, fmt.Errorf("receiver %q: %w", rc.Name, err)
+		}
+
+		switch rc.Type {
+		case "slack":
+			n.receivers = append(n.receivers, NewSlackSender(rc.Name, rc.WebhookURL, mt))
+		case "webhook":
+			n.receivers = append(n.receivers, NewWebhookSender(rc.Name, rc.WebhookURL, mt))
+		}
+	}
+
+	return n, nil
+}
+
+// clock is the time source for escalations; tests replace it to step
+// through a policy without waiting.
+type clock interface {
+	Now() time.Time
+	// Ticker ticks every d until the returned function is called.
+	Ticker(d time.Duration) (<-chan time.Time, func())
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+func (n *Notifier) Receivers() []Receiver {
+	return n.receivers
+}
+
+func (n *Notifier) Notify(ctx context.Context, alert *Alert) error {
+	if alert.DetectedAt.IsZero() {
+		alert.DetectedAt = time.Now()
+	}
+	alert.withLinks(n.dashboardURL)
+
+	if policy := n.matchPolicy(alert); policy != nil {
+		return n.startEscalation(ctx, policy, alert)
+	}
+
+	var errs []error
+	for _, r := range n.receivers {
+		if _, err := n.enqueue(ctx, r, alert); err != nil && !errors.Is(err, errRateLimited) {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) receiver(name string) (Receiver, error) {
+	for _, r := range n.receivers {
+		if r.Name() == name {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown receiver %q", name)
+}
@@ -0,0 +1,333 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/telemetry"
+)
+
+// RateLimitConfig caps how many notifications go out per window. Once a
+// receiver (or all receivers together) reaches its cap the receiver enters
+// storm mode: further alerts are suppressed and summarised in one digest
+// message when the window ends.
+//
+// Limits are counted by each replica for the alerts it raises. In a cluster
+// every replica detects its own share of the metrics, so the caps apply per
+// replica: N replicas may together send up to N times Global, and each sends
+// its own digest.
+type RateLimitConfig struct {
+	// Window defaults to 5 minutes.
+	Window time.Duration `yaml:"window"`
+	// Global caps all receivers together; zero or -1 means no global cap.
+	Global int `yaml:"global"`
+	// PerReceiver defaults to 20 when zero; -1 disables the limit.
+	PerReceiver int `yaml:"per_receiver"`
+}
+
+// errRateLimited is returned by enqueue when an alert was folded into a
+// storm digest instead of being sent.
+var errRateLimited = errors.New("suppressed by rate limit")
+
+// RateLimitStats reports suppression counters for one receiver.
+type RateLimitStats struct {
+	Receiver     string
+	Limit        int
+	SentInWindow int
+	Storm        bool
+	Suppressed   int64
+	Digests      int64
+}
+
+func (c RateLimitConfig) withDefaults() RateLimitConfig {
+	if c.Window <= 0 {
+		c.Window = 5 * time.Minute
+	}
+	if c.PerReceiver == 0 {
+		c.PerReceiver = 20
+	}
+	return c
+}
+
+type rateWindow struct {
+	start time.Time
+	sent  int
+}
+
+func (w *rateWindow) roll(now time.Time, size time.Duration) bool {
+	if w.start.IsZero() || now.Sub(w.start) >= size {
+		w.start = now
+		w.sent = 0
+		return true
+	}
+	return false
+}
+
+type receiverWindow struct {
+	rateWindow
+	storm      bool
+	suppressed int
+	metrics    map[string]int
+	severities map[string]int
+}
+
+// digest summarises the alerts a receiver suppressed during one window.
+type digest struct {
+	receiver   string
+	window     time.Duration
+	suppressed int
+	metrics    map[string]int
+	severities map[string]int
+}
+
+type rateLimiter struct {
+	mu         sync.Mutex
+	window     time.Duration
+	global     int
+	limits     map[string]int
+	globalWin  rateWindow
+	receivers  map[string]*receiverWindow
+	suppressed map[string]int64
+	digests    map[string]int64
+}
+
+func newRateLimiter(cfg RateLimitConfig, receivers []ReceiverConfig) *rateLimiter {
+	cfg = cfg.withDefaults()
+
+	l := &rateLimiter{
+		window:     cfg.Window,
+		global:     cfg.Global,
+		limits:     make(map[string]int),
+		receivers:  make(map[string]*receiverWindow),
+		suppressed: make(map[string]int64),
+		digests:    make(map[string]int64),
+	}
+	for _, r := range receivers {
+		limit := cfg.PerReceiver
+		if r.RateLimit != 0 {
+			limit = r.RateLimit
+		}
+		l.limits[r.Name] = limit
+	}
+	return l
+}
+
+// admit decides whether an alert may be sent to a receiver now. When the
+// receiver's previous window had suppressed alerts its digest is returned so
+// the caller can deliver it.
+func (l *rateLimiter) admit(receiver string, alert *Alert, now time.Time) (bool, *digest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := l.receiverWindow(receiver)
+	var d *digest
+	if w.start.IsZero() || now.Sub(w.start) >= l.window {
+		d = l.closeWindow(receiver, w)
+		w.roll(now, l.window)
+	}
+	l.globalWin.roll(now, l.window)
+
+	limit := l.limits[receiver]
+	receiverFull := limit > 0 && w.sent >= limit
+	globalFull := l.global > 0 && l.globalWin.sent >= l.global
+	if !receiverFull && !globalFull {
+		w.sent++
+		l.globalWin.sent++
+		return true, d
+	}
+
+	if !w.storm {
+		w.storm = true
+		log.Printf("🌪️  Alert storm on %s: suppressing notifications until %s",
+			receiver, w.start.Add(l.window).Format("15:04:05"))
+	}
+	w.suppressed++
+	w.metrics[alert.Metric.MetricName]++
+	w.severities[alert.Anomaly.Severity]++
+	l.suppressed[receiver]++
+	telemetry.NotificationsSuppressed.WithLabelValues(receiver).Inc()
+	return false, d
+}
+
+// flushDue closes every window that has ended and returns the digests of
+// those that suppressed alerts.
+func (l *rateLimiter) flushDue(now time.Time) []*digest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var digests []*digest
+	for name, w := range l.receivers {
+		if w.suppressed == 0 || now.Sub(w.start) < l.window {
+			continue
+		}
+		if d := l.closeWindow(name, w); d != nil {
+			digests = append(digests, d)
+		}
+		w.start = time.Time{}
+	}
+	return digests
+}
+
+func (l *rateLimiter) receiverWindow(receiver string) *receiverWindow {
+	w, ok := l.receivers[receiver]
+	if !ok {
+		w = &receiverWindow{}
+		w.reset()
+		l.receivers[receiver] = w
+	}
+	return w
+}
+
+func (l *rateLimiter) closeWindow(receiver string, w *receiverWindow) *digest {
+	defer w.reset()
+	if w.suppressed == 0 {
+		return nil
+	}
+
+	l.digests[receiver]++
+	return &digest{
+		receiver:   receiver,
+		window:     l.window,
+		suppressed: w.suppressed,
+		metrics:    w.metrics,
+		severities: w.severities,
+	}
+}
+
+func (w *receiverWindow) reset() {
+	w.sent = 0
+	w.storm = false
+	w.suppressed = 0
+	w.metrics = make(map[string]int)
+	w.severities = make(map[string]int)
+}
+
+func (l *rateLimiter) stats() []RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := make([]RateLimitStats, 0, len(l.limits))
+	for name, limit := range l.limits {
+		s := RateLimitStats{
+			Receiver:   name,
+			Limit:      limit,
+			Suppressed: l.suppressed[name],
+			Digests:    l.digests[name],
+		}
+		if w, ok := l.receivers[name]; ok {
+			s.SentInWindow = w.sent
+			s.Storm = w.storm
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Receiver < stats[j].Receiver })
+	return stats
+}
+
+// RateLimitStats returns per-receiver suppression counters of this replica
+// since startup.
+func (n *Notifier) RateLimitStats() []RateLimitStats {
+	return n.limiter.stats()
+}
+
+// flushDigests enqueues storm digests for windows that have ended.
+func (n *Notifier) flushDigests(ctx context.Context) {
+	for _, d := range n.limiter.flushDue(time.Now()) {
+		n.enqueueDigest(ctx, d)
+	}
+}
+
+func (n *Notifier) enqueueDigest(ctx context.Context, d *digest) {
+	if d == nil {
+		return
+	}
+
+	msg := d.message(n.dashboardURL)
+	if _, err := n.enqueueMessage(ctx, d.receiver, msg, nil); err != nil {
+		log.Printf("❌ Failed to enqueue storm digest for %s: %v", d.receiver, err)
+		return
+	}
+	log.Printf("🌪️  Storm digest queued for %s: %s", d.receiver, msg.Title)
+}
+
+func (d *digest) message(dashboardURL string) *Message {
+	type count struct {
+		name string
+		n    int
+	}
+	metrics := make([]count, 0, len(d.metrics))
+	for name, c := range d.metrics {
+		metrics = append(metrics, count{name, c})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].n != metrics[j].n {
+			return metrics[i].n > metrics[j].n
+		}
+		return metrics[i].name < metrics[j].name
+	})
+
+	severity := "low"
+	var bySeverity []string
+	for _, s := range []string{"critical", "high", "medium", "low"} {
+		if c := d.severities[s]; c > 0 {
+			if severityRankOf(s) > severityRankOf(severity) {
+				severity = s
+			}
+			bySeverity = append(bySeverity, fmt.Sprintf("%s: %d", s, c))
+		}
+	}
+
+	summary := fmt.Sprintf("%d anomalies across %d metrics in the last %s",
+		d.suppressed, len(d.metrics), formatWindow(d.window))
+
+	var text strings.Builder
+	text.WriteString(summary + " were held back by the rate limit.\n")
+	text.WriteString("*By severity:* " + strings.Join(bySeverity, ", ") + "\n")
+	text.WriteString("*Top metrics:*")
+	for i, m := range metrics {
+		if i == 5 {
+			fmt.Fprintf(&text, "\n• …and %d more", len(metrics)-i)
+			break
+		}
+		fmt.Fprintf(&text, "\n• %s (%d)", m.name, m.n)
+	}
+	if dashboardURL != "" {
+		fmt.Fprintf(&text, "\n<%s|Open Argus>", strings.TrimRight(dashboardURL, "/"))
+	}
+
+	return &Message{
+		Title:      "🌪️ Alert storm: " + summary,
+		Text:       text.String(),
+		Severity:   severity,
+		DetectedAt: time.Now(),
+	}
+}
+
+func severityRankOf(severity string) int {
+	switch severity {
+	case "critical":
+		return 4
+	case "high":
+		return 3
+	case "medium":
+		return 2
+	default:
+		return 1
+	}
+}
+
+func formatWindow(d time.Duration) string {
+	if d%time.Minute == 0 {
+		if m := int(d / time.Minute); m != 1 {
+			return fmt.Sprintf("%d minutes", m)
+		}
+		return "minute"
+	}
+	return d.String()
+}
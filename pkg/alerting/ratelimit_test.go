@@ -0,0 +1,165 @@
+package alerting
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+func stormAlert(metric, severity string) *Alert {
+	return &Alert{
+		Anomaly: storage.Anomaly{Severity: severity},
+		Metric:  storage.Metric{MetricName: metric},
+	}
+}
+
+func TestRateLimiterCaps(t *testing.T) {
+	receivers := []ReceiverConfig{{Name: "ops"}, {Name: "pager", RateLimit: 1}, {Name: "audit", RateLimit: -1}}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		cfg  RateLimitConfig
+		// sends lists the receiver of each alert, all within one window.
+		sends []string
+		want  []bool
+	}{
+		{"per receiver", RateLimitConfig{PerReceiver: 2}, []string{"ops", "ops", "ops", "pager", "pager"}, []bool{true, true, false, true, false}},
+		{"receivers count separately", RateLimitConfig{PerReceiver: 1}, []string{"ops", "audit", "ops", "audit"}, []bool{true, true, false, true}},
+		{"disabled", RateLimitConfig{PerReceiver: -1}, []string{"ops", "ops", "ops"}, []bool{true, true, true}},
+		{"global", RateLimitConfig{PerReceiver: -1, Global: 3}, []string{"ops", "audit", "pager", "ops", "audit"}, []bool{true, true, true, false, false}},
+		{"suppressed alerts do not count", RateLimitConfig{PerReceiver: 5, Global: 2}, []string{"pager", "pager", "ops", "ops"}, []bool{true, false, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.cfg, receivers)
+			var got []bool
+			for i, receiver := range tt.sends {
+				allowed, _ := l.admit(receiver, stormAlert("cpu", "high"), start.Add(time.Duration(i)*time.Second))
+				got = append(got, allowed)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("admit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{Window: 5 * time.Minute, PerReceiver: 2}, []ReceiverConfig{{Name: "ops"}})
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	admit := func(at time.Duration, metric, severity string) (bool, *digest) {
+		t.Helper()
+		return l.admit("ops", stormAlert(metric, severity), start.Add(at))
+	}
+
+	for i, alert := range []struct{ metric, severity string }{
+		{"cpu", "low"}, {"cpu", "low"}, {"cpu", "critical"}, {"memory", "high"}, {"cpu", "high"},
+	} {
+		allowed, d := admit(time.Duration(i)*time.Minute, alert.metric, alert.severity)
+		if allowed != (i < 2) || d != nil {
+			t.Fatalf("alert %d: admit() = %v, %v", i+1, allowed, d)
+		}
+	}
+	if s := l.stats(); len(s) != 1 || !s[0].Storm || s[0].SentInWindow != 2 || s[0].Suppressed != 3 {
+		t.Fatalf("stats() in a storm = %+v", s)
+	}
+
+	// Nothing is flushed until the window ends, at 5 minutes after its
+	// first alert.
+	if digests := l.flushDue(start.Add(5*time.Minute - time.Second)); len(digests) != 0 {
+		t.Fatalf("flushDue() before the window ends = %d digests", len(digests))
+	}
+
+	// The first alert of the next window rolls it over, returning the
+	// digest of the storm and starting a fresh count.
+	allowed, d := admit(5*time.Minute, "disk", "medium")
+	if !allowed || d == nil {
+		t.Fatalf("first alert of the next window: admit() = %v, %v", allowed, d)
+	}
+	want := &digest{
+		receiver:   "ops",
+		window:     5 * time.Minute,
+		suppressed: 3,
+		metrics:    map[string]int{"cpu": 2, "memory": 1},
+		severities: map[string]int{"critical": 1, "high": 2},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Fatalf("digest = %+v, want %+v", d, want)
+	}
+	if s := l.stats(); s[0].Storm || s[0].SentInWindow != 1 || s[0].Digests != 1 {
+		t.Fatalf("stats() after the rollover = %+v", s)
+	}
+
+	// A storm with no alert after it is flushed by the worker once, and
+	// the next alert starts a new window without a digest.
+	admit(6*time.Minute, "disk", "medium")
+	admit(7*time.Minute, "disk", "high")
+	if digests := l.flushDue(start.Add(10 * time.Minute)); len(digests) != 1 || digests[0].suppressed != 1 {
+		t.Fatalf("flushDue() = %+v, want one digest of 1 alert", digests)
+	}
+	if digests := l.flushDue(start.Add(20 * time.Minute)); len(digests) != 0 {
+		t.Fatalf("flushDue() again = %d digests, want none", len(digests))
+	}
+	if allowed, d := admit(21*time.Minute, "disk", "high"); !allowed || d != nil {
+		t.Fatalf("alert after a flushed storm: admit() = %v, %+v", allowed, d)
+	}
+}
+
+func TestDigestMessage(t *testing.T) {
+	d := &digest{
+		receiver:   "ops",
+		window:     5 * time.Minute,
+		suppressed: 27,
+		metrics:    map[string]int{},
+		severities: map[string]int{"low": 10, "high": 12, "medium": 5},
+	}
+	// Seven metrics, the busiest first; ties are broken by name.
+	for i, name := range []string{"cpu", "memory", "disk", "net_in", "net_out", "gc_pause", "threads"} {
+		d.metrics[name] = 7 - i
+	}
+	d.metrics["disk"] = d.metrics["memory"]
+
+	msg := d.message("https://argus.example.com/")
+	if want := "🌪️ Alert storm: 27 anomalies across 7 metrics in the last 5 minutes"; msg.Title != want {
+		t.Fatalf("Title = %q, want %q", msg.Title, want)
+	}
+	if msg.Severity != "high" {
+		t.Fatalf("Severity = %q, want the highest suppressed, high", msg.Severity)
+	}
+	want := strings.Join([]string{
+		"27 anomalies across 7 metrics in the last 5 minutes were held back by the rate limit.",
+		"*By severity:* high: 12, medium: 5, low: 10",
+		"*Top metrics:*",
+		"• cpu (7)",
+		"• disk (6)",
+		"• memory (6)",
+		"• net_in (4)",
+		"• net_out (3)",
+		"• …and 2 more",
+		"<https://argus.example.com|Open Argus>",
+	}, "\n")
+	if msg.Text != want {
+		t.Fatalf("Text =\n%s\nwant\n%s", msg.Text, want)
+	}
+
+	if got := (&digest{window: 90 * time.Second, severities: map[string]int{}}).message(""); strings.Contains(got.Text, "Open Argus") || !strings.Contains(got.Title, "in the last 1m30s") {
+		t.Fatalf("message() without a dashboard = %+v", got)
+	}
+}
+
+func TestFormatWindow(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		time.Minute:      "minute",
+		5 * time.Minute:  "5 minutes",
+		time.Hour:        "60 minutes",
+		90 * time.Second: "1m30s",
+	} {
+		if got := formatWindow(d); got != want {
+			t.Errorf("formatWindow(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -10,85 +10,100 @@ import (
 )
 
 type SlackSender struct {
+	name       string
 	webhookURL string
+	template   *MessageTemplate
 	httpClient *http.Client
 }
 
-func NewSlackSender(webhookURL string) *SlackSender {
+func NewSlackSender(name, webhookURL string, tmpl *MessageTemplate) *SlackSender {
 	return &SlackSender{
+		name:       name,
 		webhookURL: webhookURL,
+		template:   tmpl,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
 	}
 }
 
-func (s *SlackSender) SendAlert(ctx context.Context, metricName string, value float64, score float64, severity string) error {
-	// Build alert message
-	emoji := getSeverityEmoji(severity)
-	color := getSeverityColor(severity)
-	
-	message := map[string]interface{}{
-		"attachments": []map[string]interface{}{
-			{
-				"color": color,
-				"blocks": []map[string]interface{}{
-					{
-						"type": "header",
-						"text": map[string]string{
-							"type": "plain_text",
-							"text": fmt.Sprintf("%s Anomaly Detected!", emoji),
-						},
-					},
-					{
-						"type": "section",
-						"fields": []map[string]string{
-							{
-								"type": "mrkdwn",
-								"text": fmt.Sprintf("*Metric:*\n%s", metricName),
-							},
-							{
-								"type": "mrkdwn",
-								"text": fmt.Sprintf("*Severity:*\n%s", severity),
-							},
-							{
-								"type": "mrkdwn",
-								"text": fmt.Sprintf("*Value:*\n%.2f", value),
-							},
-							{
-								"type": "mrkdwn",
-								"text": fmt.Sprintf("*Score:*\n%.3f", score),
-							},
-						},
-					},
-					{
-						"type": "context",
-						"elements": []map[string]string{
-							{
-								"type": "mrkdwn",
-								"text": fmt.Sprintf("Detected at %s", time.Now().Format("2006-01-02 15:04:05")),
-							},
-						},
-					},
-				},
-			},
-		},
-	}
+func (s *SlackSender) Name() string {
+	return s.name
+}
+
+func (s *SlackSender) Render(alert *Alert) (*Message, error) {
+	return s.template.Render(alert)
+}
 
+func (s *SlackSender) Deliver(ctx context.Context, msg *Message) error {
 	// If no webhook URL, just log
 	if s.webhookURL == "" {
-		fmt.Printf("📢 [SLACK ALERT] %s %s anomaly detected: %s = %.2f (score: %.3f)\n",
-			emoji, severity, metricName, value, score)
+		fmt.Printf("📢 [SLACK ALERT:%s] %s\n%s\n", s.name, msg.Title, msg.Text)
 		return nil
 	}
 
 	// Send to Slack
+	return s.post(ctx, s.webhookURL, s.buildMessage(msg))
+}
+
+// buildMessage lays out the Block Kit payload for a rendered message. Open
+// anomalies get action buttons that call back into /api/slack/interactions.
+func (s *SlackSender) buildMessage(msg *Message) map[string]interface{} {
+	footer := fmt.Sprintf("Detected at %s", msg.DetectedAt.Format("2006-01-02 15:04:05"))
+	if msg.Note != "" {
+		footer = msg.Note + " • " + footer
+	}
+
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]string{
+				"type": "plain_text",
+				"text": msg.Title,
+			},
+		},
+		{
+			"type": "section",
+			"text": map[string]string{
+				"type": "mrkdwn",
+				"text": msg.Text,
+			},
+		},
+	}
+	if buttons := slackButtons(msg); len(buttons) > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "actions",
+			"block_id": slackActionBlockPrefix + s.name,
+			"elements": buttons,
+		})
+	}
+	blocks = append(blocks, map[string]interface{}{
+		"type": "context",
+		"elements": []map[string]string{
+			{
+				"type": "mrkdwn",
+				"text": footer,
+			},
+		},
+	})
+
+	return map[string]interface{}{
+		"attachments": []map[string]interface{}{
+			{
+				"color":  getSeverityColor(msg.Severity),
+				"blocks": blocks,
+			},
+		},
+	}
+}
+
+func (s *SlackSender) post(ctx context.Context, url string, message map[string]interface{}) error {
 	body, err := json.Marshal(message)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", s.webhookURL, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
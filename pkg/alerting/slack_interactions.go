@@ -0,0 +1,303 @@
+package alerting
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+const (
+	slackActionBlockPrefix = "argus_actions:"
+	slackSignatureVersion  = "v0"
+	slackMaxRequestAge     = 5 * time.Minute
+)
+
+var ErrInvalidSlackSignature = errors.New("invalid slack signature")
+
+// ErrSlackForbidden is wrapped by a SlackAuthorizer refusing a click.
+var ErrSlackForbidden = errors.New("forbidden")
+
+// SlackAuthorizer decides whether the Slack user userID may change the
+// status of an anomaly. It returns an error wrapping ErrSlackForbidden,
+// with the reason, when they may not.
+type SlackAuthorizer func(ctx context.Context, userID string, anomalyID int) error
+
+type slackAction struct {
+	ID     string
+	Label  string
+	Style  string
+	Status string
+	Snooze time.Duration
+}
+
+var slackActions = []slackAction{
+	{ID: "argus_acknowledge", Label: "Acknowledge", Style: "primary", Status: "acknowledged"},
+	{ID: "argus_resolve", Label: "Resolve", Status: "resolved"},
+	{ID: "argus_snooze_1h", Label: "Snooze 1h", Status: "snoozed", Snooze: time.Hour},
+	{ID: "argus_false_positive", Label: "False positive", Style: "danger", Status: "false_positive"},
+}
+
+// SlackInteraction is the subset of a Slack block_actions payload Argus uses.
+type SlackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Name     string `json:"name"`
+	} `json:"user"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID string `json:"action_id"`
+		BlockID  string `json:"block_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+func slackButtons(msg *Message) []map[string]interface{} {
+	if msg.AnomalyID == 0 {
+		return nil
+	}
+
+	status := msg.Status
+	if status == "" {
+		status = "open"
+	}
+
+	var buttons []map[string]interface{}
+	for _, a := range slackActions {
+		if !storage.CanTransition(status, a.Status) {
+			continue
+		}
+		button := map[string]interface{}{
+			"type":      "button",
+			"action_id": a.ID,
+			"value":     strconv.Itoa(msg.AnomalyID),
+			"text": map[string]string{
+				"type": "plain_text",
+				"text": a.Label,
+			},
+		}
+		if a.Style != "" {
+			button["style"] = a.Style
+		}
+		buttons = append(buttons, button)
+	}
+	return buttons
+}
+
+// SignSlackRequest computes the X-Slack-Signature header value for a body.
+func SignSlackRequest(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%s:", slackSignatureVersion, timestamp)
+	mac.Write(body)
+	return slackSignatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySlackSignature checks the X-Slack-Request-Timestamp and
+// X-Slack-Signature headers of an interaction request and rejects stale
+// requests to prevent replays.
+func VerifySlackSignature(secret, timestamp, signature string, body []byte, now time.Time) error {
+	if secret == "" || timestamp == "" || signature == "" {
+		return ErrInvalidSlackSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSlackSignature
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > slackMaxRequestAge || age < -slackMaxRequestAge {
+		return fmt.Errorf("%w: request timestamp too old", ErrInvalidSlackSignature)
+	}
+
+	expected := SignSlackRequest(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSlackSignature
+	}
+	return nil
+}
+
+// ParseSlackInteraction decodes the form-encoded payload Slack posts to the
+// interactivity request URL.
+func ParseSlackInteraction(body []byte) (*SlackInteraction, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	payload := form.Get("payload")
+	if payload == "" {
+		return nil, errors.New("missing payload")
+	}
+
+	var interaction SlackInteraction
+	if err := json.Unmarshal([]byte(payload), &interaction); err != nil {
+		return nil, err
+	}
+	return &interaction, nil
+}
+
+// HandleSlackInteraction applies the button a user clicked to the anomaly
+// and replaces the original Slack message with its new state. Clicks that
+// authorize refuses leave the anomaly alone and are answered only to the
+// user who clicked.
+func (n *Notifier) HandleSlackInteraction(ctx context.Context, interaction *SlackInteraction, authorize SlackAuthorizer) error {
+	if interaction.Type != "block_actions" {
+		return nil
+	}
+
+	for _, act := range interaction.Actions {
+		if !strings.HasPrefix(act.BlockID, slackActionBlockPrefix) {
+			continue
+		}
+		action := findSlackAction(act.ActionID)
+		if action == nil {
+			continue
+		}
+
+		anomalyID, err := strconv.Atoi(act.Value)
+		if err != nil {
+			return fmt.Errorf("invalid anomaly id %q", act.Value)
+		}
+
+		user := interaction.User.Username
+		if user == "" {
+			user = interaction.User.Name
+		}
+		receiver := strings.TrimPrefix(act.BlockID, slackActionBlockPrefix)
+
+		if err := authorize(ctx, interaction.User.ID, anomalyID); errors.Is(err, ErrSlackForbidden) {
+			log.Printf("🚫 Slack: @%s (%s) may not %s anomaly %d: %v", user, interaction.User.ID, strings.ToLower(action.Label), anomalyID, err)
+			if interaction.ResponseURL != "" {
+				text := fmt.Sprintf("🚫 You can't %s this anomaly (%v).", strings.ToLower(action.Label), err)
+				go n.replySlackUser(receiver, interaction.ResponseURL, text)
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		update := storage.StatusUpdate{Status: action.Status, By: "slack:@" + user}
+		if action.Snooze > 0 {
+			until := time.Now().Add(action.Snooze)
+			update.SnoozedUntil = &until
+		}
+
+		note := fmt.Sprintf("%s by @%s", slackStatusLabel(update), user)
+		if _, err := n.ChangeAnomalyStatus(ctx, anomalyID, update); errors.Is(err, sql.ErrNoRows) {
+			note = fmt.Sprintf("@%s tried to %s, but the anomaly had already changed", user, strings.ToLower(action.Label))
+		} else if err != nil {
+			return err
+		}
+		log.Printf("💬 Slack: %s (anomaly %d)", note, anomalyID)
+
+		if interaction.ResponseURL != "" {
+			go n.refreshSlackMessage(receiver, anomalyID, interaction.ResponseURL, note)
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// refreshSlackMessage re-renders the alert with the anomaly's current status
+// and replaces the original message via the interaction's response_url.
+func (n *Notifier) refreshSlackMessage(receiverName string, anomalyID int, responseURL, note string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	slack, err := n.slackSender(receiverName)
+	if err != nil {
+		log.Printf("⚠️  Cannot update Slack message: %v", err)
+		return
+	}
+
+	alert, err := LoadAlert(ctx, n.db, anomalyID)
+	if err != nil {
+		log.Printf("⚠️  Cannot update Slack message for anomaly %d: %v", anomalyID, err)
+		return
+	}
+	alert.withLinks(n.dashboardURL)
+
+	msg, err := slack.Render(alert)
+	if err != nil {
+		log.Printf("⚠️  Cannot update Slack message for anomaly %d: %v", anomalyID, err)
+		return
+	}
+	msg.Note = note
+
+	message := slack.buildMessage(msg)
+	message["replace_original"] = true
+	if err := slack.post(ctx, responseURL, message); err != nil {
+		log.Printf("⚠️  Failed to update Slack message for anomaly %d: %v", anomalyID, err)
+	}
+}
+
+// replySlackUser shows text to the user behind an interaction only, leaving
+// the original message as it is.
+func (n *Notifier) replySlackUser(receiverName, responseURL, text string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	slack, err := n.slackSender(receiverName)
+	if err != nil {
+		log.Printf("⚠️  Cannot reply in Slack: %v", err)
+		return
+	}
+	message := map[string]interface{}{
+		"response_type":    "ephemeral",
+		"replace_original": false,
+		"text":             text,
+	}
+	if err := slack.post(ctx, responseURL, message); err != nil {
+		log.Printf("⚠️  Failed to reply in Slack: %v", err)
+	}
+}
+
+func (n *Notifier) slackSender(name string) (*SlackSender, error) {
+	r, err := n.receiver(name)
+	if err != nil {
+		return nil, err
+	}
+	slack, ok := r.(*SlackSender)
+	if !ok {
+		return nil, fmt.Errorf("receiver %q is not a Slack receiver", name)
+	}
+	return slack, nil
+}
+
+func findSlackAction(id string) *slackAction {
+	for i := range slackActions {
+		if slackActions[i].ID == id {
+			return &slackActions[i]
+		}
+	}
+	return nil
+}
+
+func slackStatusLabel(update storage.StatusUpdate) string {
+	switch update.Status {
+	case "acknowledged":
+		return "✅ Acknowledged"
+	case "resolved":
+		return "✔️ Resolved"
+	case "snoozed":
+		return "😴 Snoozed until " + update.SnoozedUntil.Format("15:04")
+	case "false_positive":
+		return "🙅 Marked false positive"
+	default:
+		return update.Status
+	}
+}
@@ -0,0 +1,163 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// The fixture is a block_actions request body; its signature was computed
+// independently with HMAC-SHA256 over "v0:<timestamp>:<body>".
+const (
+	fixtureSecret    = "8f742231b10e8888abcd99yyyzzz85a5"
+	fixtureTimestamp = "1531420618"
+	fixtureSignature = "v0=2f3af669be8c3b9d662c9a9185a87d822efd6f7be5e17d1c5b0eaeb6bec2df2a"
+)
+
+func TestVerifySlackSignature(t *testing.T) {
+	body, err := os.ReadFile("testdata/slack_block_actions.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), body...)
+	tampered[len(tampered)-1] ^= 1
+	sent := time.Unix(1531420618, 0)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+		now       time.Time
+		wantErr   bool
+	}{
+		{"valid", fixtureSecret, fixtureTimestamp, fixtureSignature, body, sent, false},
+		{"valid within window", fixtureSecret, fixtureTimestamp, fixtureSignature, body, sent.Add(4 * time.Minute), false},
+		{"tampered body", fixtureSecret, fixtureTimestamp, fixtureSignature, tampered, sent, true},
+		{"wrong secret", "not-the-secret", fixtureTimestamp, fixtureSignature, body, sent, true},
+		{"expired timestamp", fixtureSecret, fixtureTimestamp, fixtureSignature, body, sent.Add(6 * time.Minute), true},
+		{"timestamp in the future", fixtureSecret, fixtureTimestamp, fixtureSignature, body, sent.Add(-6 * time.Minute), true},
+		{"replayed with new timestamp", fixtureSecret, "1531420700", fixtureSignature, body, sent, true},
+		{"malformed timestamp", fixtureSecret, "yesterday", fixtureSignature, body, sent, true},
+		{"wrong version", fixtureSecret, fixtureTimestamp, "v1=" + fixtureSignature[3:], body, sent, true},
+		{"missing signature", fixtureSecret, fixtureTimestamp, "", body, sent, true},
+		{"no secret configured", "", fixtureTimestamp, fixtureSignature, body, sent, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySlackSignature(tt.secret, tt.timestamp, tt.signature, tt.body, tt.now)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSlackSignature) {
+					t.Fatalf("VerifySlackSignature() = %v, want ErrInvalidSlackSignature", err)
+				}
+			} else if err != nil {
+				t.Fatalf("VerifySlackSignature() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestSignSlackRequest(t *testing.T) {
+	body, err := os.ReadFile("testdata/slack_block_actions.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := SignSlackRequest(fixtureSecret, fixtureTimestamp, body); got != fixtureSignature {
+		t.Fatalf("SignSlackRequest() = %s, want %s", got, fixtureSignature)
+	}
+}
+
+func TestParseSlackInteraction(t *testing.T) {
+	body, err := os.ReadFile("testdata/slack_block_actions.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	interaction, err := ParseSlackInteraction(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interaction.Type != "block_actions" || interaction.User.ID != "U2CERLKJA" {
+		t.Fatalf("unexpected interaction %+v", interaction)
+	}
+	if len(interaction.Actions) != 1 || interaction.Actions[0].ActionID != "argus_acknowledge" || interaction.Actions[0].Value != "42" {
+		t.Fatalf("unexpected actions %+v", interaction.Actions)
+	}
+}
+
+// TestHandleSlackInteractionForbidden checks that a refused click leaves
+// the anomaly alone and is answered to the user who clicked only.
+func TestHandleSlackInteractionForbidden(t *testing.T) {
+	replies := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reply map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&reply)
+		replies <- reply
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	n, db := testNotifier(t, &Config{}, NewSlackSender("oncall-slack", "", nil))
+	metric, _, err := db.CreateMetric(ctx, "http_request_duration_seconds", map[string]string{"team": "payments"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anomaly := &storage.Anomaly{MetricID: metric.ID, Timestamp: time.Now(), Value: 2.5, AnomalyScore: 0.9, Severity: "high", Status: "open"}
+	if err := db.CreateAnomaly(ctx, anomaly); err != nil {
+		t.Fatal(err)
+	}
+
+	interaction := &SlackInteraction{Type: "block_actions", ResponseURL: srv.URL}
+	interaction.User.ID = "U2CERLKJA"
+	interaction.User.Username = "roadrunner"
+	interaction.Actions = append(interaction.Actions, struct {
+		ActionID string `json:"action_id"`
+		BlockID  string `json:"block_id"`
+		Value    string `json:"value"`
+	}{"argus_resolve", slackActionBlockPrefix + "oncall-slack", strconv.Itoa(anomaly.ID)})
+
+	var asked string
+	deny := func(ctx context.Context, userID string, anomalyID int) error {
+		asked = fmt.Sprintf("%s %d", userID, anomalyID)
+		return fmt.Errorf("%w: owned by team payments", ErrSlackForbidden)
+	}
+	if err := n.HandleSlackInteraction(ctx, interaction, deny); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("U2CERLKJA %d", anomaly.ID); asked != want {
+		t.Fatalf("authorizer asked about %q, want %q", asked, want)
+	}
+
+	select {
+	case reply := <-replies:
+		want := map[string]interface{}{
+			"response_type":    "ephemeral",
+			"replace_original": false,
+			"text":             "🚫 You can't resolve this anomaly (forbidden: owned by team payments).",
+		}
+		if !reflect.DeepEqual(reply, want) {
+			t.Fatalf("reply = %v, want %v", reply, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to the user")
+	}
+	if got, err := db.GetAnomaly(ctx, anomaly.ID); err != nil || got.Status != "open" {
+		t.Fatalf("anomaly after a refused click = %+v, %v, want open", got, err)
+	}
+
+	// Errors other than a refusal fail the request.
+	broken := errors.New("database is down")
+	if err := n.HandleSlackInteraction(ctx, interaction, func(context.Context, string, int) error { return broken }); !errors.Is(err, broken) {
+		t.Fatalf("HandleSlackInteraction() = %v, want %v", err, broken)
+	}
+}
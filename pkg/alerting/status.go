@@ -0,0 +1,57 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// OnStatusChange registers fn to be called after ChangeAnomalyStatus and
+// after snoozes expire. Call it before the notifier starts.
+func (n *Notifier) OnStatusChange(fn func(ctx context.Context, anomaly storage.Anomaly, previous string)) {
+	n.onStatusChange = fn
+}
+
+// ChangeAnomalyStatus applies a status change and stops any escalation the
+// anomaly is in, unless it is only snoozed. It is shared by the REST API and
+// Slack interactions so both leave the same trail.
+func (n *Notifier) ChangeAnomalyStatus(ctx context.Context, anomalyID int, update storage.StatusUpdate) (*storage.Anomaly, error) {
+	var previous string
+	if n.onStatusChange != nil {
+		if current, err := n.db.GetAnomaly(ctx, anomalyID); err == nil {
+			previous = current.Status
+		}
+	}
+
+	anomaly, err := n.db.UpdateAnomalyStatus(ctx, anomalyID, update)
+	if err != nil {
+		return nil, err
+	}
+	if n.onStatusChange != nil {
+		n.onStatusChange(ctx, *anomaly, previous)
+	}
+
+	if update.Status != "snoozed" && update.Status != "open" {
+		if err := n.db.StopEscalations(ctx, anomalyID, update.Status, statusDetail(update)); err != nil {
+			return anomaly, err
+		}
+	}
+	return anomaly, nil
+}
+
+func statusDetail(update storage.StatusUpdate) string {
+	verb := strings.ReplaceAll(update.Status, "_", " ")
+	if update.Status == "false_positive" {
+		verb = "marked false positive"
+	}
+	detail := verb + " via API"
+	if update.By != "" {
+		detail = fmt.Sprintf("%s by %s", verb, update.By)
+	}
+	if update.OnBehalfOf != "" {
+		detail += " on behalf of " + update.OnBehalfOf
+	}
+	return detail
+}
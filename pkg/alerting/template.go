@@ -0,0 +1,199 @@
+package alerting
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// Template is the user-facing definition of a notification. Both fields are
+// Go text/template strings executed against an *Alert.
+type Template struct {
+	Title string `yaml:"title"`
+	Text  string `yaml:"text"`
+}
+
+// Message is a rendered notification. It is what gets stored in the
+// notification outbox, so retries deliver exactly what was first rendered.
+type Message struct {
+	Title      string            `json:"title"`
+	Text       string            `json:"text"`
+	Severity   string            `json:"severity"`
+	Status     string            `json:"status,omitempty"`
+	Note       string            `json:"note,omitempty"`
+	MetricName string            `json:"metric_name"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	AnomalyID  int               `json:"anomaly_id,omitempty"`
+	IncidentID int               `json:"incident_id,omitempty"`
+	Value      float64           `json:"value"`
+	Score      float64           `json:"score"`
+	RootCause  string            `json:"root_cause,omitempty"`
+	Impact     string            `json:"impact,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	DetectedAt time.Time         `json:"detected_at"`
+}
+
+type MessageTemplate struct {
+	title *template.Template
+	text  *template.Template
+}
+
+var defaultTemplates = map[string]Template{
+	"slack": {
+		Title: `{{ severityEmoji .Anomaly.Severity }} Anomaly Detected!`,
+		Text: `*Metric:* {{ .Metric.MetricName }}{{ range $k, $v := .Metric.Labels }} ` + "`{{ $k }}={{ $v }}`" + `{{ end }}
+*Severity:* {{ .Anomaly.Severity }}    *Value:* {{ printf "%.2f" .Anomaly.Value }}    *Score:* {{ printf "%.3f" .Anomaly.AnomalyScore }}
+{{- with .Anomaly.RootCause }}
+*Root cause:* {{ . }}{{ end }}
+{{- with .Anomaly.Impact }}
+*Impact:* {{ . }}{{ end }}
+{{- with .Recent }}
+*Recent values:* {{ sparkline . }} (last {{ len . }} points)
+{{- end }}
+{{- with .Incident }}
+*Incident:* #{{ .ID }}, {{ .AnomalyCount }} anomalies since {{ formatTime .OpenedAt }}{{ end }}
+{{- with .AnomalyURL }}
+<{{ . }}|View in Argus>{{ end }}`,
+	},
+	"webhook": {
+		Title: `[{{ upper .Anomaly.Severity }}] Anomaly on {{ .Metric.MetricName }}`,
+		Text: `{{ .Metric.MetricName }}{{ with .Metric.Labels }} {{ labels . }}{{ end }} = {{ printf "%.2f" .Anomaly.Value }} (score {{ printf "%.3f" .Anomaly.AnomalyScore }}, {{ join .Anomaly.DetectionMethods ", " }})
+{{- with .Anomaly.RootCause }}
+Root cause: {{ . }}{{ end }}
+{{- with .Anomaly.Impact }}
+Impact: {{ . }}{{ end }}
+{{- with .Incident }}
+Incident #{{ .ID }}: {{ .AnomalyCount }} anomalies since {{ formatTime .OpenedAt }}{{ end }}
+{{- with .AnomalyURL }}
+{{ . }}{{ end }}`,
+	},
+}
+
+var templateFuncs = template.FuncMap{
+	"severityEmoji": getSeverityEmoji,
+	"upper":         strings.ToUpper,
+	"lower":         strings.ToLower,
+	"join":          strings.Join,
+	"labels":        formatLabels,
+	"sparkline":     sparkline,
+	"formatTime": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+}
+
+// DefaultTemplate returns the built-in template for a receiver type.
+func DefaultTemplate(receiverType string) (Template, bool) {
+	t, ok := defaultTemplates[receiverType]
+	return t, ok
+}
+
+func (t Template) Compile(name string) (*MessageTemplate, error) {
+	title, err := template.New(name + ".title").Funcs(templateFuncs).Option("missingkey=error").Parse(t.Title)
+	if err != nil {
+		return nil, fmt.Errorf("title: %w", err)
+	}
+
+	text, err := template.New(name + ".text").Funcs(templateFuncs).Option("missingkey=error").Parse(t.Text)
+	if err != nil {
+		return nil, fmt.Errorf("text: %w", err)
+	}
+
+	return &MessageTemplate{title: title, text: text}, nil
+}
+
+// Validate compiles the template and renders it against a fully populated
+// sample alert, which catches references to fields that do not exist, and
+// against one outside an incident, which catches templates that use
+// .Incident without checking it is set.
+func (t Template) Validate(name string) error {
+	mt, err := t.Compile(name)
+	if err != nil {
+		return err
+	}
+
+	alert := sampleAlert()
+	if _, err := mt.Render(alert); err != nil {
+		return err
+	}
+
+	alert.Anomaly.IncidentID = nil
+	alert.Incident = nil
+	alert.IncidentURL = ""
+	if _, err := mt.Render(alert); err != nil {
+		return fmt.Errorf("without an incident: %w", err)
+	}
+	return nil
+}
+
+func (mt *MessageTemplate) Render(alert *Alert) (*Message, error) {
+	var title, text bytes.Buffer
+	if err := mt.title.Execute(&title, alert); err != nil {
+		return nil, err
+	}
+	if err := mt.text.Execute(&text, alert); err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		Title:      strings.TrimSpace(title.String()),
+		Text:       strings.TrimSpace(text.String()),
+		Severity:   alert.Anomaly.Severity,
+		Status:     alert.Anomaly.Status,
+		MetricName: alert.Metric.MetricName,
+		Labels:     alert.Metric.Labels,
+		AnomalyID:  alert.Anomaly.ID,
+		Value:      alert.Anomaly.Value,
+		Score:      alert.Anomaly.AnomalyScore,
+		RootCause:  alert.Anomaly.RootCause,
+		Impact:     alert.Anomaly.Impact,
+		URL:        alert.AnomalyURL,
+		DetectedAt: alert.DetectedAt,
+	}
+	if alert.Incident != nil {
+		msg.IncidentID = alert.Incident.ID
+	}
+	return msg, nil
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%q", k, labels[k])
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func sparkline(points []storage.MetricDataPoint) string {
+	if len(points) == 0 {
+		return ""
+	}
+
+	bars := []rune("▁▂▃▄▅▆▇█")
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		lo = math.Min(lo, p.Value)
+		hi = math.Max(hi, p.Value)
+	}
+
+	var sb strings.Builder
+	for _, p := range points {
+		idx := 0
+		if hi > lo {
+			idx = int((p.Value - lo) / (hi - lo) * float64(len(bars)-1))
+		}
+		sb.WriteRune(bars[idx])
+	}
+	return sb.String()
+}
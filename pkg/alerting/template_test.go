@@ -0,0 +1,34 @@
+package alerting
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    Template
+		wantErr string
+	}{
+		{"slack default", defaultTemplates["slack"], ""},
+		{"webhook default", defaultTemplates["webhook"], ""},
+		{"guarded incident", Template{Title: "x", Text: "{{ with .Incident }}#{{ .ID }}{{ end }}"}, ""},
+		{"unknown field", Template{Title: "{{ .Anomaly.Nope }}", Text: "x"}, "Nope"},
+		{"parse error", Template{Title: "{{ .Anomaly", Text: "x"}, "title"},
+		{"unguarded incident", Template{Title: "x", Text: "#{{ .Incident.ID }}"}, "without an incident"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tmpl.Validate("test")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("Validate() = nil, want error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookSender posts a rendered alert as JSON to an arbitrary HTTP endpoint.
+type WebhookSender struct {
+	name       string
+	url        string
+	template   *MessageTemplate
+	httpClient *http.Client
+}
+
+type webhookPayload struct {
+	Receiver string `json:"receiver"`
+	*Message
+}
+
+func NewWebhookSender(name, url string, tmpl *MessageTemplate) *WebhookSender {
+	return &WebhookSender{
+		name:     name,
+		url:      url,
+		template: tmpl,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (w *WebhookSender) Name() string {
+	return w.name
+}
+
+func (w *WebhookSender) Render(alert *Alert) (*Message, error) {
+	return w.template.Render(alert)
+}
+
+func (w *WebhookSender) Deliver(ctx context.Context, msg *Message) error {
+	payload := webhookPayload{Receiver: w.name, Message: msg}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+const (
+	defaultAnomalyLimit = 50
+	maxAnomalyLimit     = 500
+)
+
+// parseAnomalyQuery turns GET /api/anomalies parameters into a storage
+// query:
+//
+//	metric_id, metric        exact metric
+//	severity, method         comma separated lists
+//	status                   comma separated list, open by default; all for every status
+//	from, to                 RFC 3339 or unix seconds, on the anomaly timestamp
+//	min_score                lowest anomaly score
+//	label                    name=value, repeatable, matched against metric labels
+//	sort                     created_at, timestamp, score or severity; prefix - for descending
+//	limit, cursor            page size and the next_cursor of the previous page
+func parseAnomalyQuery(params url.Values) (storage.AnomalyQuery, error) {
+	q := storage.AnomalyQuery{
+		MetricName: params.Get("metric"),
+		Severities: splitList(params.Get("severity")),
+		Statuses:   []string{"open"},
+		Methods:    splitList(params.Get("method")),
+		Sort:       "created_at",
+		Desc:       true,
+		Limit:      defaultAnomalyLimit,
+	}
+
+	switch v := params.Get("status"); v {
+	case "":
+	case "all":
+		q.Statuses = nil
+	default:
+		q.Statuses = splitList(v)
+	}
+
+	if v := params.Get("metric_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid metric_id %q", v)
+		}
+		q.MetricID = id
+	}
+
+	for _, name := range []string{"from", "to"} {
+		v := params.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := parseTime(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s %q", name, v)
+		}
+		if name == "from" {
+			q.From = &t
+		} else {
+			q.To = &t
+		}
+	}
+
+	if v := params.Get("min_score"); v != "" {
+		score, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid min_score %q", v)
+		}
+		q.MinScore = &score
+	}
+
+	for _, l := range params["label"] {
+		name, value, ok := strings.Cut(l, "=")
+		if !ok || name == "" {
+			return q, fmt.Errorf("invalid label %q, expected name=value", l)
+		}
+		if q.Labels == nil {
+			q.Labels = make(map[string]string)
+		}
+		q.Labels[name] = value
+	}
+
+	if v := params.Get("sort"); v != "" {
+		q.Desc = strings.HasPrefix(v, "-")
+		q.Sort = strings.TrimPrefix(v, "-")
+		if !storage.ValidAnomalySort(q.Sort) {
+			return q, fmt.Errorf("invalid sort %q", v)
+		}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+		q.Limit = min(l, maxAnomalyLimit)
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err == nil {
+			err = cursor.Validate()
+		}
+		if err != nil {
+			return q, fmt.Errorf("invalid cursor")
+		}
+		sortID := q.Sort
+		if q.Desc {
+			sortID = "-" + q.Sort
+		}
+		if cursor.Sort != sortID {
+			return q, fmt.Errorf("cursor does not match sort %q", sortID)
+		}
+		q.After = &cursor
+	}
+
+	return q, nil
+}
+
+func encodeCursor(c storage.AnomalyCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (storage.AnomalyCursor, error) {
+	var c storage.AnomalyCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseTime accepts RFC 3339 timestamps or unix seconds.
+func parseTime(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// seedQueryAnomalies adds anomalies to the fixture's two with tied scores,
+// every status and a severity the ranking does not know.
+func seedQueryAnomalies(t *testing.T, f *testFixture) {
+	t.Helper()
+	for i, a := range []struct {
+		severity, status string
+		score            float64
+	}{
+		{"low", "open", 0.6},
+		{"medium", "resolved", 0.9},
+		{"warning", "open", 0.6},
+		{"high", "acknowledged", 0.75},
+		{"low", "false_positive", 0.6},
+		{"critical", "open", 0.95},
+	} {
+		anomaly := &storage.Anomaly{
+			MetricID:     f.metric.ID,
+			Timestamp:    f.start.Add(time.Duration(50-i) * time.Minute),
+			Value:        90,
+			AnomalyScore: a.score,
+			Severity:     a.severity,
+			Status:       a.status,
+		}
+		if err := f.db.CreateAnomaly(context.Background(), anomaly); err != nil {
+			t.Fatal(err)
+		}
+		f.anomalies = append(f.anomalies, anomaly)
+	}
+}
+
+func getAnomalies(t *testing.T, f *testFixture, query string) AnomaliesResponse {
+	t.Helper()
+	var resp AnomaliesResponse
+	decodeBody(t, f.do(t, "GET", "/api/anomalies?"+query, ""), &resp)
+	return resp
+}
+
+func responseIDs(resp AnomaliesResponse) []int {
+	ids := []int{}
+	for _, a := range resp.Anomalies {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestGetAnomaliesStatus(t *testing.T) {
+	f := newTestFixture(t)
+	seedQueryAnomalies(t, f)
+	ids := func(statuses ...string) []int {
+		var want []int
+		for i := len(f.anomalies) - 1; i >= 0; i-- {
+			for _, s := range statuses {
+				if f.anomalies[i].Status == s {
+					want = append(want, f.anomalies[i].ID)
+				}
+			}
+		}
+		return want
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"", ids("open")},
+		{"status=all", ids("open", "resolved", "acknowledged", "false_positive")},
+		{"status=resolved,acknowledged", ids("resolved", "acknowledged")},
+		{"status=snoozed", []int{}},
+	}
+	for _, tt := range tests {
+		if got := responseIDs(getAnomalies(t, f, tt.query)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET /api/anomalies?%s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestGetAnomaliesSortAndPaging checks every sort order against one built
+// here, and that paging through it with cursors returns the same anomalies.
+func TestGetAnomaliesSortAndPaging(t *testing.T) {
+	f := newTestFixture(t)
+	seedQueryAnomalies(t, f)
+
+	keys := map[string]func(a *storage.Anomaly) float64{
+		"created_at": func(a *storage.Anomaly) float64 { return float64(a.CreatedAt.UnixNano()) },
+		"timestamp":  func(a *storage.Anomaly) float64 { return float64(a.Timestamp.UnixNano()) },
+		"score":      func(a *storage.Anomaly) float64 { return a.AnomalyScore },
+		// Unknown severities rank below low.
+		"severity": func(a *storage.Anomaly) float64 { return float64(storage.SeverityRank(a.Severity)) },
+	}
+	for name, key := range keys {
+		for _, desc := range []bool{false, true} {
+			sortParam := name
+			if desc {
+				sortParam = "-" + name
+			}
+			t.Run(sortParam, func(t *testing.T) {
+				sorted := append([]*storage.Anomaly(nil), f.anomalies...)
+				sort.Slice(sorted, func(i, j int) bool {
+					a, b := sorted[i], sorted[j]
+					if desc {
+						a, b = b, a
+					}
+					if ka, kb := key(a), key(b); ka != kb {
+						return ka < kb
+					}
+					return a.ID < b.ID
+				})
+				want := []int{}
+				for _, a := range sorted {
+					want = append(want, a.ID)
+				}
+
+				params := url.Values{"status": {"all"}, "sort": {sortParam}, "limit": {"3"}}
+				var got []int
+				for pages := 1; ; pages++ {
+					resp := getAnomalies(t, f, params.Encode())
+					got = append(got, responseIDs(resp)...)
+					if resp.NextCursor == "" {
+						break
+					}
+					if pages == len(want) {
+						t.Fatalf("still paging after %d pages: %v", pages, got)
+					}
+					params.Set("cursor", resp.NextCursor)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("paged through %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestGetAnomaliesBadCursor(t *testing.T) {
+	f := newTestFixture(t)
+	seedQueryAnomalies(t, f)
+	next := getAnomalies(t, f, "status=all&sort=score&limit=2").NextCursor
+	if next == "" {
+		t.Fatal("no next_cursor")
+	}
+	encode := func(c string) string { return base64.RawURLEncoding.EncodeToString([]byte(c)) }
+
+	tests := []struct {
+		name   string
+		query  string
+		cursor string
+	}{
+		{"not base64", "sort=score", "!!"},
+		{"not json", "sort=score", encode(`score`)},
+		{"other sort", "sort=timestamp", next},
+		{"other direction", "sort=-score", next},
+		{"unknown sort", "sort=score", encode(`{"s":"value","v":"1","id":3}`)},
+		{"score not a number", "sort=score", encode(`{"s":"score","v":"high","id":3}`)},
+		{"time not a time", "sort=timestamp", encode(`{"s":"timestamp","v":"yesterday","id":3}`)},
+		{"severity not a rank", "sort=-severity", encode(`{"s":"-severity","v":"critical","id":3}`)},
+		{"no id", "sort=score", encode(`{"s":"score","v":"0.5"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.do(t, "GET", fmt.Sprintf("/api/anomalies?%s&cursor=%s", tt.query, url.QueryEscape(tt.cursor)), "")
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400; body %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const apiKeyPrefix = "argus_"
+
+var (
+	errMissingCredentials = errors.New("missing credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+	errExpiredCredentials = errors.New("credentials expired")
+)
+
+// AuthConfig controls how the API authenticates requests. API keys are always
+// accepted; bearer JWTs are accepted when OIDC is configured.
+type AuthConfig struct {
+	Disabled bool
+	OIDC     *OIDCConfig
+	// OpenTeamless lets any responder act on anomalies and incidents whose
+	// metric has no team label. By default only admins may.
+	OpenTeamless bool
+	// ScrapeToken, when set, is a bearer token that may read
+	// /metrics/anomalies only, so Prometheus needs no API key.
+	ScrapeToken string
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the caller attached by the auth middleware, or nil
+// for public endpoints.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// publicPaths skip authentication. Slack interactions carry their own
+// request signature; /metrics is scraped by Prometheus and the probes are
+// called by Kubernetes. /metrics/anomalies is not public: it carries
+// metric names and team labels.
+var publicPaths = map[string]bool{
+	"/health":                 true,
+	"/livez":                  true,
+	"/readyz":                 true,
+	"/metrics":                true,
+	"/api/openapi.json":       true,
+	"/api/slack/interactions": true,
+}
+
+const anomalyExportPath = "/metrics/anomalies"
+
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" || publicPaths[r.URL.Path] || s.scrapeAuthorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var principal *Principal
+		if s.config.Auth.Disabled {
+			principal = &Principal{Subject: "anonymous", Method: "none", Role: RoleAdmin}
+		} else {
+			var err error
+			principal, err = s.authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="argus"`)
+				respondError(w, http.StatusUnauthorized, "Unauthorized: "+err.Error())
+				return
+			}
+		}
+
+		// Handlers apply team and admin checks; this is only the floor.
+		role := RoleResponder
+		if r.Method == "GET" || r.Method == "HEAD" || readOnlyPrefix(r.URL.Path) {
+			role = RoleViewer
+		}
+		if !principal.HasRole(role) {
+			respondError(w, http.StatusForbidden, "Forbidden: requires "+role+" role")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// readOnlyPrefix reports whether path belongs to an API that uses POST only
+// to carry a query body, such as the Grafana datasource.
+func readOnlyPrefix(path string) bool {
+	return strings.HasPrefix(path, "/api/grafana/")
+}
+
+// scrapeAuthorized reports whether r reads the anomaly export with the
+// configured scrape token.
+func (s *Server) scrapeAuthorized(r *http.Request) bool {
+	scrapeToken := s.config.Auth.ScrapeToken
+	if scrapeToken == "" || r.URL.Path != anomalyExportPath {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(scrapeToken)) == 1
+}
+
+func (s *Server) authenticate(r *http.Request) (*Principal, error) {
+	token := requestToken(r)
+	if token == "" {
+		return nil, errMissingCredentials
+	}
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		return s.authenticateAPIKey(r.Context(), token)
+	}
+	if s.oidc == nil {
+		return nil, errInvalidCredentials
+	}
+	return s.oidc.Verify(r.Context(), token)
+}
+
+// requestToken reads the credential from the Authorization or X-API-Key
+// header. Browsers can't set headers on WebSocket upgrades or EventSource
+// requests, so those may pass it as ?access_token= instead.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if websocket.IsWebSocketUpgrade(r) || r.URL.Path == eventsPath {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+func (s *Server) authenticateAPIKey(ctx context.Context, key string) (*Principal, error) {
+	prefix, ok := apiKeyLookupPrefix(key)
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+
+	k, err := s.db.GetAPIKeyByPrefix(ctx, prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		log.Printf("❌ API key lookup failed: %v", err)
+		return nil, errInvalidCredentials
+	}
+	if subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(k.KeyHash)) != 1 || k.RevokedAt != nil {
+		return nil, errInvalidCredentials
+	}
+	if k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt) {
+		return nil, errExpiredCredentials
+	}
+
+	if err := s.db.TouchAPIKey(ctx, k.ID); err != nil {
+		log.Printf("⚠️  Failed to record API key use: %v", err)
+	}
+	return newPrincipal("apikey:"+k.Name, "api_key", k.Scopes), nil
+}
+
+// GenerateAPIKey returns a new random key of the form argus_<prefix>_<secret>
+// along with the prefix and hash to store. The key itself is never stored.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, 36)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(buf[:4])
+	key = apiKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(buf[4:])
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// HashAPIKey hashes a key for storage. Keys carry 256 bits of randomness, so
+// a plain SHA-256 is enough; a slow KDF would only add latency per request.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func apiKeyLookupPrefix(key string) (string, bool) {
+	rest := strings.TrimPrefix(key, apiKeyPrefix)
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 8 || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// allowedOrigin reports whether a browser origin may call the API. An empty
+// list allows no cross-origin callers; "*" allows any.
+func (s *Server) allowedOrigin(origin string) bool {
+	for _, o := range s.config.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWSOrigin rejects WebSocket upgrades from browser origins that aren't
+// allowed. Non-browser clients send no Origin and are let through to auth.
+func (s *Server) checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || s.allowedOrigin(origin)
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// EventVersion is the version of the EventMessage envelope. It changes when
+// a field is removed or changes meaning; new event types and fields keep
+// the version, so clients should ignore what they do not know.
+const EventVersion = 1
+
+// Event types sent by the hub.
+const (
+	EventAnomalyDetected      = "anomaly_detected"
+	EventAnomalyStatusChanged = "anomaly_status_changed"
+	EventIncidentOpened       = "incident_opened"
+	EventIncidentUpdated      = "incident_updated"
+	EventIncidentResolved     = "incident_resolved"
+	EventMetricDiscovered     = "metric_discovered"
+	EventCycleCompleted       = "cycle_completed"
+	EventDependencyChanged    = "dependency_changed"
+)
+
+// dependencyWatchInterval is how often dependencies are checked for
+// dependency_changed events.
+const dependencyWatchInterval = 30 * time.Second
+
+// EventMessage is the envelope of every event sent to clients. Type says
+// which of the payload fields is set.
+type EventMessage struct {
+	// Seq is set on delivery; see ResumeReply.
+	Seq       int64  `json:"seq,omitempty"`
+	Version   int    `json:"version"`
+	Type      string `json:"type"`
+	Timestamp string `json:"timestamp"`
+
+	// Anomaly is set for anomaly_detected and anomaly_status_changed.
+	Anomaly *AnomalyInfo `json:"anomaly,omitempty"`
+	// PreviousStatus is the anomaly's or dependency's status before an
+	// anomaly_status_changed or dependency_changed event.
+	PreviousStatus string            `json:"previous_status,omitempty"`
+	Incident       *IncidentInfo     `json:"incident,omitempty"`
+	Metric         *MetricInfo       `json:"metric,omitempty"`
+	Cycle          *CycleInfo        `json:"cycle,omitempty"`
+	Dependency     *DependencyStatus `json:"dependency,omitempty"`
+}
+
+type IncidentInfo struct {
+	ID             int    `json:"id"`
+	MetricID       int    `json:"metric_id"`
+	MetricName     string `json:"metric_name,omitempty"`
+	Status         string `json:"status"`
+	Severity       string `json:"severity"`
+	AnomalyCount   int    `json:"anomaly_count"`
+	OpenedAt       string `json:"opened_at"`
+	UpdatedAt      string `json:"updated_at"`
+	ResolvedAt     string `json:"resolved_at,omitempty"`
+	AcknowledgedAt string `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string `json:"acknowledged_by,omitempty"`
+	OnBehalfOf     string `json:"acknowledged_on_behalf_of,omitempty"`
+}
+
+// CycleInfo describes a completed collector or detector cycle. Stats are
+// counts that depend on the worker, such as metrics and anomalies.
+type CycleInfo struct {
+	Worker     string         `json:"worker"`
+	DurationMs float64        `json:"duration_ms"`
+	Stats      map[string]int `json:"stats"`
+}
+
+func newIncidentInfo(inc storage.Incident, metric storage.Metric) IncidentInfo {
+	info := IncidentInfo{
+		ID:             inc.ID,
+		MetricID:       inc.MetricID,
+		MetricName:     metric.MetricName,
+		Status:         inc.Status,
+		Severity:       inc.Severity,
+		AnomalyCount:   inc.AnomalyCount,
+		OpenedAt:       inc.OpenedAt.Format("2006-01-02T15:04:05Z"),
+		UpdatedAt:      inc.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+		AcknowledgedBy: inc.AcknowledgedBy,
+		OnBehalfOf:     inc.AcknowledgedOnBehalfOf,
+	}
+	if inc.ResolvedAt != nil {
+		info.ResolvedAt = inc.ResolvedAt.Format("2006-01-02T15:04:05Z")
+	}
+	if inc.AcknowledgedAt != nil {
+		info.AcknowledgedAt = inc.AcknowledgedAt.Format("2006-01-02T15:04:05Z")
+	}
+	return info
+}
+
+// emit sends an event to the clients whose subscriptions match the metric,
+// severity and team given.
+func (h *Hub) emit(message EventMessage, metric storage.Metric, severity string) {
+	message.Version = EventVersion
+	message.Timestamp = timeNow()
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("❌ Failed to marshal %s event: %v", message.Type, err)
+		return
+	}
+	h.publish(&hubEvent{
+		Type:     message.Type,
+		Metric:   metric.MetricName,
+		Severity: severity,
+		Team:     metric.Labels[TeamLabel],
+		Key:      eventKey(message),
+		Data:     data,
+	})
+}
+
+// eventKey names what an event is about, so that a slow client can be sent
+// only the latest of several events about the same thing. Detections and
+// discoveries are each news, and have none.
+func eventKey(m EventMessage) string {
+	switch {
+	case m.Type == EventAnomalyStatusChanged:
+		return fmt.Sprintf("anomaly:%d", m.Anomaly.ID)
+	case m.Incident != nil:
+		return fmt.Sprintf("incident:%d", m.Incident.ID)
+	case m.Cycle != nil:
+		return "cycle:" + m.Cycle.Worker
+	case m.Dependency != nil:
+		return "dependency:" + m.Dependency.Name
+	}
+	return ""
+}
+
+// BroadcastAnomaly sends a newly detected anomaly to the clients subscribed
+// to it.
+func (h *Hub) BroadcastAnomaly(anomaly storage.Anomaly, metric storage.Metric) {
+	info := newAnomalyInfo(anomaly)
+	info.MetricName = metric.MetricName
+	h.emit(EventMessage{Type: EventAnomalyDetected, Anomaly: &info}, metric, anomaly.Severity)
+}
+
+// BroadcastStatusChange sends an anomaly that moved from status previous.
+func (h *Hub) BroadcastStatusChange(anomaly storage.Anomaly, metric storage.Metric, previous string) {
+	info := newAnomalyInfo(anomaly)
+	info.MetricName = metric.MetricName
+	h.emit(EventMessage{Type: EventAnomalyStatusChanged, Anomaly: &info, PreviousStatus: previous}, metric, anomaly.Severity)
+}
+
+// BroadcastIncident sends an incident that was opened, updated or resolved.
+// A resolved incident is sent as incident_resolved, an open one holding a
+// single anomaly as incident_opened, and any other as incident_updated.
+func (h *Hub) BroadcastIncident(incident storage.Incident, metric storage.Metric) {
+	eventType := EventIncidentUpdated
+	switch {
+	case incident.Status == "resolved":
+		eventType = EventIncidentResolved
+	case incident.Status == "open" && incident.AnomalyCount == 1:
+		eventType = EventIncidentOpened
+	}
+	info := newIncidentInfo(incident, metric)
+	h.emit(EventMessage{Type: eventType, Incident: &info}, metric, incident.Severity)
+}
+
+// BroadcastMetricDiscovered sends a metric the collector saw for the first
+// time.
+func (h *Hub) BroadcastMetricDiscovered(metric storage.Metric) {
+	info := newMetricInfo(metric)
+	h.emit(EventMessage{Type: EventMetricDiscovered, Metric: &info}, metric, "")
+}
+
+// BroadcastCycle sends the stats of a completed worker cycle.
+func (h *Hub) BroadcastCycle(worker string, duration time.Duration, stats map[string]int) {
+	h.emit(EventMessage{Type: EventCycleCompleted, Cycle: &CycleInfo{
+		Worker:     worker,
+		DurationMs: float64(duration.Microseconds()) / 1000,
+		Stats:      stats,
+	}}, storage.Metric{}, "")
+}
+
+// broadcastDependency sends a dependency whose status changed.
+func (h *Hub) broadcastDependency(status DependencyStatus, previous string) {
+	h.emit(EventMessage{Type: EventDependencyChanged, Dependency: &status, PreviousStatus: previous}, storage.Metric{}, "")
+}
+
+// publishStatusChange broadcasts a status change made through the notifier.
+func (s *Server) publishStatusChange(ctx context.Context, anomaly storage.Anomaly, previous string) {
+	metric, err := s.db.GetMetric(ctx, anomaly.MetricID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load metric %d for a status event: %v", anomaly.MetricID, err)
+		metric = &storage.Metric{ID: anomaly.MetricID}
+	}
+	s.hub.BroadcastStatusChange(anomaly, *metric, previous)
+}
+
+// publishIncidentAcknowledged broadcasts an acknowledged incident and the
+// anomalies acknowledged with it.
+func (s *Server) publishIncidentAcknowledged(ctx context.Context, incident storage.Incident, anomalyIDs []int) {
+	metric, err := s.db.GetMetric(ctx, incident.MetricID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load metric %d for an incident event: %v", incident.MetricID, err)
+		metric = &storage.Metric{ID: incident.MetricID}
+	}
+	s.hub.BroadcastIncident(incident, *metric)
+	for _, id := range anomalyIDs {
+		if anomaly, err := s.db.GetAnomaly(ctx, id); err == nil {
+			s.hub.BroadcastStatusChange(*anomaly, *metric, "open")
+		}
+	}
+}
+
+// watchDependencies checks dependencies every dependencyWatchInterval and
+// broadcasts the ones whose status changed. Every replica reports what it
+// sees itself.
+func (s *Server) watchDependencies(ctx context.Context) {
+	ticker := time.NewTicker(dependencyWatchInterval)
+	defer ticker.Stop()
+
+	last := make(map[string]string)
+	for {
+		statuses := s.checkDependencies(ctx, false)
+		if ctx.Err() != nil {
+			return
+		}
+		for _, d := range statuses {
+			if previous, ok := last[d.Name]; ok && previous != d.Status {
+				log.Printf("📡 Dependency %s is %s (was %s)", d.Name, d.Status, previous)
+				s.hub.broadcastDependency(d, previous)
+			}
+			last[d.Name] = d.Status
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
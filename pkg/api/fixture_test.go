@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/alerting"
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/storage/embedded"
+)
+
+// testFixture is a server backed by an embedded store holding one metric
+// with an hour of data, a baseline, two anomalies in one incident and a
+// notification.
+type testFixture struct {
+	server    *Server
+	db        *embedded.Store
+	metric    *storage.Metric
+	anomalies []*storage.Anomaly
+	incident  *storage.Incident
+	start     time.Time
+}
+
+func newTestFixture(t *testing.T) *testFixture {
+	t.Helper()
+	ctx := context.Background()
+
+	db, err := embedded.Open(t.TempDir(), embedded.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	f := &testFixture{db: db, start: time.Now().Add(-time.Hour).Truncate(time.Minute)}
+	f.metric, _, err = db.CreateMetric(ctx, "http_request_duration_seconds", map[string]string{"job": "api", "team": "payments"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var points []storage.MetricDataPoint
+	var expected []storage.ExpectedPoint
+	for i := 0; i < 60; i++ {
+		ts := f.start.Add(time.Duration(i) * time.Minute)
+		points = append(points, storage.MetricDataPoint{MetricID: f.metric.ID, Timestamp: ts, Value: 40 + float64(i%5)})
+		expected = append(expected, storage.ExpectedPoint{Timestamp: ts, Value: 42, Lower: 38, Upper: 46})
+	}
+	if err := db.InsertMetricData(ctx, points); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertBaseline(ctx, f.metric.ID, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, severity := range []string{"high", "critical"} {
+		a := &storage.Anomaly{
+			MetricID:         f.metric.ID,
+			Timestamp:        f.start.Add(time.Duration(30+i) * time.Minute),
+			Value:            97.5,
+			AnomalyScore:     0.9,
+			DetectionMethods: []string{"stl", "isolation_forest"},
+			Severity:         severity,
+			Status:           "open",
+			RootCause:        "Extreme spike detected",
+			Impact:           "Investigate",
+		}
+		if err := db.CreateAnomaly(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+		if f.incident, err = db.AttachToIncident(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+		f.anomalies = append(f.anomalies, a)
+	}
+
+	anomalyID := f.anomalies[0].ID
+	if err := db.EnqueueNotification(ctx, &storage.Notification{
+		Receiver:  "slack",
+		AnomalyID: &anomalyID,
+		Payload:   json.RawMessage(`{"title":"Anomaly Detected!","text":"x","severity":"high","metric_name":"http_request_duration_seconds","value":97.5,"score":0.9,"detected_at":"2026-01-01T00:00:00Z"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier, err := alerting.NewNotifier(alerting.DefaultConfig(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.server = NewServer(db, notifier, Config{Auth: AuthConfig{Disabled: true}})
+	return f
+}
+
+// do sends a request through the server's full handler chain.
+func (f *testFixture) do(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	f.server.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, out interface{}) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+}
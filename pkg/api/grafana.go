@@ -0,0 +1,412 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// Grafana SimpleJSON datasource API, mounted at /api/grafana. Point a
+// SimpleJSON (or JSON API) datasource at http://argus:8080/api/grafana and
+// send an API key in the X-API-Key header.
+//
+// Query targets are metric names, optionally wrapped in a function:
+//
+//	http_requests_total            average per interval
+//	min(m), max(m), count(m)       other aggregates per interval
+//	expected(m), lower(m), upper(m) the detector's expected band
+//	score(m)                       anomaly scores at the anomalies' timestamps
+//
+// Annotation queries are space-separated key=value filters:
+//
+//	type=anomalies|incidents  (default anomalies)
+//	metric=<name>  severity=high,critical  status=open  label.<name>=<value>
+
+const maxGrafanaAnnotations = 1000
+
+var grafanaFunctions = map[string]bool{
+	"min": true, "max": true, "count": true,
+	"expected": true, "lower": true, "upper": true,
+	"score": true,
+}
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type GrafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+type GrafanaQueryRequest struct {
+	Range         grafanaRange `json:"range"`
+	IntervalMs    int64        `json:"intervalMs"`
+	MaxDataPoints int          `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+type GrafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type GrafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []GrafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type GrafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type GrafanaAnnotationRequest struct {
+	Range      grafanaRange    `json:"range"`
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+type GrafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	Time       int64           `json:"time"`
+	TimeEnd    int64           `json:"timeEnd,omitempty"`
+	IsRegion   bool            `json:"isRegion,omitempty"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// handleGrafanaTest answers the datasource's "Save & test".
+func (s *Server) handleGrafanaTest(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var req GrafanaSearchRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	metrics, err := s.db.GetMetrics(r.Context())
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch metrics")
+		return
+	}
+
+	names := []string{}
+	for _, m := range metrics {
+		if strings.Contains(m.MetricName, req.Target) {
+			names = append(names, m.MetricName)
+		}
+	}
+	sort.Strings(names)
+	respondJSON(w, http.StatusOK, names)
+}
+
+func (s *Server) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var req GrafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !req.Range.From.Before(req.Range.To) {
+		respondError(w, http.StatusBadRequest, "range.from must be before range.to")
+		return
+	}
+
+	maxPoints := req.MaxDataPoints
+	if maxPoints <= 0 || maxPoints > maxSeriesMaxPoints {
+		maxPoints = defaultSeriesMaxPoints
+	}
+	step := seriesStep(req.Range.From, req.Range.To, time.Duration(req.IntervalMs)*time.Millisecond, maxPoints)
+
+	ctx := r.Context()
+	results := []interface{}{}
+	for _, t := range req.Targets {
+		if t.Target == "" {
+			continue
+		}
+		fn, name := parseGrafanaTarget(t.Target)
+
+		metric, err := s.db.GetMetricByName(ctx, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Unknown metric %q", name))
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to fetch metric")
+			return
+		}
+
+		if t.Type == "table" {
+			table, err := s.grafanaAnomalyTable(r, metric, req.Range)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to fetch anomalies")
+				return
+			}
+			results = append(results, table)
+			continue
+		}
+
+		series := GrafanaTimeSeries{Target: t.Target, Datapoints: [][2]float64{}}
+		switch fn {
+		case "expected", "lower", "upper":
+			points, err := s.db.GetBaseline(ctx, metric.ID, req.Range.From, req.Range.To, step)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to fetch baseline")
+				return
+			}
+			for _, p := range points {
+				v := map[string]float64{"expected": p.Value, "lower": p.Lower, "upper": p.Upper}[fn]
+				series.Datapoints = append(series.Datapoints, [2]float64{v, unixMillis(p.Timestamp)})
+			}
+		case "score":
+			page, err := s.db.QueryAnomalies(ctx, storage.AnomalyQuery{
+				MetricID: metric.ID,
+				From:     &req.Range.From,
+				To:       &req.Range.To,
+				Sort:     "timestamp",
+				Limit:    maxGrafanaAnnotations,
+			})
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to fetch anomalies")
+				return
+			}
+			for _, a := range page.Anomalies {
+				series.Datapoints = append(series.Datapoints, [2]float64{a.AnomalyScore, unixMillis(a.Timestamp)})
+			}
+		default:
+			points, err := s.db.GetMetricSeries(ctx, metric.ID, req.Range.From, req.Range.To, step)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to fetch series")
+				return
+			}
+			for _, p := range points {
+				v := map[string]float64{"": p.Avg, "min": p.Min, "max": p.Max, "count": float64(p.Count)}[fn]
+				series.Datapoints = append(series.Datapoints, [2]float64{v, unixMillis(p.Timestamp)})
+			}
+		}
+		results = append(results, series)
+	}
+
+	respondJSON(w, http.StatusOK, results)
+}
+
+func (s *Server) grafanaAnomalyTable(r *http.Request, metric *storage.Metric, rng grafanaRange) (GrafanaTable, error) {
+	page, err := s.db.QueryAnomalies(r.Context(), storage.AnomalyQuery{
+		MetricID: metric.ID,
+		From:     &rng.From,
+		To:       &rng.To,
+		Sort:     "timestamp",
+		Desc:     true,
+		Limit:    maxGrafanaAnnotations,
+	})
+	if err != nil {
+		return GrafanaTable{}, err
+	}
+
+	table := GrafanaTable{
+		Type: "table",
+		Columns: []GrafanaColumn{
+			{Text: "Time", Type: "time"},
+			{Text: "Metric", Type: "string"},
+			{Text: "Severity", Type: "string"},
+			{Text: "Status", Type: "string"},
+			{Text: "Score", Type: "number"},
+			{Text: "Value", Type: "number"},
+			{Text: "Root cause", Type: "string"},
+		},
+		Rows: [][]interface{}{},
+	}
+	for _, a := range page.Anomalies {
+		table.Rows = append(table.Rows, []interface{}{
+			unixMillis(a.Timestamp), a.MetricName, a.Severity, a.Status, a.AnomalyScore, a.Value, a.RootCause,
+		})
+	}
+	return table, nil
+}
+
+func (s *Server) handleGrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	var req GrafanaAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var annotation struct {
+		Query string `json:"query"`
+	}
+	if len(req.Annotation) > 0 {
+		if err := json.Unmarshal(req.Annotation, &annotation); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid annotation")
+			return
+		}
+	}
+
+	filter, err := parseAnnotationQuery(annotation.Query)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var annotations []GrafanaAnnotation
+	if filter.incidents {
+		annotations, err = s.incidentAnnotations(r, filter, req.Range)
+	} else {
+		annotations, err = s.anomalyAnnotations(r, filter, req.Range)
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch annotations")
+		return
+	}
+
+	for i := range annotations {
+		annotations[i].Annotation = req.Annotation
+	}
+	respondJSON(w, http.StatusOK, annotations)
+}
+
+type annotationFilter struct {
+	incidents  bool
+	metric     string
+	severities []string
+	statuses   []string
+	labels     map[string]string
+}
+
+func parseAnnotationQuery(query string) (annotationFilter, error) {
+	var f annotationFilter
+	for _, term := range strings.Fields(query) {
+		key, value, ok := strings.Cut(term, "=")
+		if !ok {
+			return f, fmt.Errorf("invalid annotation filter %q, expected key=value", term)
+		}
+		switch {
+		case key == "type":
+			switch value {
+			case "anomalies":
+			case "incidents":
+				f.incidents = true
+			default:
+				return f, fmt.Errorf("invalid type %q, expected anomalies or incidents", value)
+			}
+		case key == "metric":
+			f.metric = value
+		case key == "severity":
+			f.severities = splitList(value)
+		case key == "status":
+			f.statuses = splitList(value)
+		case strings.HasPrefix(key, "label."):
+			if f.labels == nil {
+				f.labels = make(map[string]string)
+			}
+			f.labels[strings.TrimPrefix(key, "label.")] = value
+		default:
+			return f, fmt.Errorf("unknown annotation filter %q", key)
+		}
+	}
+	return f, nil
+}
+
+func (s *Server) anomalyAnnotations(r *http.Request, f annotationFilter, rng grafanaRange) ([]GrafanaAnnotation, error) {
+	page, err := s.db.QueryAnomalies(r.Context(), storage.AnomalyQuery{
+		MetricName: f.metric,
+		Severities: f.severities,
+		Statuses:   f.statuses,
+		Labels:     f.labels,
+		From:       &rng.From,
+		To:         &rng.To,
+		Sort:       "timestamp",
+		Limit:      maxGrafanaAnnotations,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := make([]GrafanaAnnotation, len(page.Anomalies))
+	for i, a := range page.Anomalies {
+		text := fmt.Sprintf("Value %.2f, score %.2f, status %s", a.Value, a.AnomalyScore, a.Status)
+		if a.RootCause != "" {
+			text += "<br>Root cause: " + a.RootCause
+		}
+		if a.Impact != "" {
+			text += "<br>Impact: " + a.Impact
+		}
+		annotations[i] = GrafanaAnnotation{
+			Time:  a.Timestamp.UnixMilli(),
+			Title: fmt.Sprintf("%s anomaly on %s", a.Severity, a.MetricName),
+			Text:  text,
+			Tags:  append([]string{"argus", a.Severity, a.Status, a.MetricName}, a.DetectionMethods...),
+		}
+	}
+	return annotations, nil
+}
+
+func (s *Server) incidentAnnotations(r *http.Request, f annotationFilter, rng grafanaRange) ([]GrafanaAnnotation, error) {
+	incidents, err := s.db.ListIncidents(r.Context(), storage.IncidentFilter{
+		MetricName: f.metric,
+		Labels:     f.labels,
+		Severities: f.severities,
+		Statuses:   f.statuses,
+		From:       rng.From,
+		To:         rng.To,
+		Limit:      maxGrafanaAnnotations,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := make([]GrafanaAnnotation, len(incidents))
+	for i, inc := range incidents {
+		end := time.Now()
+		if inc.ResolvedAt != nil {
+			end = *inc.ResolvedAt
+		}
+		text := fmt.Sprintf("%d anomalies, status %s", inc.AnomalyCount, inc.Status)
+		if inc.AcknowledgedBy != "" {
+			text += ", acknowledged by " + inc.AcknowledgedBy
+		}
+		if inc.AcknowledgedOnBehalfOf != "" {
+			text += " on behalf of " + inc.AcknowledgedOnBehalfOf
+		}
+		annotations[i] = GrafanaAnnotation{
+			Time:     inc.OpenedAt.UnixMilli(),
+			TimeEnd:  end.UnixMilli(),
+			IsRegion: true,
+			Title:    fmt.Sprintf("Incident #%d on %s (%s)", inc.ID, inc.MetricName, inc.Severity),
+			Text:     text,
+			Tags:     []string{"argus", "incident", inc.Severity, inc.Status, inc.MetricName},
+		}
+	}
+	return annotations, nil
+}
+
+// parseGrafanaTarget splits "max(name)" into its function and metric name.
+// Names without a known function wrapper are plain metric names, so
+// recording-rule names with colons or parentheses still work.
+func parseGrafanaTarget(target string) (string, string) {
+	if open := strings.IndexByte(target, '('); open > 0 && strings.HasSuffix(target, ")") {
+		if fn := target[:open]; grafanaFunctions[fn] {
+			return fn, target[open+1 : len(target)-1]
+		}
+	}
+	return "", target
+}
+
+func unixMillis(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
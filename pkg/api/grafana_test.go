@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// grafanaFixture reads a request body recorded from Grafana's SimpleJSON
+// datasource, with its time range moved onto the test fixture's hour. The
+// range runs a minute past it so that it includes the fixture's incident,
+// which opened when the fixture was built.
+func grafanaFixture(t *testing.T, f *testFixture, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "grafana", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.NewReplacer(
+		"{{from}}", f.start.UTC().Format("2006-01-02T15:04:05.000Z"),
+		"{{to}}", f.start.Add(time.Hour+time.Minute).UTC().Format("2006-01-02T15:04:05.000Z"),
+	).Replace(string(b))
+}
+
+func TestGrafanaSearch(t *testing.T) {
+	f := newTestFixture(t)
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"fixture", grafanaFixture(t, f, "search.json"), []string{"http_request_duration_seconds"}},
+		{"empty target", `{"target":""}`, []string{"http_request_duration_seconds"}},
+		{"no body", "", []string{"http_request_duration_seconds"}},
+		{"no match", `{"target":"node_cpu"}`, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			decodeBody(t, f.do(t, "POST", "/api/grafana/search", tt.body), &got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("search = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrafanaQuery(t *testing.T) {
+	f := newTestFixture(t)
+
+	var results []json.RawMessage
+	decodeBody(t, f.do(t, "POST", "/api/grafana/query", grafanaFixture(t, f, "query.json")), &results)
+	// The hidden target with no expression is skipped.
+	if len(results) != 5 {
+		t.Fatalf("got %d results, want 5: %s", len(results), results)
+	}
+
+	from, to := float64(f.start.UnixMilli()), float64(f.start.Add(time.Hour).UnixMilli())
+	series := func(i int, target string, wantPoints int, check func(v float64) bool) {
+		t.Helper()
+		var s GrafanaTimeSeries
+		if err := json.Unmarshal(results[i], &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Target != target {
+			t.Errorf("results[%d].target = %q, want %q", i, s.Target, target)
+		}
+		if len(s.Datapoints) != wantPoints {
+			t.Errorf("%s: %d datapoints, want %d", target, len(s.Datapoints), wantPoints)
+		}
+		for j, p := range s.Datapoints {
+			if !check(p[0]) {
+				t.Errorf("%s: datapoint %d value %v out of range", target, j, p[0])
+			}
+			if p[1] < from || p[1] > to || (j > 0 && p[1] <= s.Datapoints[j-1][1]) {
+				t.Errorf("%s: datapoint %d timestamp %v out of order or range", target, j, p[1])
+			}
+		}
+	}
+	// One point per minute: intervalMs wins over the much finer maxDataPoints.
+	series(0, "http_request_duration_seconds", 60, func(v float64) bool { return v >= 40 && v <= 44 })
+	series(1, "expected(http_request_duration_seconds)", 60, func(v float64) bool { return v == 42 })
+	series(2, "upper(http_request_duration_seconds)", 60, func(v float64) bool { return v == 46 })
+	series(3, "score(http_request_duration_seconds)", 2, func(v float64) bool { return v == 0.9 })
+
+	var table GrafanaTable
+	if err := json.Unmarshal(results[4], &table); err != nil {
+		t.Fatal(err)
+	}
+	if table.Type != "table" || len(table.Columns) != 7 || len(table.Rows) != 2 {
+		t.Fatalf("table = %+v", table)
+	}
+	// Newest first.
+	if table.Rows[0][2] != "critical" || table.Rows[1][2] != "high" {
+		t.Errorf("table severities = %v, %v, want critical, high", table.Rows[0][2], table.Rows[1][2])
+	}
+	for i, row := range table.Rows {
+		if len(row) != len(table.Columns) {
+			t.Errorf("row %d has %d cells for %d columns", i, len(row), len(table.Columns))
+		}
+	}
+}
+
+func TestGrafanaQueryErrors(t *testing.T) {
+	f := newTestFixture(t)
+	body := grafanaFixture(t, f, "query.json")
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"unknown metric", strings.Replace(body, `"expected(http_request_duration_seconds)"`, `"expected(node_cpu)"`, 1)},
+		{"reversed range", strings.NewReplacer(`"from": "`, `"to": "`, `"to": "`, `"from": "`).Replace(body)},
+		{"not json", "range=now-1h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := f.do(t, "POST", "/api/grafana/query", tt.body); rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestGrafanaAnnotations(t *testing.T) {
+	f := newTestFixture(t)
+	anomalies := grafanaFixture(t, f, "annotations_anomalies.json")
+	incidents := grafanaFixture(t, f, "annotations_incidents.json")
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantTitles []string
+		wantRegion bool
+	}{
+		{
+			name:       "anomalies",
+			body:       anomalies,
+			wantStatus: http.StatusOK,
+			wantTitles: []string{"critical anomaly on http_request_duration_seconds"},
+		},
+		{
+			name:       "incidents",
+			body:       incidents,
+			wantStatus: http.StatusOK,
+			wantTitles: []string{fmt.Sprintf("Incident #%d on http_request_duration_seconds (critical)", f.incident.ID)},
+			wantRegion: true,
+		},
+		{
+			name:       "other team",
+			body:       strings.Replace(incidents, "label.team=payments", "label.team=search", 1),
+			wantStatus: http.StatusOK,
+			wantTitles: []string{},
+		},
+		{
+			name:       "bad filter",
+			body:       strings.Replace(anomalies, "severity=critical", "severity", 1),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown filter",
+			body:       strings.Replace(anomalies, "severity=critical", "owner=ana", 1),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.do(t, "POST", "/api/grafana/annotations", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got []GrafanaAnnotation
+			decodeBody(t, rec, &got)
+			titles := []string{}
+			for _, a := range got {
+				titles = append(titles, a.Title)
+			}
+			if !reflect.DeepEqual(titles, tt.wantTitles) {
+				t.Fatalf("titles = %q, want %q", titles, tt.wantTitles)
+			}
+
+			var req struct {
+				Annotation json.RawMessage `json:"annotation"`
+			}
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			for _, a := range got {
+				// Grafana matches results to the annotation query that
+				// asked for them, so it must come back unchanged.
+				if !jsonEqual(t, a.Annotation, req.Annotation) {
+					t.Errorf("annotation = %s, want %s", a.Annotation, req.Annotation)
+				}
+				if a.IsRegion != tt.wantRegion || (tt.wantRegion && a.TimeEnd < a.Time) {
+					t.Errorf("region = %v %d..%d, want region %v", a.IsRegion, a.Time, a.TimeEnd, tt.wantRegion)
+				}
+				if len(a.Tags) == 0 || a.Tags[0] != "argus" {
+					t.Errorf("tags = %v", a.Tags)
+				}
+			}
+		})
+	}
+}
+
+func jsonEqual(t *testing.T, a, b json.RawMessage) bool {
+	t.Helper()
+	var x, y interface{}
+	if err := json.Unmarshal(a, &x); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(x, y)
+}
@@ -1,18 +1,32 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mjrtuhin/argus/pkg/storage"
 )
 
+// How long /health waits for the database to answer.
+const healthCheckTimeout = 2 * time.Second
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
 type HealthResponse struct {
-	Status  string `json:"status"`
-	Service string `json:"service"`
-	Time    string `json:"time"`
+	Status   string `json:"status"`
+	Service  string `json:"service"`
+	Time     string `json:"time"`
+	Database string `json:"database"`
+	Error    string `json:"error,omitempty"`
 }
 
 type MetricsResponse struct {
@@ -21,15 +35,17 @@ type MetricsResponse struct {
 }
 
 type MetricInfo struct {
-	ID              int    `json:"id"`
-	MetricName      string `json:"metric_name"`
-	IsActive        bool   `json:"is_active"`
-	LastCollectedAt string `json:"last_collected_at,omitempty"`
+	ID              int               `json:"id"`
+	MetricName      string            `json:"metric_name"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	IsActive        bool              `json:"is_active"`
+	LastCollectedAt string            `json:"last_collected_at,omitempty"`
 }
 
 type AnomaliesResponse struct {
-	Anomalies []AnomalyInfo `json:"anomalies"`
-	Total     int           `json:"total"`
+	Anomalies  []AnomalyInfo `json:"anomalies"`
+	Total      int           `json:"total"`
+	NextCursor string        `json:"next_cursor,omitempty"`
 }
 
 type AnomalyInfo struct {
@@ -44,14 +60,60 @@ type AnomalyInfo struct {
 	Status           string   `json:"status"`
 	RootCause        string   `json:"root_cause"`
 	Impact           string   `json:"impact"`
+	IncidentID       *int     `json:"incident_id,omitempty"`
+	AcknowledgedAt   string   `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy   string   `json:"acknowledged_by,omitempty"`
+	OnBehalfOf       string   `json:"acknowledged_on_behalf_of,omitempty"`
+	ResolvedAt       string   `json:"resolved_at,omitempty"`
+	SnoozedUntil     string   `json:"snoozed_until,omitempty"`
 	CreatedAt        string   `json:"created_at"`
 }
 
+type AnomalyDetail struct {
+	AnomalyInfo
+	Escalation []EscalationEventInfo `json:"escalation"`
+}
+
+type EscalationEventInfo struct {
+	Step      int    `json:"step"`
+	Receiver  string `json:"receiver,omitempty"`
+	Event     string `json:"event"`
+	Detail    string `json:"detail,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// AcknowledgeRequest and StatusRequest take an optional "by": who the
+// caller acts for. The caller is always recorded as acknowledged_by.
+type AcknowledgeRequest struct {
+	By string `json:"by"`
+}
+
+type StatusRequest struct {
+	Status        string `json:"status"`
+	By            string `json:"by"`
+	SnoozeMinutes int    `json:"snooze_minutes,omitempty"`
+}
+
+type IncidentAcknowledgeResponse struct {
+	IncidentID   int    `json:"incident_id"`
+	Status       string `json:"status"`
+	Acknowledged []int  `json:"acknowledged_anomalies"`
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	response := HealthResponse{
-		Status:  "healthy",
-		Service: "argus-api",
-		Time:    timeNow(),
+		Status:   "healthy",
+		Service:  "argus-api",
+		Time:     timeNow(),
+		Database: "ok",
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	if err := s.db.Ping(ctx); err != nil {
+		response.Status, response.Database, response.Error = "unhealthy", "unavailable", err.Error()
+		respondJSON(w, http.StatusServiceUnavailable, response)
+		return
 	}
 	respondJSON(w, http.StatusOK, response)
 }
@@ -67,14 +129,7 @@ func (s *Server) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
 
 	metricInfos := make([]MetricInfo, len(metrics))
 	for i, m := range metrics {
-		metricInfos[i] = MetricInfo{
-			ID:         m.ID,
-			MetricName: m.MetricName,
-			IsActive:   m.IsActive,
-		}
-		if m.LastCollectedAt != nil {
-			metricInfos[i].LastCollectedAt = m.LastCollectedAt.Format("2006-01-02T15:04:05Z")
-		}
+		metricInfos[i] = newMetricInfo(m)
 	}
 
 	response := MetricsResponse{
@@ -87,83 +142,286 @@ func (s *Server) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleGetAnomalies(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// Get limit from query params (default 50)
-	limitStr := r.URL.Query().Get("limit")
-	limit := 50
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
-		}
+	query, err := parseAnomalyQuery(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	anomalies, err := s.db.GetRecentAnomalies(ctx, limit)
+	page, err := s.db.QueryAnomalies(ctx, query)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to fetch anomalies")
 		return
 	}
 
-	anomalyInfos := make([]AnomalyInfo, len(anomalies))
-	for i, a := range anomalies {
-		anomalyInfos[i] = AnomalyInfo{
-			ID:               a.ID,
-			MetricID:         a.MetricID,
-			Timestamp:        a.Timestamp.Format("2006-01-02T15:04:05Z"),
-			Value:            a.Value,
-			AnomalyScore:     a.AnomalyScore,
-			DetectionMethods: a.DetectionMethods,
-			Severity:         a.Severity,
-			Status:           a.Status,
-			RootCause:        a.RootCause,
-			Impact:           a.Impact,
-			CreatedAt:        a.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		}
+	anomalyInfos := make([]AnomalyInfo, len(page.Anomalies))
+	for i, a := range page.Anomalies {
+		anomalyInfos[i] = newAnomalyInfo(a.Anomaly)
+		anomalyInfos[i].MetricName = a.MetricName
 	}
 
 	response := AnomaliesResponse{
 		Anomalies: anomalyInfos,
 		Total:     len(anomalyInfos),
 	}
+	if page.Next != nil {
+		response.NextCursor = encodeCursor(*page.Next)
+	}
 	respondJSON(w, http.StatusOK, response)
 }
 
 func (s *Server) handleGetAnomalyByID(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	idStr := vars["id"]
+	id, ok := pathID(w, r, "anomaly")
+	if !ok {
+		return
+	}
 
-	id, err := strconv.Atoi(idStr)
+	ctx := r.Context()
+	anomaly, err := s.db.GetAnomaly(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "Anomaly not found")
+		return
+	}
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid anomaly ID")
+		respondError(w, http.StatusInternalServerError, "Failed to fetch anomaly")
 		return
 	}
 
-	ctx := r.Context()
-	anomalies, err := s.db.GetRecentAnomalies(ctx, 100)
+	events, err := s.db.GetEscalationTimeline(ctx, id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch escalation timeline")
+		return
+	}
+
+	detail := AnomalyDetail{
+		AnomalyInfo: newAnomalyInfo(*anomaly),
+		Escalation:  make([]EscalationEventInfo, len(events)),
+	}
+	if metric, err := s.db.GetMetric(ctx, anomaly.MetricID); err == nil {
+		detail.MetricName = metric.MetricName
+	}
+	for i, ev := range events {
+		detail.Escalation[i] = EscalationEventInfo{
+			Step:      ev.Step + 1,
+			Receiver:  ev.Receiver,
+			Event:     ev.Event,
+			Detail:    ev.Detail,
+			CreatedAt: ev.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		}
+	}
+
+	respondJSON(w, http.StatusOK, detail)
+}
+
+func (s *Server) handleAcknowledgeAnomaly(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "anomaly")
+	if !ok {
+		return
+	}
+
+	req, ok := decodeAcknowledgeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.updateAnomalyStatus(w, r, id, storage.StatusUpdate{Status: "acknowledged", By: req.By})
+}
+
+func (s *Server) handleUpdateAnomalyStatus(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "anomaly")
+	if !ok {
+		return
+	}
+
+	var req StatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	update := storage.StatusUpdate{Status: req.Status, By: req.By}
+	switch req.Status {
+	case "open", "acknowledged", "resolved", "false_positive":
+	case "snoozed":
+		if req.SnoozeMinutes <= 0 {
+			respondError(w, http.StatusBadRequest, "snooze_minutes is required when snoozing")
+			return
+		}
+		until := time.Now().Add(time.Duration(req.SnoozeMinutes) * time.Minute)
+		update.SnoozedUntil = &until
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	s.updateAnomalyStatus(w, r, id, update)
+}
+
+func (s *Server) updateAnomalyStatus(w http.ResponseWriter, r *http.Request, id int, update storage.StatusUpdate) {
+	current, err := s.db.GetAnomaly(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "Anomaly not found")
+		return
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to fetch anomaly")
 		return
 	}
+	if !s.requireTeam(w, r, current.MetricID) {
+		return
+	}
+
+	update.By, update.OnBehalfOf = actor(r, update.By)
+	anomaly, err := s.notifier.ChangeAnomalyStatus(r.Context(), id, update)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusConflict, fmt.Sprintf("Anomaly not found or cannot move to %s", update.Status))
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update anomaly")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newAnomalyInfo(*anomaly))
+}
+
+func (s *Server) handleAcknowledgeIncident(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "incident")
+	if !ok {
+		return
+	}
+
+	req, ok := decodeAcknowledgeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	current, err := s.db.GetIncident(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "Incident not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch incident")
+		return
+	}
+	if !s.requireTeam(w, r, current.MetricID) {
+		return
+	}
+
+	by, onBehalfOf := actor(r, req.By)
+	incident, anomalyIDs, err := s.db.AcknowledgeIncident(ctx, id, by, onBehalfOf)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusConflict, "Incident not found or not open")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to acknowledge incident")
+		return
+	}
 
-	for _, a := range anomalies {
-		if a.ID == id {
-			anomalyInfo := AnomalyInfo{
-				ID:               a.ID,
-				MetricID:         a.MetricID,
-				Timestamp:        a.Timestamp.Format("2006-01-02T15:04:05Z"),
-				Value:            a.Value,
-				AnomalyScore:     a.AnomalyScore,
-				DetectionMethods: a.DetectionMethods,
-				Severity:         a.Severity,
-				Status:           a.Status,
-				RootCause:        a.RootCause,
-				Impact:           a.Impact,
-				CreatedAt:        a.CreatedAt.Format("2006-01-02T15:04:05Z"),
-			}
-			respondJSON(w, http.StatusOK, anomalyInfo)
+	detail := fmt.Sprintf("incident #%d %s", id, acknowledgedDetail(by, onBehalfOf))
+	for _, anomalyID := range anomalyIDs {
+		if err := s.db.StopEscalations(ctx, anomalyID, "acknowledged", detail); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to stop escalation")
 			return
 		}
 	}
+	s.publishIncidentAcknowledged(ctx, *incident, anomalyIDs)
+
+	respondJSON(w, http.StatusOK, IncidentAcknowledgeResponse{
+		IncidentID:   incident.ID,
+		Status:       incident.Status,
+		Acknowledged: anomalyIDs,
+	})
+}
+
+func newMetricInfo(m storage.Metric) MetricInfo {
+	info := MetricInfo{
+		ID:         m.ID,
+		MetricName: m.MetricName,
+		Labels:     m.Labels,
+		IsActive:   m.IsActive,
+	}
+	if m.LastCollectedAt != nil {
+		info.LastCollectedAt = m.LastCollectedAt.Format("2006-01-02T15:04:05Z")
+	}
+	return info
+}
+
+func newAnomalyInfo(a storage.Anomaly) AnomalyInfo {
+	info := AnomalyInfo{
+		ID:               a.ID,
+		MetricID:         a.MetricID,
+		Timestamp:        a.Timestamp.Format("2006-01-02T15:04:05Z"),
+		Value:            a.Value,
+		AnomalyScore:     a.AnomalyScore,
+		DetectionMethods: a.DetectionMethods,
+		Severity:         a.Severity,
+		Status:           a.Status,
+		RootCause:        a.RootCause,
+		Impact:           a.Impact,
+		IncidentID:       a.IncidentID,
+		AcknowledgedBy:   a.AcknowledgedBy,
+		OnBehalfOf:       a.AcknowledgedOnBehalfOf,
+		CreatedAt:        a.CreatedAt.Format("2006-01-02T15:04:05Z"),
+	}
+	if a.AcknowledgedAt != nil {
+		info.AcknowledgedAt = a.AcknowledgedAt.Format("2006-01-02T15:04:05Z")
+	}
+	if a.ResolvedAt != nil {
+		info.ResolvedAt = a.ResolvedAt.Format("2006-01-02T15:04:05Z")
+	}
+	if a.SnoozedUntil != nil {
+		info.SnoozedUntil = a.SnoozedUntil.Format("2006-01-02T15:04:05Z")
+	}
+	return info
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, kind string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s ID", kind))
+		return 0, false
+	}
+	return id, true
+}
+
+func decodeAcknowledgeRequest(w http.ResponseWriter, r *http.Request) (AcknowledgeRequest, bool) {
+	var req AcknowledgeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return req, false
+		}
+	}
+	return req, true
+}
 
-	respondError(w, http.StatusNotFound, "Anomaly not found")
+// actor returns who performed an action: always the authenticated caller.
+// A different name given in the request is returned as onBehalfOf, so it
+// is recorded without standing in for the caller.
+func actor(r *http.Request, requested string) (by, onBehalfOf string) {
+	if p := PrincipalFrom(r.Context()); p != nil && p.Method != "none" {
+		by = p.Subject
+	}
+	if requested != by {
+		onBehalfOf = requested
+	}
+	return by, onBehalfOf
+}
+
+// acknowledgedDetail describes an acknowledgement on the escalation timeline.
+func acknowledgedDetail(by, onBehalfOf string) string {
+	detail := "acknowledged via API"
+	if by != "" {
+		detail = "acknowledged by " + by
+	}
+	if onBehalfOf != "" {
+		detail += " on behalf of " + onBehalfOf
+	}
+	return detail
 }
 
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -173,7 +431,7 @@ func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 }
 
 func respondError(w http.ResponseWriter, status int, message string) {
-	respondJSON(w, status, map[string]string{"error": message})
+	respondJSON(w, status, ErrorResponse{Error: message})
 }
 
 func timeNow() string {
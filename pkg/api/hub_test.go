@@ -0,0 +1,424 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
+)
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+// startHub runs a hub with policy until the test ends.
+func startHub(t *testing.T, policy SlowClientPolicy) *Hub {
+	t.Helper()
+	h := NewHub(nil)
+	h.SetSlowClientPolicy(policy)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go h.Run(ctx)
+	return h
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// testMessage is what publishN sends: N counts the events published.
+type testMessage struct {
+	Seq int64 `json:"seq"`
+	N   int   `json:"n"`
+}
+
+func decodeMessages(t *testing.T, messages [][]byte) []testMessage {
+	t.Helper()
+	out := make([]testMessage, len(messages))
+	for i, data := range messages {
+		if err := json.Unmarshal(data, &out[i]); err != nil {
+			t.Errorf("message %s: %v", data, err)
+		}
+	}
+	return out
+}
+
+// publishN publishes n events, with key(i) as the key of the i-th.
+func publishN(h *Hub, n int, key func(i int) string) {
+	for i := 0; i < n; i++ {
+		h.publish(&hubEvent{Type: EventCycleCompleted, Key: key(i), Data: []byte(fmt.Sprintf(`{"n":%d}`, i))})
+	}
+}
+
+func TestClientQueuePolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		full        bool
+		key         string
+		policy      SlowClientPolicy
+		wantDropped int
+		wantOK      bool
+		// wantGone is the queued message discarded, or -1 for none.
+		wantGone int
+	}{
+		{"disconnect with room", false, "k2", SlowClientsDisconnect, 0, true, -1},
+		{"disconnect when full", true, "k2", SlowClientsDisconnect, 0, false, -1},
+		{"drop_oldest with room", false, "k2", SlowClientsDropOldest, 0, true, -1},
+		{"drop_oldest when full", true, "k2", SlowClientsDropOldest, 1, true, 0},
+		{"coalesce with room", false, "k2", SlowClientsCoalesce, 1, true, 2},
+		{"coalesce when full", true, "k2", SlowClientsCoalesce, 1, true, 2},
+		{"coalesce when full without a match", true, "k9", SlowClientsCoalesce, 1, true, 0},
+		{"coalesce when full without a key", true, "", SlowClientsCoalesce, 1, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The i-th queued message has key "k<i mod 4>", so the oldest
+			// holding "k2" is the third.
+			n := 4
+			if tt.full {
+				n = clientQueueSize
+			}
+			q := newClientQueue()
+			var before [][]byte
+			for i := 0; i < n; i++ {
+				data := []byte(fmt.Sprint(i))
+				q.push(fmt.Sprintf("k%d", i%4), data, SlowClientsDisconnect)
+				before = append(before, data)
+			}
+
+			dropped, ok := q.push(tt.key, []byte("new"), tt.policy)
+			if dropped != tt.wantDropped || ok != tt.wantOK {
+				t.Fatalf("push() = %d, %v, want %d, %v", dropped, ok, tt.wantDropped, tt.wantOK)
+			}
+
+			var want [][]byte
+			for i, data := range before {
+				if i != tt.wantGone {
+					want = append(want, data)
+				}
+			}
+			if tt.wantOK {
+				want = append(want, []byte("new"))
+			}
+			got, closed := q.drain()
+			if closed {
+				t.Fatal("drain() reports the queue closed")
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("drain() = %d messages starting %q, want %d starting %q", len(got), got[:3], len(want), want[:3])
+			}
+		})
+	}
+}
+
+func TestClientQueueCoalescesWithRoom(t *testing.T) {
+	q := newClientQueue()
+	q.push("incident:1", []byte("opened"), SlowClientsCoalesce)
+	q.push("", []byte("detected"), SlowClientsCoalesce)
+	q.push("", []byte("detected again"), SlowClientsCoalesce)
+	if dropped, ok := q.push("incident:1", []byte("updated"), SlowClientsCoalesce); dropped != 1 || !ok {
+		t.Fatalf("push() = %d, %v, want 1, true", dropped, ok)
+	}
+
+	got, _ := q.drain()
+	want := [][]byte{[]byte("detected"), []byte("detected again"), []byte("updated")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("drain() = %q, want %q", got, want)
+	}
+}
+
+func TestClientQueueClosed(t *testing.T) {
+	q := newClientQueue()
+	q.push("", []byte("last"), SlowClientsDisconnect)
+	q.close()
+	q.close()
+
+	for _, policy := range []SlowClientPolicy{SlowClientsDisconnect, SlowClientsDropOldest, SlowClientsCoalesce} {
+		if dropped, ok := q.push("", []byte("late"), policy); dropped != 0 || ok {
+			t.Fatalf("push(%s) to a closed queue = %d, %v, want 0, false", policy, dropped, ok)
+		}
+	}
+	got, closed := q.drain()
+	if !closed || len(got) != 1 {
+		t.Fatalf("drain() = %d messages, closed %v, want 1, true", len(got), closed)
+	}
+}
+
+func TestHubSlowClientPolicies(t *testing.T) {
+	const extra = 10
+	noKey := func(int) string { return "" }
+	fiveKeys := func(i int) string { return fmt.Sprintf("cycle:w%d", i%5) }
+
+	tests := []struct {
+		policy SlowClientPolicy
+		key    func(int) string
+		// wantN is the events left queued for the client, by n.
+		wantN            []int
+		wantDropped      int
+		wantDisconnected int
+	}{
+		{SlowClientsDisconnect, noKey, seqRange(0, clientQueueSize), 0, 1},
+		{SlowClientsDropOldest, noKey, seqRange(extra, clientQueueSize+extra), extra, 0},
+		{SlowClientsCoalesce, fiveKeys, seqRange(clientQueueSize+extra-5, clientQueueSize+extra), clientQueueSize + extra - 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dropped := counterValue(t, telemetry.WebSocketDropped)
+			disconnected := counterValue(t, telemetry.SlowClientsDisconnected)
+
+			h := startHub(t, tt.policy)
+			c := h.attach(nil, 0)
+			publishN(h, clientQueueSize+extra, tt.key)
+			waitFor(t, "every event to be broadcast", func() bool {
+				return h.log.lastSeq() == clientQueueSize+extra
+			})
+			// Run takes the next client only once it has finished
+			// broadcasting the last event.
+			h.attach(nil, 0)
+
+			h.mu.RLock()
+			attached := h.clients[c]
+			h.mu.RUnlock()
+			if attached != (tt.wantDisconnected == 0) {
+				t.Fatalf("client attached = %v after falling behind", attached)
+			}
+
+			messages, closed := c.queue.drain()
+			if closed != (tt.wantDisconnected > 0) {
+				t.Fatalf("queue closed = %v after falling behind", closed)
+			}
+			var got []int
+			for _, m := range decodeMessages(t, messages) {
+				if m.Seq != int64(m.N+1) {
+					t.Fatalf("event %d has seq %d, want %d", m.N, m.Seq, m.N+1)
+				}
+				got = append(got, m.N)
+			}
+			if !reflect.DeepEqual(got, tt.wantN) {
+				t.Fatalf("client received events %v, want %v", got, tt.wantN)
+			}
+
+			if got := counterValue(t, telemetry.WebSocketDropped) - dropped; got != float64(tt.wantDropped) {
+				t.Errorf("dropped messages counter rose by %v, want %d", got, tt.wantDropped)
+			}
+			if got := counterValue(t, telemetry.SlowClientsDisconnected) - disconnected; got != float64(tt.wantDisconnected) {
+				t.Errorf("disconnected clients counter rose by %v, want %d", got, tt.wantDisconnected)
+			}
+		})
+	}
+}
+
+func seqRange(from, to int) []int {
+	var ns []int
+	for n := from; n < to; n++ {
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+func TestHubEventsDropped(t *testing.T) {
+	publishDropped := counterValue(t, telemetry.HubEventsDropped.WithLabelValues("publish"))
+	relayDropped := counterValue(t, telemetry.HubEventsDropped.WithLabelValues("relay"))
+
+	// The hub is not running, so nothing drains its queues.
+	h := NewHub(nil)
+	publishN(h, hubQueueSize+3, func(int) string { return "" })
+	for i := 0; i < hubQueueSize+2; i++ {
+		h.Deliver([]byte(`{"type":"cycle_completed","data":{}}`))
+	}
+	h.Deliver([]byte(`not json`))
+
+	if got := h.Pending(); got != 2*hubQueueSize {
+		t.Fatalf("Pending() = %d, want %d", got, 2*hubQueueSize)
+	}
+	if got := counterValue(t, telemetry.HubEventsDropped.WithLabelValues("publish")) - publishDropped; got != 3 {
+		t.Errorf("published events dropped counter rose by %v, want 3", got)
+	}
+	if got := counterValue(t, telemetry.HubEventsDropped.WithLabelValues("relay")) - relayDropped; got != 2 {
+		t.Errorf("relayed events dropped counter rose by %v, want 2", got)
+	}
+}
+
+// TestHubStopped checks that clients joining, resuming and leaving after
+// Run returns don't block, and that a client joining then is closed.
+func TestHubStopped(t *testing.T) {
+	h := NewHub(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		h.Run(ctx)
+		close(stopped)
+	}()
+	live := h.attach(nil, 0)
+	cancel()
+	<-stopped
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.resume(live, 1)
+		h.detach(live)
+		c := h.attach(nil, 0)
+		if _, closed := c.queue.drain(); !closed {
+			t.Error("client attached to a stopped hub is not closed")
+		}
+		h.detach(c)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("clients blocked on a stopped hub")
+	}
+}
+
+// TestHubConcurrentClients attaches, detaches and resumes clients while
+// events are published. Run it with -race.
+func TestHubConcurrentClients(t *testing.T) {
+	const (
+		publishers = 4
+		events     = 200
+		cyclers    = 8
+		cycles     = 25
+		total      = publishers * events
+	)
+	subscribe := []byte(`{"action":"subscribe","id":"cycles","filter":{"types":["cycle_completed"]}}`)
+
+	for _, policy := range []SlowClientPolicy{SlowClientsDisconnect, SlowClientsDropOldest, SlowClientsCoalesce} {
+		t.Run(string(policy), func(t *testing.T) {
+			h := startHub(t, policy)
+
+			// The watcher stays attached and drains as it goes, checking
+			// that events arrive in order.
+			watcher := h.attach(nil, 0)
+			var seqs []int64
+			stop, watched := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(watched)
+				for {
+					select {
+					case <-watcher.queue.ready:
+					case <-stop:
+						return
+					}
+					messages, _ := watcher.queue.drain()
+					for _, m := range decodeMessages(t, messages) {
+						seqs = append(seqs, m.Seq)
+					}
+				}
+			}()
+
+			var wg sync.WaitGroup
+			for p := 0; p < publishers; p++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					metric := storage.Metric{MetricName: "api_latency", Labels: map[string]string{TeamLabel: "payments"}}
+					for i := 0; i < events; i++ {
+						if i%2 == 0 {
+							h.BroadcastCycle(fmt.Sprintf("worker-%d", p), time.Millisecond, map[string]int{"i": i})
+						} else {
+							h.BroadcastAnomaly(storage.Anomaly{ID: i, Severity: "high"}, metric)
+						}
+					}
+				}()
+			}
+			for c := 0; c < cyclers; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < cycles; i++ {
+						var filter *SubscriptionFilter
+						if i%3 == 0 {
+							filter = &SubscriptionFilter{MinSeverity: "critical"}
+						}
+						var lastSeq int64
+						if i%2 == 1 {
+							lastSeq = int64(c*cycles + i)
+						}
+						client := h.attach(filter, lastSeq)
+						if i%2 == 0 {
+							client.subs.handle(subscribe)
+						}
+						client.reply([]byte(`{"type":"subscribed"}`))
+						h.resume(client, int64(c*cycles+i))
+						client.queue.drain()
+						h.detach(client)
+					}
+				}()
+			}
+			wg.Wait()
+
+			waitFor(t, "every event to be broadcast", func() bool { return h.log.lastSeq() == total })
+			// Run takes this client only once it has finished with every
+			// earlier detach and broadcast.
+			last := h.attach(nil, 0)
+			close(stop)
+			<-watched
+			messages, _ := watcher.queue.drain()
+			for _, m := range decodeMessages(t, messages) {
+				seqs = append(seqs, m.Seq)
+			}
+
+			for i := 1; i < len(seqs); i++ {
+				if seqs[i] <= seqs[i-1] {
+					t.Fatalf("watcher received seq %d after %d", seqs[i], seqs[i-1])
+				}
+			}
+
+			h.mu.RLock()
+			defer h.mu.RUnlock()
+			attached := map[*Client]bool{}
+			for c := range h.clients {
+				if c != last {
+					attached[c] = true
+				}
+			}
+			want := map[*Client]bool{}
+			if policy == SlowClientsDisconnect && !h.clients[watcher] {
+				// Falling behind is allowed; being left attached with a
+				// closed queue is not.
+				if _, closed := watcher.queue.drain(); !closed {
+					t.Fatal("watcher detached but its queue is open")
+				}
+			} else {
+				want[watcher] = true
+				if n := len(seqs); n == 0 || seqs[n-1] != total {
+					t.Fatalf("watcher's last event is not %d: %v", total, seqs[max(n-5, 0):])
+				}
+			}
+			if !reflect.DeepEqual(attached, want) {
+				t.Fatalf("%d clients attached, want %d; detached clients must not remain", len(attached), len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+type NotificationsResponse struct {
+	Notifications []NotificationInfo     `json:"notifications"`
+	Receivers     []ReceiverDeliveryInfo `json:"receivers"`
+	RateLimits    []RateLimitInfo        `json:"rate_limits"`
+	Total         int                    `json:"total"`
+}
+
+type RateLimitInfo struct {
+	Receiver     string `json:"receiver"`
+	Limit        int    `json:"limit"`
+	SentInWindow int    `json:"sent_in_window"`
+	Storm        bool   `json:"storm"`
+	Suppressed   int64  `json:"suppressed_total"`
+	Digests      int64  `json:"digests_total"`
+}
+
+type NotificationInfo struct {
+	ID            int             `json:"id"`
+	Receiver      string          `json:"receiver"`
+	AnomalyID     *int            `json:"anomaly_id,omitempty"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt string          `json:"next_attempt_at,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	CreatedAt     string          `json:"created_at"`
+	SentAt        string          `json:"sent_at,omitempty"`
+}
+
+type ReceiverDeliveryInfo struct {
+	Receiver   string `json:"receiver"`
+	Pending    int    `json:"pending"`
+	Sent       int    `json:"sent"`
+	Failed     int    `json:"failed"`
+	Dead       int    `json:"dead"`
+	LastSentAt string `json:"last_sent_at,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+func (s *Server) handleGetNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	filter := storage.NotificationFilter{
+		Receiver: query.Get("receiver"),
+		Status:   query.Get("status"),
+		Limit:    50,
+	}
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		filter.Limit = l
+	}
+
+	notifications, err := s.db.ListNotifications(ctx, filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch notifications")
+		return
+	}
+
+	stats, err := s.db.GetDeliveryStats(ctx)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch delivery stats")
+		return
+	}
+
+	limits := s.notifier.RateLimitStats()
+
+	response := NotificationsResponse{
+		Notifications: make([]NotificationInfo, len(notifications)),
+		Receivers:     make([]ReceiverDeliveryInfo, len(stats)),
+		RateLimits:    make([]RateLimitInfo, len(limits)),
+		Total:         len(notifications),
+	}
+	for i, l := range limits {
+		response.RateLimits[i] = RateLimitInfo{
+			Receiver:     l.Receiver,
+			Limit:        l.Limit,
+			SentInWindow: l.SentInWindow,
+			Storm:        l.Storm,
+			Suppressed:   l.Suppressed,
+			Digests:      l.Digests,
+		}
+	}
+	for i, n := range notifications {
+		response.Notifications[i] = newNotificationInfo(n)
+	}
+	for i, st := range stats {
+		response.Receivers[i] = ReceiverDeliveryInfo{
+			Receiver:  st.Receiver,
+			Pending:   st.Pending,
+			Sent:      st.Sent,
+			Failed:    st.Failed,
+			Dead:      st.Dead,
+			LastError: st.LastError,
+		}
+		if st.LastSentAt != nil {
+			response.Receivers[i].LastSentAt = st.LastSentAt.Format("2006-01-02T15:04:05Z")
+		}
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleGetNotificationByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "notification")
+	if !ok {
+		return
+	}
+
+	n, err := s.db.GetNotification(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch notification")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newNotificationInfo(*n))
+}
+
+func (s *Server) handleRetryNotification(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, RoleAdmin) {
+		return
+	}
+	id, ok := pathID(w, r, "notification")
+	if !ok {
+		return
+	}
+
+	n, err := s.db.RetryNotification(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusConflict, "Notification not found or not failed")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to retry notification")
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, newNotificationInfo(*n))
+}
+
+func newNotificationInfo(n storage.Notification) NotificationInfo {
+	info := NotificationInfo{
+		ID:        n.ID,
+		Receiver:  n.Receiver,
+		AnomalyID: n.AnomalyID,
+		Status:    n.Status,
+		Attempts:  n.Attempts,
+		LastError: n.LastError,
+		Payload:   n.Payload,
+		CreatedAt: n.CreatedAt.Format("2006-01-02T15:04:05Z"),
+	}
+	if n.Status != "sent" && n.Status != "dead" {
+		info.NextAttemptAt = n.NextAttemptAt.Format("2006-01-02T15:04:05Z")
+	}
+	if n.SentAt != nil {
+		info.SentAt = n.SentAt.Format("2006-01-02T15:04:05Z")
+	}
+	return info
+}
@@ -0,0 +1,437 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig configures bearer JWT validation. JWKSURL is discovered from the
+// issuer's /.well-known/openid-configuration when empty.
+type OIDCConfig struct {
+	Issuer        string
+	Audience      string
+	JWKSURL       string
+	ScopeClaim    string // defaults to "scope"
+	UsernameClaim string // defaults to "email", falling back to "sub"
+	// TeamsClaim optionally names a claim (such as "groups") whose values are
+	// team names, for providers that can't put team:<name> in the scopes.
+	TeamsClaim string
+}
+
+const (
+	jwksRefreshInterval = time.Hour
+	jwksMinRefresh      = time.Minute
+	jwtLeeway           = time.Minute
+)
+
+// oidcVerifier validates JWTs against the issuer's signing keys, caching the
+// JWKS and refetching it when a token names a key it hasn't seen. Fetches
+// run without the lock held and at most one at a time, so a slow issuer
+// holds up only the requests waiting for a key it has not served yet.
+type oidcVerifier struct {
+	cfg        OIDCConfig
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	jwksURL   string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time // when the last fetch started
+	fetchErr  error
+	// refreshing is closed when the running fetch finishes; nil when none
+	// is running.
+	refreshing chan struct{}
+}
+
+func newOIDCVerifier(cfg OIDCConfig) *oidcVerifier {
+	if cfg.ScopeClaim == "" {
+		cfg.ScopeClaim = "scope"
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "email"
+	}
+	return &oidcVerifier{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		jwksURL:    cfg.JWKSURL,
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the token's signature, issuer, audience and validity window
+// and maps its claims to a Principal.
+func (v *oidcVerifier) Verify(ctx context.Context, raw string) (*Principal, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidCredentials
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errInvalidCredentials
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errInvalidCredentials
+	}
+	if err := v.checkClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims[v.cfg.UsernameClaim].(string)
+	if subject == "" {
+		subject, _ = claims["sub"].(string)
+	}
+	grants := claimGrants(claims[v.cfg.ScopeClaim])
+	if v.cfg.TeamsClaim != "" {
+		for _, team := range claimStrings(claims[v.cfg.TeamsClaim]) {
+			grants = append(grants, teamGrantPrefix+team)
+		}
+	}
+	return newPrincipal(subject, "oidc", grants), nil
+}
+
+func (v *oidcVerifier) checkClaims(claims map[string]interface{}, now time.Time) error {
+	if iss, _ := claims["iss"].(string); v.cfg.Issuer != "" && iss != v.cfg.Issuer {
+		return errInvalidCredentials
+	}
+	if v.cfg.Audience != "" && !audienceContains(claims["aud"], v.cfg.Audience) {
+		return errInvalidCredentials
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errInvalidCredentials
+	}
+	if now.Add(-jwtLeeway).After(time.Unix(int64(exp), 0)) {
+		return errExpiredCredentials
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errInvalidCredentials
+	}
+	return nil
+}
+
+func audienceContains(aud interface{}, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if s, _ := a.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimGrants picks the role and team grants out of a scope claim. Provider
+// scopes may be namespaced, as in argus:responder or argus:team:payments.
+func claimGrants(claim interface{}) []string {
+	var grants []string
+	for _, s := range claimStrings(claim) {
+		if s = strings.TrimPrefix(s, "argus:"); ValidGrant(s) {
+			grants = append(grants, s)
+		}
+	}
+	return grants
+}
+
+// claimStrings accepts both the space-separated "scope" form and a JSON array
+// ("scp" or "groups" in some providers).
+func claimStrings(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return strings.Fields(c)
+	case []interface{}:
+		var values []string
+		for _, s := range c {
+			if str, ok := s.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// key returns the signing key named kid. Cached keys are returned at once,
+// refreshing the JWKS in the background when it is stale. An unknown kid
+// waits for a fetch, but fetches for unknown kids start at most once every
+// jwksMinRefresh.
+func (v *oidcVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	key, known := v.keys[kid]
+	age := time.Since(v.fetchedAt)
+	done := v.refreshing
+	if done == nil && (age > jwksRefreshInterval || !known && age > jwksMinRefresh) {
+		done = v.startRefresh()
+	}
+	v.mu.Unlock()
+
+	if known {
+		return key, nil
+	}
+	if done == nil {
+		return nil, errInvalidCredentials
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if v.keys == nil && v.fetchErr != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", v.fetchErr)
+	}
+	return nil, errInvalidCredentials
+}
+
+// startRefresh fetches the JWKS in the background and returns a channel
+// closed when it is done. It must be called with v.mu held and no fetch
+// running.
+func (v *oidcVerifier) startRefresh() chan struct{} {
+	done := make(chan struct{})
+	v.refreshing = done
+	v.fetchedAt = time.Now()
+	jwksURL := v.jwksURL
+
+	go func() {
+		// Not tied to the request that started it: others may be waiting.
+		keys, jwksURL, err := v.fetchKeys(context.Background(), jwksURL)
+
+		v.mu.Lock()
+		if err == nil {
+			v.keys = keys
+			v.jwksURL = jwksURL
+		}
+		v.fetchErr = err
+		v.refreshing = nil
+		v.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// fetchKeys reads the signing keys, discovering the JWKS URL from the
+// issuer when jwksURL is empty.
+func (v *oidcVerifier) fetchKeys(ctx context.Context, jwksURL string) (map[string]crypto.PublicKey, string, error) {
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		url := strings.TrimSuffix(v.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := v.getJSON(ctx, url, &discovery); err != nil {
+			return nil, "", err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, "", errors.New("issuer did not advertise a jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, "", err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, jwksURL, nil
+}
+
+func (v *oidcVerifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) > size || len(y) > size {
+			return nil, errors.New("invalid EC point")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		new(big.Int).SetBytes(x).FillBytes(point[1 : 1+size])
+		new(big.Int).SetBytes(y).FillBytes(point[1+size:])
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyJWTSignature supports the asymmetric algorithms identity providers
+// use. HMAC and "none" are rejected: a shared secret can't come from a JWKS.
+func verifyJWTSignature(alg string, key crypto.PublicKey, input string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	digest := hashInput(hash, input)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match alg")
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match alg")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if pub.Curve.Params().BitSize != esCurveBits[alg] {
+			return errors.New("curve does not match alg")
+		}
+		if len(sig) != 2*size {
+			return errors.New("bad signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported alg %q", alg)
+}
+
+// esCurveBits pairs each ECDSA alg with the only curve it may be used with.
+var esCurveBits = map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}
+
+func hashInput(hash crypto.Hash, input string) []byte {
+	switch hash {
+	case crypto.SHA384:
+		sum := sha512.Sum384([]byte(input))
+		return sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512([]byte(input))
+		return sum[:]
+	default:
+		sum := sha256.Sum256([]byte(input))
+		return sum[:]
+	}
+}
+
+func decodeSegment(seg string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testAudience = "argus"
+
+type testIdP struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	server *httptest.Server
+
+	fetches atomic.Int32
+	// block, when set, holds JWKS requests until it is closed.
+	block chan struct{}
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	idp := &testIdP{}
+	var err error
+	if idp.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if idp.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	ecX, ecY := idp.ecKey.PublicKey.X.FillBytes(make([]byte, 32)), idp.ecKey.PublicKey.Y.FillBytes(make([]byte, 32))
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(idp.rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(idp.rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "use": "sig", "crv": "P-256", "x": b64(ecX), "y": b64(ecY)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(idp.rsaKey.N.Bytes()), "e": "AQAB"},
+	}})
+
+	idp.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"jwks_uri": idp.server.URL + "/jwks"})
+		case "/jwks":
+			idp.fetches.Add(1)
+			if idp.block != nil {
+				<-idp.block
+			}
+			w.Write(jwks)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *testIdP) verifier() *oidcVerifier {
+	return newOIDCVerifier(OIDCConfig{Issuer: idp.server.URL, Audience: testAudience})
+}
+
+func (idp *testIdP) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   idp.server.URL,
+		"aud":   testAudience,
+		"sub":   "user-1",
+		"email": "ana@example.com",
+		"scope": "openid argus:responder argus:team:payments",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// sign builds a JWT. For RS* and PS* the RSA key signs, for ES256 the EC
+// key, and for HS256 the DER encoding of the RSA public key is used as the
+// HMAC secret, as in the classic algorithm confusion attack.
+func (idp *testIdP) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:])
+	case "PS256":
+		sig, err = rsa.SignPSS(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "HS256":
+		der, _ := x509.MarshalPKIXPublicKey(&idp.rsaKey.PublicKey)
+		mac := hmac.New(sha256.New, der)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case "none":
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCVerify(t *testing.T) {
+	idp := newTestIdP(t)
+	with := func(key string, value interface{}) map[string]interface{} {
+		c := idp.claims()
+		if value == nil {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"RS256", idp.sign(t, "RS256", "rsa", idp.claims()), nil},
+		{"PS256", idp.sign(t, "PS256", "rsa", idp.claims()), nil},
+		{"ES256", idp.sign(t, "ES256", "ec", idp.claims()), nil},
+		{"audience list", idp.sign(t, "RS256", "rsa", with("aud", []string{"other", testAudience})), nil},
+		{"alg none", idp.sign(t, "none", "rsa", idp.claims()), errInvalidCredentials},
+		{"HS256 with public key as secret", idp.sign(t, "HS256", "rsa", idp.claims()), errInvalidCredentials},
+		{"ES256 header on RSA key", idp.sign(t, "ES256", "rsa", idp.claims()), errInvalidCredentials},
+		{"RS256 header on EC key", idp.sign(t, "RS256", "ec", idp.claims()), errInvalidCredentials},
+		{"encryption key", idp.sign(t, "RS256", "enc", idp.claims()), errInvalidCredentials},
+		{"unknown kid", idp.sign(t, "RS256", "rotated", idp.claims()), errInvalidCredentials},
+		{"expired", idp.sign(t, "RS256", "rsa", with("exp", time.Now().Add(-2*jwtLeeway).Unix())), errExpiredCredentials},
+		{"expired within leeway", idp.sign(t, "RS256", "rsa", with("exp", time.Now().Add(-jwtLeeway/2).Unix())), nil},
+		{"no exp", idp.sign(t, "RS256", "rsa", with("exp", nil)), errInvalidCredentials},
+		{"not yet valid", idp.sign(t, "RS256", "rsa", with("nbf", time.Now().Add(2*jwtLeeway).Unix())), errInvalidCredentials},
+		{"wrong audience", idp.sign(t, "RS256", "rsa", with("aud", "grafana")), errInvalidCredentials},
+		{"no audience", idp.sign(t, "RS256", "rsa", with("aud", nil)), errInvalidCredentials},
+		{"wrong issuer", idp.sign(t, "RS256", "rsa", with("iss", "https://evil.example.com")), errInvalidCredentials},
+		{"malformed", "not.a-jwt", errInvalidCredentials},
+	}
+
+	v := idp.verifier()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := v.Verify(context.Background(), tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (p.Subject != "ana@example.com" || p.Role != RoleResponder || !p.InTeam("payments")) {
+				t.Fatalf("Verify() = %+v", p)
+			}
+		})
+	}
+
+	// The unknown kid triggered at most one extra fetch.
+	if n := idp.fetches.Load(); n > 2 {
+		t.Errorf("JWKS fetched %d times, want at most 2", n)
+	}
+}
+
+func TestOIDCTamperedPayload(t *testing.T) {
+	idp := newTestIdP(t)
+	token := idp.sign(t, "RS256", "rsa", idp.claims())
+	forged := idp.claims()
+	forged["scope"] = "argus:admin"
+	payload, _ := json.Marshal(forged)
+
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	if _, err := idp.verifier().Verify(context.Background(), parts[0]+"."+parts[1]+"."+parts[2]); !errors.Is(err, errInvalidCredentials) {
+		t.Fatalf("Verify() error = %v, want errInvalidCredentials", err)
+	}
+}
+
+// A fetch for an unknown kid must not hold up tokens signed with a cached
+// key, and concurrent requests for unknown kids share one fetch.
+func TestOIDCSlowJWKSDoesNotBlock(t *testing.T) {
+	idp := newTestIdP(t)
+	v := idp.verifier()
+	good := idp.sign(t, "RS256", "rsa", idp.claims())
+	if _, err := v.Verify(context.Background(), good); err != nil {
+		t.Fatal(err)
+	}
+
+	idp.block = make(chan struct{})
+	v.mu.Lock()
+	v.fetchedAt = time.Now().Add(-2 * jwksMinRefresh)
+	v.mu.Unlock()
+
+	unknown := idp.sign(t, "RS256", "rotated", idp.claims())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v.Verify(context.Background(), unknown)
+		}()
+	}
+	for idp.fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(context.Background(), good)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("verifying a token with a cached key waited for the JWKS fetch")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := v.Verify(ctx, unknown); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Verify() with a cancelled context = %v", err)
+	}
+
+	close(idp.block)
+	wg.Wait()
+	if n := idp.fetches.Load(); n != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", n)
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPISpec describes every route in setupRoutes. Keep it in step with the
+// handlers; Start logs any route it doesn't cover.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (s *Server) handleGetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// undocumentedRoutes lists registered method and path pairs that have no
+// operation in openapi.json.
+func (s *Server) undocumentedRoutes() []string {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return []string{"openapi.json: " + err.Error()}
+	}
+
+	var missing []string
+	s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"GET"}
+		}
+		for _, m := range methods {
+			if m == "OPTIONS" {
+				continue
+			}
+			if _, ok := spec.Paths[path][strings.ToLower(m)]; !ok {
+				missing = append(missing, m+" "+path)
+			}
+		}
+		return nil
+	})
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TestOpenAPIResponses checks recorded handler responses against the
+// schemas openapi.json declares for them. Fields a handler returns that the
+// schema does not list are reported too, so the document cannot fall behind.
+func TestOpenAPIResponses(t *testing.T) {
+	f := newTestFixture(t)
+	spec := loadSpec(t)
+
+	anomaly := f.anomalies[0].ID
+	from := f.start.Format(time.RFC3339)
+	to := f.start.Add(time.Hour).Format(time.RFC3339)
+	grafanaRange := fmt.Sprintf(`"range":{"from":%q,"to":%q}`, from, to)
+
+	requests := []struct {
+		method, path, body string
+	}{
+		{"GET", "/health", ""},
+		{"GET", "/livez", ""},
+		{"GET", "/api/me", ""},
+		{"GET", "/api/metrics", ""},
+		{"GET", fmt.Sprintf("/api/metrics/%d/series?from=%s&to=%s&step=5m", f.metric.ID, from, to), ""},
+		{"GET", "/api/metrics/999/series", ""},
+		{"GET", "/api/anomalies", ""},
+		{"GET", "/api/anomalies?limit=1&sort=-score", ""},
+		{"GET", "/api/anomalies?severity=bogus", ""},
+		{"GET", fmt.Sprintf("/api/anomalies/%d", anomaly), ""},
+		{"GET", "/api/anomalies/999", ""},
+		{"GET", "/api/notifications", ""},
+		{"GET", "/api/notifications/1", ""},
+		{"POST", fmt.Sprintf("/api/anomalies/%d/status", f.anomalies[1].ID), `{"status":"snoozed","snooze_minutes":30,"by":"bob"}`},
+		{"POST", fmt.Sprintf("/api/anomalies/%d/status", anomaly), `{"status":"sideways"}`},
+		{"POST", fmt.Sprintf("/api/incidents/%d/acknowledge", f.incident.ID), `{"by":"bob"}`},
+		{"POST", fmt.Sprintf("/api/anomalies/%d/acknowledge", anomaly), ""},
+		{"GET", fmt.Sprintf("/api/anomalies/%d", f.anomalies[1].ID), ""},
+		{"POST", fmt.Sprintf("/api/anomalies/%d/status", f.anomalies[1].ID), `{"status":"acknowledged","by":"bob"}`},
+		{"GET", "/api/grafana/", ""},
+		{"POST", "/api/grafana/search", `{"target":""}`},
+		{"POST", "/api/grafana/query", `{` + grafanaRange + `,"intervalMs":60000,"maxDataPoints":100,"targets":[
+			{"refId":"A","target":"http_request_duration_seconds","type":"timeserie"},
+			{"refId":"B","target":"expected(http_request_duration_seconds)","type":"timeserie"},
+			{"refId":"C","target":"http_request_duration_seconds","type":"table"}]}`},
+		{"POST", "/api/grafana/annotations", `{` + grafanaRange + `,"annotation":{"name":"Anomalies","enable":true,"query":"type=anomalies"}}`},
+		{"POST", "/api/grafana/annotations", `{` + grafanaRange + `,"annotation":{"name":"Incidents","enable":true,"query":"type=incidents label.team=payments"}}`},
+	}
+
+	for _, req := range requests {
+		t.Run(req.method+" "+req.path, func(t *testing.T) {
+			rec := f.do(t, req.method, req.path, req.body)
+
+			template := routeTemplate(t, f.server, req.method, req.path)
+			schema, ok := spec.responseSchema(template, req.method, rec.Code)
+			if !ok {
+				t.Fatalf("openapi.json has no %d response for %s %s (body %s)", rec.Code, req.method, template, rec.Body)
+			}
+			var body interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response is not JSON: %v: %s", err, rec.Body)
+			}
+			if errs := spec.validate(schema, body, "$"); len(errs) > 0 {
+				t.Errorf("%d response does not match openapi.json:\n  %s\nbody: %s", rec.Code, strings.Join(errs, "\n  "), rec.Body)
+			}
+		})
+	}
+}
+
+func routeTemplate(t *testing.T, s *Server, method, path string) string {
+	t.Helper()
+	var match mux.RouteMatch
+	if !s.router.Match(httptest.NewRequest(method, path, nil), &match) || match.Route == nil {
+		t.Fatalf("no route for %s %s", method, path)
+	}
+	template, err := match.Route.GetPathTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return template
+}
+
+type openAPIDoc map[string]interface{}
+
+func loadSpec(t *testing.T) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// lookup follows a JSON pointer such as "#/components/schemas/Anomaly".
+func (d openAPIDoc) lookup(ref string) map[string]interface{} {
+	var node interface{} = map[string]interface{}(d)
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, _ := node.(map[string]interface{})
+		node = m[part]
+	}
+	m, _ := node.(map[string]interface{})
+	return m
+}
+
+func (d openAPIDoc) deref(node map[string]interface{}) map[string]interface{} {
+	for node != nil {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		node = d.lookup(ref)
+	}
+	return node
+}
+
+func (d openAPIDoc) responseSchema(path, method string, status int) (map[string]interface{}, bool) {
+	paths, _ := d["paths"].(map[string]interface{})
+	item, _ := paths[path].(map[string]interface{})
+	op, _ := item[strings.ToLower(method)].(map[string]interface{})
+	responses, _ := op["responses"].(map[string]interface{})
+	resp, _ := responses[strconv.Itoa(status)].(map[string]interface{})
+	resp = d.deref(resp)
+	if resp == nil {
+		return nil, false
+	}
+	content, _ := resp["content"].(map[string]interface{})
+	media, _ := content["application/json"].(map[string]interface{})
+	schema, _ := media["schema"].(map[string]interface{})
+	return schema, schema != nil
+}
+
+// properties returns the properties a schema documents, including those of
+// allOf parts.
+func (d openAPIDoc) properties(schema map[string]interface{}) map[string]interface{} {
+	schema = d.deref(schema)
+	props := make(map[string]interface{})
+	if p, ok := schema["properties"].(map[string]interface{}); ok {
+		for k, v := range p {
+			props[k] = v
+		}
+	}
+	parts, _ := schema["allOf"].([]interface{})
+	for _, part := range parts {
+		m, _ := part.(map[string]interface{})
+		for k, v := range d.properties(m) {
+			props[k] = v
+		}
+	}
+	return props
+}
+
+// validate supports the subset of JSON Schema openapi.json uses.
+func (d openAPIDoc) validate(schema map[string]interface{}, v interface{}, at string) []string {
+	schema = d.deref(schema)
+	if schema == nil {
+		return []string{at + ": schema not found"}
+	}
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+	}
+
+	var errs []string
+	if parts, ok := schema["allOf"].([]interface{}); ok {
+		for _, part := range parts {
+			m, _ := part.(map[string]interface{})
+			errs = append(errs, d.validateShallow(m, v, at)...)
+		}
+		return append(errs, d.validateObject(schema, v, at)...)
+	}
+	if options, ok := schema["oneOf"].([]interface{}); ok {
+		var all []string
+		for _, option := range options {
+			m, _ := option.(map[string]interface{})
+			e := d.validate(m, v, at)
+			if len(e) == 0 {
+				return nil
+			}
+			all = append(all, e...)
+		}
+		return append([]string{at + ": matches no oneOf schema"}, all...)
+	}
+
+	switch schema["type"] {
+	case "object":
+		if _, ok := v.(map[string]interface{}); !ok {
+			return []string{fmt.Sprintf("%s: want object, got %T", at, v)}
+		}
+		return d.validateObject(schema, v, at)
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: want array, got %T", at, v)}
+		}
+		if min, ok := schema["minItems"].(float64); ok && float64(len(items)) < min {
+			errs = append(errs, fmt.Sprintf("%s: %d items, want at least %v", at, len(items), min))
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(items)) > max {
+			errs = append(errs, fmt.Sprintf("%s: %d items, want at most %v", at, len(items), max))
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			if itemSchema != nil {
+				errs = append(errs, d.validate(itemSchema, item, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want string, got %T", at, v)}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not a date-time", at, s))
+			}
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			return []string{fmt.Sprintf("%s: want integer, got %v", at, v)}
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return []string{fmt.Sprintf("%s: want number, got %T", at, v)}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []string{fmt.Sprintf("%s: want boolean, got %T", at, v)}
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if e == v {
+				found = true
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v is not one of %v", at, v, enum))
+		}
+	}
+	return errs
+}
+
+// validateShallow checks an allOf part without reporting properties that
+// other parts document.
+func (d openAPIDoc) validateShallow(schema map[string]interface{}, v interface{}, at string) []string {
+	schema = d.deref(schema)
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return []string{fmt.Sprintf("%s: want object, got %T", at, v)}
+	}
+	var errs []string
+	required, _ := schema["required"].([]interface{})
+	for _, r := range required {
+		if _, ok := obj[r.(string)]; !ok {
+			errs = append(errs, fmt.Sprintf("%s: missing required %q", at, r))
+		}
+	}
+	return errs
+}
+
+func (d openAPIDoc) validateObject(schema map[string]interface{}, v interface{}, at string) []string {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var errs []string
+	required, _ := schema["required"].([]interface{})
+	for _, r := range required {
+		if _, ok := obj[r.(string)]; !ok {
+			errs = append(errs, fmt.Sprintf("%s: missing required %q", at, r))
+		}
+	}
+
+	props := d.properties(schema)
+	extra, hasExtra := schema["additionalProperties"].(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch p, ok := props[k].(map[string]interface{}); {
+		case ok:
+			errs = append(errs, d.validate(p, obj[k], at+"."+k)...)
+		case hasExtra:
+			errs = append(errs, d.validate(extra, obj[k], at+"."+k)...)
+		case len(props) > 0:
+			errs = append(errs, fmt.Sprintf("%s: field %q is not in openapi.json", at, k))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SlowClientPolicy decides what happens when a client's queue is full.
+type SlowClientPolicy string
+
+const (
+	// SlowClientsDisconnect closes the connection. The client reconnects
+	// and resumes from the last event it saw.
+	SlowClientsDisconnect SlowClientPolicy = "disconnect"
+	// SlowClientsDropOldest discards the oldest queued message.
+	SlowClientsDropOldest SlowClientPolicy = "drop_oldest"
+	// SlowClientsCoalesce replaces a queued event about the same anomaly,
+	// incident, worker or dependency with the newer one, and otherwise
+	// discards the oldest queued message.
+	SlowClientsCoalesce SlowClientPolicy = "coalesce"
+)
+
+// ParseSlowClientPolicy returns the policy named s; empty means
+// SlowClientsDisconnect.
+func ParseSlowClientPolicy(s string) (SlowClientPolicy, error) {
+	switch p := SlowClientPolicy(s); p {
+	case "":
+		return SlowClientsDisconnect, nil
+	case SlowClientsDisconnect, SlowClientsDropOldest, SlowClientsCoalesce:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown slow client policy %q (want disconnect, drop_oldest or coalesce)", s)
+}
+
+type queuedMessage struct {
+	// key identifies what the message is about for coalescing; messages
+	// with an empty key are never coalesced.
+	key  string
+	data []byte
+}
+
+// clientQueue holds the messages waiting to be written to one client. The
+// hub adds to it without blocking; the client's write loop drains it.
+type clientQueue struct {
+	mu     sync.Mutex
+	items  []queuedMessage
+	closed bool
+	// ready is signalled when messages are added or the queue is closed.
+	ready chan struct{}
+}
+
+func newClientQueue() *clientQueue {
+	return &clientQueue{ready: make(chan struct{}, 1)}
+}
+
+// push queues a message, making room according to policy when the queue
+// holds clientQueueSize messages. It returns how many queued messages were
+// discarded, and false if the message was not queued: the queue is closed,
+// or full under SlowClientsDisconnect.
+func (q *clientQueue) push(key string, data []byte, policy SlowClientPolicy) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0, false
+	}
+	dropped := 0
+	if len(q.items) >= clientQueueSize {
+		switch policy {
+		case SlowClientsDropOldest, SlowClientsCoalesce:
+			i := 0
+			if policy == SlowClientsCoalesce && key != "" {
+				i = max(q.find(key), 0)
+			}
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			dropped = 1
+		default:
+			return 0, false
+		}
+	} else if policy == SlowClientsCoalesce && key != "" {
+		if i := q.find(key); i >= 0 {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			dropped = 1
+		}
+	}
+	q.items = append(q.items, queuedMessage{key: key, data: data})
+	q.signal()
+	return dropped, true
+}
+
+// find returns the index of the oldest queued message with key, or -1.
+func (q *clientQueue) find(key string) int {
+	for i, m := range q.items {
+		if m.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// drain takes every queued message. closed is true once the queue has been
+// closed, after which nothing more is queued.
+func (q *clientQueue) drain() (messages [][]byte, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, m := range q.items {
+		messages = append(messages, m.data)
+	}
+	q.items = q.items[:0]
+	return messages, q.closed
+}
+
+// close stops the queue accepting messages and wakes the write loop, which
+// writes what is left and returns. It is safe to call more than once.
+func (q *clientQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		q.signal()
+	}
+}
+
+func (q *clientQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
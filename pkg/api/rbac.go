@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Roles, from least to most privileged. Viewers can read everything;
+// responders can change the status of anomalies and incidents owned by their
+// teams; admins can do anything, including retrying notifications.
+const (
+	RoleViewer    = "viewer"
+	RoleResponder = "responder"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleViewer:    1,
+	RoleResponder: 2,
+	RoleAdmin:     3,
+}
+
+// TeamLabel is the metric label that says which team owns a metric and the
+// anomalies and incidents raised on it.
+const TeamLabel = "team"
+
+const teamGrantPrefix = "team:"
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject string
+	Method  string // "api_key", "oidc", "slack" or "none"
+	Role    string
+	Teams   []string // "*" means every team
+}
+
+// newPrincipal builds a principal from grants as stored on API keys and sent
+// in token scopes: role names plus "team:<name>" entries. The highest role
+// listed wins.
+func newPrincipal(subject, method string, grants []string) *Principal {
+	p := &Principal{Subject: subject, Method: method}
+	for _, g := range grants {
+		if team, ok := strings.CutPrefix(g, teamGrantPrefix); ok {
+			if team != "" {
+				p.Teams = append(p.Teams, team)
+			}
+		} else if roleRank[g] > roleRank[p.Role] {
+			p.Role = g
+		}
+	}
+	return p
+}
+
+// HasRole reports whether the principal's role is at least role.
+func (p *Principal) HasRole(role string) bool {
+	return roleRank[p.Role] >= roleRank[role]
+}
+
+// InTeam reports whether the principal may act on resources owned by team.
+// Resources whose metric has no team label belong to no team, so only
+// admins and principals granted every team may act on them.
+func (p *Principal) InTeam(team string) bool {
+	if p.Role == RoleAdmin {
+		return true
+	}
+	for _, t := range p.Teams {
+		if t == "*" || t != "" && t == team {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidGrant reports whether g is a role or team grant the API understands.
+func ValidGrant(g string) bool {
+	if team, ok := strings.CutPrefix(g, teamGrantPrefix); ok {
+		return team != ""
+	}
+	_, ok := roleRank[g]
+	return ok
+}
+
+// requireRole writes 403 and returns false unless the caller has role.
+func requireRole(w http.ResponseWriter, r *http.Request, role string) bool {
+	if p := PrincipalFrom(r.Context()); p == nil || !p.HasRole(role) {
+		respondError(w, http.StatusForbidden, "Forbidden: requires "+role+" role")
+		return false
+	}
+	return true
+}
+
+// requireTeam writes 403 and returns false unless the caller is a responder
+// allowed to act on resources of the given metric's team.
+func (s *Server) requireTeam(w http.ResponseWriter, r *http.Request, metricID int) bool {
+	if !requireRole(w, r, RoleResponder) {
+		return false
+	}
+
+	team, err := s.metricTeam(r.Context(), metricID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch metric")
+		return false
+	}
+	if err := s.teamAccess(PrincipalFrom(r.Context()), team); err != nil {
+		respondError(w, http.StatusForbidden, "Forbidden: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// teamAccess returns why p may not act on resources owned by team, or nil
+// if it may.
+func (s *Server) teamAccess(p *Principal, team string) error {
+	switch {
+	case team == "" && s.config.Auth.OpenTeamless:
+	case p.InTeam(team):
+	case team == "":
+		return errors.New("the metric has no team label")
+	default:
+		return fmt.Errorf("owned by team %s", team)
+	}
+	return nil
+}
+
+func (s *Server) metricTeam(ctx context.Context, metricID int) (string, error) {
+	metric, err := s.db.GetMetric(ctx, metricID)
+	if err != nil {
+		return "", err
+	}
+	return metric.Labels[TeamLabel], nil
+}
+
+type PrincipalInfo struct {
+	Subject string   `json:"subject"`
+	Method  string   `json:"method"`
+	Role    string   `json:"role"`
+	Teams   []string `json:"teams"`
+}
+
+// handleGetMe lets clients such as the dashboard find out what the caller is
+// allowed to do, so they can hide actions that would be refused.
+func (s *Server) handleGetMe(w http.ResponseWriter, r *http.Request) {
+	p := PrincipalFrom(r.Context())
+	respondJSON(w, http.StatusOK, PrincipalInfo{
+		Subject: p.Subject,
+		Method:  p.Method,
+		Role:    p.Role,
+		Teams:   append([]string{}, p.Teams...),
+	})
+}
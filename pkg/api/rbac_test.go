@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrincipalInTeam(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *Principal
+		team      string
+		want      bool
+	}{
+		{"own team", newPrincipal("ana", "api_key", []string{"responder", "team:payments"}), "payments", true},
+		{"other team", newPrincipal("ana", "api_key", []string{"responder", "team:payments"}), "search", false},
+		{"teamless", newPrincipal("ana", "api_key", []string{"responder", "team:payments"}), "", false},
+		{"no teams, teamless", newPrincipal("ana", "api_key", []string{"responder"}), "", false},
+		{"every team", newPrincipal("ana", "api_key", []string{"responder", "team:*"}), "search", true},
+		{"every team, teamless", newPrincipal("ana", "api_key", []string{"responder", "team:*"}), "", true},
+		{"admin, teamless", newPrincipal("root", "api_key", []string{"admin"}), "", true},
+		{"admin, any team", newPrincipal("root", "api_key", []string{"admin"}), "search", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.principal.InTeam(tt.team); got != tt.want {
+				t.Fatalf("InTeam(%q) = %v, want %v", tt.team, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActor(t *testing.T) {
+	ana := newPrincipal("ana@example.com", "oidc", []string{"responder"})
+	anonymous := &Principal{Subject: "anonymous", Method: "none", Role: RoleAdmin}
+
+	tests := []struct {
+		name           string
+		principal      *Principal
+		requested      string
+		wantBy, wantOn string
+	}{
+		{"caller only", ana, "", "ana@example.com", ""},
+		{"caller names themselves", ana, "ana@example.com", "ana@example.com", ""},
+		{"caller acts for someone", ana, "bob", "ana@example.com", "bob"},
+		{"auth disabled", anonymous, "bob", "", "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/anomalies/1/acknowledge", nil)
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, tt.principal))
+			by, onBehalfOf := actor(r, tt.requested)
+			if by != tt.wantBy || onBehalfOf != tt.wantOn {
+				t.Fatalf("actor() = %q, %q; want %q, %q", by, onBehalfOf, tt.wantBy, tt.wantOn)
+			}
+		})
+	}
+}
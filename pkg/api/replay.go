@@ -0,0 +1,295 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
+)
+
+// Every event a client receives carries a "seq" that increases with each
+// broadcast. A client that reconnects passes the last one it saw, as
+// ?last_seq= on the WebSocket URL or as Last-Event-ID for SSE, and gets the
+// events it missed, matching its subscriptions, before any live event:
+//
+//	GET /ws/anomalies?last_seq=1041
+//	← {"seq": 1042, "type": "anomaly_detected", ...}
+//	← {"type": "resumed", "last_seq": 1057, "replayed": 3, "complete": true}
+//
+// Replies hold at most maxReplay events. When "more" is set the client
+// resumes again from "last_seq" with {"action": "resume", "last_seq": N},
+// and receives no live events until it has caught up. "complete" is false
+// when some missed events are no longer kept; the client should reload
+// state over the REST API.
+//
+// A connected client may also send the resume action on its own. Events
+// delivered since it connected are not repeated, but live events already
+// queued are sent before the replayed ones.
+//
+// Sequence numbers come from the database when it keeps an event log, so
+// they are shared by every replica and survive restarts. Otherwise the hub
+// numbers events itself, starting again at 1 when the process restarts.
+
+const (
+	// replayLogSize is how many recent events each hub keeps in memory.
+	replayLogSize = 1024
+	// maxReplay is the most events sent in answer to one resume. It is
+	// kept below clientQueueSize.
+	maxReplay = 200
+	// clientQueueSize is how many messages may wait for a slow client
+	// before its SlowClientPolicy applies.
+	clientQueueSize = 256
+
+	eventLogTimeout    = 5 * time.Second
+	eventRetention     = 24 * time.Hour
+	eventPruneInterval = time.Hour
+)
+
+// ResumeReply ends the events replayed for a resume request.
+type ResumeReply struct {
+	Type string `json:"type"`
+	// LastSeq is the sequence number to resume from next.
+	LastSeq  int64 `json:"last_seq"`
+	Replayed int   `json:"replayed"`
+	More     bool  `json:"more,omitempty"`
+	Complete bool  `json:"complete"`
+}
+
+type resumeRequest struct {
+	client  *Client
+	lastSeq int64
+
+	// Events read from the event log when the in-memory log does not reach
+	// back to lastSeq.
+	fromStore bool
+	stored    []storage.Event
+	err       error
+}
+
+// replayLog is a ring of the most recent sequenced events.
+type replayLog struct {
+	mu     sync.RWMutex
+	events []*hubEvent
+	next   int
+	head   int64 // highest sequence number added
+	// floor is the highest sequence number the log cannot vouch for: the
+	// last one evicted, or the one before the first event added.
+	floor int64
+}
+
+func newReplayLog(size int) *replayLog {
+	return &replayLog{events: make([]*hubEvent, 0, size)}
+}
+
+func (l *replayLog) add(e *hubEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.events) == 0 && l.head == 0 {
+		l.floor = e.Seq - 1
+	}
+	if len(l.events) < cap(l.events) {
+		l.events = append(l.events, e)
+	} else {
+		l.floor = max(l.floor, l.events[l.next].Seq)
+		l.events[l.next] = e
+		l.next = (l.next + 1) % len(l.events)
+	}
+	l.head = max(l.head, e.Seq)
+}
+
+// covers reports whether every event after seq is still in the log.
+func (l *replayLog) covers(seq int64) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.head > 0 && seq >= l.floor
+}
+
+func (l *replayLog) lastSeq() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.head
+}
+
+// since returns the events after seq in sequence order.
+func (l *replayLog) since(seq int64) []*hubEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var events []*hubEvent
+	for _, e := range l.events {
+		if e.Seq > seq {
+			events = append(events, e)
+		}
+	}
+	slices.SortFunc(events, func(a, b *hubEvent) int { return cmp.Compare(a.Seq, b.Seq) })
+	return events
+}
+
+// SetEventLog numbers events from log and keeps them there for clients
+// that miss more than the in-memory log holds. Call it before the hub
+// starts.
+func (h *Hub) SetEventLog(log storage.EventLog) {
+	h.events = log
+}
+
+// record stores an event in the event log, setting its sequence number.
+// Events that cannot be stored are still broadcast, without one.
+func (h *Hub) record(event *hubEvent) {
+	stored := storage.Event{
+		Type:     event.Type,
+		Metric:   event.Metric,
+		Severity: event.Severity,
+		Team:     event.Team,
+		Data:     event.Data,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventLogTimeout)
+	defer cancel()
+	if err := h.events.AppendEvent(ctx, &stored); err != nil {
+		log.Printf("⚠️  Failed to log %s event, it will not be replayed: %v", event.Type, err)
+		return
+	}
+	event.Seq = stored.Seq
+}
+
+// sequence numbers an event if the hub does so itself, and adds the number
+// to the message. It runs on the hub goroutine.
+func (h *Hub) sequence(event *hubEvent) {
+	if h.events == nil {
+		event.Seq = h.log.lastSeq() + 1
+	}
+	if event.Seq == 0 {
+		return
+	}
+	event.Data = withSeq(event.Data, event.Seq)
+	h.log.add(event)
+}
+
+// resumeFrom prepares a request to replay the events after lastSeq, none
+// when it is zero. It runs on the client's goroutine and reads from the
+// event log there, so that a slow query holds up only this client.
+func (h *Hub) resumeFrom(c *Client, lastSeq int64) *resumeRequest {
+	req := &resumeRequest{client: c, lastSeq: lastSeq}
+	if lastSeq > 0 && h.events != nil && !h.log.covers(lastSeq) {
+		// Read from lastSeq itself: if it is gone, so may be later events.
+		ctx, cancel := context.WithTimeout(context.Background(), eventLogTimeout)
+		req.fromStore = true
+		req.stored, req.err = h.events.EventsSince(ctx, lastSeq-1, maxReplay+2)
+		cancel()
+	}
+	return req
+}
+
+// resume replays the events after lastSeq to a connected client.
+func (h *Hub) resume(c *Client, lastSeq int64) {
+	select {
+	case h.resumes <- h.resumeFrom(c, lastSeq):
+	case <-h.done:
+	}
+}
+
+// join adds a client to the hub. A client resuming gets the events it
+// missed first, under the same hold of h.mu, so no broadcast comes between
+// them. It runs on the hub goroutine; the caller holds h.mu.
+func (h *Hub) join(req *resumeRequest) {
+	h.clients[req.client] = true
+	if req.lastSeq > 0 {
+		req.client.catchingUp = true
+		h.replay(req)
+	}
+}
+
+// replay answers a resume request for a client of the hub. It runs on the
+// hub goroutine, so the replayed events reach the client before any later
+// broadcast. The caller holds h.mu.
+func (h *Hub) replay(req *resumeRequest) {
+	c := req.client
+
+	head := h.log.lastSeq()
+	after, complete := req.lastSeq, true
+	var events []*hubEvent
+	switch {
+	case req.fromStore && req.err != nil:
+		log.Printf("⚠️  Failed to read missed events: %v", req.err)
+		complete = false
+	case req.fromStore:
+		stored := req.stored
+		if len(stored) > 0 && stored[0].Seq == req.lastSeq {
+			stored = stored[1:]
+		} else if req.lastSeq > 0 {
+			complete = false
+		}
+		for _, e := range stored {
+			events = append(events, &hubEvent{
+				Seq:      e.Seq,
+				Type:     e.Type,
+				Metric:   e.Metric,
+				Severity: e.Severity,
+				Team:     e.Team,
+				Data:     withSeq(e.Data, e.Seq),
+			})
+			after = e.Seq
+		}
+	case h.events == nil && req.lastSeq > head:
+		// The client saw events numbered by an earlier process.
+		after, complete = 0, false
+	case !h.log.covers(req.lastSeq) && req.lastSeq < head:
+		complete = false
+	}
+	events = append(events, h.log.since(after)...)
+
+	reply := ResumeReply{Type: "resumed", LastSeq: max(head, after), Complete: complete}
+	if len(events) > maxReplay {
+		events = events[:maxReplay]
+		reply.More = true
+		reply.LastSeq = events[len(events)-1].Seq
+	}
+
+	for _, e := range events {
+		if c.firstLive > 0 && e.Seq >= c.firstLive || !c.subs.matches(e) {
+			continue
+		}
+		if !h.deliver(c, e.Key, e.Data) {
+			return
+		}
+		reply.Replayed++
+	}
+	telemetry.WebSocketReplayed.Add(float64(reply.Replayed))
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		h.drop(c)
+		return
+	}
+	if h.deliver(c, "", data) && !reply.More {
+		c.catchingUp = false
+	}
+}
+
+// prune deletes events older than eventRetention from the event log.
+func (h *Hub) prune() {
+	ctx, cancel := context.WithTimeout(context.Background(), eventLogTimeout)
+	defer cancel()
+	if _, err := h.events.PruneEvents(ctx, time.Now().Add(-eventRetention)); err != nil {
+		log.Printf("⚠️  Failed to prune the event log: %v", err)
+	}
+}
+
+// withSeq adds a "seq" field to a JSON object.
+func withSeq(data []byte, seq int64) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	prefix := fmt.Sprintf(`{"seq":%d`, seq)
+	if data[1] != '}' {
+		prefix += ","
+	}
+	return append([]byte(prefix), data[1:]...)
+}
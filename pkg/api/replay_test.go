@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+func TestReplayLog(t *testing.T) {
+	l := newReplayLog(4)
+	if l.covers(0) || l.lastSeq() != 0 {
+		t.Fatal("an empty log covers events")
+	}
+	// Numbering may start anywhere, as it does when an event log numbers
+	// events; the log can vouch only for what comes after the first.
+	l.add(&hubEvent{Seq: 5})
+	if !l.covers(4) || l.covers(3) {
+		t.Fatalf("after adding seq 5: covers(4) = %v, covers(3) = %v, want true, false", l.covers(4), l.covers(3))
+	}
+	for seq := int64(6); seq <= 10; seq++ {
+		l.add(&hubEvent{Seq: seq})
+	}
+
+	// The ring holds 7 to 10; 5 and 6 were evicted.
+	tests := []struct {
+		after      int64
+		wantCovers bool
+		wantSince  []int64
+	}{
+		{4, false, []int64{7, 8, 9, 10}},
+		{5, false, []int64{7, 8, 9, 10}},
+		{6, true, []int64{7, 8, 9, 10}},
+		{8, true, []int64{9, 10}},
+		{10, true, nil},
+		{12, true, nil},
+	}
+	for _, tt := range tests {
+		if got := l.covers(tt.after); got != tt.wantCovers {
+			t.Errorf("covers(%d) = %v, want %v", tt.after, got, tt.wantCovers)
+		}
+		var since []int64
+		for _, e := range l.since(tt.after) {
+			since = append(since, e.Seq)
+		}
+		if !reflect.DeepEqual(since, tt.wantSince) {
+			t.Errorf("since(%d) = %v, want %v", tt.after, since, tt.wantSince)
+		}
+	}
+	if got := l.lastSeq(); got != 10 {
+		t.Fatalf("lastSeq() = %d, want 10", got)
+	}
+}
+
+// memEventLog is a storage.EventLog in memory. Events before floor have
+// been pruned, and err, when set, fails reads.
+type memEventLog struct {
+	mu     sync.Mutex
+	events []storage.Event
+	floor  int64
+	err    error
+}
+
+func (l *memEventLog) AppendEvent(ctx context.Context, e *storage.Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Seq = int64(len(l.events)) + 1
+	l.events = append(l.events, *e)
+	return nil
+}
+
+func (l *memEventLog) EventsSince(ctx context.Context, seq int64, limit int) ([]storage.Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return nil, l.err
+	}
+	var out []storage.Event
+	for _, e := range l.events {
+		if e.Seq > seq && e.Seq >= l.floor && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (l *memEventLog) PruneEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	return 0, nil
+}
+
+// resumeMessage is a message as a resuming client sees it: an event, or
+// a ResumeReply when Type is "resumed".
+type resumeMessage struct {
+	Seq int64 `json:"seq"`
+	ResumeReply
+}
+
+// catchUp drains c until it has caught up, resuming page by page as the
+// SSE handler does. It returns the seqs of the messages received, replies
+// in order among them as negative numbers, and the last reply.
+func catchUp(t *testing.T, h *Hub, c *Client) ([]int64, ResumeReply) {
+	t.Helper()
+	var got []int64
+	var final *ResumeReply
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-c.queue.ready:
+		case <-timeout:
+			t.Fatalf("no final resume reply; received %v", got)
+		}
+		messages, closed := c.queue.drain()
+		for _, data := range messages {
+			var m resumeMessage
+			if err := json.Unmarshal(data, &m); err != nil {
+				t.Fatalf("message %s: %v", data, err)
+			}
+			if m.Type != "resumed" {
+				got = append(got, m.Seq)
+				continue
+			}
+			got = append(got, -m.LastSeq)
+			if m.More {
+				h.resume(c, m.LastSeq)
+			} else {
+				final = &m.ResumeReply
+			}
+		}
+		if final != nil {
+			return got, *final
+		}
+		if closed {
+			t.Fatalf("client disconnected while catching up; received %v", got)
+		}
+	}
+}
+
+func seqs(from, to int64) []int64 {
+	var s []int64
+	for seq := from; seq <= to; seq++ {
+		s = append(s, seq)
+	}
+	return s
+}
+
+func TestResumeGaps(t *testing.T) {
+	const published = 20
+	unavailable := errors.New("connection refused")
+
+	tests := []struct {
+		name     string
+		eventLog *memEventLog
+		lastSeq  int64
+		// The in-memory log keeps the last 8 events: 13 to 20.
+		wantSeqs     []int64
+		wantComplete bool
+	}{
+		{"in memory", nil, 15, seqs(16, 20), true},
+		{"up to date", nil, 20, nil, true},
+		{"evicted from memory", nil, 5, seqs(13, 20), false},
+		{"from an earlier process", nil, 50, seqs(13, 20), false},
+		{"from the event log", &memEventLog{}, 5, seqs(6, 20), true},
+		{"pruned from the event log", &memEventLog{floor: 10}, 5, seqs(10, 20), false},
+		{"event log unavailable", &memEventLog{err: unavailable}, 5, seqs(13, 20), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(nil)
+			h.log = newReplayLog(8)
+			if tt.eventLog != nil {
+				h.SetEventLog(tt.eventLog)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go h.Run(ctx)
+
+			publishN(h, published, func(int) string { return "" })
+			waitFor(t, "every event to be broadcast", func() bool { return h.log.lastSeq() == published })
+
+			got, reply := catchUp(t, h, h.attach(nil, tt.lastSeq))
+			want := append(tt.wantSeqs, -published)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("resuming from %d received %v, want %v", tt.lastSeq, got, want)
+			}
+			if reply.Complete != tt.wantComplete || reply.Replayed != len(tt.wantSeqs) {
+				t.Fatalf("reply = %+v, want complete %v after %d events", reply, tt.wantComplete, len(tt.wantSeqs))
+			}
+		})
+	}
+}
+
+// TestResumeOrdering resumes while events are still being published, and
+// checks that the client gets what it missed, page by page, before any
+// live event.
+func TestResumeOrdering(t *testing.T) {
+	const before, during = 600, 100
+
+	for _, missed := range []int64{0, 5, maxReplay, 2*maxReplay + 50} {
+		t.Run(fmt.Sprintf("missed=%d", missed), func(t *testing.T) {
+			h := startHub(t, SlowClientsDisconnect)
+			publishN(h, before, func(int) string { return "" })
+			waitFor(t, "the missed events to be broadcast", func() bool { return h.log.lastSeq() == before })
+
+			published := make(chan struct{})
+			go func() {
+				defer close(published)
+				publishN(h, during, func(int) string { return "" })
+			}()
+
+			lastSeq := before - missed
+			c := h.attach(nil, lastSeq)
+			got, reply := catchUp(t, h, c)
+			if !reply.Complete {
+				t.Fatalf("reply = %+v, want complete", reply)
+			}
+			<-published
+			waitFor(t, "every live event", func() bool {
+				messages, _ := c.queue.drain()
+				for _, m := range decodeMessages(t, messages) {
+					got = append(got, m.Seq)
+				}
+				// The final reply may already cover every event.
+				last := got[len(got)-1]
+				return last == before+during || last == -(before+during)
+			})
+
+			// Every event after lastSeq, once and in order, with each
+			// reply right after the last event it covers.
+			var events []int64
+			for i, seq := range got {
+				if seq > 0 {
+					events = append(events, seq)
+					continue
+				}
+				if i > 0 && got[i-1] > 0 && got[i-1] != -seq || i == 0 && -seq != lastSeq {
+					t.Fatalf("reply for %d after seq %v: %v", -seq, got[max(i-1, 0)], got)
+				}
+			}
+			if want := seqs(lastSeq+1, before+during); !reflect.DeepEqual(events, want) {
+				t.Fatalf("received %d events %v..., want %d from %d in order", len(events), events[:min(len(events), 5)], len(want), lastSeq+1)
+			}
+		})
+	}
+}
+
+func TestServeWSResume(t *testing.T) {
+	h := startHub(t, SlowClientsDisconnect)
+	srv := httptest.NewServer(http.HandlerFunc(h.ServeWS))
+	defer srv.Close()
+
+	metric := storage.Metric{MetricName: "api_latency", Labels: map[string]string{TeamLabel: "payments"}}
+	for i := 1; i <= 6; i++ {
+		h.BroadcastAnomaly(storage.Anomaly{ID: i, Severity: []string{"low", "critical"}[i%2]}, metric)
+	}
+	waitFor(t, "every event to be broadcast", func() bool { return h.log.lastSeq() == 6 })
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantStatus int
+	}{
+		{"bad last_seq", url.Values{"last_seq": {"-1"}}, http.StatusBadRequest},
+		{"bad subscription", url.Values{"subscribe": {`{"min_severity":"urgent"}`}}, http.StatusBadRequest},
+		{"subscription not json", url.Values{"subscribe": {`critical`}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeWS(rec, httptest.NewRequest("GET", "/ws/anomalies?"+tt.query.Encode(), nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// Subscribed to critical events as it connects, the client is sent the
+	// critical ones after seq 2, then the reply, then live events.
+	query := url.Values{"last_seq": {"2"}, "subscribe": {`{"min_severity":"critical"}`}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var got []string
+	read := func(n int) {
+		t.Helper()
+		for ; n > 0; n-- {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var m resumeMessage
+			if err := conn.ReadJSON(&m); err != nil {
+				t.Fatalf("read after %v: %v", got, err)
+			}
+			got = append(got, fmt.Sprintf("%s %d", m.Type, max(m.Seq, m.LastSeq)))
+		}
+	}
+	read(3)
+	h.BroadcastAnomaly(storage.Anomaly{ID: 7, Severity: "critical"}, metric)
+	h.BroadcastAnomaly(storage.Anomaly{ID: 8, Severity: "low"}, metric)
+	h.BroadcastAnomaly(storage.Anomaly{ID: 9, Severity: "critical"}, metric)
+	read(2)
+	want := []string{"anomaly_detected 3", "anomaly_detected 5", "resumed 6", "anomaly_detected 7", "anomaly_detected 9"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+}
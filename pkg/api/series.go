@@ -0,0 +1,193 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+const (
+	defaultSeriesWindow    = 24 * time.Hour
+	defaultSeriesMaxPoints = 1000
+	maxSeriesMaxPoints     = 5000
+	maxSeriesAnomalies     = 1000
+)
+
+type SeriesResponse struct {
+	Metric      MetricInfo          `json:"metric"`
+	From        string              `json:"from"`
+	To          string              `json:"to"`
+	StepSeconds int64               `json:"step_seconds"`
+	Points      []SeriesPointInfo   `json:"points"`
+	Expected    []ExpectedPointInfo `json:"expected"`
+	Anomalies   []AnomalyInfo       `json:"anomalies"`
+}
+
+type SeriesPointInfo struct {
+	Timestamp string  `json:"timestamp"`
+	Value     float64 `json:"value"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Count     int     `json:"count"`
+}
+
+type ExpectedPointInfo struct {
+	Timestamp string  `json:"timestamp"`
+	Value     float64 `json:"value"`
+	Lower     float64 `json:"lower"`
+	Upper     float64 `json:"upper"`
+}
+
+// handleGetMetricSeries serves GET /api/metrics/{id}/series. from and to
+// default to the last 24 hours; step (a duration such as 5m, or seconds) is
+// raised as needed so the response has at most max_points points.
+func (s *Server) handleGetMetricSeries(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "metric")
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	to := time.Now()
+	if v := params.Get("to"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid to")
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultSeriesWindow)
+	if v := params.Get("from"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid from")
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		respondError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	maxPoints := defaultSeriesMaxPoints
+	if v := params.Get("max_points"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid max_points")
+			return
+		}
+		maxPoints = min(n, maxSeriesMaxPoints)
+	}
+
+	var step time.Duration
+	if v := params.Get("step"); v != "" {
+		d, err := parseStep(v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid step")
+			return
+		}
+		step = d
+	}
+	step = seriesStep(from, to, step, maxPoints)
+
+	ctx := r.Context()
+	metric, err := s.db.GetMetric(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "Metric not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch metric")
+		return
+	}
+
+	points, err := s.db.GetMetricSeries(ctx, id, from, to, step)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch series")
+		return
+	}
+
+	expected, err := s.db.GetBaseline(ctx, id, from, to, step)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch baseline")
+		return
+	}
+
+	page, err := s.db.QueryAnomalies(ctx, storage.AnomalyQuery{
+		MetricID: id,
+		From:     &from,
+		To:       &to,
+		Sort:     "timestamp",
+		Limit:    maxSeriesAnomalies,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch anomalies")
+		return
+	}
+
+	response := SeriesResponse{
+		Metric:      newMetricInfo(*metric),
+		From:        from.UTC().Format(time.RFC3339),
+		To:          to.UTC().Format(time.RFC3339),
+		StepSeconds: int64(step / time.Second),
+		Points:      make([]SeriesPointInfo, len(points)),
+		Expected:    make([]ExpectedPointInfo, len(expected)),
+		Anomalies:   make([]AnomalyInfo, len(page.Anomalies)),
+	}
+	for i, p := range points {
+		response.Points[i] = SeriesPointInfo{
+			Timestamp: p.Timestamp.UTC().Format(time.RFC3339),
+			Value:     p.Avg,
+			Min:       p.Min,
+			Max:       p.Max,
+			Count:     p.Count,
+		}
+	}
+	for i, e := range expected {
+		response.Expected[i] = ExpectedPointInfo{
+			Timestamp: e.Timestamp.UTC().Format(time.RFC3339),
+			Value:     e.Value,
+			Lower:     e.Lower,
+			Upper:     e.Upper,
+		}
+	}
+	for i, a := range page.Anomalies {
+		response.Anomalies[i] = newAnomalyInfo(a.Anomaly)
+		response.Anomalies[i].MetricName = a.MetricName
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// seriesStep returns the bucket width to use: the requested step, widened so
+// that the window fits in maxPoints buckets and rounded up to whole seconds.
+// Rounding up keeps the step from dropping back below that minimum.
+func seriesStep(from, to time.Time, requested time.Duration, maxPoints int) time.Duration {
+	minStep := (to.Sub(from) + time.Duration(maxPoints) - 1) / time.Duration(maxPoints)
+	step := max(requested, minStep, time.Second)
+	return (step + time.Second - 1).Truncate(time.Second)
+}
+
+func parseStep(v string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs <= 0 {
+			return 0, errors.New("step must be positive")
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("step must be positive")
+	}
+	return d, nil
+}
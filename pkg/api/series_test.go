@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSeriesStep(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		window    time.Duration
+		requested time.Duration
+		maxPoints int
+		want      time.Duration
+	}{
+		{"requested step fits", time.Hour, 90 * time.Second, 1000, 90 * time.Second},
+		{"requested step exactly", time.Hour, 5 * time.Minute, 12, 5 * time.Minute},
+		{"requested step too fine", time.Hour, time.Second, 60, time.Minute},
+		{"fractional step", 10 * time.Minute, 1500 * time.Millisecond, 1000, 2 * time.Second},
+		{"no step, short window", 10 * time.Second, 0, 1000, time.Second},
+		{"no step, rounded up", time.Hour, 0, 1000, 4 * time.Second},
+		// 514.29s would round to 514s, which needs 8 points.
+		{"no step, rounding down would overflow", time.Hour, 0, 7, 515 * time.Second},
+		{"no step, a day", 24 * time.Hour, 0, 1000, 87 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := seriesStep(from, from.Add(tt.window), tt.requested, tt.maxPoints); got != tt.want {
+				t.Fatalf("seriesStep(%v, %v, %d) = %v, want %v", tt.window, tt.requested, tt.maxPoints, got, tt.want)
+			}
+		})
+	}
+
+	// Whatever the window, the step is whole seconds and fits it in
+	// maxPoints buckets.
+	for _, window := range []time.Duration{time.Minute, 7 * time.Hour, 30*24*time.Hour + 17*time.Second} {
+		for maxPoints := 1; maxPoints <= 5000; maxPoints = maxPoints*3 + 1 {
+			step := seriesStep(from, from.Add(window), 0, maxPoints)
+			if step%time.Second != 0 || step*time.Duration(maxPoints) < window {
+				t.Fatalf("seriesStep(%v, 0, %d) = %v", window, maxPoints, step)
+			}
+		}
+	}
+}
+
+func TestParseStep(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"300", 5 * time.Minute, false},
+		{"5m", 5 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"0", 0, true},
+		{"-5m", 0, true},
+		{"five", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseStep(tt.v)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseStep(%q) = %v, %v, want %v, error %v", tt.v, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestGetMetricSeriesDownsampling reads the fixture's hour of minutely
+// points, valued 40 to 44 in turn, in wider buckets.
+func TestGetMetricSeriesDownsampling(t *testing.T) {
+	f := newTestFixture(t)
+	from, to := f.start.Unix(), f.start.Add(time.Hour).Unix()
+
+	tests := []struct {
+		name      string
+		query     string
+		wantStep  int64
+		maxPoints int
+	}{
+		{"step", "step=10m", 600, 7},
+		{"step in seconds", "step=300", 300, 13},
+		{"max_points widens the step", "max_points=7", 515, 8},
+		{"max_points overrides a fine step", "step=1m&max_points=6", 600, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp SeriesResponse
+			path := fmt.Sprintf("/api/metrics/%d/series?from=%d&to=%d&%s", f.metric.ID, from, to, tt.query)
+			decodeBody(t, f.do(t, "GET", path, ""), &resp)
+
+			if resp.StepSeconds != tt.wantStep {
+				t.Fatalf("step_seconds = %d, want %d", resp.StepSeconds, tt.wantStep)
+			}
+			// Buckets are aligned to the step, so the window may be cut
+			// into one more than it spans.
+			if len(resp.Points) == 0 || len(resp.Points) > tt.maxPoints {
+				t.Fatalf("%d points, want 1 to %d", len(resp.Points), tt.maxPoints)
+			}
+			count := 0
+			for _, p := range resp.Points {
+				ts, err := time.Parse(time.RFC3339, p.Timestamp)
+				if err != nil || ts.Unix()%resp.StepSeconds != 0 {
+					t.Fatalf("point at %s is not on a %ds boundary", p.Timestamp, resp.StepSeconds)
+				}
+				if p.Min < 40 || p.Max > 44 || p.Value < p.Min || p.Value > p.Max {
+					t.Fatalf("point %+v outside the data", p)
+				}
+				// A full cycle of the five values averages 42.
+				if p.Count%5 == 0 && p.Value != 42 {
+					t.Fatalf("point %+v of whole cycles, want an average of 42", p)
+				}
+				count += p.Count
+			}
+			if count != 60 {
+				t.Fatalf("points cover %d samples, want 60", count)
+			}
+		})
+	}
+
+	rec := f.do(t, "GET", fmt.Sprintf("/api/metrics/%d/series?step=0", f.metric.ID), "")
+	if rec.Code != 400 {
+		t.Fatalf("step=0: status = %d, want 400", rec.Code)
+	}
+}
@@ -8,21 +8,60 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mjrtuhin/argus/pkg/alerting"
 	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
 )
 
+type Config struct {
+	Port               string
+	SlackSigningSecret string
+	// SlackUsers maps Slack user IDs to grants, as stored on API keys, for
+	// the buttons on Slack alerts. Users not listed can't use them.
+	SlackUsers map[string][]string
+	Auth       AuthConfig
+	// AllowedOrigins lists browser origins allowed to call the API and open
+	// the WebSocket. "*" allows any origin.
+	AllowedOrigins []string
+	// SlowClients is what happens to event stream clients that fall
+	// behind. Empty means SlowClientsDisconnect.
+	SlowClients SlowClientPolicy
+}
+
 type Server struct {
-	router *mux.Router
-	db     *storage.DB
-	hub    *Hub
-	port   string
+	router   *mux.Router
+	db       storage.Store
+	notifier *alerting.Notifier
+	hub      *Hub
+	oidc     *oidcVerifier
+	config   Config
+	port     string
+
+	dependencies []dependency
+	workers      []workerProbe
+	queues       []queueProbe
 }
-func NewServer(db *storage.DB, port string) *Server {
+func NewServer(db storage.Store, notifier *alerting.Notifier, config Config) *Server {
 	s := &Server{
-		router: mux.NewRouter(),
-		db:     db,
-		hub:    NewHub(),
-		port:   port,
+		router:   mux.NewRouter(),
+		db:       db,
+		notifier: notifier,
+		config:   config,
+		port:     config.Port,
+	}
+	s.hub = NewHub(s.checkWSOrigin)
+	if config.SlowClients != "" {
+		s.hub.SetSlowClientPolicy(config.SlowClients)
+	}
+	if events, ok := db.(storage.EventLog); ok {
+		s.hub.SetEventLog(events)
+	}
+	s.registerDefaultProbes()
+	if notifier != nil {
+		notifier.OnStatusChange(s.publishStatusChange)
+	}
+	if config.Auth.OIDC != nil {
+		s.oidc = newOIDCVerifier(*config.Auth.OIDC)
 	}
 
 	s.setupRoutes()
@@ -31,25 +70,51 @@ func NewServer(db *storage.DB, port string) *Server {
 func (s *Server) setupRoutes() {
 	// Health check
 	s.router.HandleFunc("/health", s.handleHealth).Methods("GET")
+	s.router.HandleFunc("/livez", s.handleLivez).Methods("GET")
+	s.router.HandleFunc("/readyz", s.handleReadyz).Methods("GET")
+
+	// Argus's own metrics for Prometheus
+	s.router.Handle("/metrics", telemetry.Handler()).Methods("GET")
+	s.router.Handle(anomalyExportPath, telemetry.Exports.Handler()).Methods("GET")
 
 	// WebSocket endpoint
 	s.router.HandleFunc("/ws/anomalies", s.hub.ServeWS)
 
 	// API routes
 	api := s.router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/openapi.json", s.handleGetOpenAPI).Methods("GET")
+	api.HandleFunc("/me", s.handleGetMe).Methods("GET")
+	api.HandleFunc("/status", s.handleGetStatus).Methods("GET")
+	api.HandleFunc("/events", s.handleEvents).Methods("GET")
 	api.HandleFunc("/metrics", s.handleGetMetrics).Methods("GET")
+	api.HandleFunc("/metrics/{id}/series", s.handleGetMetricSeries).Methods("GET")
 	api.HandleFunc("/anomalies", s.handleGetAnomalies).Methods("GET")
 	api.HandleFunc("/anomalies/{id}", s.handleGetAnomalyByID).Methods("GET")
-
-	// CORS middleware
-	s.router.Use(corsMiddleware)
+	api.HandleFunc("/anomalies/{id}/acknowledge", s.handleAcknowledgeAnomaly).Methods("POST", "OPTIONS")
+	api.HandleFunc("/anomalies/{id}/status", s.handleUpdateAnomalyStatus).Methods("POST", "OPTIONS")
+	api.HandleFunc("/incidents/{id}/acknowledge", s.handleAcknowledgeIncident).Methods("POST", "OPTIONS")
+	api.HandleFunc("/notifications", s.handleGetNotifications).Methods("GET")
+	api.HandleFunc("/notifications/{id}", s.handleGetNotificationByID).Methods("GET")
+	api.HandleFunc("/notifications/{id}/retry", s.handleRetryNotification).Methods("POST", "OPTIONS")
+	api.HandleFunc("/slack/interactions", s.handleSlackInteraction).Methods("POST")
+
+	// Grafana SimpleJSON datasource
+	api.HandleFunc("/grafana/", s.handleGrafanaTest).Methods("GET")
+	api.HandleFunc("/grafana/search", s.handleGrafanaSearch).Methods("POST", "OPTIONS")
+	api.HandleFunc("/grafana/query", s.handleGrafanaQuery).Methods("POST", "OPTIONS")
+	api.HandleFunc("/grafana/annotations", s.handleGrafanaAnnotations).Methods("POST", "OPTIONS")
+
+	// Logging and authentication middleware. CORS wraps the whole router in
+	// Handler, since mux only runs middleware on matched routes and
+	// preflights would otherwise get a bare 404.
 	s.router.Use(loggingMiddleware)
+	s.router.Use(s.authMiddleware)
 }
 
 func (s *Server) Start(ctx context.Context) error {
 	srv := &http.Server{
 		Addr:         ":" + s.port,
-		Handler:      s.router,
+		Handler:      s.Handler(),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -63,10 +128,18 @@ func (s *Server) Start(ctx context.Context) error {
 		srv.Shutdown(shutdownCtx)
 	}()
 
+	go s.watchDependencies(ctx)
+
+	for _, route := range s.undocumentedRoutes() {
+		log.Printf("⚠️  %s is missing from the OpenAPI document", route)
+	}
+
 	log.Printf("🌐 API server started on http://localhost:%s", s.port)
 	log.Printf("   Health: http://localhost:%s/health", s.port)
+	log.Printf("   Metrics: http://localhost:%s/metrics", s.port)
 	log.Printf("   Metrics: http://localhost:%s/api/metrics", s.port)
 	log.Printf("   Anomalies: http://localhost:%s/api/anomalies", s.port)
+	log.Printf("   OpenAPI: http://localhost:%s/api/openapi.json", s.port)
 
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("server error: %w", err)
@@ -75,11 +148,19 @@ func (s *Server) Start(ctx context.Context) error {
 	return nil
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
+// Handler returns the server's routes with CORS applied to every request.
+func (s *Server) Handler() http.Handler {
+	return s.corsMiddleware(s.router)
+}
+
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origin != "" && s.allowedOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		}
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	s := NewServer(nil, nil, Config{AllowedOrigins: []string{"http://localhost:3000"}})
+	h := s.Handler()
+
+	tests := []struct {
+		path   string
+		origin string
+		allow  bool
+	}{
+		{"/api/anomalies", "http://localhost:3000", true},
+		{"/api/metrics", "http://localhost:3000", true},
+		{"/api/events", "http://localhost:3000", true},
+		{"/api/anomalies/1/status", "http://localhost:3000", true},
+		{"/api/anomalies", "https://evil.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" from "+tt.origin, func(t *testing.T) {
+			req := httptest.NewRequest("OPTIONS", tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", "GET")
+			req.Header.Set("Access-Control-Request-Headers", "x-api-key")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			got := rec.Header().Get("Access-Control-Allow-Origin")
+			if tt.allow && got != tt.origin {
+				t.Fatalf("Access-Control-Allow-Origin = %q, want %q", got, tt.origin)
+			}
+			if !tt.allow && got != "" {
+				t.Fatalf("Access-Control-Allow-Origin = %q for a disallowed origin", got)
+			}
+		})
+	}
+}
+
+func TestCORSOnUnauthorizedResponse(t *testing.T) {
+	s := NewServer(nil, nil, Config{AllowedOrigins: []string{"http://localhost:3000"}})
+	req := httptest.NewRequest("GET", "/api/anomalies", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Fatal("401 response is missing Access-Control-Allow-Origin")
+	}
+}
+
+func TestAnomalyExportAuth(t *testing.T) {
+	f := newTestFixture(t)
+	s := NewServer(f.db, nil, Config{Auth: AuthConfig{ScrapeToken: "scrape-secret"}})
+	key, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.db.CreateAPIKey(context.Background(), &storage.APIKey{Name: "grafana", Prefix: prefix, KeyHash: hash, Scopes: []string{RoleViewer}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"no credentials", "/metrics/anomalies", "", http.StatusUnauthorized},
+		{"scrape token", "/metrics/anomalies", "scrape-secret", http.StatusOK},
+		{"wrong scrape token", "/metrics/anomalies", "scrape-secrets", http.StatusUnauthorized},
+		{"api key", "/metrics/anomalies", key, http.StatusOK},
+		{"scrape token elsewhere", "/api/anomalies", "scrape-secret", http.StatusUnauthorized},
+		{"own metrics stay public", "/metrics", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// Without a scrape token configured, an empty bearer token is not one.
+	open := NewServer(f.db, nil, Config{})
+	req := httptest.NewRequest("GET", "/metrics/anomalies", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	open.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("empty token without a scrape token: status = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/alerting"
+)
+
+// Slack interaction payloads are small; anything larger is not from Slack.
+const maxSlackBodyBytes = 1 << 20
+
+func (s *Server) handleSlackInteraction(w http.ResponseWriter, r *http.Request) {
+	if s.config.SlackSigningSecret == "" {
+		respondError(w, http.StatusServiceUnavailable, "Slack interactivity is not configured")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSlackBodyBytes))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+
+	if err := alerting.VerifySlackSignature(
+		s.config.SlackSigningSecret,
+		r.Header.Get("X-Slack-Request-Timestamp"),
+		r.Header.Get("X-Slack-Signature"),
+		body,
+		time.Now(),
+	); err != nil {
+		respondError(w, http.StatusUnauthorized, "Invalid Slack signature")
+		return
+	}
+
+	interaction, err := alerting.ParseSlackInteraction(body)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid Slack payload")
+		return
+	}
+
+	if err := s.notifier.HandleSlackInteraction(r.Context(), interaction, s.authorizeSlack); err != nil {
+		log.Printf("❌ Slack interaction failed: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to handle Slack action")
+		return
+	}
+
+	// Slack only needs a quick 200; the message is updated via response_url
+	w.WriteHeader(http.StatusOK)
+}
+
+// authorizeSlack maps a Slack user to a principal through
+// Config.SlackUsers and applies the checks of the status API: a responder
+// in the team that owns the anomaly's metric.
+func (s *Server) authorizeSlack(ctx context.Context, userID string, anomalyID int) error {
+	if s.config.Auth.Disabled {
+		return nil
+	}
+	grants, ok := s.config.SlackUsers[userID]
+	if !ok {
+		return fmt.Errorf("%w: Slack user %s has no Argus role", alerting.ErrSlackForbidden, userID)
+	}
+	p := newPrincipal("slack:"+userID, "slack", grants)
+	if !p.HasRole(RoleResponder) {
+		return fmt.Errorf("%w: requires %s role", alerting.ErrSlackForbidden, RoleResponder)
+	}
+
+	anomaly, err := s.db.GetAnomaly(ctx, anomalyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Nothing to change; the notifier reports it.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	team, err := s.metricTeam(ctx, anomaly.MetricID)
+	if err != nil {
+		return err
+	}
+	if err := s.teamAccess(p, team); err != nil {
+		return fmt.Errorf("%w: %v", alerting.ErrSlackForbidden, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/alerting"
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+const testSlackSecret = "slack-signing-secret"
+
+// slackClick posts a signed click on the acknowledge button of an anomaly,
+// as Slack does, and returns the response status.
+func slackClick(t *testing.T, s *Server, userID string, anomalyID int) int {
+	t.Helper()
+	payload, err := json.Marshal(map[string]interface{}{
+		"type": "block_actions",
+		"user": map[string]string{"id": userID, "username": "ana"},
+		"actions": []map[string]string{{
+			"action_id": "argus_acknowledge",
+			"block_id":  "argus_actions:oncall-slack",
+			"value":     strconv.Itoa(anomalyID),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := "payload=" + url.QueryEscape(string(payload))
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req := httptest.NewRequest("POST", "/api/slack/interactions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", alerting.SignSlackRequest(testSlackSecret, ts, []byte(body)))
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestSlackInteractionAuthorization(t *testing.T) {
+	f := newTestFixture(t)
+	ctx := context.Background()
+	teamless, _, err := f.db.CreateMetric(ctx, "queue_depth", map[string]string{"job": "worker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := alerting.NewNotifier(&alerting.Config{Receivers: []alerting.ReceiverConfig{{Name: "oncall-slack", Type: "slack"}}}, f.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(f.db, notifier, Config{
+		SlackSigningSecret: testSlackSecret,
+		SlackUsers: map[string][]string{
+			"UVIEWER":   {RoleViewer, "team:payments"},
+			"USEARCH":   {RoleResponder, "team:search"},
+			"UPAYMENTS": {RoleResponder, "team:payments"},
+			"UADMIN":    {RoleAdmin},
+		},
+	})
+
+	tests := []struct {
+		name   string
+		user   string
+		metric *storage.Metric
+		want   string
+	}{
+		{"unknown user", "UNKNOWN", f.metric, "open"},
+		{"viewer", "UVIEWER", f.metric, "open"},
+		{"responder in another team", "USEARCH", f.metric, "open"},
+		{"responder in the team", "UPAYMENTS", f.metric, "acknowledged"},
+		{"responder on a teamless metric", "UPAYMENTS", teamless, "open"},
+		{"admin on a teamless metric", "UADMIN", teamless, "acknowledged"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anomaly := &storage.Anomaly{MetricID: tt.metric.ID, Timestamp: time.Now(), Value: 90, AnomalyScore: 0.9, Severity: "high", Status: "open"}
+			if err := f.db.CreateAnomaly(ctx, anomaly); err != nil {
+				t.Fatal(err)
+			}
+			// Refused clicks are answered in Slack, not with an error.
+			if code := slackClick(t, s, tt.user, anomaly.ID); code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", code)
+			}
+			got, err := f.db.GetAnomaly(ctx, anomaly.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.want {
+				t.Fatalf("anomaly status = %s, want %s", got.Status, tt.want)
+			}
+		})
+	}
+}
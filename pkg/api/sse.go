@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// eventsPath streams hub events as Server-Sent Events, for clients and
+// proxies that cannot use WebSockets.
+const eventsPath = "/api/events"
+
+// sseHeartbeatInterval is how often an idle stream gets a comment. Tests
+// shorten it.
+var sseHeartbeatInterval = 15 * time.Second
+
+const (
+	sseWriteTimeout = 10 * time.Second
+	// sseRetry is the reconnect delay suggested to clients.
+	sseRetry = 5 * time.Second
+)
+
+// handleEvents streams the events matching the filter in the query string:
+//
+//	metrics        metric names or patterns, comma separated
+//	min_severity   lowest severity
+//	teams          team label values, comma separated
+//	types          event types, comma separated
+//
+// Each event is sent with its seq as the id and its type as the event name.
+// A client that reconnects with a Last-Event-ID header, or ?last_event_id=,
+// first gets the events it missed, then a "resumed" event holding a
+// ResumeReply. A comment is sent every sseHeartbeatInterval to keep proxies
+// from closing an idle stream.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	lastSeq, err := parseLastSeq(lastID)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	write := func(frame string) bool {
+		rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+		if _, err := fmt.Fprint(w, frame); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !write(fmt.Sprintf("retry: %d\n\n", sseRetry.Milliseconds())) {
+		return
+	}
+
+	client := s.hub.attach(filter, lastSeq)
+	defer s.hub.detach(client)
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		case <-client.queue.ready:
+			messages, closed := client.queue.drain()
+			for _, message := range messages {
+				frame, reply := sseFrame(message)
+				if !write(frame) {
+					return
+				}
+				if reply.More {
+					s.hub.resume(client, reply.LastSeq)
+				}
+			}
+			if closed {
+				// Disconnected by the hub for falling behind
+				return
+			}
+		}
+	}
+}
+
+// sseFrame formats a hub message as an event. For a resume reply it also
+// returns the reply, so the caller can continue the replay.
+func sseFrame(message []byte) (string, ResumeReply) {
+	var head struct {
+		Seq int64 `json:"seq"`
+		ResumeReply
+	}
+	json.Unmarshal(message, &head)
+
+	frame := ""
+	if head.Seq > 0 {
+		frame = fmt.Sprintf("id: %d\n", head.Seq)
+	}
+	if head.Type != "" {
+		frame += "event: " + head.Type + "\n"
+	}
+	frame += "data: " + string(message) + "\n\n"
+
+	if head.Type != "resumed" {
+		return frame, ResumeReply{}
+	}
+	return frame, head.ResumeReply
+}
+
+// parseLastSeq reads the sequence number a client resumes from; empty is
+// zero, for none.
+func parseLastSeq(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("invalid last event ID %q", s)
+	}
+	return seq, nil
+}
+
+// parseEventFilter reads a subscription filter from query parameters. It
+// returns nil when none are set.
+func parseEventFilter(params url.Values) (*SubscriptionFilter, error) {
+	filter := &SubscriptionFilter{
+		Metrics:     splitList(params.Get("metrics")),
+		MinSeverity: params.Get("min_severity"),
+		Teams:       splitList(params.Get("teams")),
+		Types:       splitList(params.Get("types")),
+	}
+	if filter.Metrics == nil && filter.MinSeverity == "" && filter.Teams == nil && filter.Types == nil {
+		return nil, nil
+	}
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/telemetry"
+)
+
+// sseStream is an open GET /api/events response.
+type sseStream struct {
+	t      *testing.T
+	body   *bufio.Reader
+	cancel context.CancelFunc
+}
+
+// serveEvents starts the fixture's hub and serves the API over HTTP.
+func serveEvents(t *testing.T, f *testFixture) (string, *Hub) {
+	t.Helper()
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+	hub := f.server.GetHub()
+	go hub.Run(ctx)
+	srv := httptest.NewServer(f.server.Handler())
+	t.Cleanup(srv.Close)
+	return srv.URL, hub
+}
+
+// openEvents opens an event stream with query and headers.
+func openEvents(t *testing.T, baseURL, query string, header http.Header) *sseStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+eventsPath+"?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s = %d %s", eventsPath, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return &sseStream{t: t, body: bufio.NewReader(resp.Body), cancel: cancel}
+}
+
+// next reads the next frame, up to the blank line that ends it.
+func (s *sseStream) next() string {
+	s.t.Helper()
+	type result struct {
+		frame string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var frame strings.Builder
+		for {
+			line, err := s.body.ReadString('\n')
+			if err != nil {
+				done <- result{frame.String(), err}
+				return
+			}
+			if line == "\n" {
+				done <- result{frame.String(), nil}
+				return
+			}
+			frame.WriteString(line)
+		}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			s.t.Fatalf("reading frame: %v (read %q)", r.err, r.frame)
+		}
+		return r.frame
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("timed out waiting for a frame")
+		return ""
+	}
+}
+
+// nextEvent skips heartbeats and returns the next event frame.
+func (s *sseStream) nextEvent() string {
+	s.t.Helper()
+	for {
+		if frame := s.next(); frame != ": heartbeat\n" {
+			return frame
+		}
+	}
+}
+
+func anomalyFrame(t *testing.T, frame string) (seq int64, msg EventMessage) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(frame, "\n"), "\n")
+	if len(lines) != 3 || lines[1] != "event: anomaly_detected" || !strings.HasPrefix(lines[2], "data: ") {
+		t.Fatalf("frame %q, want id, event and data lines", frame)
+	}
+	if _, err := fmt.Sscanf(lines[0], "id: %d", &seq); err != nil {
+		t.Fatalf("frame %q: %v", frame, err)
+	}
+	data := strings.TrimPrefix(lines[2], "data: ")
+	var head struct {
+		Seq int64 `json:"seq"`
+	}
+	if err := json.Unmarshal([]byte(data), &msg); err != nil || json.Unmarshal([]byte(data), &head) != nil {
+		t.Fatalf("frame %q: data is not an event: %v", frame, err)
+	}
+	if head.Seq != seq {
+		t.Fatalf("frame %q: id %d, seq %d", frame, seq, head.Seq)
+	}
+	return seq, msg
+}
+
+func TestEventsFraming(t *testing.T) {
+	f := newTestFixture(t)
+	url, hub := serveEvents(t, f)
+	stream := openEvents(t, url, "types=anomaly_detected&min_severity=high", nil)
+	if got, want := stream.next(), "retry: 5000\n"; got != want {
+		t.Fatalf("first frame = %q, want %q", got, want)
+	}
+
+	// The filter skips the cycle and the medium anomaly.
+	waitForClients(t, hub, 1)
+	hub.BroadcastCycle("collector", time.Second, map[string]int{"metrics": 1})
+	medium := *f.anomalies[0]
+	medium.Severity = "medium"
+	hub.BroadcastAnomaly(medium, *f.metric)
+	hub.BroadcastAnomaly(*f.anomalies[1], *f.metric)
+
+	seq, msg := anomalyFrame(t, stream.nextEvent())
+	if seq == 0 || msg.Anomaly == nil || msg.Anomaly.ID != f.anomalies[1].ID {
+		t.Fatalf("event %d = %+v, want anomaly %d", seq, msg, f.anomalies[1].ID)
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	f := newTestFixture(t)
+	url, hub := serveEvents(t, f)
+	openEvents(t, url, "", nil)
+	waitForClients(t, hub, 1)
+	for _, a := range f.anomalies {
+		hub.BroadcastAnomaly(*a, *f.metric)
+	}
+	waitFor(t, "both events to be broadcast", func() bool { return hub.log.lastSeq() >= 2 })
+	first := hub.log.lastSeq() - 1
+
+	// A client that saw the first anomaly gets the second, then a
+	// resumed event.
+	stream := openEvents(t, url, "types=anomaly_detected,resumed", http.Header{"Last-Event-ID": {fmt.Sprint(first)}})
+	stream.next() // retry
+	if seq, msg := anomalyFrame(t, stream.nextEvent()); seq != first+1 || msg.Anomaly.ID != f.anomalies[1].ID {
+		t.Fatalf("replayed event %d = %+v, want %d", seq, msg, first+1)
+	}
+	if frame := stream.nextEvent(); !strings.HasPrefix(frame, "event: resumed\ndata: ") {
+		t.Fatalf("frame after the replay = %q, want a resumed event", frame)
+	}
+}
+
+func TestEventsHeartbeat(t *testing.T) {
+	defer func(d time.Duration) { sseHeartbeatInterval = d }(sseHeartbeatInterval)
+	sseHeartbeatInterval = 10 * time.Millisecond
+
+	url, _ := serveEvents(t, newTestFixture(t))
+	stream := openEvents(t, url, "", nil)
+	stream.next() // retry
+	for i := 0; i < 3; i++ {
+		if got := stream.next(); got != ": heartbeat\n" {
+			t.Fatalf("idle frame = %q, want a heartbeat", got)
+		}
+	}
+}
+
+func TestEventsDisconnect(t *testing.T) {
+	f := newTestFixture(t)
+	url, hub := serveEvents(t, f)
+	stream := openEvents(t, url, "", nil)
+	stream.next() // retry
+	waitForClients(t, hub, 1)
+
+	stream.cancel()
+	waitForClients(t, hub, 0)
+	if got := gaugeValue(t, telemetry.WebSocketClients); got != 0 {
+		t.Fatalf("clients gauge = %v after the client left, want 0", got)
+	}
+}
+
+func TestEventsBadFilter(t *testing.T) {
+	f := newTestFixture(t)
+	for _, query := range []string{"metrics=%5B", "last_event_id=soon"} {
+		if rec := f.do(t, "GET", eventsPath+"?"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s?%s = %d, want 400", eventsPath, query, rec.Code)
+		}
+	}
+}
+
+func waitForClients(t *testing.T, h *Hub, n int) {
+	t.Helper()
+	waitFor(t, fmt.Sprintf("%d clients", n), func() bool {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		return len(h.clients) == n
+	})
+}
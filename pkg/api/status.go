@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A worker whose last successful cycle is older than this many intervals is
+// reported as stale.
+const staleCycles = 3
+
+type LivenessResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+type StatusResponse struct {
+	Status       string             `json:"status"`
+	Time         string             `json:"time"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+	Workers      []WorkerStatus     `json:"workers"`
+	Queues       []QueueStatus      `json:"queues"`
+}
+
+type DependencyStatus struct {
+	Name      string  `json:"name"`
+	Required  bool    `json:"required"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type WorkerStatus struct {
+	Name            string     `json:"name"`
+	Status          string     `json:"status"`
+	IntervalSeconds float64    `json:"interval_seconds"`
+	LastSuccessAt   *time.Time `json:"last_success_at"`
+	LastDurationMs  float64    `json:"last_duration_ms"`
+}
+
+type QueueStatus struct {
+	Name  string `json:"name"`
+	Depth int    `json:"depth"`
+	Error string `json:"error,omitempty"`
+}
+
+// WorkerState is what a background worker reports about itself.
+type WorkerState struct {
+	LastSuccess  time.Time
+	LastDuration time.Duration
+	Paused       bool
+}
+
+type dependency struct {
+	name     string
+	required bool
+	check    func(context.Context) error
+}
+
+type workerProbe struct {
+	name     string
+	interval time.Duration
+	state    func() WorkerState
+}
+
+type queueProbe struct {
+	name  string
+	depth func(context.Context) (int, error)
+}
+
+// AddDependency registers a service checked by /api/status. Required
+// dependencies must be up for /readyz to succeed.
+func (s *Server) AddDependency(name string, required bool, check func(context.Context) error) {
+	s.dependencies = append(s.dependencies, dependency{name, required, check})
+}
+
+// AddWorker registers a background worker reported by /api/status.
+func (s *Server) AddWorker(name string, interval time.Duration, state func() WorkerState) {
+	s.workers = append(s.workers, workerProbe{name, interval, state})
+}
+
+// AddQueue registers a queue whose depth is reported by /api/status.
+func (s *Server) AddQueue(name string, depth func(context.Context) (int, error)) {
+	s.queues = append(s.queues, queueProbe{name, depth})
+}
+
+func (s *Server) registerDefaultProbes() {
+	s.AddDependency("database", true, func(ctx context.Context) error {
+		return s.db.Ping(ctx)
+	})
+	s.AddQueue("websocket_broadcast", func(context.Context) (int, error) {
+		return s.hub.Pending(), nil
+	})
+	s.AddQueue("notifications", func(ctx context.Context) (int, error) {
+		stats, err := s.db.GetDeliveryStats(ctx)
+		pending := 0
+		for _, st := range stats {
+			pending += st.Pending
+		}
+		return pending, err
+	})
+}
+
+// checkDependencies runs the checks concurrently, each bounded by
+// healthCheckTimeout. With requiredOnly, optional dependencies are skipped.
+func (s *Server) checkDependencies(ctx context.Context, requiredOnly bool) []DependencyStatus {
+	var deps []dependency
+	for _, d := range s.dependencies {
+		if d.required || !requiredOnly {
+			deps = append(deps, d)
+		}
+	}
+
+	results := make([]DependencyStatus, len(deps))
+	var wg sync.WaitGroup
+	for i, d := range deps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := d.check(checkCtx)
+			results[i] = DependencyStatus{
+				Name:      d.name,
+				Required:  d.required,
+				Status:    "up",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status, results[i].Error = "down", err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// handleLivez reports that the process is up and serving requests. It
+// checks nothing else, so an outage of a dependency never restarts Argus.
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, LivenessResponse{Status: "alive"})
+}
+
+// handleReadyz reports whether Argus can serve traffic: every required
+// dependency must be up.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	response := ReadinessResponse{Status: "ready"}
+	for _, d := range s.checkDependencies(r.Context(), true) {
+		if d.Status != "up" {
+			if response.Failed == nil {
+				response.Failed = make(map[string]string)
+			}
+			response.Failed[d.Name] = d.Error
+		}
+	}
+	if response.Failed != nil {
+		response.Status = "not ready"
+		respondJSON(w, http.StatusServiceUnavailable, response)
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// handleGetStatus reports every dependency, worker and queue. Status is
+// "unavailable" when a required dependency is down, "degraded" when an
+// optional one is down or a worker is paused or stale, and "ok" otherwise.
+func (s *Server) handleGetStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	response := StatusResponse{
+		Status:       "ok",
+		Time:         timeNow(),
+		Dependencies: s.checkDependencies(ctx, false),
+		Workers:      []WorkerStatus{},
+		Queues:       []QueueStatus{},
+	}
+
+	degrade := func(status string) {
+		if response.Status == "ok" || status == "unavailable" {
+			response.Status = status
+		}
+	}
+	for _, d := range response.Dependencies {
+		if d.Status == "up" {
+			continue
+		}
+		if d.Required {
+			degrade("unavailable")
+		} else {
+			degrade("degraded")
+		}
+	}
+
+	now := time.Now()
+	for _, wp := range s.workers {
+		state := wp.state()
+		ws := WorkerStatus{
+			Name:            wp.name,
+			Status:          "running",
+			IntervalSeconds: wp.interval.Seconds(),
+			LastDurationMs:  float64(state.LastDuration.Microseconds()) / 1000,
+		}
+		if !state.LastSuccess.IsZero() {
+			last := state.LastSuccess
+			ws.LastSuccessAt = &last
+		}
+		switch {
+		case state.Paused:
+			ws.Status = "paused"
+		case state.LastSuccess.IsZero():
+			ws.Status = "starting"
+		case now.Sub(state.LastSuccess) > staleCycles*wp.interval:
+			ws.Status = "stale"
+		}
+		if ws.Status == "paused" || ws.Status == "stale" {
+			degrade("degraded")
+		}
+		response.Workers = append(response.Workers, ws)
+	}
+
+	for _, q := range s.queues {
+		depth, err := q.depth(ctx)
+		qs := QueueStatus{Name: q.name, Depth: depth}
+		if err != nil {
+			qs.Error = err.Error()
+		}
+		response.Queues = append(response.Queues, qs)
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// WebSocket clients receive every event until they subscribe. After that
+// they receive the events matching any of their subscriptions.
+//
+//	→ {"action": "subscribe", "id": "payments", "filter": {"teams": ["payments"], "min_severity": "high"}}
+//	← {"type": "subscribed", "id": "payments", "filter": {...}}
+//	→ {"action": "unsubscribe", "id": "payments"}
+//	← {"type": "unsubscribed", "id": "payments"}
+//
+// Unsubscribing without an id drops every subscription. Malformed requests
+// are answered with {"type": "error", "error": "..."}. Clients catch up after
+// reconnecting with the "resume" action, described in replay.go.
+
+// SubscriptionFilter selects hub events. Empty fields match everything.
+// Metrics and Teams apply only to events about a metric, and MinSeverity
+// only to anomaly and incident events, so that cycle and dependency events
+// chosen by Types still arrive.
+type SubscriptionFilter struct {
+	// Metrics are metric names or path.Match patterns such as "http_*".
+	Metrics     []string `json:"metrics,omitempty"`
+	MinSeverity string   `json:"min_severity,omitempty"`
+	// Teams match the metric's team label.
+	Teams []string `json:"teams,omitempty"`
+	Types []string `json:"types,omitempty"`
+}
+
+// SubscriptionRequest is a message sent by a WebSocket client.
+type SubscriptionRequest struct {
+	Action string             `json:"action"`
+	ID     string             `json:"id,omitempty"`
+	Filter SubscriptionFilter `json:"filter"`
+	// LastSeq is the last event seen, for the resume action.
+	LastSeq int64 `json:"last_seq,omitempty"`
+}
+
+// SubscriptionReply answers a SubscriptionRequest.
+type SubscriptionReply struct {
+	Type   string              `json:"type"`
+	ID     string              `json:"id,omitempty"`
+	Filter *SubscriptionFilter `json:"filter,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// Most subscriptions a client may hold at once.
+const maxSubscriptions = 32
+
+// hubEvent is a message for WebSocket clients along with what filters
+// match on. It is also the form relayed between replicas. Key identifies
+// what the event is about, for SlowClientsCoalesce.
+type hubEvent struct {
+	Seq      int64           `json:"seq,omitempty"`
+	Type     string          `json:"type"`
+	Metric   string          `json:"metric,omitempty"`
+	Severity string          `json:"severity,omitempty"`
+	Team     string          `json:"team,omitempty"`
+	Key      string          `json:"key,omitempty"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func (f *SubscriptionFilter) validate() error {
+	for _, m := range f.Metrics {
+		if _, err := path.Match(m, ""); err != nil {
+			return fmt.Errorf("invalid metric pattern %q", m)
+		}
+	}
+	if f.MinSeverity != "" && storage.SeverityRank(f.MinSeverity) == 0 {
+		return fmt.Errorf("unknown severity %q", f.MinSeverity)
+	}
+	return nil
+}
+
+// Event types that are not about a metric, and those that have a severity.
+var (
+	systemEvents   = []string{EventCycleCompleted, EventDependencyChanged}
+	severityEvents = []string{
+		EventAnomalyDetected, EventAnomalyStatusChanged,
+		EventIncidentOpened, EventIncidentUpdated, EventIncidentResolved,
+	}
+)
+
+func (f *SubscriptionFilter) matches(e *hubEvent) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+	aboutMetric := !slices.Contains(systemEvents, e.Type)
+	if aboutMetric && len(f.Metrics) > 0 && !slices.ContainsFunc(f.Metrics, func(p string) bool {
+		ok, _ := path.Match(p, e.Metric)
+		return ok
+	}) {
+		return false
+	}
+	if f.MinSeverity != "" && slices.Contains(severityEvents, e.Type) &&
+		storage.SeverityRank(e.Severity) < storage.SeverityRank(f.MinSeverity) {
+		return false
+	}
+	if aboutMetric && len(f.Teams) > 0 && !slices.Contains(f.Teams, e.Team) {
+		return false
+	}
+	return true
+}
+
+// subscriptions is a client's filters. It is changed by the client's read
+// loop and read by the hub.
+type subscriptions struct {
+	mu      sync.RWMutex
+	filters map[string]SubscriptionFilter // nil until the first subscribe
+	nextID  int
+}
+
+func (s *subscriptions) matches(e *hubEvent) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.filters == nil {
+		return true
+	}
+	for _, f := range s.filters {
+		if f.matches(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSubscriptions reads the filters a WebSocket client subscribes with
+// when it connects, each as JSON, keyed by the IDs the subscribe action
+// would give them. It returns nil when there are none.
+func parseSubscriptions(values []string) (map[string]SubscriptionFilter, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if len(values) > maxSubscriptions {
+		return nil, fmt.Errorf("at most %d subscriptions", maxSubscriptions)
+	}
+	filters := make(map[string]SubscriptionFilter)
+	for i, v := range values {
+		var f SubscriptionFilter
+		if err := json.Unmarshal([]byte(v), &f); err != nil {
+			return nil, fmt.Errorf("invalid subscription %q: %v", v, err)
+		}
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+		filters[fmt.Sprintf("sub-%d", i+1)] = f
+	}
+	return filters, nil
+}
+
+// handle applies a client request and returns the reply.
+func (s *subscriptions) handle(data []byte) SubscriptionReply {
+	var req SubscriptionRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return SubscriptionReply{Type: "error", Error: "invalid JSON: " + err.Error()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Action {
+	case "subscribe":
+		if err := req.Filter.validate(); err != nil {
+			return SubscriptionReply{Type: "error", ID: req.ID, Error: err.Error()}
+		}
+		if s.filters == nil {
+			s.filters = make(map[string]SubscriptionFilter)
+		}
+		if req.ID == "" {
+			s.nextID++
+			req.ID = fmt.Sprintf("sub-%d", s.nextID)
+		}
+		if _, exists := s.filters[req.ID]; !exists && len(s.filters) >= maxSubscriptions {
+			return SubscriptionReply{Type: "error", ID: req.ID, Error: fmt.Sprintf("at most %d subscriptions", maxSubscriptions)}
+		}
+		s.filters[req.ID] = req.Filter
+		return SubscriptionReply{Type: "subscribed", ID: req.ID, Filter: &req.Filter}
+	case "unsubscribe":
+		if req.ID == "" {
+			s.filters = make(map[string]SubscriptionFilter)
+		} else if _, ok := s.filters[req.ID]; ok {
+			delete(s.filters, req.ID)
+		} else {
+			return SubscriptionReply{Type: "error", ID: req.ID, Error: "no such subscription"}
+		}
+		return SubscriptionReply{Type: "unsubscribed", ID: req.ID}
+	default:
+		return SubscriptionReply{Type: "error", ID: req.ID, Error: fmt.Sprintf("unknown action %q", req.Action)}
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSubscriptionFilterMatches(t *testing.T) {
+	anomaly := &hubEvent{Type: EventAnomalyDetected, Metric: "http_requests_total", Severity: "high", Team: "payments"}
+	lowAnomaly := &hubEvent{Type: EventAnomalyDetected, Metric: "http_requests_total", Severity: "low", Team: "payments"}
+	noTeam := &hubEvent{Type: EventAnomalyDetected, Metric: "node_load1", Severity: "critical"}
+	incident := &hubEvent{Type: EventIncidentOpened, Metric: "db_connections", Severity: "critical", Team: "platform"}
+	discovered := &hubEvent{Type: EventMetricDiscovered, Metric: "http_errors_total", Team: "payments"}
+	cycle := &hubEvent{Type: EventCycleCompleted}
+	dependency := &hubEvent{Type: EventDependencyChanged}
+
+	tests := []struct {
+		name   string
+		filter SubscriptionFilter
+		event  *hubEvent
+		want   bool
+	}{
+		{"empty filter", SubscriptionFilter{}, anomaly, true},
+		{"empty filter, cycle", SubscriptionFilter{}, cycle, true},
+		{"type", SubscriptionFilter{Types: []string{EventIncidentOpened}}, incident, true},
+		{"other type", SubscriptionFilter{Types: []string{EventIncidentOpened}}, anomaly, false},
+		{"metric name", SubscriptionFilter{Metrics: []string{"http_requests_total"}}, anomaly, true},
+		{"metric pattern", SubscriptionFilter{Metrics: []string{"http_*"}}, discovered, true},
+		{"other metric", SubscriptionFilter{Metrics: []string{"http_*"}}, incident, false},
+		{"severity at the floor", SubscriptionFilter{MinSeverity: "high"}, anomaly, true},
+		{"severity above the floor", SubscriptionFilter{MinSeverity: "high"}, incident, true},
+		{"severity below the floor", SubscriptionFilter{MinSeverity: "high"}, lowAnomaly, false},
+		{"team", SubscriptionFilter{Teams: []string{"payments", "search"}}, anomaly, true},
+		{"other team", SubscriptionFilter{Teams: []string{"payments"}}, incident, false},
+		{"metric without a team", SubscriptionFilter{Teams: []string{"payments"}}, noTeam, false},
+		{"all fields", SubscriptionFilter{Types: []string{EventAnomalyDetected}, Metrics: []string{"http_*"}, MinSeverity: "medium", Teams: []string{"payments"}}, anomaly, true},
+
+		// Events without a severity, or not about a metric, pass the
+		// filters that do not apply to them.
+		{"severity floor, discovered metric", SubscriptionFilter{MinSeverity: "high"}, discovered, true},
+		{"severity floor, cycle", SubscriptionFilter{Types: []string{EventCycleCompleted, EventAnomalyDetected}, MinSeverity: "high"}, cycle, true},
+		{"severity floor, low anomaly with cycles", SubscriptionFilter{Types: []string{EventCycleCompleted, EventAnomalyDetected}, MinSeverity: "high"}, lowAnomaly, false},
+		{"team, dependency", SubscriptionFilter{Teams: []string{"payments"}}, dependency, true},
+		{"metric, cycle", SubscriptionFilter{Metrics: []string{"http_*"}}, cycle, true},
+		{"team, discovered metric of another team", SubscriptionFilter{Teams: []string{"platform"}}, discovered, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.matches(tt.event); got != tt.want {
+				t.Fatalf("matches(%+v) = %v, want %v", tt.event, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionFilterValidate(t *testing.T) {
+	tests := []struct {
+		filter  SubscriptionFilter
+		wantErr string
+	}{
+		{SubscriptionFilter{Metrics: []string{"http_*", "node_[a-z]*"}, MinSeverity: "critical"}, ""},
+		{SubscriptionFilter{Metrics: []string{"http_["}}, "invalid metric pattern"},
+		{SubscriptionFilter{MinSeverity: "urgent"}, "unknown severity"},
+	}
+	for _, tt := range tests {
+		err := tt.filter.validate()
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("validate(%+v) = %v, want %q", tt.filter, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSubscriptionsHandle(t *testing.T) {
+	var s subscriptions
+	cycle := &hubEvent{Type: EventCycleCompleted}
+	anomaly := &hubEvent{Type: EventAnomalyDetected, Metric: "http_requests_total", Severity: "critical", Team: "payments"}
+
+	steps := []struct {
+		request string
+		want    SubscriptionReply
+		// What the client receives afterwards.
+		wantCycle, wantAnomaly bool
+	}{
+		{``, SubscriptionReply{}, true, true},
+		{`{"action":"subscribe","id":"payments","filter":{"teams":["payments"]}}`, SubscriptionReply{Type: "subscribed", ID: "payments"}, true, true},
+		{`{"action":"subscribe","filter":{"types":["cycle_completed"]}}`, SubscriptionReply{Type: "subscribed", ID: "sub-1"}, true, true},
+		{`{"action":"unsubscribe","id":"payments"}`, SubscriptionReply{Type: "unsubscribed", ID: "payments"}, true, false},
+		{`{"action":"unsubscribe","id":"payments"}`, SubscriptionReply{Type: "error", ID: "payments", Error: "no such subscription"}, true, false},
+		// Replacing a subscription keeps its ID.
+		{`{"action":"subscribe","id":"sub-1","filter":{"types":["anomaly_detected"]}}`, SubscriptionReply{Type: "subscribed", ID: "sub-1"}, false, true},
+		{`{"action":"subscribe","filter":{"min_severity":"urgent"}}`, SubscriptionReply{Type: "error", Error: `unknown severity "urgent"`}, false, true},
+		{`{"action":"resubscribe"}`, SubscriptionReply{Type: "error", Error: `unknown action "resubscribe"`}, false, true},
+		{`{"action":`, SubscriptionReply{Type: "error", Error: "invalid JSON: unexpected end of JSON input"}, false, true},
+		// Unsubscribing from everything leaves the client receiving nothing,
+		// unlike a client that never subscribed.
+		{`{"action":"unsubscribe"}`, SubscriptionReply{Type: "unsubscribed"}, false, false},
+	}
+	for i, step := range steps {
+		if step.request != "" {
+			got := s.handle([]byte(step.request))
+			got.Filter = nil
+			if got != step.want {
+				t.Fatalf("step %d: handle(%s) = %+v, want %+v", i, step.request, got, step.want)
+			}
+		}
+		if got := s.matches(cycle); got != step.wantCycle {
+			t.Fatalf("step %d: matches(cycle) = %v, want %v", i, got, step.wantCycle)
+		}
+		if got := s.matches(anomaly); got != step.wantAnomaly {
+			t.Fatalf("step %d: matches(anomaly) = %v, want %v", i, got, step.wantAnomaly)
+		}
+	}
+}
+
+func TestSubscriptionsLimit(t *testing.T) {
+	var s subscriptions
+	for i := 0; i < maxSubscriptions; i++ {
+		if reply := s.handle([]byte(`{"action":"subscribe","filter":{}}`)); reply.Type != "subscribed" {
+			t.Fatalf("subscription %d: %+v", i+1, reply)
+		}
+	}
+	if reply := s.handle([]byte(`{"action":"subscribe","filter":{}}`)); reply.Type != "error" {
+		t.Fatalf("subscription %d = %+v, want an error", maxSubscriptions+1, reply)
+	}
+	// An existing subscription can still be replaced.
+	if reply := s.handle([]byte(`{"action":"subscribe","id":"sub-1","filter":{"types":["cycle_completed"]}}`)); reply.Type != "subscribed" {
+		t.Fatalf("replacing a subscription at the limit = %+v", reply)
+	}
+}
+
+func TestParseSubscriptions(t *testing.T) {
+	filters, err := parseSubscriptions([]string{`{"teams":["payments"]}`, `{"types":["cycle_completed"]}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 2 || filters["sub-1"].Teams[0] != "payments" || filters["sub-2"].Types[0] != EventCycleCompleted {
+		t.Fatalf("parseSubscriptions() = %+v", filters)
+	}
+	if filters, err := parseSubscriptions(nil); filters != nil || err != nil {
+		t.Fatalf("parseSubscriptions(nil) = %v, %v, want nil, nil", filters, err)
+	}
+
+	tooMany := make([]string, maxSubscriptions+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf(`{"metrics":["m%d"]}`, i)
+	}
+	for _, values := range [][]string{{`{"min_severity":"urgent"}`}, {`teams=payments`}, tooMany} {
+		if _, err := parseSubscriptions(values); err == nil {
+			t.Errorf("parseSubscriptions() of %d starting %s: error = nil", len(values), values[0])
+		}
+	}
+}
@@ -10,128 +10,295 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
-	},
-}
+// hubQueueSize is how many published events may wait to be recorded and
+// fanned out before further ones are dropped.
+const hubQueueSize = 1024
 
+// Hub fans events out to connected clients. Publishing never blocks the
+// caller: events go through outbox to a goroutine that records and relays
+// them, then to Run, which queues them for each client. What happens when
+// a client's queue is full is set by policy.
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
+	outbox     chan *hubEvent
+	broadcast  chan *hubEvent
+	register   chan *resumeRequest
 	unregister chan *Client
+	resumes    chan *resumeRequest
 	mu         sync.RWMutex
+	upgrader   websocket.Upgrader
+	policy     SlowClientPolicy
+
+	// done is closed when Run returns, so clients joining or leaving
+	// afterwards don't wait on it forever.
+	done chan struct{}
+
+	// relay, when set, publishes events to every replica instead of
+	// broadcasting them locally; each replica passes them to Deliver.
+	relay func([]byte) error
+
+	// log keeps recent events for clients that reconnect; events, when
+	// set, numbers them and keeps them for longer.
+	log    *replayLog
+	events storage.EventLog
 }
 
+// Client is a connection receiving hub events: a WebSocket, or an SSE
+// stream when conn is nil.
 type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
-	send chan []byte
-}
+	subs subscriptions
 
-type AnomalyMessage struct {
-	Type      string      `json:"type"`
-	Timestamp string      `json:"timestamp"`
-	Anomaly   AnomalyInfo `json:"anomaly"`
+	queue *clientQueue
+
+	// firstLive is the sequence number of the first event broadcast to the
+	// client, which resuming does not repeat. Used by the hub goroutine.
+	firstLive int64
+	// catchingUp is set while a client that connected to resume has missed
+	// events left to replay; it receives no broadcasts until then. Guarded
+	// by the hub's mu.
+	catchingUp bool
 }
 
-func NewHub() *Hub {
+func NewHub(checkOrigin func(r *http.Request) bool) *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 256),
-		register:   make(chan *Client),
+		outbox:     make(chan *hubEvent, hubQueueSize),
+		broadcast:  make(chan *hubEvent, hubQueueSize),
+		register:   make(chan *resumeRequest),
 		unregister: make(chan *Client),
+		resumes:    make(chan *resumeRequest),
+		log:        newReplayLog(replayLogSize),
+		upgrader:   websocket.Upgrader{CheckOrigin: checkOrigin},
+		policy:     SlowClientsDisconnect,
+		done:       make(chan struct{}),
+	}
+}
+
+// SetSlowClientPolicy sets what happens to clients that fall behind. Call
+// it before the hub starts.
+func (h *Hub) SetSlowClientPolicy(policy SlowClientPolicy) {
+	h.policy = policy
+}
+
+// SetRelay routes broadcasts through publish so that clients connected to
+// other replicas receive them too. Call it before the hub starts.
+func (h *Hub) SetRelay(publish func([]byte) error) {
+	h.relay = publish
+}
+
+// Deliver broadcasts an event received from the relay to local clients.
+// It does not block; the event is dropped if the hub is too far behind.
+func (h *Hub) Deliver(data []byte) {
+	var event hubEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Printf("❌ Invalid relayed event: %v", err)
+		return
+	}
+	select {
+	case h.broadcast <- &event:
+	default:
+		telemetry.HubEventsDropped.WithLabelValues("relay").Inc()
 	}
 }
 
+// Pending returns how many published events are waiting to be fanned out.
+func (h *Hub) Pending() int {
+	return len(h.outbox) + len(h.broadcast)
+}
+
 func (h *Hub) Run(ctx context.Context) {
 	log.Println("📡 WebSocket hub started")
-	
+	defer close(h.done)
+	go h.forward(ctx)
+
+	var prune <-chan time.Time
+	if h.events != nil {
+		ticker := time.NewTicker(eventPruneInterval)
+		defer ticker.Stop()
+		prune = ticker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("🛑 WebSocket hub stopped")
 			return
-		case client := <-h.register:
+		case req := <-h.register:
 			h.mu.Lock()
-			h.clients[client] = true
+			h.join(req)
+			total := len(h.clients)
+			telemetry.WebSocketClients.Set(float64(total))
 			h.mu.Unlock()
-			log.Printf("📱 New WebSocket client connected (total: %d)", len(h.clients))
+			log.Printf("📱 New client connected (total: %d)", total)
 		case client := <-h.unregister:
 			h.mu.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.send)
+				client.close()
 			}
+			total := len(h.clients)
+			telemetry.WebSocketClients.Set(float64(total))
 			h.mu.Unlock()
-			log.Printf("📴 WebSocket client disconnected (total: %d)", len(h.clients))
-		case message := <-h.broadcast:
-			h.mu.RLock()
+			log.Printf("📴 Client disconnected (total: %d)", total)
+		case event := <-h.broadcast:
+			h.sequence(event)
+			h.mu.Lock()
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if client.catchingUp || !client.subs.matches(event) {
+					continue
+				}
+				if !h.deliver(client, event.Key, event.Data) {
+					continue
+				}
+				if client.firstLive == 0 {
+					client.firstLive = event.Seq
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
+		case req := <-h.resumes:
+			h.mu.Lock()
+			if h.clients[req.client] {
+				h.replay(req)
+			}
+			h.mu.Unlock()
+		case <-prune:
+			go h.prune()
 		}
 	}
 }
 
-func (h *Hub) BroadcastAnomaly(anomaly storage.Anomaly, metricName string) {
-	message := AnomalyMessage{
-		Type:      "anomaly_detected",
-		Timestamp: time.Now().Format("2006-01-02T15:04:05Z"),
-		Anomaly: AnomalyInfo{
-			ID:               anomaly.ID,
-			MetricID:         anomaly.MetricID,
-			MetricName:       metricName,
-			Timestamp:        anomaly.Timestamp.Format("2006-01-02T15:04:05Z"),
-			Value:            anomaly.Value,
-			AnomalyScore:     anomaly.AnomalyScore,
-			DetectionMethods: anomaly.DetectionMethods,
-			Severity:         anomaly.Severity,
-			Status:           anomaly.Status,
-			CreatedAt:        anomaly.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		},
+// deliver queues a message for a client under the hub's slow client
+// policy. It returns false if the client was disconnected for falling
+// behind. The caller holds h.mu.
+func (h *Hub) deliver(c *Client, key string, data []byte) bool {
+	dropped, ok := c.queue.push(key, data, h.policy)
+	if dropped > 0 {
+		telemetry.WebSocketDropped.Add(float64(dropped))
+	}
+	if !ok {
+		h.drop(c)
 	}
+	return ok
+}
 
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("❌ Failed to marshal anomaly: %v", err)
+// drop disconnects a client that is not keeping up. The caller holds h.mu.
+func (h *Hub) drop(c *Client) {
+	if _, ok := h.clients[c]; !ok {
 		return
 	}
+	telemetry.SlowClientsDisconnected.Inc()
+	c.close()
+	delete(h.clients, c)
+}
 
-	h.broadcast <- data
+// publish hands an event to the hub without blocking. Events are dropped
+// when hubQueueSize are already waiting.
+func (h *Hub) publish(event *hubEvent) {
+	select {
+	case h.outbox <- event:
+	default:
+		telemetry.HubEventsDropped.WithLabelValues("publish").Inc()
+	}
 }
 
+// forward records and relays published events in order, then passes them
+// to Run. It runs until ctx is done.
+func (h *Hub) forward(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-h.outbox:
+			if h.events != nil {
+				h.record(event)
+			}
+			if h.relay != nil {
+				data, err := json.Marshal(event)
+				if err == nil {
+					err = h.relay(data)
+				}
+				if err == nil {
+					continue
+				}
+				log.Printf("⚠️  Failed to relay %s to other replicas: %v", event.Type, err)
+			}
+			select {
+			case h.broadcast <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// ServeWS upgrades a request to a WebSocket client. The query may hold
+// subscribe parameters, each a SubscriptionFilter as JSON, for the client's
+// first subscriptions, and last_seq to resume from.
 func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	filters, err := parseSubscriptions(r.URL.Query()["subscribe"])
 	if err != nil {
-		log.Printf("❌ WebSocket upgrade failed: %v", err)
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lastSeq, err := parseLastSeq(r.URL.Query().Get("last_seq"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	client := &Client{
-		hub:  h,
-		conn: conn,
-		send: make(chan []byte, 256),
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("❌ WebSocket upgrade failed: %v", err)
+		return
 	}
 
-	client.hub.register <- client
+	client := &Client{hub: h, conn: conn, queue: newClientQueue()}
+	client.subs.filters = filters
+	client.subs.nextID = len(filters)
+	h.add(h.resumeFrom(client, lastSeq))
 
 	go client.writePump()
 	go client.readPump()
 }
 
+// attach registers a client that drains its queue itself, with filter as
+// its only subscription if set. When lastSeq is set, the events after it
+// are queued before any live one.
+func (h *Hub) attach(filter *SubscriptionFilter, lastSeq int64) *Client {
+	c := &Client{hub: h, queue: newClientQueue()}
+	if filter != nil {
+		c.subs.filters = map[string]SubscriptionFilter{"query": *filter}
+	}
+	h.add(h.resumeFrom(c, lastSeq))
+	return c
+}
+
+// add registers a client. If the hub has stopped, the client is closed
+// instead, so whatever drains its queue ends.
+func (h *Hub) add(req *resumeRequest) {
+	select {
+	case h.register <- req:
+	case <-h.done:
+		req.client.close()
+	}
+}
+
+// detach unregisters a client. It does nothing once the hub has stopped.
+func (h *Hub) detach(c *Client) {
+	select {
+	case h.unregister <- c:
+	case <-h.done:
+	}
+}
+
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.detach(c)
 		c.conn.Close()
 	}()
 
@@ -142,13 +309,41 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		var req SubscriptionRequest
+		if json.Unmarshal(data, &req) == nil && req.Action == "resume" {
+			c.hub.resume(c, req.LastSeq)
+			continue
+		}
+
+		reply, err := json.Marshal(c.subs.handle(data))
 		if err != nil {
+			continue
+		}
+		if !c.reply(reply) {
 			break
 		}
 	}
 }
 
+// reply queues an answer to a client request. It returns false if the
+// client is gone or was disconnected for falling behind.
+func (c *Client) reply(message []byte) bool {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	return c.hub.clients[c] && c.hub.deliver(c, "", message)
+}
+
+// close ends the client's write loop once it has written what is queued.
+// It is safe to call more than once.
+func (c *Client) close() {
+	c.queue.close()
+}
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(54 * time.Second)
 	defer func() {
@@ -158,14 +353,17 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case <-c.queue.ready:
+			messages, closed := c.queue.drain()
+			for _, message := range messages {
+				c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+					return
+				}
 			}
-
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if closed {
+				c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
@@ -0,0 +1,397 @@
+// Package client is a typed Go client for the Argus HTTP API described by
+// /api/openapi.json. Responses use the same types the server encodes.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mjrtuhin/argus/pkg/api"
+)
+
+type Client struct {
+	baseURL    string
+	apiKey     string
+	token      string
+	httpClient *http.Client
+}
+
+type Option func(*Client)
+
+// WithAPIKey authenticates with a key from `argus apikey create`.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithBearerToken authenticates with an OIDC access token.
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is returned for any non-2xx response.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("argus: %d %s", e.StatusCode, e.Message)
+}
+
+// AnomalyFilter mirrors the GET /api/anomalies query parameters. Zero values
+// are left out.
+type AnomalyFilter struct {
+	MetricID   int
+	MetricName string
+	Severities []string
+	Statuses   []string // open when empty; "all" for every status
+	Methods    []string
+	From       time.Time
+	To         time.Time
+	MinScore   *float64
+	Labels     map[string]string
+	Sort       string // e.g. "-score"
+	Limit      int
+	Cursor     string // NextCursor of the previous page
+}
+
+func (f AnomalyFilter) values() url.Values {
+	v := url.Values{}
+	if f.MetricID != 0 {
+		v.Set("metric_id", strconv.Itoa(f.MetricID))
+	}
+	setString(v, "metric", f.MetricName)
+	setString(v, "severity", strings.Join(f.Severities, ","))
+	setString(v, "status", strings.Join(f.Statuses, ","))
+	setString(v, "method", strings.Join(f.Methods, ","))
+	setTime(v, "from", f.From)
+	setTime(v, "to", f.To)
+	if f.MinScore != nil {
+		v.Set("min_score", strconv.FormatFloat(*f.MinScore, 'g', -1, 64))
+	}
+	for name, value := range f.Labels {
+		v.Add("label", name+"="+value)
+	}
+	setString(v, "sort", f.Sort)
+	if f.Limit > 0 {
+		v.Set("limit", strconv.Itoa(f.Limit))
+	}
+	setString(v, "cursor", f.Cursor)
+	return v
+}
+
+// SeriesOptions mirrors the GET /api/metrics/{id}/series query parameters.
+type SeriesOptions struct {
+	From      time.Time
+	To        time.Time
+	Step      time.Duration
+	MaxPoints int
+}
+
+type NotificationFilter struct {
+	Receiver string
+	Status   string
+	Limit    int
+}
+
+func (c *Client) Health(ctx context.Context) (*api.HealthResponse, error) {
+	var out api.HealthResponse
+	return &out, c.get(ctx, "/health", nil, &out)
+}
+
+func (c *Client) Me(ctx context.Context) (*api.PrincipalInfo, error) {
+	var out api.PrincipalInfo
+	return &out, c.get(ctx, "/api/me", nil, &out)
+}
+
+func (c *Client) ListMetrics(ctx context.Context) (*api.MetricsResponse, error) {
+	var out api.MetricsResponse
+	return &out, c.get(ctx, "/api/metrics", nil, &out)
+}
+
+func (c *Client) GetMetricSeries(ctx context.Context, metricID int, opts SeriesOptions) (*api.SeriesResponse, error) {
+	v := url.Values{}
+	setTime(v, "from", opts.From)
+	setTime(v, "to", opts.To)
+	if opts.Step > 0 {
+		v.Set("step", strconv.FormatInt(int64(opts.Step/time.Second), 10))
+	}
+	if opts.MaxPoints > 0 {
+		v.Set("max_points", strconv.Itoa(opts.MaxPoints))
+	}
+
+	var out api.SeriesResponse
+	return &out, c.get(ctx, fmt.Sprintf("/api/metrics/%d/series", metricID), v, &out)
+}
+
+func (c *Client) ListAnomalies(ctx context.Context, filter AnomalyFilter) (*api.AnomaliesResponse, error) {
+	var out api.AnomaliesResponse
+	return &out, c.get(ctx, "/api/anomalies", filter.values(), &out)
+}
+
+// AllAnomalies follows next_cursor until every page matching filter has been
+// read, calling fn for each anomaly. Returning an error from fn stops paging.
+func (c *Client) AllAnomalies(ctx context.Context, filter AnomalyFilter, fn func(api.AnomalyInfo) error) error {
+	for {
+		page, err := c.ListAnomalies(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, a := range page.Anomalies {
+			if err := fn(a); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+func (c *Client) GetAnomaly(ctx context.Context, id int) (*api.AnomalyDetail, error) {
+	var out api.AnomalyDetail
+	return &out, c.get(ctx, fmt.Sprintf("/api/anomalies/%d", id), nil, &out)
+}
+
+func (c *Client) AcknowledgeAnomaly(ctx context.Context, id int, by string) (*api.AnomalyInfo, error) {
+	var out api.AnomalyInfo
+	return &out, c.post(ctx, fmt.Sprintf("/api/anomalies/%d/acknowledge", id), api.AcknowledgeRequest{By: by}, &out)
+}
+
+func (c *Client) UpdateAnomalyStatus(ctx context.Context, id int, req api.StatusRequest) (*api.AnomalyInfo, error) {
+	var out api.AnomalyInfo
+	return &out, c.post(ctx, fmt.Sprintf("/api/anomalies/%d/status", id), req, &out)
+}
+
+func (c *Client) AcknowledgeIncident(ctx context.Context, id int, by string) (*api.IncidentAcknowledgeResponse, error) {
+	var out api.IncidentAcknowledgeResponse
+	return &out, c.post(ctx, fmt.Sprintf("/api/incidents/%d/acknowledge", id), api.AcknowledgeRequest{By: by}, &out)
+}
+
+func (c *Client) ListNotifications(ctx context.Context, filter NotificationFilter) (*api.NotificationsResponse, error) {
+	v := url.Values{}
+	setString(v, "receiver", filter.Receiver)
+	setString(v, "status", filter.Status)
+	if filter.Limit > 0 {
+		v.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	var out api.NotificationsResponse
+	return &out, c.get(ctx, "/api/notifications", v, &out)
+}
+
+func (c *Client) GetNotification(ctx context.Context, id int) (*api.NotificationInfo, error) {
+	var out api.NotificationInfo
+	return &out, c.get(ctx, fmt.Sprintf("/api/notifications/%d", id), nil, &out)
+}
+
+func (c *Client) RetryNotification(ctx context.Context, id int) (*api.NotificationInfo, error) {
+	var out api.NotificationInfo
+	return &out, c.post(ctx, fmt.Sprintf("/api/notifications/%d/retry", id), nil, &out)
+}
+
+// OpenAPI returns the server's OpenAPI 3 document.
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var out json.RawMessage
+	return out, c.get(ctx, "/api/openapi.json", nil, &out)
+}
+
+// StreamAnomalies connects to /ws/anomalies and calls fn for each anomaly
+// event until ctx is cancelled or the connection drops. It does not
+// reconnect. With filters, only anomalies matching one of them are streamed.
+func (c *Client) StreamAnomalies(ctx context.Context, fn func(api.EventMessage), filters ...api.SubscriptionFilter) error {
+	return c.ResumeAnomalies(ctx, 0, fn, filters...)
+}
+
+// ResumeAnomalies is StreamAnomalies for a client reconnecting after it
+// received the message with Seq lastSeq: the messages it missed since are
+// streamed first. Missed messages the server no longer keeps are skipped.
+func (c *Client) ResumeAnomalies(ctx context.Context, lastSeq int64, fn func(api.EventMessage), filters ...api.SubscriptionFilter) error {
+	return c.StreamEvents(ctx, lastSeq, func(msg api.EventMessage) {
+		if msg.Anomaly != nil {
+			fn(msg)
+		}
+	}, filters...)
+}
+
+// StreamEvents calls fn for every hub event, of any type, like
+// ResumeAnomalies. Pass lastSeq 0 to start with live events.
+func (c *Client) StreamEvents(ctx context.Context, lastSeq int64, fn func(api.EventMessage), filters ...api.SubscriptionFilter) error {
+	u, err := url.Parse(c.baseURL + "/ws/anomalies")
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	// Subscribing and resuming in the URL gets the missed events matching
+	// the filters before any live event.
+	query := url.Values{}
+	for _, f := range filters {
+		data, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		query.Add("subscribe", string(data))
+	}
+	if lastSeq > 0 {
+		query.Set("last_seq", strconv.FormatInt(lastSeq, 10))
+	}
+	u.RawQuery = query.Encode()
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), c.authHeader())
+	if err != nil {
+		if resp != nil {
+			return c.responseError(resp)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	for {
+		var data json.RawMessage
+		if err := conn.ReadJSON(&data); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		var reply api.SubscriptionReply
+		if err := json.Unmarshal(data, &reply); err != nil {
+			return err
+		}
+		switch reply.Type {
+		case "subscribed", "unsubscribed":
+			continue
+		case "resumed":
+			var resumed api.ResumeReply
+			if err := json.Unmarshal(data, &resumed); err != nil {
+				return err
+			}
+			if resumed.More {
+				if err := conn.WriteJSON(api.SubscriptionRequest{Action: "resume", LastSeq: resumed.LastSeq}); err != nil {
+					return err
+				}
+			}
+			continue
+		case "error":
+			return fmt.Errorf("subscription rejected: %s", reply.Error)
+		}
+
+		var msg api.EventMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		fn(msg)
+	}
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return c.do(ctx, "GET", path, nil, out)
+}
+
+func (c *Client) post(ctx context.Context, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	return c.do(ctx, "POST", path, body, out)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.authHeader() {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return c.responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) authHeader() http.Header {
+	h := http.Header{}
+	switch {
+	case c.token != "":
+		h.Set("Authorization", "Bearer "+c.token)
+	case c.apiKey != "":
+		h.Set("X-API-Key", c.apiKey)
+	}
+	return h
+}
+
+func (c *Client) responseError(resp *http.Response) error {
+	var body api.ErrorResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body); err != nil || body.Error == "" {
+		body.Error = http.StatusText(resp.StatusCode)
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: body.Error}
+}
+
+func setString(v url.Values, key, value string) {
+	if value != "" {
+		v.Set(key, value)
+	}
+}
+
+func setTime(v url.Values, key string, t time.Time) {
+	if !t.IsZero() {
+		v.Set(key, t.UTC().Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,252 @@
+// Package cluster lets several Argus replicas share one PostgreSQL database.
+// Replicas register in the replicas table and send heartbeats; metrics are
+// spread across the live replicas with a consistent hash ring, and work that
+// must run once, such as escalations and rollup compaction, runs on the
+// replica holding an advisory lock.
+//
+// When a replica stops sending heartbeats, the others drop it from the ring
+// within ReplicaTTL and PostgreSQL releases its lock when its session ends.
+package cluster
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// leaderLockID is the advisory lock key held by the leader.
+const leaderLockID = 0x61726776 // "argv"
+
+type Config struct {
+	// ReplicaID names this replica. It must be unique and stable for the
+	// life of the process; the hostname suits a Kubernetes pod.
+	ReplicaID string
+	// HeartbeatInterval is how often the replica reports in and refreshes
+	// the ring and leadership. Default 10s.
+	HeartbeatInterval time.Duration
+	// ReplicaTTL is how long a replica without heartbeats keeps its share
+	// of metrics. Default 3 heartbeat intervals.
+	ReplicaTTL time.Duration
+}
+
+// Cluster tracks the live replicas and whether this one is the leader.
+type Cluster struct {
+	db  *storage.DB
+	cfg Config
+
+	mu       sync.RWMutex
+	ring     *ring
+	replicas []string
+	lock     *storage.Lock
+	changed  chan struct{} // closed and replaced when leadership changes
+}
+
+func New(db *storage.DB, cfg Config) *Cluster {
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 10 * time.Second
+	}
+	if cfg.ReplicaTTL <= 0 {
+		cfg.ReplicaTTL = 3 * cfg.HeartbeatInterval
+	}
+	return &Cluster{
+		db:      db,
+		cfg:     cfg,
+		ring:    newRing(nil),
+		changed: make(chan struct{}),
+	}
+}
+
+func (c *Cluster) ID() string {
+	return c.cfg.ReplicaID
+}
+
+// Join registers the replica and loads the ring. Call it before starting
+// workers so the first cycle already sees its share of metrics.
+func (c *Cluster) Join(ctx context.Context) error {
+	if err := c.db.Heartbeat(ctx, c.cfg.ReplicaID); err != nil {
+		return err
+	}
+	return c.refresh(ctx)
+}
+
+// Run sends heartbeats and keeps the ring and leadership current until ctx
+// ends, then steps down and deregisters.
+func (c *Cluster) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	log.Printf("🤝 Cluster member %s started (heartbeat: %v)", c.cfg.ReplicaID, c.cfg.HeartbeatInterval)
+	c.elect(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.leave()
+			log.Println("🛑 Cluster member stopped")
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.db.Heartbeat(ctx, c.cfg.ReplicaID); err != nil {
+			log.Printf("⚠️  Cluster heartbeat failed: %v", err)
+		} else if err := c.refresh(ctx); err != nil {
+			log.Printf("⚠️  Failed to refresh cluster members: %v", err)
+		}
+		c.elect(ctx)
+	}
+}
+
+func (c *Cluster) refresh(ctx context.Context) error {
+	live, err := c.db.ListReplicas(ctx, c.cfg.ReplicaTTL)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(live))
+	for i, r := range live {
+		ids[i] = r.ID
+	}
+	c.setReplicas(ids)
+	return nil
+}
+
+// setReplicas rebuilds the ring over the live replicas ids.
+func (c *Cluster) setReplicas(ids []string) {
+	// Keep our own share while our heartbeat is in flight.
+	if !slices.Contains(ids, c.cfg.ReplicaID) {
+		ids = append(ids, c.cfg.ReplicaID)
+	}
+	slices.Sort(ids)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.Equal(ids, c.replicas) {
+		return
+	}
+	log.Printf("🤝 Cluster members changed: %v (was %v)", ids, c.replicas)
+	c.replicas = ids
+	c.ring = newRing(ids)
+}
+
+// elect checks that a held leader lock is still valid, or tries to take it.
+func (c *Cluster) elect(ctx context.Context) {
+	c.mu.RLock()
+	lock := c.lock
+	c.mu.RUnlock()
+
+	if lock != nil {
+		if err := lock.Check(ctx); err == nil || ctx.Err() != nil {
+			return
+		}
+		log.Printf("⚠️  Lost the leader lock, stepping down")
+		lock.Release(context.Background())
+		c.setLock(nil)
+		return
+	}
+
+	lock, err := c.db.TryLock(ctx, leaderLockID)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("⚠️  Leader election failed: %v", err)
+		}
+		return
+	}
+	if lock != nil {
+		log.Printf("👑 %s is now the leader", c.cfg.ReplicaID)
+		c.setLock(lock)
+	}
+}
+
+func (c *Cluster) setLock(lock *storage.Lock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lock = lock
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Cluster) leave() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c.mu.RLock()
+	lock := c.lock
+	c.mu.RUnlock()
+	if lock != nil {
+		lock.Release(ctx)
+		c.setLock(nil)
+	}
+	if err := c.db.RemoveReplica(ctx, c.cfg.ReplicaID); err != nil {
+		log.Printf("⚠️  Failed to deregister replica: %v", err)
+	}
+}
+
+// Owns reports whether this replica is responsible for key.
+func (c *Cluster) Owns(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.owner(key) == c.cfg.ReplicaID
+}
+
+// IsLeader reports whether this replica holds the leader lock.
+func (c *Cluster) IsLeader() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lock != nil
+}
+
+// Replicas returns the IDs of the live replicas.
+func (c *Cluster) Replicas() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.replicas)
+}
+
+// RunAsLeader calls run whenever this replica becomes the leader, and
+// cancels the context passed to it when leadership is lost. It returns when
+// ctx ends.
+func (c *Cluster) RunAsLeader(ctx context.Context, name string, run func(context.Context)) {
+	for {
+		c.mu.RLock()
+		leader, changed := c.lock != nil, c.changed
+		c.mu.RUnlock()
+
+		if !leader {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				continue
+			}
+		}
+
+		log.Printf("👑 Starting %s on the leader", name)
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			run(runCtx)
+		}()
+
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		}
+		cancel()
+		<-done
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Standalone is the membership of a replica running alone: it owns every
+// key.
+var Standalone standalone
+
+type standalone struct{}
+
+func (standalone) Owns(string) bool { return true }
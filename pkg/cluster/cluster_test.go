@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+func TestOwns(t *testing.T) {
+	ids := []string{"argus-a", "argus-b", "argus-c"}
+	var replicas []*Cluster
+	for _, id := range ids {
+		c := New(nil, Config{ReplicaID: id})
+		c.setReplicas([]string{"argus-c", "argus-a", "argus-b"})
+		replicas = append(replicas, c)
+	}
+
+	// Every key is owned by exactly one replica, the ring's owner.
+	r := newRing(ids)
+	for _, key := range ringKeys(1000) {
+		owners := 0
+		for _, c := range replicas {
+			if c.Owns(key) {
+				owners++
+				if c.ID() != r.owner(key) {
+					t.Fatalf("%s owns %s, the ring says %s", c.ID(), key, r.owner(key))
+				}
+			}
+		}
+		if owners != 1 {
+			t.Fatalf("%s has %d owners, want 1", key, owners)
+		}
+	}
+
+	// A replica whose heartbeat is not yet listed keeps its own share.
+	c := New(nil, Config{ReplicaID: "argus-d"})
+	c.setReplicas([]string{"argus-a", "argus-b"})
+	if got, want := c.Replicas(), []string{"argus-a", "argus-b", "argus-d"}; !slices.Equal(got, want) {
+		t.Fatalf("Replicas() = %v, want %v", got, want)
+	}
+
+	if New(nil, Config{ReplicaID: "argus-a"}).Owns("cpu") {
+		t.Fatal("Owns() before joining = true, want false")
+	}
+	if !Standalone.Owns("cpu") {
+		t.Fatal("Standalone.Owns() = false")
+	}
+}
+
+// TestRunAsLeader gains and loses leadership by hand and checks that the
+// leader's work starts, is cancelled and starts again.
+func TestRunAsLeader(t *testing.T) {
+	c := New(nil, Config{ReplicaID: "argus-a"})
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		c.RunAsLeader(ctx, "test", func(runCtx context.Context) {
+			started <- struct{}{}
+			<-runCtx.Done()
+			stopped <- struct{}{}
+		})
+	}()
+
+	wait := func(ch chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+	expectNone := func(ch chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+			t.Fatalf("%s unexpectedly", what)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	expectNone(started, "started before becoming the leader")
+	for i := 0; i < 2; i++ {
+		c.setLock(&storage.Lock{})
+		wait(started, "the leader's work to start")
+		if !c.IsLeader() {
+			t.Fatal("IsLeader() = false holding the lock")
+		}
+		c.setLock(nil)
+		wait(stopped, "the work to stop when the lock is lost")
+		expectNone(started, "started again without the lock")
+	}
+
+	c.setLock(&storage.Lock{})
+	wait(started, "the leader's work to start")
+	cancel()
+	wait(stopped, "the work to stop with its context")
+	wait(returned, "RunAsLeader to return")
+}
@@ -0,0 +1,26 @@
+package cluster
+
+import (
+	"context"
+	"log"
+)
+
+// eventsChannel carries hub events between replicas, so WebSocket clients
+// see anomalies detected by any replica.
+const eventsChannel = "argus_events"
+
+// Publish sends a hub event to every replica, this one included.
+func (c *Cluster) Publish(ctx context.Context, event []byte) error {
+	return c.db.Notify(ctx, eventsChannel, string(event))
+}
+
+// Subscribe passes events published by any replica to deliver until ctx
+// ends.
+func (c *Cluster) Subscribe(ctx context.Context, deliver func([]byte)) {
+	err := c.db.Listen(ctx, eventsChannel, func(payload string) {
+		deliver([]byte(payload))
+	})
+	if err != nil {
+		log.Printf("❌ Cluster event relay stopped: %v", err)
+	}
+}
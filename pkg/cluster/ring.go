@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Points per replica on the ring. More points spread metrics more evenly.
+const virtualNodes = 128
+
+// ring is a consistent hash ring: when a replica joins or leaves, only the
+// keys on its arcs move.
+type ring struct {
+	hashes []uint64
+	owners map[uint64]string
+}
+
+func newRing(replicas []string) *ring {
+	r := &ring{owners: make(map[uint64]string, len(replicas)*virtualNodes)}
+	for _, id := range replicas {
+		for i := 0; i < virtualNodes; i++ {
+			h := hash(id + "#" + strconv.Itoa(i))
+			if _, taken := r.owners[h]; taken {
+				continue
+			}
+			r.owners[h] = id
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// owner returns the replica responsible for key, or "" for an empty ring.
+func (r *ring) owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// hash is FNV-1a followed by the MurmurHash3 finalizer. FNV alone barely
+// separates keys that differ in their last characters, such as the points
+// of one replica, which leaves some replicas with several times the share
+// of others. Replicas must all hash alike, so a change here needs every
+// replica restarted together.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
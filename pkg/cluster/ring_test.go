@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func ringKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("metric_%d_seconds", i)
+	}
+	return keys
+}
+
+func owners(r *ring, keys []string) map[string]string {
+	m := make(map[string]string, len(keys))
+	for _, k := range keys {
+		m[k] = r.owner(k)
+	}
+	return m
+}
+
+func TestRingEmpty(t *testing.T) {
+	if got := newRing(nil).owner("cpu"); got != "" {
+		t.Fatalf("owner() on an empty ring = %q, want none", got)
+	}
+}
+
+func TestRingBalance(t *testing.T) {
+	keys := ringKeys(20000)
+	for _, n := range []int{2, 3, 5, 8} {
+		var replicas []string
+		for i := 0; i < n; i++ {
+			replicas = append(replicas, fmt.Sprintf("argus-%d", i))
+		}
+		counts := make(map[string]int)
+		for _, owner := range owners(newRing(replicas), keys) {
+			counts[owner]++
+		}
+
+		// With 128 points each, every share is within a third of even.
+		even := len(keys) / n
+		for _, id := range replicas {
+			if c := counts[id]; c < even*2/3 || c > even*4/3 {
+				t.Errorf("%d replicas: %s owns %d keys, want about %d", n, id, c, even)
+			}
+		}
+	}
+}
+
+// TestRingStability checks that a replica joining takes keys only from the
+// others, and one leaving hands over only its own.
+func TestRingStability(t *testing.T) {
+	keys := ringKeys(20000)
+	three := owners(newRing([]string{"argus-a", "argus-b", "argus-c"}), keys)
+	four := owners(newRing([]string{"argus-a", "argus-b", "argus-c", "argus-d"}), keys)
+
+	moved := 0
+	for _, k := range keys {
+		if three[k] == four[k] {
+			continue
+		}
+		if four[k] != "argus-d" {
+			t.Fatalf("%s moved from %s to %s when argus-d joined", k, three[k], four[k])
+		}
+		moved++
+	}
+	if want := len(keys) / 4; moved < want*2/3 || moved > want*4/3 {
+		t.Errorf("%d keys moved to argus-d, want about %d", moved, want)
+	}
+
+	// The ring doesn't depend on the order replicas are listed in.
+	two := owners(newRing([]string{"argus-c", "argus-a"}), keys)
+	for _, k := range keys {
+		if three[k] != "argus-b" && two[k] != three[k] {
+			t.Fatalf("%s moved from %s to %s when argus-b left", k, three[k], two[k])
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/mjrtuhin/argus/pkg/telemetry"
 )
 
 type MLClient struct {
@@ -22,20 +24,30 @@ type DetectionRequest struct {
 }
 
 type Anomaly struct {
-	Timestamp  int64    `json:"timestamp"`
-	Value      float64  `json:"value"`
-	Score      float64  `json:"score"`
-	Methods    []string `json:"methods"`
-	RootCause  string   `json:"root_cause"`
-	Impact     string   `json:"impact"`
+	Timestamp int64    `json:"timestamp"`
+	Value     float64  `json:"value"`
+	Score     float64  `json:"score"`
+	Methods   []string `json:"methods"`
+	RootCause string   `json:"root_cause"`
+	Impact    string   `json:"impact"`
+}
+
+// ExpectedPoint is the model's prediction for one input timestamp. Only
+// returned when the forecasting model succeeded.
+type ExpectedPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+	Lower     float64 `json:"lower"`
+	Upper     float64 `json:"upper"`
 }
 
 type DetectionResponse struct {
-	MetricID      int       `json:"metric_id"`
-	MetricName    string    `json:"metric_name"`
-	Anomalies     []Anomaly `json:"anomalies"`
-	TotalPoints   int       `json:"total_points"`
-	AnomalyCount  int       `json:"anomaly_count"`
+	MetricID     int             `json:"metric_id"`
+	MetricName   string          `json:"metric_name"`
+	Anomalies    []Anomaly       `json:"anomalies"`
+	Expected     []ExpectedPoint `json:"expected,omitempty"`
+	TotalPoints  int             `json:"total_points"`
+	AnomalyCount int             `json:"anomaly_count"`
 }
 
 func NewMLClient(baseURL string) *MLClient {
@@ -47,7 +59,15 @@ func NewMLClient(baseURL string) *MLClient {
 	}
 }
 
-func (c *MLClient) DetectAnomalies(ctx context.Context, req *DetectionRequest) (*DetectionResponse, error) {
+func (c *MLClient) DetectAnomalies(ctx context.Context, req *DetectionRequest) (_ *DetectionResponse, err error) {
+	start := time.Now()
+	defer func() {
+		telemetry.MLRequestDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			telemetry.MLRequestErrors.Inc()
+		}
+	}()
+
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
@@ -76,3 +96,22 @@ func (c *MLClient) DetectAnomalies(ctx context.Context, req *DetectionRequest) (
 
 	return &result, nil
 }
+
+// Ping checks that the ML service is up.
+func (c *MLClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ML service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/mjrtuhin/argus/pkg/telemetry"
 )
 
 type Client struct {
@@ -33,7 +35,8 @@ func NewClient(baseURL string) *Client {
 	}
 }
 
-func (c *Client) Query(ctx context.Context, query string) (*QueryResult, error) {
+func (c *Client) Query(ctx context.Context, query string) (_ *QueryResult, err error) {
+	defer observe("query", time.Now(), &err)
 	url := fmt.Sprintf("%s/api/v1/query?query=%s", c.baseURL, query)
 	
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -55,7 +58,8 @@ func (c *Client) Query(ctx context.Context, query string) (*QueryResult, error)
 	return &result, nil
 }
 
-func (c *Client) ListMetrics(ctx context.Context) ([]string, error) {
+func (c *Client) ListMetrics(ctx context.Context) (_ []string, err error) {
+	defer observe("label_values", time.Now(), &err)
 	url := fmt.Sprintf("%s/api/v1/label/__name__/values", c.baseURL)
 	
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -80,3 +84,30 @@ func (c *Client) ListMetrics(ctx context.Context) ([]string, error) {
 	
 	return result.Data, nil
 }
+
+// Ping checks that Prometheus is ready to serve queries.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/-/ready", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Prometheus returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// observe records the latency and outcome of one Prometheus API request.
+func observe(endpoint string, start time.Time, err *error) {
+	telemetry.PrometheusQueryDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		telemetry.PrometheusQueryErrors.WithLabelValues(endpoint).Inc()
+	}
+}
@@ -2,23 +2,93 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/lib/pq"
 )
 
 type Anomaly struct {
-	ID               int
-	MetricID         int
-	Timestamp        time.Time
-	Value            float64
-	AnomalyScore     float64
-	DetectionMethods []string
-	Severity         string
-	Status           string
-	RootCause        string
-	Impact           string
-	CreatedAt        time.Time
+	ID                     int
+	MetricID               int
+	Timestamp              time.Time
+	Value                  float64
+	AnomalyScore           float64
+	DetectionMethods       []string
+	Severity               string
+	Status                 string
+	RootCause              string
+	Impact                 string
+	IncidentID             *int
+	AcknowledgedAt         *time.Time
+	AcknowledgedBy         string
+	AcknowledgedOnBehalfOf string
+	ResolvedAt             *time.Time
+	SnoozedUntil           *time.Time
+	CreatedAt              time.Time
+}
+
+// StatusUpdate changes the lifecycle status of an anomaly. SnoozedUntil is
+// required when moving to "snoozed". OnBehalfOf is recorded with By when
+// acknowledging.
+type StatusUpdate struct {
+	Status       string
+	By           string
+	OnBehalfOf   string
+	SnoozedUntil *time.Time
+}
+
+// anomalyTransitions lists, per target status, the statuses an anomaly may
+// move from.
+var anomalyTransitions = map[string][]string{
+	"open":           {"snoozed"},
+	"acknowledged":   {"open", "snoozed"},
+	"snoozed":        {"open", "acknowledged"},
+	"resolved":       {"open", "acknowledged", "snoozed"},
+	"false_positive": {"open", "acknowledged", "snoozed"},
+}
+
+// ValidAnomalyStatus reports whether status is a status anomalies can be
+// moved to.
+func ValidAnomalyStatus(status string) bool {
+	_, ok := anomalyTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether an anomaly in status from may move to to.
+func CanTransition(from, to string) bool {
+	for _, s := range anomalyTransitions[to] {
+		if s == from {
+			return true
+		}
+	}
+	return false
+}
+
+const anomalyColumns = `id, metric_id, timestamp, value, anomaly_score,
+		        detection_methods, severity, status, root_cause, impact, incident_id,
+		        acknowledged_at, COALESCE(acknowledged_by, ''), COALESCE(acknowledged_on_behalf_of, ''),
+		        resolved_at, snoozed_until, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAnomaly(row rowScanner) (Anomaly, error) {
+	var a Anomaly
+	err := row.Scan(anomalyDest(&a)...)
+	return a, err
+}
+
+// anomalyDest lists scan destinations in anomalyColumns order.
+func anomalyDest(a *Anomaly) []interface{} {
+	return []interface{}{
+		&a.ID, &a.MetricID, &a.Timestamp, &a.Value,
+		&a.AnomalyScore, pq.Array(&a.DetectionMethods),
+		&a.Severity, &a.Status, &a.RootCause, &a.Impact, &a.IncidentID,
+		&a.AcknowledgedAt, &a.AcknowledgedBy, &a.AcknowledgedOnBehalfOf,
+		&a.ResolvedAt, &a.SnoozedUntil, &a.CreatedAt,
+	}
 }
 
 func (db *DB) CreateAnomaly(ctx context.Context, anomaly *Anomaly) error {
@@ -39,35 +109,77 @@ func (db *DB) CreateAnomaly(ctx context.Context, anomaly *Anomaly) error {
 	).Scan(&anomaly.ID, &anomaly.CreatedAt)
 }
 
-func (db *DB) GetRecentAnomalies(ctx context.Context, limit int) ([]Anomaly, error) {
-	rows, err := db.conn.QueryContext(ctx,
-		`SELECT id, metric_id, timestamp, value, anomaly_score, 
-		        detection_methods, severity, status, root_cause, impact, created_at
+func (db *DB) GetAnomaly(ctx context.Context, id int) (*Anomaly, error) {
+	a, err := scanAnomaly(db.conn.QueryRowContext(ctx,
+		`SELECT `+anomalyColumns+`
 		 FROM anomalies
-		 WHERE status = 'open'
-		 ORDER BY created_at DESC
-		 LIMIT $1`,
-		limit,
-	)
+		 WHERE id = $1`,
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// AcknowledgeAnomaly moves an open anomaly to acknowledged. It returns
+// sql.ErrNoRows when the anomaly does not exist or is no longer open.
+func (db *DB) AcknowledgeAnomaly(ctx context.Context, id int, by string) (*Anomaly, error) {
+	return db.UpdateAnomalyStatus(ctx, id, StatusUpdate{Status: "acknowledged", By: by})
+}
+
+// UpdateAnomalyStatus applies a status change if it is a valid transition
+// from the anomaly's current status. It returns sql.ErrNoRows when the
+// anomaly does not exist or cannot move to the requested status.
+func (db *DB) UpdateAnomalyStatus(ctx context.Context, id int, update StatusUpdate) (*Anomaly, error) {
+	from, ok := anomalyTransitions[update.Status]
+	if !ok {
+		return nil, fmt.Errorf("unknown anomaly status %q", update.Status)
+	}
+	if update.Status == "snoozed" && update.SnoozedUntil == nil {
+		return nil, fmt.Errorf("snoozed status requires an end time")
+	}
+
+	a, err := scanAnomaly(db.conn.QueryRowContext(ctx,
+		`UPDATE anomalies
+		 SET status = $2,
+		     acknowledged_at = CASE WHEN $2 = 'acknowledged' THEN NOW() ELSE acknowledged_at END,
+		     acknowledged_by = CASE WHEN $2 = 'acknowledged' THEN $3 ELSE acknowledged_by END,
+		     acknowledged_on_behalf_of = CASE WHEN $2 = 'acknowledged' THEN NULLIF($6, '') ELSE acknowledged_on_behalf_of END,
+		     resolved_at = CASE WHEN $2 IN ('resolved', 'false_positive') THEN NOW() ELSE resolved_at END,
+		     snoozed_until = $4
+		 WHERE id = $1 AND status = ANY($5)
+		 RETURNING `+anomalyColumns,
+		id, update.Status, update.By, update.SnoozedUntil, pq.Array(from), update.OnBehalfOf,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ReopenExpiredSnoozes returns snoozed anomalies whose snooze has ended to
+// the open state, and returns them.
+func (db *DB) ReopenExpiredSnoozes(ctx context.Context) ([]Anomaly, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`UPDATE anomalies
+		 SET status = 'open', snoozed_until = NULL
+		 WHERE status = 'snoozed' AND snoozed_until <= NOW()
+		 RETURNING `+anomalyColumns)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var anomalies []Anomaly
+	var reopened []Anomaly
 	for rows.Next() {
-		var a Anomaly
-		if err := rows.Scan(
-			&a.ID, &a.MetricID, &a.Timestamp, &a.Value,
-			&a.AnomalyScore, pq.Array(&a.DetectionMethods),
-			&a.Severity, &a.Status, &a.RootCause, &a.Impact, &a.CreatedAt,
-		); err != nil {
+		a, err := scanAnomaly(rows)
+		if err != nil {
 			return nil, err
 		}
-		anomalies = append(anomalies, a)
+		reopened = append(reopened, a)
 	}
-
-	return anomalies, rows.Err()
+	return reopened, rows.Err()
 }
 
 func classifySeverity(score float64) string {
@@ -82,3 +194,42 @@ func classifySeverity(score float64) string {
 		return "low"
 	}
 }
+
+// OpenAnomalyCount is the number of unresolved anomalies for one metric,
+// severity and status.
+type OpenAnomalyCount struct {
+	MetricID   int
+	MetricName string
+	Labels     map[string]string
+	Severity   string
+	Status     string
+	Count      int
+}
+
+// CountOpenAnomalies counts anomalies that are open, acknowledged or snoozed.
+func (db *DB) CountOpenAnomalies(ctx context.Context) ([]OpenAnomalyCount, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT m.id, m.metric_name, m.labels, a.severity, a.status, COUNT(*)
+		 FROM anomalies a
+		 JOIN metrics m ON m.id = a.metric_id
+		 WHERE a.status IN ('open', 'acknowledged', 'snoozed')
+		 GROUP BY m.id, m.metric_name, m.labels, a.severity, a.status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []OpenAnomalyCount
+	for rows.Next() {
+		var c OpenAnomalyCount
+		var labels []byte
+		if err := rows.Scan(&c.MetricID, &c.MetricName, &labels, &c.Severity, &c.Status, &c.Count); err != nil {
+			return nil, err
+		}
+		if c.Labels, err = decodeLabels(labels); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
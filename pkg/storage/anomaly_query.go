@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AnomalyQuery filters, sorts and pages through anomalies. Zero values mean
+// "no filter"; a zero Limit returns every match in one page.
+type AnomalyQuery struct {
+	MetricID   int
+	MetricName string
+	Severities []string
+	Statuses   []string
+	From       *time.Time
+	To         *time.Time
+	MinScore   *float64
+	Methods    []string
+	Labels     map[string]string
+	Sort       string
+	Desc       bool
+	Limit      int
+	After      *AnomalyCursor
+}
+
+// AnomalyCursor marks the last row of a page: the value of the sort column
+// and the anomaly ID as a tie breaker. Sort records the ordering the cursor
+// was issued for so it cannot be replayed against a different one.
+type AnomalyCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// AnomalyResult is an anomaly together with the name of its metric.
+type AnomalyResult struct {
+	Anomaly
+	MetricName string
+}
+
+type AnomalyPage struct {
+	Anomalies []AnomalyResult
+	Next      *AnomalyCursor
+}
+
+type anomalySortKey struct {
+	expr   string
+	cast   string
+	cursor func(a *Anomaly) string
+	// parse checks a cursor value before it is cast in SQL.
+	parse func(v string) error
+}
+
+func parseCursorTime(v string) error {
+	_, err := time.Parse(time.RFC3339Nano, v)
+	return err
+}
+
+// severityRankExpr ranks the severity column as SeverityRank does, with
+// unknown severities at 0, so that severity cursors match the ORDER BY.
+func severityRankExpr() string {
+	var b strings.Builder
+	b.WriteString("CASE severity")
+	for _, s := range []string{"critical", "high", "medium", "low"} {
+		fmt.Fprintf(&b, " WHEN '%s' THEN %d", s, SeverityRank(s))
+	}
+	b.WriteString(" ELSE 0 END")
+	return b.String()
+}
+
+var anomalySortKeys = map[string]anomalySortKey{
+	"created_at": {
+		expr:   "created_at",
+		cast:   "timestamptz",
+		cursor: func(a *Anomaly) string { return a.CreatedAt.Format(time.RFC3339Nano) },
+		parse:  parseCursorTime,
+	},
+	"timestamp": {
+		expr:   "timestamp",
+		cast:   "timestamptz",
+		cursor: func(a *Anomaly) string { return a.Timestamp.Format(time.RFC3339Nano) },
+		parse:  parseCursorTime,
+	},
+	"score": {
+		expr:   "anomaly_score",
+		cast:   "float8",
+		cursor: func(a *Anomaly) string { return strconv.FormatFloat(a.AnomalyScore, 'g', -1, 64) },
+		parse: func(v string) error {
+			_, err := strconv.ParseFloat(v, 64)
+			return err
+		},
+	},
+	"severity": {
+		expr:   severityRankExpr(),
+		cast:   "int",
+		cursor: func(a *Anomaly) string { return strconv.Itoa(SeverityRank(a.Severity)) },
+		parse: func(v string) error {
+			_, err := strconv.Atoi(v)
+			return err
+		},
+	},
+}
+
+// ValidAnomalySort reports whether key can be used as AnomalyQuery.Sort.
+func ValidAnomalySort(key string) bool {
+	_, ok := anomalySortKeys[key]
+	return ok
+}
+
+// AnomalySortValue renders a's sort key for an AnomalyCursor.
+func AnomalySortValue(sort string, a *Anomaly) string {
+	return anomalySortKeys[sort].cursor(a)
+}
+
+// Validate checks that the cursor names a known sort and carries a value
+// of that sort's type, so a tampered cursor is rejected before it reaches
+// the query.
+func (c AnomalyCursor) Validate() error {
+	key, ok := anomalySortKeys[strings.TrimPrefix(c.Sort, "-")]
+	if !ok {
+		return fmt.Errorf("unknown sort %q", c.Sort)
+	}
+	if err := key.parse(c.Value); err != nil {
+		return fmt.Errorf("invalid %s value %q", c.Sort, c.Value)
+	}
+	if c.ID <= 0 {
+		return fmt.Errorf("invalid id %d", c.ID)
+	}
+	return nil
+}
+
+// QueryAnomalies returns one page of anomalies matching q, using keyset
+// pagination so pages stay stable while new anomalies arrive.
+func (db *DB) QueryAnomalies(ctx context.Context, q AnomalyQuery) (*AnomalyPage, error) {
+	if q.Sort == "" {
+		q.Sort = "created_at"
+	}
+	key, ok := anomalySortKeys[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.MetricID != 0 {
+		where = append(where, "metric_id = "+arg(q.MetricID))
+	}
+	if q.MetricName != "" {
+		where = append(where, "metric_name = "+arg(q.MetricName))
+	}
+	if len(q.Severities) > 0 {
+		where = append(where, "severity = ANY("+arg(pq.Array(q.Severities))+")")
+	}
+	if len(q.Statuses) > 0 {
+		where = append(where, "status = ANY("+arg(pq.Array(q.Statuses))+")")
+	}
+	if q.From != nil {
+		where = append(where, "timestamp >= "+arg(*q.From))
+	}
+	if q.To != nil {
+		where = append(where, "timestamp < "+arg(*q.To))
+	}
+	if q.MinScore != nil {
+		where = append(where, "anomaly_score >= "+arg(*q.MinScore))
+	}
+	if len(q.Methods) > 0 {
+		where = append(where, "detection_methods && "+arg(pq.Array(q.Methods)))
+	}
+	if len(q.Labels) > 0 {
+		labels, err := json.Marshal(q.Labels)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, "metric_labels @> "+arg(string(labels))+"::jsonb")
+	}
+
+	op, dir, sortID := ">", "ASC", q.Sort
+	if q.Desc {
+		op, dir, sortID = "<", "DESC", "-"+q.Sort
+	}
+	if q.After != nil {
+		if q.After.Sort != sortID {
+			return nil, fmt.Errorf("cursor was issued for sort %q", q.After.Sort)
+		}
+		if err := q.After.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid cursor: %w", err)
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+			key.expr, op, arg(q.After.Value), key.cast, arg(q.After.ID)))
+	}
+
+	query := `SELECT ` + anomalyColumns + `, metric_name
+		 FROM (
+		     SELECT a.*, m.metric_name, m.labels AS metric_labels
+		     FROM anomalies a
+		     JOIN metrics m ON m.id = a.metric_id
+		 ) q`
+	if len(where) > 0 {
+		query += "\n\t\t WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf("\n\t\t ORDER BY %s %s, id %s", key.expr, dir, dir)
+	if q.Limit > 0 {
+		// One extra row tells whether there is a next page.
+		query += "\n\t\t LIMIT " + arg(q.Limit+1)
+	}
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &AnomalyPage{}
+	for rows.Next() {
+		var r AnomalyResult
+		if err := rows.Scan(append(anomalyDest(&r.Anomaly), &r.MetricName)...); err != nil {
+			return nil, err
+		}
+		page.Anomalies = append(page.Anomalies, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if q.Limit > 0 && len(page.Anomalies) > q.Limit {
+		page.Anomalies = page.Anomalies[:q.Limit]
+		last := &page.Anomalies[len(page.Anomalies)-1].Anomaly
+		page.Next = &AnomalyCursor{Sort: sortID, Value: AnomalySortValue(q.Sort, last), ID: last.ID}
+	}
+	return page, nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSeverityRankExpr(t *testing.T) {
+	expr := severityRankExpr()
+	for _, severity := range []string{"low", "medium", "high", "critical"} {
+		if want := fmt.Sprintf("WHEN '%s' THEN %d", severity, SeverityRank(severity)); !strings.Contains(expr, want) {
+			t.Errorf("severityRankExpr() = %q, missing %q", expr, want)
+		}
+	}
+	// Cursors for unknown severities carry the rank the ELSE branch gives.
+	if want := fmt.Sprintf("ELSE %d END", SeverityRank("warning")); !strings.HasSuffix(expr, want) {
+		t.Errorf("severityRankExpr() = %q, want it to end %q", expr, want)
+	}
+}
+
+func TestAnomalyCursorValidate(t *testing.T) {
+	tests := []struct {
+		cursor  AnomalyCursor
+		wantErr bool
+	}{
+		{AnomalyCursor{Sort: "created_at", Value: "2026-01-02T15:04:05.123456789Z", ID: 1}, false},
+		{AnomalyCursor{Sort: "-timestamp", Value: "2026-01-02T15:04:05Z", ID: 7}, false},
+		{AnomalyCursor{Sort: "score", Value: "0.75", ID: 2}, false},
+		{AnomalyCursor{Sort: "-severity", Value: "0", ID: 2}, false},
+		{AnomalyCursor{Sort: "value", Value: "1", ID: 2}, true},
+		{AnomalyCursor{Sort: "--score", Value: "0.75", ID: 2}, true},
+		{AnomalyCursor{Sort: "created_at", Value: "yesterday", ID: 1}, true},
+		{AnomalyCursor{Sort: "score", Value: "high", ID: 2}, true},
+		{AnomalyCursor{Sort: "severity", Value: "critical", ID: 2}, true},
+		{AnomalyCursor{Sort: "score", Value: "0.75"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.cursor.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, want error %v", tt.cursor, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey is a stored API credential. Only the SHA-256 hash of the key is
+// kept; the prefix identifies the row so lookups don't scan every hash.
+type APIKey struct {
+	ID         int
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.KeyHash, pq.Array(&k.Scopes),
+		&k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt)
+	return k, err
+}
+
+func (db *DB) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	return db.conn.QueryRowContext(ctx,
+		`INSERT INTO api_keys (name, prefix, key_hash, scopes, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING id, created_at`,
+		k.Name, k.Prefix, k.KeyHash, pq.Array(k.Scopes), k.ExpiresAt,
+	).Scan(&k.ID, &k.CreatedAt)
+}
+
+// GetAPIKeyByPrefix returns the key with the given prefix, including revoked
+// and expired ones; callers decide whether it is still usable.
+func (db *DB) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	k, err := scanAPIKey(db.conn.QueryRowContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1`, prefix))
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (db *DB) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes an active key. It returns sql.ErrNoRows when the key
+// doesn't exist or is already revoked.
+func (db *DB) RevokeAPIKey(ctx context.Context, id int) error {
+	res, err := db.conn.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchAPIKey records that a key was used. Writes are limited to one a minute
+// per key so busy clients don't turn every request into an UPDATE.
+func (db *DB) TouchAPIKey(ctx context.Context, id int) error {
+	_, err := db.conn.ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at = NOW()
+		 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, id)
+	return err
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ExpectedPoint is the detector's expected value and confidence band for a
+// metric at one point in time.
+type ExpectedPoint struct {
+	MetricID  int
+	Timestamp time.Time
+	Value     float64
+	Lower     float64
+	Upper     float64
+}
+
+// UpsertBaseline stores the expected band returned by the detector,
+// replacing earlier predictions for the same timestamps.
+func (db *DB) UpsertBaseline(ctx context.Context, metricID int, points []ExpectedPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	timestamps := make([]time.Time, len(points))
+	values := make([]float64, len(points))
+	lowers := make([]float64, len(points))
+	uppers := make([]float64, len(points))
+	for i, p := range points {
+		timestamps[i] = p.Timestamp
+		values[i] = p.Value
+		lowers[i] = p.Lower
+		uppers[i] = p.Upper
+	}
+
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO metric_baselines (metric_id, timestamp, expected, lower_bound, upper_bound)
+		 SELECT $1, t, e, l, u
+		 FROM unnest($2::timestamptz[], $3::float8[], $4::float8[], $5::float8[]) AS b(t, e, l, u)
+		 ON CONFLICT (metric_id, timestamp) DO UPDATE
+		 SET expected = EXCLUDED.expected, lower_bound = EXCLUDED.lower_bound, upper_bound = EXCLUDED.upper_bound`,
+		metricID, pq.Array(timestamps), pq.Array(values), pq.Array(lowers), pq.Array(uppers),
+	)
+	return err
+}
+
+// GetBaseline returns the expected band in [from, to), averaged into buckets
+// of step.
+func (db *DB) GetBaseline(ctx context.Context, metricID int, from, to time.Time, step time.Duration) ([]ExpectedPoint, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT to_timestamp(floor(extract(epoch FROM timestamp) / $4) * $4) AS bucket,
+		        AVG(expected), AVG(lower_bound), AVG(upper_bound)
+		 FROM metric_baselines
+		 WHERE metric_id = $1 AND timestamp >= $2 AND timestamp < $3
+		 GROUP BY bucket
+		 ORDER BY bucket ASC`,
+		metricID, from, to, step.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []ExpectedPoint
+	for rows.Next() {
+		p := ExpectedPoint{MetricID: metricID}
+		if err := rows.Scan(&p.Timestamp, &p.Value, &p.Lower, &p.Upper); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	return points, rows.Err()
+}
@@ -0,0 +1,376 @@
+package embedded
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+func (s *Store) CreateEscalation(ctx context.Context, anomalyID int, policy string, nextAt time.Time) (*storage.Escalation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	e := storage.Escalation{
+		ID:        s.nextID(kindEscalation),
+		AnomalyID: anomalyID,
+		Policy:    policy,
+		NextAt:    &nextAt,
+		State:     "active",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.commit(newRecord(kindEscalation, e)); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (s *Store) GetDueEscalations(ctx context.Context, now time.Time) ([]storage.Escalation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var due []storage.Escalation
+	for _, e := range s.escalations {
+		if e.State == "active" && e.NextAt != nil && !e.NextAt.After(now) {
+			due = append(due, *e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAt.Before(*due[j].NextAt) })
+	return due, nil
+}
+
+func (s *Store) UpdateEscalation(ctx context.Context, id, nextStep int, nextAt *time.Time, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.escalations[id]
+	if !ok {
+		return nil
+	}
+	e := *current
+	e.NextStep, e.NextAt, e.State, e.UpdatedAt = nextStep, nextAt, state, time.Now()
+	return s.commit(newRecord(kindEscalation, e))
+}
+
+func (s *Store) StopEscalations(ctx context.Context, anomalyID int, state, detail string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var records []record
+	nextEvent := s.nextID(kindEvent)
+	for _, id := range sortedIDs(s.escalations) {
+		e := *s.escalations[id]
+		if e.AnomalyID != anomalyID || e.State != "active" {
+			continue
+		}
+		e.State, e.NextAt, e.UpdatedAt = state, nil, now
+		records = append(records,
+			newRecord(kindEscalation, e),
+			newRecord(kindEvent, storage.EscalationEvent{
+				ID:           nextEvent,
+				EscalationID: e.ID,
+				AnomalyID:    anomalyID,
+				Step:         e.NextStep,
+				Event:        state,
+				Detail:       detail,
+				CreatedAt:    now,
+			}))
+		nextEvent++
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return s.commit(records...)
+}
+
+func (s *Store) AddEscalationEvent(ctx context.Context, ev *storage.EscalationEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := *ev
+	e.ID, e.CreatedAt = s.nextID(kindEvent), time.Now()
+	if err := s.commit(newRecord(kindEvent, e)); err != nil {
+		return err
+	}
+	ev.ID, ev.CreatedAt = e.ID, e.CreatedAt
+	return nil
+}
+
+func (s *Store) GetEscalationTimeline(ctx context.Context, anomalyID int) ([]storage.EscalationEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []storage.EscalationEvent
+	for _, id := range sortedIDs(s.events) {
+		if ev := s.events[id]; ev.AnomalyID == anomalyID {
+			events = append(events, *ev)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt.Before(events[j].CreatedAt) })
+	return events, nil
+}
+
+func (s *Store) EnqueueNotification(ctx context.Context, n *storage.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	c := *n
+	c.ID, c.Status, c.NextAttemptAt, c.CreatedAt, c.UpdatedAt = s.nextID(kindNotification), "pending", now, now, now
+	if err := s.commit(newRecord(kindNotification, c)); err != nil {
+		return err
+	}
+	n.ID, n.Status, n.NextAttemptAt, n.CreatedAt, n.UpdatedAt = c.ID, c.Status, c.NextAttemptAt, c.CreatedAt, c.UpdatedAt
+	return nil
+}
+
+// ClaimNotifications marks up to limit due notifications as sending and
+// returns them. The claim expires after lease, so deliveries interrupted by
+// a crash are picked up again.
+func (s *Store) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]storage.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var due []storage.Notification
+	for _, n := range s.notifications {
+		switch n.Status {
+		case "pending", "failed", "sending":
+			if !n.NextAttemptAt.After(now) {
+				due = append(due, *n)
+			}
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	records := make([]record, len(due))
+	for i := range due {
+		due[i].Status, due[i].NextAttemptAt, due[i].UpdatedAt = "sending", now.Add(lease), now
+		records[i] = newRecord(kindNotification, due[i])
+	}
+	return due, s.commit(records...)
+}
+
+func (s *Store) MarkNotificationSent(ctx context.Context, id int) error {
+	return s.updateNotification(id, func(n *storage.Notification, now time.Time) bool {
+		n.Status, n.LastError, n.SentAt = "sent", "", &now
+		n.Attempts++
+		return true
+	})
+}
+
+func (s *Store) MarkNotificationFailed(ctx context.Context, id int, deliveryErr string, retryAt *time.Time) error {
+	return s.updateNotification(id, func(n *storage.Notification, now time.Time) bool {
+		n.Status, n.LastError = "failed", deliveryErr
+		if retryAt == nil {
+			n.Status = "dead"
+		} else {
+			n.NextAttemptAt = *retryAt
+		}
+		n.Attempts++
+		return true
+	})
+}
+
+func (s *Store) RetryNotification(ctx context.Context, id int) (*storage.Notification, error) {
+	var retried *storage.Notification
+	err := s.updateNotification(id, func(n *storage.Notification, now time.Time) bool {
+		if n.Status != "failed" && n.Status != "dead" {
+			return false
+		}
+		n.Status, n.Attempts, n.NextAttemptAt = "pending", 0, now
+		retried = n
+		return true
+	})
+	if err == nil && retried == nil {
+		err = sql.ErrNoRows
+	}
+	return retried, err
+}
+
+// updateNotification applies change to a copy of the notification and
+// stores it if change returns true. Missing notifications are ignored.
+func (s *Store) updateNotification(id int, change func(n *storage.Notification, now time.Time) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.notifications[id]
+	if !ok {
+		return nil
+	}
+	n := *current
+	now := time.Now()
+	if !change(&n, now) {
+		return nil
+	}
+	n.UpdatedAt = now
+	return s.commit(newRecord(kindNotification, n))
+}
+
+func (s *Store) GetNotification(ctx context.Context, id int) (*storage.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n, ok := s.notifications[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := *n
+	return &c, nil
+}
+
+func (s *Store) ListNotifications(ctx context.Context, filter storage.NotificationFilter) ([]storage.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var statuses []string
+	if filter.Status != "" {
+		statuses = strings.Split(filter.Status, ",")
+	}
+
+	var notifications []storage.Notification
+	for _, n := range s.notifications {
+		if filter.Receiver != "" && n.Receiver != filter.Receiver {
+			continue
+		}
+		if statuses != nil && !contains(statuses, n.Status) {
+			continue
+		}
+		notifications = append(notifications, *n)
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		a, b := notifications[i], notifications[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	if len(notifications) > filter.Limit {
+		notifications = notifications[:filter.Limit]
+	}
+	return notifications, nil
+}
+
+func (s *Store) GetDeliveryStats(ctx context.Context) ([]storage.ReceiverDeliveryStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byReceiver := make(map[string]*storage.ReceiverDeliveryStats)
+	lastErrorAt := make(map[string]time.Time)
+	for _, n := range s.notifications {
+		st := byReceiver[n.Receiver]
+		if st == nil {
+			st = &storage.ReceiverDeliveryStats{Receiver: n.Receiver}
+			byReceiver[n.Receiver] = st
+		}
+		switch n.Status {
+		case "pending", "sending":
+			st.Pending++
+		case "sent":
+			st.Sent++
+		case "failed":
+			st.Failed++
+		case "dead":
+			st.Dead++
+		}
+		if n.SentAt != nil && (st.LastSentAt == nil || n.SentAt.After(*st.LastSentAt)) {
+			sentAt := *n.SentAt
+			st.LastSentAt = &sentAt
+		}
+		if n.LastError != "" && n.UpdatedAt.After(lastErrorAt[n.Receiver]) {
+			st.LastError, lastErrorAt[n.Receiver] = n.LastError, n.UpdatedAt
+		}
+	}
+
+	stats := make([]storage.ReceiverDeliveryStats, 0, len(byReceiver))
+	for _, st := range byReceiver {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Receiver < stats[j].Receiver })
+	return stats, nil
+}
+
+func (s *Store) CreateAPIKey(ctx context.Context, k *storage.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.apiKeys {
+		if existing.Prefix == k.Prefix {
+			return errors.New("api key prefix already exists")
+		}
+	}
+
+	c := *k
+	c.ID, c.CreatedAt = s.nextID(kindAPIKey), time.Now()
+	if err := s.commit(newRecord(kindAPIKey, c)); err != nil {
+		return err
+	}
+	k.ID, k.CreatedAt = c.ID, c.CreatedAt
+	return nil
+}
+
+func (s *Store) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*storage.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.apiKeys {
+		if k.Prefix == prefix {
+			c := *k
+			return &c, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *Store) ListAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []storage.APIKey
+	for _, id := range sortedIDs(s.apiKeys) {
+		keys = append(keys, *s.apiKeys[id])
+	}
+	return keys, nil
+}
+
+func (s *Store) RevokeAPIKey(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.apiKeys[id]
+	if !ok || current.RevokedAt != nil {
+		return sql.ErrNoRows
+	}
+	k := *current
+	now := time.Now()
+	k.RevokedAt = &now
+	return s.commit(newRecord(kindAPIKey, k))
+}
+
+// TouchAPIKey records that a key was used, at most once a minute per key.
+func (s *Store) TouchAPIKey(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.apiKeys[id]
+	now := time.Now()
+	if !ok || (current.LastUsedAt != nil && current.LastUsedAt.After(now.Add(-time.Minute))) {
+		return nil
+	}
+	k := *current
+	k.LastUsedAt = &now
+	return s.commit(newRecord(kindAPIKey, k))
+}
@@ -0,0 +1,455 @@
+package embedded
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+func (s *Store) CreateAnomaly(ctx context.Context, anomaly *storage.Anomaly) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.metrics[anomaly.MetricID]; !ok {
+		return fmt.Errorf("metric %d does not exist", anomaly.MetricID)
+	}
+
+	a := *anomaly
+	a.ID = s.nextID(kindAnomaly)
+	a.CreatedAt = time.Now()
+	if a.Severity == "" {
+		a.Severity = "medium"
+	}
+	if a.Status == "" {
+		a.Status = "open"
+	}
+	if err := s.commit(newRecord(kindAnomaly, a)); err != nil {
+		return err
+	}
+	anomaly.ID, anomaly.CreatedAt = a.ID, a.CreatedAt
+	return nil
+}
+
+func (s *Store) GetAnomaly(ctx context.Context, id int) (*storage.Anomaly, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.anomalies[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := *a
+	return &c, nil
+}
+
+func (s *Store) AcknowledgeAnomaly(ctx context.Context, id int, by string) (*storage.Anomaly, error) {
+	return s.UpdateAnomalyStatus(ctx, id, storage.StatusUpdate{Status: "acknowledged", By: by})
+}
+
+func (s *Store) UpdateAnomalyStatus(ctx context.Context, id int, update storage.StatusUpdate) (*storage.Anomaly, error) {
+	if !storage.ValidAnomalyStatus(update.Status) {
+		return nil, fmt.Errorf("unknown anomaly status %q", update.Status)
+	}
+	if update.Status == "snoozed" && update.SnoozedUntil == nil {
+		return nil, fmt.Errorf("snoozed status requires an end time")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.anomalies[id]
+	if !ok || !storage.CanTransition(current.Status, update.Status) {
+		return nil, sql.ErrNoRows
+	}
+
+	a := *current
+	now := time.Now()
+	a.Status = update.Status
+	switch update.Status {
+	case "acknowledged":
+		a.AcknowledgedAt, a.AcknowledgedBy, a.AcknowledgedOnBehalfOf = &now, update.By, update.OnBehalfOf
+	case "resolved", "false_positive":
+		a.ResolvedAt = &now
+	}
+	a.SnoozedUntil = update.SnoozedUntil
+	if err := s.commit(newRecord(kindAnomaly, a)); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *Store) ReopenExpiredSnoozes(ctx context.Context) ([]storage.Anomaly, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var records []record
+	var reopened []storage.Anomaly
+	for _, id := range sortedIDs(s.anomalies) {
+		a := *s.anomalies[id]
+		if a.Status != "snoozed" || a.SnoozedUntil == nil || a.SnoozedUntil.After(now) {
+			continue
+		}
+		a.Status, a.SnoozedUntil = "open", nil
+		records = append(records, newRecord(kindAnomaly, a))
+		reopened = append(reopened, a)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	if err := s.commit(records...); err != nil {
+		return nil, err
+	}
+	return reopened, nil
+}
+
+func (s *Store) CountOpenAnomalies(ctx context.Context) ([]storage.OpenAnomalyCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	type key struct {
+		metricID         int
+		severity, status string
+	}
+	counts := make(map[key]int)
+	for _, a := range s.anomalies {
+		switch a.Status {
+		case "open", "acknowledged", "snoozed":
+			counts[key{a.MetricID, a.Severity, a.Status}]++
+		}
+	}
+
+	result := make([]storage.OpenAnomalyCount, 0, len(counts))
+	for k, n := range counts {
+		m := copyMetric(s.metrics[k.metricID])
+		result = append(result, storage.OpenAnomalyCount{
+			MetricID:   k.metricID,
+			MetricName: m.MetricName,
+			Labels:     m.Labels,
+			Severity:   k.severity,
+			Status:     k.status,
+			Count:      n,
+		})
+	}
+	return result, nil
+}
+
+// anomalySortValues compare anomalies by a sort key and read a cursor's key
+// value back into a pivot anomaly.
+var anomalySortValues = map[string]struct {
+	compare func(a, b *storage.Anomaly) int
+	parse   func(value string, pivot *storage.Anomaly) error
+}{
+	"created_at": {
+		compare: func(a, b *storage.Anomaly) int { return a.CreatedAt.Compare(b.CreatedAt) },
+		parse: func(v string, p *storage.Anomaly) (err error) {
+			p.CreatedAt, err = time.Parse(time.RFC3339Nano, v)
+			return
+		},
+	},
+	"timestamp": {
+		compare: func(a, b *storage.Anomaly) int { return a.Timestamp.Compare(b.Timestamp) },
+		parse: func(v string, p *storage.Anomaly) (err error) {
+			p.Timestamp, err = time.Parse(time.RFC3339Nano, v)
+			return
+		},
+	},
+	"score": {
+		compare: func(a, b *storage.Anomaly) int { return compareOrdered(a.AnomalyScore, b.AnomalyScore) },
+		parse: func(v string, p *storage.Anomaly) (err error) {
+			p.AnomalyScore, err = strconv.ParseFloat(v, 64)
+			return
+		},
+	},
+	"severity": {
+		compare: func(a, b *storage.Anomaly) int {
+			return compareOrdered(storage.SeverityRank(a.Severity), storage.SeverityRank(b.Severity))
+		},
+		parse: func(v string, p *storage.Anomaly) error {
+			rank, err := strconv.Atoi(v)
+			for _, name := range []string{"low", "medium", "high", "critical"} {
+				if storage.SeverityRank(name) == rank {
+					p.Severity = name
+				}
+			}
+			return err
+		},
+	},
+}
+
+func (s *Store) QueryAnomalies(ctx context.Context, q storage.AnomalyQuery) (*storage.AnomalyPage, error) {
+	if q.Sort == "" {
+		q.Sort = "created_at"
+	}
+	key, ok := anomalySortValues[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	sortID := q.Sort
+	if q.Desc {
+		sortID = "-" + q.Sort
+	}
+	compare := func(a, b *storage.Anomaly) int {
+		c := key.compare(a, b)
+		if c == 0 {
+			c = compareOrdered(a.ID, b.ID)
+		}
+		if q.Desc {
+			c = -c
+		}
+		return c
+	}
+
+	var pivot *storage.Anomaly
+	if q.After != nil {
+		if q.After.Sort != sortID {
+			return nil, fmt.Errorf("cursor was issued for sort %q", q.After.Sort)
+		}
+		if err := q.After.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid cursor: %w", err)
+		}
+		pivot = &storage.Anomaly{ID: q.After.ID}
+		if err := key.parse(q.After.Value, pivot); err != nil {
+			return nil, fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []*storage.Anomaly
+	for _, a := range s.anomalies {
+		if s.anomalyMatches(a, q) && (pivot == nil || compare(a, pivot) > 0) {
+			matches = append(matches, a)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return compare(matches[i], matches[j]) < 0 })
+
+	page := &storage.AnomalyPage{}
+	for i, a := range matches {
+		if q.Limit > 0 && i == q.Limit {
+			last := &page.Anomalies[len(page.Anomalies)-1].Anomaly
+			page.Next = &storage.AnomalyCursor{Sort: sortID, Value: storage.AnomalySortValue(q.Sort, last), ID: last.ID}
+			break
+		}
+		page.Anomalies = append(page.Anomalies, storage.AnomalyResult{
+			Anomaly:    *a,
+			MetricName: s.metrics[a.MetricID].MetricName,
+		})
+	}
+	return page, nil
+}
+
+func (s *Store) anomalyMatches(a *storage.Anomaly, q storage.AnomalyQuery) bool {
+	m := s.metrics[a.MetricID]
+	switch {
+	case q.MetricID != 0 && a.MetricID != q.MetricID,
+		q.MetricName != "" && m.MetricName != q.MetricName,
+		len(q.Severities) > 0 && !contains(q.Severities, a.Severity),
+		len(q.Statuses) > 0 && !contains(q.Statuses, a.Status),
+		q.From != nil && a.Timestamp.Before(*q.From),
+		q.To != nil && !a.Timestamp.Before(*q.To),
+		q.MinScore != nil && a.AnomalyScore < *q.MinScore,
+		len(q.Methods) > 0 && !overlaps(q.Methods, a.DetectionMethods),
+		!hasLabels(m.Labels, q.Labels):
+		return false
+	}
+	return true
+}
+
+// AttachToIncident links a freshly stored anomaly to the open incident of its
+// metric, opening a new incident when there is none.
+func (s *Store) AttachToIncident(ctx context.Context, anomaly *storage.Anomaly) (*storage.Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.anomalies[anomaly.ID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	var inc storage.Incident
+	var found bool
+	for _, i := range s.incidents {
+		if i.MetricID == anomaly.MetricID && i.Status != "resolved" &&
+			(!found || i.OpenedAt.After(inc.OpenedAt)) {
+			inc, found = *i, true
+		}
+	}
+
+	now := time.Now()
+	if found {
+		inc.AnomalyCount++
+		if storage.SeverityRank(anomaly.Severity) > storage.SeverityRank(inc.Severity) {
+			inc.Severity = anomaly.Severity
+		}
+		inc.UpdatedAt = now
+	} else {
+		inc = storage.Incident{
+			ID:           s.nextID(kindIncident),
+			MetricID:     anomaly.MetricID,
+			Status:       "open",
+			Severity:     anomaly.Severity,
+			AnomalyCount: 1,
+			OpenedAt:     now,
+			UpdatedAt:    now,
+		}
+	}
+
+	a := *stored
+	a.IncidentID = &inc.ID
+	if err := s.commit(newRecord(kindIncident, inc), newRecord(kindAnomaly, a)); err != nil {
+		return nil, err
+	}
+
+	anomaly.IncidentID = &inc.ID
+	return &inc, nil
+}
+
+func (s *Store) GetIncident(ctx context.Context, id int) (*storage.Incident, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	inc, ok := s.incidents[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := *inc
+	return &c, nil
+}
+
+func (s *Store) AcknowledgeIncident(ctx context.Context, id int, by, onBehalfOf string) (*storage.Incident, []int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.incidents[id]
+	if !ok || current.Status != "open" {
+		return nil, nil, sql.ErrNoRows
+	}
+
+	now := time.Now()
+	inc := *current
+	inc.Status, inc.AcknowledgedAt, inc.AcknowledgedBy, inc.AcknowledgedOnBehalfOf = "acknowledged", &now, by, onBehalfOf
+	records := []record{newRecord(kindIncident, inc)}
+
+	var anomalyIDs []int
+	for _, aid := range sortedIDs(s.anomalies) {
+		a := *s.anomalies[aid]
+		if a.IncidentID == nil || *a.IncidentID != id || a.Status != "open" {
+			continue
+		}
+		a.Status, a.AcknowledgedAt, a.AcknowledgedBy, a.AcknowledgedOnBehalfOf = "acknowledged", &now, by, onBehalfOf
+		records = append(records, newRecord(kindAnomaly, a))
+		anomalyIDs = append(anomalyIDs, a.ID)
+	}
+
+	if err := s.commit(records...); err != nil {
+		return nil, nil, err
+	}
+	return &inc, anomalyIDs, nil
+}
+
+func (s *Store) ResolveIdleIncidents(ctx context.Context, idle time.Duration) ([]storage.Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-idle)
+	var records []record
+	var resolved []storage.Incident
+	for _, id := range sortedIDs(s.incidents) {
+		inc := *s.incidents[id]
+		if inc.Status == "resolved" || !inc.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		inc.Status, inc.ResolvedAt = "resolved", &now
+		records = append(records, newRecord(kindIncident, inc))
+		resolved = append(resolved, inc)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	if err := s.commit(records...); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+func (s *Store) ListIncidents(ctx context.Context, f storage.IncidentFilter) ([]storage.IncidentResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var incidents []storage.IncidentResult
+	for _, inc := range s.incidents {
+		m := s.metrics[inc.MetricID]
+		end := now
+		if inc.ResolvedAt != nil {
+			end = *inc.ResolvedAt
+		}
+		switch {
+		case inc.OpenedAt.After(f.To), end.Before(f.From),
+			f.MetricName != "" && m.MetricName != f.MetricName,
+			!hasLabels(m.Labels, f.Labels),
+			len(f.Severities) > 0 && !contains(f.Severities, inc.Severity),
+			len(f.Statuses) > 0 && !contains(f.Statuses, inc.Status):
+			continue
+		}
+		incidents = append(incidents, storage.IncidentResult{Incident: *inc, MetricName: m.MetricName})
+	}
+
+	sort.Slice(incidents, func(i, j int) bool {
+		if !incidents[i].OpenedAt.Equal(incidents[j].OpenedAt) {
+			return incidents[i].OpenedAt.Before(incidents[j].OpenedAt)
+		}
+		return incidents[i].ID < incidents[j].ID
+	})
+	if f.Limit > 0 && len(incidents) > f.Limit {
+		incidents = incidents[:f.Limit]
+	}
+	return incidents, nil
+}
+
+func compareOrdered[T int | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		if contains(b, x) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLabels reports whether labels contains every pair in want.
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
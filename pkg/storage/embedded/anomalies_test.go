@@ -0,0 +1,190 @@
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// seedAnomalies stores ten anomalies over two metrics. Scores repeat so
+// sorting by score needs the ID tie breaker.
+func seedAnomalies(t *testing.T, s *Store) time.Time {
+	t.Helper()
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+	api, _, err := s.CreateMetric(ctx, "api_latency", map[string]string{"team": "payments", "env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _, err := s.CreateMetric(ctx, "db_connections", map[string]string{"team": "platform", "env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	severities := []string{"low", "medium", "high", "critical"}
+	for i := 0; i < 10; i++ {
+		metric := api
+		methods := []string{"stl"}
+		if i%2 == 1 {
+			metric = db
+			methods = []string{"isolation_forest", "zscore"}
+		}
+		// Timestamps run backwards so they order differently from IDs.
+		a := &storage.Anomaly{
+			MetricID:         metric.ID,
+			Timestamp:        start.Add(time.Duration(10-i) * time.Minute),
+			Value:            float64(i),
+			AnomalyScore:     []float64{0.5, 0.7, 0.9}[i%3],
+			DetectionMethods: methods,
+			Severity:         severities[i%4],
+		}
+		if err := s.CreateAnomaly(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.AcknowledgeAnomaly(ctx, 1, "ana"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateAnomalyStatus(ctx, 2, storage.StatusUpdate{Status: "resolved"}); err != nil {
+		t.Fatal(err)
+	}
+	return start
+}
+
+func anomalyIDs(page *storage.AnomalyPage) []int {
+	ids := []int{}
+	for _, a := range page.Anomalies {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestQueryAnomaliesFilters(t *testing.T) {
+	s := openStore(t, t.TempDir())
+	defer s.Close()
+	start := seedAnomalies(t, s)
+
+	minScore := 0.8
+	from, to := start.Add(3*time.Minute), start.Add(6*time.Minute)
+
+	tests := []struct {
+		name string
+		q    storage.AnomalyQuery
+		want []int
+	}{
+		{"all", storage.AnomalyQuery{}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"metric id", storage.AnomalyQuery{MetricID: 2}, []int{2, 4, 6, 8, 10}},
+		{"metric name", storage.AnomalyQuery{MetricName: "api_latency"}, []int{1, 3, 5, 7, 9}},
+		{"unknown metric", storage.AnomalyQuery{MetricName: "nope"}, []int{}},
+		{"severities", storage.AnomalyQuery{Severities: []string{"critical", "high"}}, []int{3, 4, 7, 8}},
+		{"statuses", storage.AnomalyQuery{Statuses: []string{"acknowledged", "resolved"}}, []int{1, 2}},
+		// To is exclusive: anomaly 5 sits exactly on it.
+		{"time range", storage.AnomalyQuery{From: &from, To: &to}, []int{6, 7, 8}},
+		{"min score", storage.AnomalyQuery{MinScore: &minScore}, []int{3, 6, 9}},
+		{"any method", storage.AnomalyQuery{Methods: []string{"zscore", "prophet"}}, []int{2, 4, 6, 8, 10}},
+		{"labels", storage.AnomalyQuery{Labels: map[string]string{"team": "payments", "env": "prod"}}, []int{1, 3, 5, 7, 9}},
+		{"label mismatch", storage.AnomalyQuery{Labels: map[string]string{"team": "payments", "env": "dev"}}, []int{}},
+		{"combined", storage.AnomalyQuery{MetricID: 1, Severities: []string{"low"}, Statuses: []string{"open"}}, []int{5, 9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := s.QueryAnomalies(context.Background(), tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := anomalyIDs(page); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("QueryAnomalies() = %v, want %v", got, tt.want)
+			}
+			if page.Next != nil {
+				t.Fatalf("QueryAnomalies() without a limit returned a cursor")
+			}
+			for _, a := range page.Anomalies {
+				if a.MetricName == "" {
+					t.Fatalf("anomaly %d has no metric name", a.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryAnomaliesPagination(t *testing.T) {
+	s := openStore(t, t.TempDir())
+	defer s.Close()
+	seedAnomalies(t, s)
+	ctx := context.Background()
+
+	tests := []struct {
+		sort string
+		desc bool
+		want []int
+	}{
+		{"created_at", false, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"created_at", true, []int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{"timestamp", false, []int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{"score", false, []int{1, 4, 7, 10, 2, 5, 8, 3, 6, 9}},
+		{"score", true, []int{9, 6, 3, 8, 5, 2, 10, 7, 4, 1}},
+		{"severity", true, []int{8, 4, 7, 3, 10, 6, 2, 9, 5, 1}},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 3, 10, 20} {
+			t.Run(fmt.Sprintf("%s desc=%v limit=%d", tt.sort, tt.desc, limit), func(t *testing.T) {
+				q := storage.AnomalyQuery{Sort: tt.sort, Desc: tt.desc, Limit: limit}
+				got := []int{}
+				for pages := 1; ; pages++ {
+					page, err := s.QueryAnomalies(ctx, q)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(page.Anomalies) > limit {
+						t.Fatalf("page %d has %d anomalies, limit %d", pages, len(page.Anomalies), limit)
+					}
+					got = append(got, anomalyIDs(page)...)
+					if page.Next == nil {
+						break
+					}
+					if pages > len(tt.want) {
+						t.Fatal("paging does not end")
+					}
+					q.After = page.Next
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("paged through %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestQueryAnomaliesCursorErrors(t *testing.T) {
+	s := openStore(t, t.TempDir())
+	defer s.Close()
+	seedAnomalies(t, s)
+	ctx := context.Background()
+
+	page, err := s.QueryAnomalies(ctx, storage.AnomalyQuery{Sort: "score", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		q    storage.AnomalyQuery
+	}{
+		{"unknown sort", storage.AnomalyQuery{Sort: "value"}},
+		{"cursor for another sort", storage.AnomalyQuery{Sort: "timestamp", After: page.Next}},
+		{"cursor for another direction", storage.AnomalyQuery{Sort: "score", Desc: true, After: page.Next}},
+		{"bad cursor value", storage.AnomalyQuery{Sort: "score", After: &storage.AnomalyCursor{Sort: "score", Value: "high", ID: 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.QueryAnomalies(ctx, tt.q); err == nil {
+				t.Fatal("QueryAnomalies() error = nil")
+			}
+		})
+	}
+}
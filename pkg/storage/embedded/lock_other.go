@@ -0,0 +1,11 @@
+//go:build !unix
+
+package embedded
+
+import "os"
+
+// lockDir opens the lock file without locking it; only one process should
+// use a data directory on platforms without flock.
+func lockDir(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+}
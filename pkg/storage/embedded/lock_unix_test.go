@@ -0,0 +1,27 @@
+//go:build unix
+
+package embedded
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOpenLocksDirectory(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+
+	// flock locks belong to the open file, so a second Open in the same
+	// process conflicts just as another process would.
+	if other, err := Open(dir, Options{}); err == nil {
+		other.Close()
+		t.Fatal("second Open() of a directory in use succeeded")
+	} else if !strings.Contains(err.Error(), "in use by another argus process") {
+		t.Fatalf("second Open() error = %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	openStore(t, dir).Close()
+}
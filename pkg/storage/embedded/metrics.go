@@ -0,0 +1,244 @@
+package embedded
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+func (s *Store) CreateMetric(ctx context.Context, metricName string, labels map[string]string) (*storage.Metric, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, exists := s.names[metricName]
+	m := storage.Metric{MetricName: metricName}
+	if exists {
+		m = copyMetric(s.metrics[id])
+	} else {
+		m.ID = s.nextID(kindMetric)
+	}
+	m.IsActive = true
+	m.Labels = nil
+	if len(labels) > 0 {
+		m.Labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			m.Labels[k] = v
+		}
+	}
+	if err := s.commit(newRecord(kindMetric, m)); err != nil {
+		return nil, false, err
+	}
+	return &m, !exists, nil
+}
+
+func (s *Store) GetMetrics(ctx context.Context) ([]storage.Metric, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var metrics []storage.Metric
+	for _, id := range sortedIDs(s.metrics) {
+		if m := s.metrics[id]; m.IsActive {
+			metrics = append(metrics, copyMetric(m))
+		}
+	}
+	return metrics, nil
+}
+
+func (s *Store) GetMetric(ctx context.Context, id int) (*storage.Metric, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.metrics[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := copyMetric(m)
+	return &c, nil
+}
+
+func (s *Store) GetMetricByName(ctx context.Context, name string) (*storage.Metric, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.names[name]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := copyMetric(s.metrics[id])
+	return &c, nil
+}
+
+func copyMetric(m *storage.Metric) storage.Metric {
+	c := *m
+	if m.Labels != nil {
+		c.Labels = make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			c.Labels[k] = v
+		}
+	}
+	return c
+}
+
+// InsertMetricData stores points, ignoring any whose metric and timestamp
+// are already stored.
+func (s *Store) InsertMetricData(ctx context.Context, points []storage.MetricDataPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(newRecord(kindPoints, points))
+}
+
+func (s *Store) addPoints(points []storage.MetricDataPoint) {
+	defer s.prune()
+	for _, p := range points {
+		series := s.points[p.MetricID]
+		// Points almost always arrive in order.
+		if n := len(series); n == 0 || series[n-1].Timestamp.Before(p.Timestamp) {
+			s.points[p.MetricID] = append(series, p)
+			continue
+		}
+		i := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(p.Timestamp) })
+		if i < len(series) && series[i].Timestamp.Equal(p.Timestamp) {
+			continue
+		}
+		series = append(series, storage.MetricDataPoint{})
+		copy(series[i+1:], series[i:])
+		series[i] = p
+		s.points[p.MetricID] = series
+	}
+}
+
+func (s *Store) GetMetricData(ctx context.Context, metricID int, since time.Time) ([]storage.MetricDataPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series := s.points[metricID]
+	i := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(since) })
+	if i == len(series) {
+		return nil, nil
+	}
+	return append([]storage.MetricDataPoint(nil), series[i:]...), nil
+}
+
+// GetMetricSeries aggregates the points in [from, to) into buckets of step
+// aligned to the Unix epoch, like the PostgreSQL backend.
+func (s *Store) GetMetricSeries(ctx context.Context, metricID int, from, to time.Time, step time.Duration) ([]storage.SeriesPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var points []storage.SeriesPoint
+	for _, p := range window(s.points[metricID], from, to, func(p storage.MetricDataPoint) time.Time { return p.Timestamp }) {
+		b := bucket(p.Timestamp, step)
+		if n := len(points); n > 0 && points[n-1].Timestamp.Equal(b) {
+			last := &points[n-1]
+			last.Avg += p.Value
+			last.Min = math.Min(last.Min, p.Value)
+			last.Max = math.Max(last.Max, p.Value)
+			last.Count++
+			continue
+		}
+		points = append(points, storage.SeriesPoint{Timestamp: b, Avg: p.Value, Min: p.Value, Max: p.Value, Count: 1})
+	}
+	for i := range points {
+		points[i].Avg /= float64(points[i].Count)
+	}
+	return points, nil
+}
+
+func (s *Store) UpsertBaseline(ctx context.Context, metricID int, points []storage.ExpectedPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(newRecord(kindBaseline, baselineRecord{MetricID: metricID, Points: points}))
+}
+
+func (s *Store) addBaseline(metricID int, points []storage.ExpectedPoint) {
+	byTime := make(map[int64]storage.ExpectedPoint, len(s.baselines[metricID])+len(points))
+	for _, p := range s.baselines[metricID] {
+		byTime[p.Timestamp.UnixNano()] = p
+	}
+	for _, p := range points {
+		p.MetricID = metricID
+		byTime[p.Timestamp.UnixNano()] = p
+	}
+
+	merged := make([]storage.ExpectedPoint, 0, len(byTime))
+	for _, p := range byTime {
+		merged = append(merged, p)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Timestamp.Before(merged[j].Timestamp) })
+	s.baselines[metricID] = merged
+}
+
+func (s *Store) GetBaseline(ctx context.Context, metricID int, from, to time.Time, step time.Duration) ([]storage.ExpectedPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var points []storage.ExpectedPoint
+	var counts []int
+	for _, p := range window(s.baselines[metricID], from, to, func(p storage.ExpectedPoint) time.Time { return p.Timestamp }) {
+		b := bucket(p.Timestamp, step)
+		if n := len(points); n > 0 && points[n-1].Timestamp.Equal(b) {
+			points[n-1].Value += p.Value
+			points[n-1].Lower += p.Lower
+			points[n-1].Upper += p.Upper
+			counts[n-1]++
+			continue
+		}
+		points = append(points, storage.ExpectedPoint{MetricID: metricID, Timestamp: b, Value: p.Value, Lower: p.Lower, Upper: p.Upper})
+		counts = append(counts, 1)
+	}
+	for i, n := range counts {
+		points[i].Value /= float64(n)
+		points[i].Lower /= float64(n)
+		points[i].Upper /= float64(n)
+	}
+	return points, nil
+}
+
+// prune drops points and baselines older than the retention. Expired data
+// leaves the journal the next time it is compacted.
+func (s *Store) prune() {
+	if s.opts.Retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.opts.Retention)
+	for id, series := range s.points {
+		if i := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(cutoff) }); i > 0 {
+			s.points[id] = series[i:]
+		}
+	}
+	for id, series := range s.baselines {
+		if i := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(cutoff) }); i > 0 {
+			s.baselines[id] = series[i:]
+		}
+	}
+}
+
+// window returns the part of a time-ordered slice in [from, to).
+func window[T any](series []T, from, to time.Time, ts func(T) time.Time) []T {
+	i := sort.Search(len(series), func(i int) bool { return !ts(series[i]).Before(from) })
+	j := sort.Search(len(series), func(j int) bool { return !ts(series[j]).Before(to) })
+	if i >= j {
+		return nil
+	}
+	return series[i:j]
+}
+
+func bucket(t time.Time, step time.Duration) time.Time {
+	secs := int64(step / time.Second)
+	if secs <= 0 {
+		secs = 1
+	}
+	return time.Unix(t.Unix()/secs*secs, 0)
+}
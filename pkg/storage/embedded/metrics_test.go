@@ -0,0 +1,41 @@
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+func BenchmarkInsertMetricData(b *testing.B) {
+	for _, batch := range []int{500, 5000} {
+		b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+			s, err := Open(b.TempDir(), Options{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer s.Close()
+			ctx := context.Background()
+			m, _, err := s.CreateMetric(ctx, "http_requests_total", nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			start := time.Now()
+			points := make([]storage.MetricDataPoint, batch)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := range points {
+					points[j] = storage.MetricDataPoint{MetricID: m.ID, Timestamp: start.Add(time.Duration(i*batch+j) * time.Millisecond), Value: float64(j)}
+				}
+				if err := s.InsertMetricData(ctx, points); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*batch)/b.Elapsed().Seconds(), "points/s")
+		})
+	}
+}
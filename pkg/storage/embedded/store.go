@@ -0,0 +1,384 @@
+// Package embedded is a storage backend that needs no database server. It
+// keeps everything in memory and persists it to an append-only journal in a
+// data directory, so Argus can run as a single binary for small teams.
+//
+// Every change is appended to argus.journal before it is applied. On open the
+// journal is replayed and rewritten without superseded records; it is
+// rewritten again whenever enough records have been appended since. Writes
+// are not fsynced one by one, so a power loss can drop the last changes but
+// a crashed process cannot.
+package embedded
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+const (
+	journalFile = "argus.journal"
+	lockFile    = "argus.lock"
+
+	// The journal is rewritten after this many appended records.
+	compactEvery = 50000
+	// Data points per record when rewriting the journal.
+	pointsPerRecord = 10000
+)
+
+var _ storage.Store = (*Store)(nil)
+
+type Options struct {
+	// Retention drops data points and baselines older than this. Zero keeps
+	// them forever.
+	Retention time.Duration
+}
+
+type Store struct {
+	mu        sync.RWMutex
+	dir       string
+	opts      Options
+	journal   *os.File
+	w         *bufio.Writer
+	lock      *os.File
+	appended  int
+	closed    bool
+	lastID    map[string]int
+	metrics   map[int]*storage.Metric
+	names     map[string]int
+	points    map[int][]storage.MetricDataPoint // by timestamp
+	baselines map[int][]storage.ExpectedPoint   // by timestamp
+
+	anomalies     map[int]*storage.Anomaly
+	incidents     map[int]*storage.Incident
+	escalations   map[int]*storage.Escalation
+	events        map[int]*storage.EscalationEvent
+	notifications map[int]*storage.Notification
+	apiKeys       map[int]*storage.APIKey
+}
+
+// Record kinds. Entity records replace the stored entity with the same ID;
+// points and baseline records add to a metric's series.
+const (
+	kindMetric       = "metric"
+	kindPoints       = "points"
+	kindBaseline     = "baseline"
+	kindAnomaly      = "anomaly"
+	kindIncident     = "incident"
+	kindEscalation   = "escalation"
+	kindEvent        = "event"
+	kindNotification = "notification"
+	kindAPIKey       = "apikey"
+)
+
+type record struct {
+	Kind string          `json:"k"`
+	Data json.RawMessage `json:"d"`
+}
+
+type baselineRecord struct {
+	MetricID int
+	Points   []storage.ExpectedPoint
+}
+
+// Open loads the store in dir, creating it if needed. Only one process may
+// have a data directory open at a time.
+func Open(dir string, opts Options) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(filepath.Join(dir, lockFile))
+	if err != nil {
+		return nil, fmt.Errorf("data directory %s: %w", dir, err)
+	}
+
+	s := &Store{
+		dir:           dir,
+		opts:          opts,
+		lock:          lock,
+		lastID:        make(map[string]int),
+		metrics:       make(map[int]*storage.Metric),
+		names:         make(map[string]int),
+		points:        make(map[int][]storage.MetricDataPoint),
+		baselines:     make(map[int][]storage.ExpectedPoint),
+		anomalies:     make(map[int]*storage.Anomaly),
+		incidents:     make(map[int]*storage.Incident),
+		escalations:   make(map[int]*storage.Escalation),
+		events:        make(map[int]*storage.EscalationEvent),
+		notifications: make(map[int]*storage.Notification),
+		apiKeys:       make(map[int]*storage.APIKey),
+	}
+	if err := s.replay(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	err := s.w.Flush()
+	if syncErr := s.journal.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := s.journal.Close(); err == nil {
+		err = closeErr
+	}
+	s.lock.Close()
+	return err
+}
+
+// Ping reports an error once the store is closed or its journal can no
+// longer be written.
+func (s *Store) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("embedded store is closed")
+	}
+	return s.w.Flush()
+}
+
+func (s *Store) replay() error {
+	f, err := os.Open(filepath.Join(s.dir, journalFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for n := 1; ; n++ {
+		var rec record
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// A write cut short by a crash; everything before it is intact.
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("journal record %d: %w", n, err)
+		}
+		if err := s.apply(rec); err != nil {
+			return fmt.Errorf("journal record %d: %w", n, err)
+		}
+	}
+}
+
+// commit appends records to the journal and applies them. Callers hold the
+// write lock.
+func (s *Store) commit(records ...record) error {
+	for _, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if _, err := s.w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		if err := s.apply(rec); err != nil {
+			return err
+		}
+	}
+
+	s.appended += len(records)
+	if s.appended >= compactEvery {
+		return s.compact()
+	}
+	return nil
+}
+
+func newRecord(kind string, v interface{}) record {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// Only storage types are encoded and they always marshal.
+		panic(err)
+	}
+	return record{Kind: kind, Data: data}
+}
+
+func (s *Store) apply(rec record) error {
+	switch rec.Kind {
+	case kindMetric:
+		var m storage.Metric
+		if err := json.Unmarshal(rec.Data, &m); err != nil {
+			return err
+		}
+		s.metrics[m.ID] = &m
+		s.names[m.MetricName] = m.ID
+		s.seen(kindMetric, m.ID)
+	case kindPoints:
+		var points []storage.MetricDataPoint
+		if err := json.Unmarshal(rec.Data, &points); err != nil {
+			return err
+		}
+		s.addPoints(points)
+	case kindBaseline:
+		var b baselineRecord
+		if err := json.Unmarshal(rec.Data, &b); err != nil {
+			return err
+		}
+		s.addBaseline(b.MetricID, b.Points)
+	case kindAnomaly:
+		return applyEntity(rec, s.anomalies, s, func(a *storage.Anomaly) int { return a.ID })
+	case kindIncident:
+		return applyEntity(rec, s.incidents, s, func(i *storage.Incident) int { return i.ID })
+	case kindEscalation:
+		return applyEntity(rec, s.escalations, s, func(e *storage.Escalation) int { return e.ID })
+	case kindEvent:
+		return applyEntity(rec, s.events, s, func(e *storage.EscalationEvent) int { return e.ID })
+	case kindNotification:
+		return applyEntity(rec, s.notifications, s, func(n *storage.Notification) int { return n.ID })
+	case kindAPIKey:
+		return applyEntity(rec, s.apiKeys, s, func(k *storage.APIKey) int { return k.ID })
+	default:
+		return fmt.Errorf("unknown record kind %q", rec.Kind)
+	}
+	return nil
+}
+
+func applyEntity[T any](rec record, into map[int]*T, s *Store, id func(*T) int) error {
+	v := new(T)
+	if err := json.Unmarshal(rec.Data, v); err != nil {
+		return err
+	}
+	into[id(v)] = v
+	s.seen(rec.Kind, id(v))
+	return nil
+}
+
+func (s *Store) seen(kind string, id int) {
+	s.lastID[kind] = max(s.lastID[kind], id)
+}
+
+func (s *Store) nextID(kind string) int {
+	return s.lastID[kind] + 1
+}
+
+// compact rewrites the journal with one record per live entity and reopens
+// it for appending.
+func (s *Store) compact() error {
+	path := filepath.Join(s.dir, journalFile)
+	tmp, err := os.CreateTemp(s.dir, journalFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	write := func(kind string, v interface{}) error {
+		return enc.Encode(newRecord(kind, v))
+	}
+
+	for _, id := range sortedIDs(s.metrics) {
+		if err := write(kindMetric, s.metrics[id]); err != nil {
+			return err
+		}
+	}
+	s.prune()
+	for _, id := range sortedIDs(s.points) {
+		points := s.points[id]
+		for len(points) > 0 {
+			n := min(len(points), pointsPerRecord)
+			if err := write(kindPoints, points[:n]); err != nil {
+				return err
+			}
+			points = points[n:]
+		}
+	}
+	for _, id := range sortedIDs(s.baselines) {
+		if err := write(kindBaseline, baselineRecord{MetricID: id, Points: s.baselines[id]}); err != nil {
+			return err
+		}
+	}
+	if err := writeEntities(write, kindAnomaly, s.anomalies); err != nil {
+		return err
+	}
+	if err := writeEntities(write, kindIncident, s.incidents); err != nil {
+		return err
+	}
+	if err := writeEntities(write, kindEscalation, s.escalations); err != nil {
+		return err
+	}
+	if err := writeEntities(write, kindEvent, s.events); err != nil {
+		return err
+	}
+	if err := writeEntities(write, kindNotification, s.notifications); err != nil {
+		return err
+	}
+	if err := writeEntities(write, kindAPIKey, s.apiKeys); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if s.journal != nil {
+		s.w.Flush()
+		s.journal.Close()
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	s.journal, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	s.w = bufio.NewWriter(s.journal)
+	s.appended = 0
+	return nil
+}
+
+func writeEntities[T any](write func(string, interface{}) error, kind string, entities map[int]*T) error {
+	for _, id := range sortedIDs(entities) {
+		if err := write(kind, entities[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedIDs[T any](m map[int]T) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
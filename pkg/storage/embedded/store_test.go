@@ -0,0 +1,216 @@
+package embedded
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+func openStore(t *testing.T, dir string) *Store {
+	t.Helper()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// populate stores one of everything, changing some entities after they are
+// created so the journal holds superseded records.
+func populate(t *testing.T, s *Store) {
+	t.Helper()
+	ctx := context.Background()
+	check := func(what string, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+	m, _, err := s.CreateMetric(ctx, "http_request_duration_seconds", map[string]string{"team": "payments"})
+	check("CreateMetric", err)
+	_, _, err = s.CreateMetric(ctx, "http_request_duration_seconds", map[string]string{"team": "checkout"})
+	check("CreateMetric again", err)
+
+	var points []storage.MetricDataPoint
+	var expected []storage.ExpectedPoint
+	for i := 0; i < 10; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		points = append(points, storage.MetricDataPoint{MetricID: m.ID, Timestamp: ts, Value: float64(i)})
+		expected = append(expected, storage.ExpectedPoint{Timestamp: ts, Value: 5, Lower: 0, Upper: 10})
+	}
+	check("InsertMetricData", s.InsertMetricData(ctx, points[:5]))
+	check("InsertMetricData", s.InsertMetricData(ctx, points[3:]))
+	check("UpsertBaseline", s.UpsertBaseline(ctx, m.ID, expected))
+
+	var anomalies []*storage.Anomaly
+	for i := 0; i < 2; i++ {
+		a := &storage.Anomaly{MetricID: m.ID, Timestamp: points[i].Timestamp, Value: 99, AnomalyScore: 0.9, Severity: "critical"}
+		check("CreateAnomaly", s.CreateAnomaly(ctx, a))
+		_, err := s.AttachToIncident(ctx, a)
+		check("AttachToIncident", err)
+		anomalies = append(anomalies, a)
+	}
+	_, _, err = s.AcknowledgeIncident(ctx, *mustAnomaly(t, s, anomalies[0].ID).IncidentID, "ana", "bob")
+	check("AcknowledgeIncident", err)
+
+	esc, err := s.CreateEscalation(ctx, anomalies[0].ID, "default", time.Now())
+	check("CreateEscalation", err)
+	check("AddEscalationEvent", s.AddEscalationEvent(ctx, &storage.EscalationEvent{EscalationID: esc.ID, AnomalyID: anomalies[0].ID, Event: "notified"}))
+	check("StopEscalations", s.StopEscalations(ctx, anomalies[0].ID, "acknowledged", "by ana"))
+
+	n := &storage.Notification{Receiver: "slack", AnomalyID: &anomalies[0].ID, Payload: json.RawMessage(`{"title":"x"}`)}
+	check("EnqueueNotification", s.EnqueueNotification(ctx, n))
+	check("MarkNotificationSent", s.MarkNotificationSent(ctx, n.ID))
+
+	key := &storage.APIKey{Name: "ci", Prefix: "argus_ab12", KeyHash: "hash", Scopes: []string{"role:viewer"}}
+	check("CreateAPIKey", s.CreateAPIKey(ctx, key))
+	check("RevokeAPIKey", s.RevokeAPIKey(ctx, key.ID))
+}
+
+func mustAnomaly(t *testing.T, s *Store, id int) *storage.Anomaly {
+	t.Helper()
+	a, err := s.GetAnomaly(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// dump reads back everything populate stored, as JSON so that values
+// compare equal whether they came from memory or from the journal.
+func dump(t *testing.T, s *Store) string {
+	t.Helper()
+	ctx := context.Background()
+	var out []interface{}
+	add := func(v interface{}, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, v)
+	}
+
+	add(s.GetMetrics(ctx))
+	add(s.GetMetricData(ctx, 1, time.Time{}))
+	add(s.GetBaseline(ctx, 1, time.Now().Add(-2*time.Hour), time.Now(), time.Minute))
+	add(s.QueryAnomalies(ctx, storage.AnomalyQuery{}))
+	add(s.ListIncidents(ctx, storage.IncidentFilter{To: time.Now()}))
+	add(s.GetEscalationTimeline(ctx, 1))
+	add(s.ListNotifications(ctx, storage.NotificationFilter{}))
+	add(s.ListAPIKeys(ctx))
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReopenReplaysJournal(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	populate(t, s)
+	want := dump(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first reopen replays the records appended above, superseded ones
+	// included; the second replays the journal that reopen compacted.
+	for i := 0; i < 2; i++ {
+		s = openStore(t, dir)
+		if got := dump(t, s); got != want {
+			t.Fatalf("reopen %d: store differs from before closing\n got %s\nwant %s", i+1, got, want)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// IDs carry on from the replayed records.
+	s = openStore(t, dir)
+	defer s.Close()
+	m, created, err := s.CreateMetric(context.Background(), "node_cpu_seconds_total", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || m.ID != 2 {
+		t.Fatalf("CreateMetric() after reopen = ID %d, created %v, want ID 2", m.ID, created)
+	}
+}
+
+func TestReplayTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	populate(t, s)
+	want := dump(t, s)
+	s.Close()
+
+	// A crash in the middle of appending leaves half a record at the end.
+	appendJournal(t, dir, `{"k":"metric","d":{"ID":9,"MetricName":"tor`)
+
+	s = openStore(t, dir)
+	defer s.Close()
+	if got := dump(t, s); got != want {
+		t.Fatalf("store differs after a torn write\n got %s\nwant %s", got, want)
+	}
+	if _, err := s.GetMetric(context.Background(), 9); err == nil {
+		t.Fatal("the torn record was applied")
+	}
+}
+
+func TestReplayCorruptJournal(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  string
+		wantErr string
+	}{
+		{"not json", "garbage\n" + `{"k":"metric","d":{"ID":9}}` + "\n", "journal record"},
+		{"unknown kind", `{"k":"widget","d":{}}` + "\n", `unknown record kind "widget"`},
+		{"bad entity", `{"k":"anomaly","d":{"ID":"one"}}` + "\n", "journal record"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, dir)
+			populate(t, s)
+			s.Close()
+
+			appendJournal(t, dir, tt.record)
+			s, err := Open(dir, Options{})
+			if err == nil {
+				s.Close()
+				t.Fatal("Open() of a corrupt journal succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %q", err, tt.wantErr)
+			}
+			// A failed open must release the directory.
+			lock, err := lockDir(filepath.Join(dir, lockFile))
+			if err != nil {
+				t.Fatalf("directory still locked after a failed Open(): %v", err)
+			}
+			lock.Close()
+		})
+	}
+}
+
+func appendJournal(t *testing.T, dir, data string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Escalation tracks where an anomaly is in its escalation policy.
+type Escalation struct {
+	ID        int
+	AnomalyID int
+	Policy    string
+	NextStep  int
+	NextAt    *time.Time
+	State     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// EscalationEvent is one entry in an anomaly's escalation timeline.
+type EscalationEvent struct {
+	ID           int
+	EscalationID int
+	AnomalyID    int
+	Step         int
+	Receiver     string
+	Event        string
+	Detail       string
+	CreatedAt    time.Time
+}
+
+const escalationColumns = `id, anomaly_id, policy, next_step, next_at, state, created_at, updated_at`
+
+func scanEscalation(row rowScanner) (Escalation, error) {
+	var e Escalation
+	err := row.Scan(&e.ID, &e.AnomalyID, &e.Policy, &e.NextStep, &e.NextAt, &e.State, &e.CreatedAt, &e.UpdatedAt)
+	return e, err
+}
+
+func (db *DB) CreateEscalation(ctx context.Context, anomalyID int, policy string, nextAt time.Time) (*Escalation, error) {
+	e, err := scanEscalation(db.conn.QueryRowContext(ctx,
+		`INSERT INTO escalations (anomaly_id, policy, next_step, next_at, state)
+		 VALUES ($1, $2, 0, $3, 'active')
+		 RETURNING `+escalationColumns,
+		anomalyID, policy, nextAt,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// GetDueEscalations returns active escalations whose next step is due.
+func (db *DB) GetDueEscalations(ctx context.Context, now time.Time) ([]Escalation, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT `+escalationColumns+`
+		 FROM escalations
+		 WHERE state = 'active' AND next_at <= $1
+		 ORDER BY next_at ASC`,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var escalations []Escalation
+	for rows.Next() {
+		e, err := scanEscalation(rows)
+		if err != nil {
+			return nil, err
+		}
+		escalations = append(escalations, e)
+	}
+
+	return escalations, rows.Err()
+}
+
+// UpdateEscalation moves an escalation to its next step, or finishes it when
+// nextAt is nil.
+func (db *DB) UpdateEscalation(ctx context.Context, id, nextStep int, nextAt *time.Time, state string) error {
+	_, err := db.conn.ExecContext(ctx,
+		`UPDATE escalations
+		 SET next_step = $2, next_at = $3, state = $4, updated_at = NOW()
+		 WHERE id = $1`,
+		id, nextStep, nextAt, state,
+	)
+	return err
+}
+
+// StopEscalations ends every active escalation for an anomaly and records
+// why on its timeline.
+func (db *DB) StopEscalations(ctx context.Context, anomalyID int, state, detail string) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`UPDATE escalations
+		 SET state = $2, next_at = NULL, updated_at = NOW()
+		 WHERE anomaly_id = $1 AND state = 'active'
+		 RETURNING id, next_step`,
+		anomalyID, state,
+	)
+	if err != nil {
+		return err
+	}
+
+	var stopped []EscalationEvent
+	for rows.Next() {
+		ev := EscalationEvent{AnomalyID: anomalyID, Event: state, Detail: detail}
+		if err := rows.Scan(&ev.EscalationID, &ev.Step); err != nil {
+			rows.Close()
+			return err
+		}
+		stopped = append(stopped, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ev := range stopped {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO escalation_events (escalation_id, anomaly_id, step, receiver, event, detail)
+			 VALUES ($1, $2, $3, '', $4, $5)`,
+			ev.EscalationID, ev.AnomalyID, ev.Step, ev.Event, ev.Detail,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) AddEscalationEvent(ctx context.Context, ev *EscalationEvent) error {
+	return db.conn.QueryRowContext(ctx,
+		`INSERT INTO escalation_events (escalation_id, anomaly_id, step, receiver, event, detail)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, created_at`,
+		ev.EscalationID, ev.AnomalyID, ev.Step, ev.Receiver, ev.Event, ev.Detail,
+	).Scan(&ev.ID, &ev.CreatedAt)
+}
+
+// GetEscalationTimeline returns every escalation event for an anomaly in the
+// order it happened.
+func (db *DB) GetEscalationTimeline(ctx context.Context, anomalyID int) ([]EscalationEvent, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT e.id, e.escalation_id, e.anomaly_id, e.step, e.receiver, e.event, e.detail, e.created_at
+		 FROM escalation_events e
+		 WHERE e.anomaly_id = $1
+		 ORDER BY e.created_at ASC, e.id ASC`,
+		anomalyID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []EscalationEvent
+	for rows.Next() {
+		var ev EscalationEvent
+		if err := rows.Scan(&ev.ID, &ev.EscalationID, &ev.AnomalyID, &ev.Step,
+			&ev.Receiver, &ev.Event, &ev.Detail, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Event is a message broadcast to WebSocket clients, kept so that clients
+// that reconnect can catch up. Seq is assigned by the database and
+// increases with every event.
+type Event struct {
+	Seq       int64
+	Type      string
+	Metric    string
+	Severity  string
+	Team      string
+	Data      []byte
+	CreatedAt time.Time
+}
+
+const eventColumns = `seq, type, metric, severity, team, data, created_at`
+
+// AppendEvent stores e and sets its Seq and CreatedAt.
+func (db *DB) AppendEvent(ctx context.Context, e *Event) error {
+	return db.conn.QueryRowContext(ctx,
+		`INSERT INTO hub_events (type, metric, severity, team, data)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING seq, created_at`,
+		e.Type, e.Metric, e.Severity, e.Team, e.Data,
+	).Scan(&e.Seq, &e.CreatedAt)
+}
+
+// EventsSince returns up to limit events with a sequence number above seq,
+// oldest first.
+func (db *DB) EventsSince(ctx context.Context, seq int64, limit int) ([]Event, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT `+eventColumns+` FROM hub_events
+		 WHERE seq > $1
+		 ORDER BY seq
+		 LIMIT $2`, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.Seq, &e.Type, &e.Metric, &e.Severity, &e.Team, &e.Data, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// PruneEvents deletes events created before cutoff and returns how many
+// were removed.
+func (db *DB) PruneEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := db.conn.ExecContext(ctx, `DELETE FROM hub_events WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
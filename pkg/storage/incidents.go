@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Incident groups the anomalies of one metric that occur close together,
+// so responders see one ongoing problem instead of a stream of points.
+type Incident struct {
+	ID                     int
+	MetricID               int
+	Status                 string
+	Severity               string
+	AnomalyCount           int
+	OpenedAt               time.Time
+	UpdatedAt              time.Time
+	ResolvedAt             *time.Time
+	AcknowledgedAt         *time.Time
+	AcknowledgedBy         string
+	AcknowledgedOnBehalfOf string
+}
+
+const incidentColumns = `id, metric_id, status, severity, anomaly_count, opened_at, updated_at,
+		        resolved_at, acknowledged_at, COALESCE(acknowledged_by, ''),
+		        COALESCE(acknowledged_on_behalf_of, '')`
+
+func scanIncident(row rowScanner) (Incident, error) {
+	var inc Incident
+	err := row.Scan(&inc.ID, &inc.MetricID, &inc.Status, &inc.Severity, &inc.AnomalyCount,
+		&inc.OpenedAt, &inc.UpdatedAt, &inc.ResolvedAt, &inc.AcknowledgedAt, &inc.AcknowledgedBy,
+		&inc.AcknowledgedOnBehalfOf)
+	return inc, err
+}
+
+var severityRank = map[string]int{
+	"low":      1,
+	"medium":   2,
+	"high":     3,
+	"critical": 4,
+}
+
+// SeverityRank orders severities from low (1) to critical (4).
+func SeverityRank(severity string) int {
+	return severityRank[severity]
+}
+
+// AttachToIncident links a freshly stored anomaly to the open incident of its
+// metric, opening a new incident when there is none.
+func (db *DB) AttachToIncident(ctx context.Context, anomaly *Anomaly) (*Incident, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inc, err := scanIncident(tx.QueryRowContext(ctx,
+		`SELECT `+incidentColumns+`
+		 FROM incidents
+		 WHERE metric_id = $1 AND status <> 'resolved'
+		 ORDER BY opened_at DESC
+		 LIMIT 1
+		 FOR UPDATE`,
+		anomaly.MetricID,
+	))
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		inc, err = scanIncident(tx.QueryRowContext(ctx,
+			`INSERT INTO incidents (metric_id, status, severity, anomaly_count)
+			 VALUES ($1, 'open', $2, 1)
+			 RETURNING `+incidentColumns,
+			anomaly.MetricID, anomaly.Severity,
+		))
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		severity := inc.Severity
+		if severityRank[anomaly.Severity] > severityRank[severity] {
+			severity = anomaly.Severity
+		}
+		err = tx.QueryRowContext(ctx,
+			`UPDATE incidents
+			 SET anomaly_count = anomaly_count + 1, severity = $2, updated_at = NOW()
+			 WHERE id = $1
+			 RETURNING anomaly_count, severity, updated_at`,
+			inc.ID, severity,
+		).Scan(&inc.AnomalyCount, &inc.Severity, &inc.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE anomalies SET incident_id = $1 WHERE id = $2`,
+		inc.ID, anomaly.ID,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	anomaly.IncidentID = &inc.ID
+	return &inc, nil
+}
+
+func (db *DB) GetIncident(ctx context.Context, id int) (*Incident, error) {
+	inc, err := scanIncident(db.conn.QueryRowContext(ctx,
+		`SELECT `+incidentColumns+`
+		 FROM incidents
+		 WHERE id = $1`,
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &inc, nil
+}
+
+// AcknowledgeIncident acknowledges an incident together with its open
+// anomalies and returns the IDs of the anomalies that changed. onBehalfOf,
+// if set, is recorded alongside by.
+func (db *DB) AcknowledgeIncident(ctx context.Context, id int, by, onBehalfOf string) (*Incident, []int, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	inc, err := scanIncident(tx.QueryRowContext(ctx,
+		`UPDATE incidents
+		 SET status = 'acknowledged', acknowledged_at = NOW(), acknowledged_by = $2,
+		     acknowledged_on_behalf_of = NULLIF($3, '')
+		 WHERE id = $1 AND status = 'open'
+		 RETURNING `+incidentColumns,
+		id, by, onBehalfOf,
+	))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`UPDATE anomalies
+		 SET status = 'acknowledged', acknowledged_at = NOW(), acknowledged_by = $2,
+		     acknowledged_on_behalf_of = NULLIF($3, '')
+		 WHERE incident_id = $1 AND status = 'open'
+		 RETURNING id`,
+		id, by, onBehalfOf,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var anomalyIDs []int
+	for rows.Next() {
+		var anomalyID int
+		if err := rows.Scan(&anomalyID); err != nil {
+			return nil, nil, err
+		}
+		anomalyIDs = append(anomalyIDs, anomalyID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return &inc, anomalyIDs, tx.Commit()
+}
+
+// ResolveIdleIncidents closes incidents that have not seen a new anomaly
+// within the idle window, and returns them.
+func (db *DB) ResolveIdleIncidents(ctx context.Context, idle time.Duration) ([]Incident, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`UPDATE incidents
+		 SET status = 'resolved', resolved_at = NOW()
+		 WHERE status <> 'resolved' AND updated_at < $1
+		 RETURNING `+incidentColumns,
+		time.Now().Add(-idle),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resolved []Incident
+	for rows.Next() {
+		inc, err := scanIncident(rows)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, inc)
+	}
+	return resolved, rows.Err()
+}
+
+// IncidentFilter selects incidents active at some point in [From, To].
+type IncidentFilter struct {
+	MetricName string
+	Labels     map[string]string
+	Severities []string
+	Statuses   []string
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+// IncidentResult is an incident with the name of its metric.
+type IncidentResult struct {
+	Incident
+	MetricName string
+}
+
+// ListIncidents returns incidents that were open at any time in the filter's
+// range, oldest first.
+func (db *DB) ListIncidents(ctx context.Context, f IncidentFilter) ([]IncidentResult, error) {
+	labels, err := json.Marshal(f.Labels)
+	if err != nil {
+		return nil, err
+	}
+	if f.Labels == nil {
+		labels = []byte("{}")
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT `+incidentColumns+`, metric_name
+		 FROM (
+		     SELECT i.*, m.metric_name, COALESCE(m.labels, '{}'::jsonb) AS metric_labels
+		     FROM incidents i
+		     JOIN metrics m ON m.id = i.metric_id
+		 ) incidents
+		 WHERE opened_at <= $1
+		   AND COALESCE(resolved_at, NOW()) >= $2
+		   AND ($3 = '' OR metric_name = $3)
+		   AND metric_labels @> $4::jsonb
+		   AND (cardinality($5::text[]) = 0 OR severity = ANY($5))
+		   AND (cardinality($6::text[]) = 0 OR status = ANY($6))
+		 ORDER BY opened_at, id
+		 LIMIT $7`,
+		f.To, f.From, f.MetricName, string(labels), pq.Array(f.Severities), pq.Array(f.Statuses), f.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var incidents []IncidentResult
+	for rows.Next() {
+		var r IncidentResult
+		err := rows.Scan(&r.ID, &r.MetricID, &r.Status, &r.Severity, &r.AnomalyCount,
+			&r.OpenedAt, &r.UpdatedAt, &r.ResolvedAt, &r.AcknowledgedAt, &r.AcknowledgedBy,
+			&r.AcknowledgedOnBehalfOf, &r.MetricName)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, r)
+	}
+	return incidents, rows.Err()
+}
@@ -5,7 +5,21 @@ import (
 	"time"
 )
 
+// GetMetricData returns the points stored since the given time. When raw
+// data no longer reaches back that far, it returns rollup averages instead.
 func (db *DB) GetMetricData(ctx context.Context, metricID int, since time.Time) ([]MetricDataPoint, error) {
+	if r, ok := db.seriesRollup(since, 0); ok {
+		series, err := db.GetMetricSeries(ctx, metricID, since, time.Now(), r.step)
+		if err != nil {
+			return nil, err
+		}
+		points := make([]MetricDataPoint, len(series))
+		for i, p := range series {
+			points[i] = MetricDataPoint{MetricID: metricID, Timestamp: p.Timestamp, Value: p.Avg}
+		}
+		return points, nil
+	}
+
 	rows, err := db.conn.QueryContext(ctx,
 		`SELECT metric_id, timestamp, value 
 		 FROM metric_data 
@@ -29,3 +43,105 @@ func (db *DB) GetMetricData(ctx context.Context, metricID int, since time.Time)
 
 	return points, rows.Err()
 }
+
+// SeriesPoint is one bucket of a downsampled series.
+type SeriesPoint struct {
+	Timestamp time.Time
+	Avg       float64
+	Min       float64
+	Max       float64
+	Count     int
+}
+
+// rollupColumns are the columns of metric_rollup_5m and metric_rollup_1h.
+const rollupColumns = `metric_id, bucket, avg_value, min_value, max_value, sample_count`
+
+// GetMetricSeries returns the stored points in [from, to) aggregated into
+// buckets of step. Wide steps and windows older than the raw retention are
+// read from rollups, with the part newer than the rollups filled in from
+// raw data.
+func (db *DB) GetMetricSeries(ctx context.Context, metricID int, from, to time.Time, step time.Duration) ([]SeriesPoint, error) {
+	r, ok := db.seriesRollup(from, step)
+	if !ok {
+		return db.rawSeries(ctx, metricID, from, to, step)
+	}
+
+	cutoff := time.Now().Add(-rollupLag).Truncate(r.step)
+	if cutoff.After(to) {
+		cutoff = to
+	}
+	if !cutoff.After(from) {
+		return db.rawSeries(ctx, metricID, from, to, step)
+	}
+
+	points, err := db.rollupSeries(ctx, r, metricID, from, cutoff, step)
+	if err != nil || !to.After(cutoff) {
+		return points, err
+	}
+	recent, err := db.rawSeries(ctx, metricID, cutoff, to, step)
+	if err != nil {
+		return nil, err
+	}
+
+	// The bucket containing cutoff may have come back from both queries.
+	if n := len(points); n > 0 && len(recent) > 0 && points[n-1].Timestamp.Equal(recent[0].Timestamp) {
+		points[n-1] = mergeSeriesPoints(points[n-1], recent[0])
+		recent = recent[1:]
+	}
+	return append(points, recent...), nil
+}
+
+func (db *DB) rollupSeries(ctx context.Context, r rollup, metricID int, from, to time.Time, step time.Duration) ([]SeriesPoint, error) {
+	return db.querySeries(ctx,
+		`SELECT to_timestamp(floor(extract(epoch FROM bucket) / $4) * $4) AS b,
+		        SUM(avg_value * sample_count) / SUM(sample_count), MIN(min_value), MAX(max_value),
+		        SUM(sample_count)::bigint
+		 FROM `+r.table+`
+		 WHERE metric_id = $1 AND bucket >= $2 AND bucket < $3
+		 GROUP BY b
+		 ORDER BY b ASC`,
+		metricID, from, to, step.Seconds(),
+	)
+}
+
+func (db *DB) rawSeries(ctx context.Context, metricID int, from, to time.Time, step time.Duration) ([]SeriesPoint, error) {
+	return db.querySeries(ctx,
+		`SELECT to_timestamp(floor(extract(epoch FROM timestamp) / $4) * $4) AS bucket,
+		        AVG(value), MIN(value), MAX(value), COUNT(*)
+		 FROM metric_data
+		 WHERE metric_id = $1 AND timestamp >= $2 AND timestamp < $3
+		 GROUP BY bucket
+		 ORDER BY bucket ASC`,
+		metricID, from, to, step.Seconds(),
+	)
+}
+
+func (db *DB) querySeries(ctx context.Context, query string, args ...interface{}) ([]SeriesPoint, error) {
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []SeriesPoint
+	for rows.Next() {
+		var p SeriesPoint
+		if err := rows.Scan(&p.Timestamp, &p.Avg, &p.Min, &p.Max, &p.Count); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	return points, rows.Err()
+}
+
+func mergeSeriesPoints(a, b SeriesPoint) SeriesPoint {
+	count := a.Count + b.Count
+	return SeriesPoint{
+		Timestamp: a.Timestamp,
+		Avg:       (a.Avg*float64(a.Count) + b.Avg*float64(b.Count)) / float64(count),
+		Min:       min(a.Min, b.Min),
+		Max:       max(a.Max, b.Max),
+		Count:     count,
+	}
+}
@@ -2,12 +2,16 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Metric struct {
 	ID              int
 	MetricName      string
+	Labels          map[string]string
 	IsActive        bool
 	LastCollectedAt *time.Time
 }
@@ -18,40 +22,73 @@ type MetricDataPoint struct {
 	Value     float64
 }
 
-func (db *DB) CreateMetric(ctx context.Context, metricName string) (*Metric, error) {
-	var metric Metric
-	err := db.conn.QueryRowContext(ctx,
-		`INSERT INTO metrics (metric_name, is_active) 
-		 VALUES ($1, true) 
-		 ON CONFLICT (metric_name) DO UPDATE SET is_active = true
-		 RETURNING id, metric_name, is_active`,
-		metricName,
-	).Scan(&metric.ID, &metric.MetricName, &metric.IsActive)
-
-	return &metric, err
+// CreateMetric returns the metric named metricName, creating it if needed,
+// and sets its labels. created reports whether it did.
+func (db *DB) CreateMetric(ctx context.Context, metricName string, labels map[string]string) (metric *Metric, created bool, err error) {
+	encoded, err := json.Marshal(labels)
+	if err != nil {
+		return nil, false, err
+	}
+	if labels == nil {
+		encoded = []byte("{}")
+	}
+
+	metric = &Metric{}
+	var stored []byte
+	err = db.conn.QueryRowContext(ctx,
+		`INSERT INTO metrics (metric_name, labels, is_active)
+		 VALUES ($1, $2::jsonb, true)
+		 ON CONFLICT (metric_name) DO UPDATE SET labels = EXCLUDED.labels, is_active = true
+		 RETURNING id, metric_name, labels, is_active, xmax = 0`,
+		metricName, string(encoded),
+	).Scan(&metric.ID, &metric.MetricName, &stored, &metric.IsActive, &created)
+	if err != nil {
+		return nil, false, err
+	}
+
+	metric.Labels, err = decodeLabels(stored)
+	return metric, created, err
 }
 
+// insertBatchSize is the most points sent in one INSERT statement.
+const insertBatchSize = 5000
+
+// InsertMetricData stores points with one multi-row INSERT per
+// insertBatchSize points, ignoring any whose metric and timestamp are
+// already stored.
 func (db *DB) InsertMetricData(ctx context.Context, points []MetricDataPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
 	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.PrepareContext(ctx,
-		`INSERT INTO metric_data (metric_id, timestamp, value) 
-		 VALUES ($1, $2, $3) 
-		 ON CONFLICT (metric_id, timestamp) DO NOTHING`)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+	metricIDs := make([]int64, 0, min(len(points), insertBatchSize))
+	timestamps := make([]time.Time, 0, cap(metricIDs))
+	values := make([]float64, 0, cap(metricIDs))
+	for len(points) > 0 {
+		n := min(len(points), insertBatchSize)
+		metricIDs, timestamps, values = metricIDs[:0], timestamps[:0], values[:0]
+		for _, p := range points[:n] {
+			metricIDs = append(metricIDs, int64(p.MetricID))
+			timestamps = append(timestamps, p.Timestamp)
+			values = append(values, p.Value)
+		}
 
-	for _, point := range points {
-		_, err := stmt.ExecContext(ctx, point.MetricID, point.Timestamp, point.Value)
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO metric_data (metric_id, timestamp, value)
+			 SELECT * FROM unnest($1::int[], $2::timestamptz[], $3::float8[])
+			 ON CONFLICT (metric_id, timestamp) DO NOTHING`,
+			pq.Array(metricIDs), pq.Array(timestamps), pq.Array(values),
+		)
 		if err != nil {
 			return err
 		}
+		points = points[n:]
 	}
 
 	return tx.Commit()
@@ -59,7 +96,7 @@ func (db *DB) InsertMetricData(ctx context.Context, points []MetricDataPoint) er
 
 func (db *DB) GetMetrics(ctx context.Context) ([]Metric, error) {
 	rows, err := db.conn.QueryContext(ctx,
-		`SELECT id, metric_name, is_active, last_collected_at 
+		`SELECT id, metric_name, labels, is_active, last_collected_at 
 		 FROM metrics 
 		 WHERE is_active = true`)
 	if err != nil {
@@ -70,7 +107,11 @@ func (db *DB) GetMetrics(ctx context.Context) ([]Metric, error) {
 	var metrics []Metric
 	for rows.Next() {
 		var m Metric
-		if err := rows.Scan(&m.ID, &m.MetricName, &m.IsActive, &m.LastCollectedAt); err != nil {
+		var labels []byte
+		if err := rows.Scan(&m.ID, &m.MetricName, &labels, &m.IsActive, &m.LastCollectedAt); err != nil {
+			return nil, err
+		}
+		if m.Labels, err = decodeLabels(labels); err != nil {
 			return nil, err
 		}
 		metrics = append(metrics, m)
@@ -78,3 +119,49 @@ func (db *DB) GetMetrics(ctx context.Context) ([]Metric, error) {
 
 	return metrics, rows.Err()
 }
+
+func (db *DB) GetMetric(ctx context.Context, id int) (*Metric, error) {
+	var m Metric
+	var labels []byte
+	err := db.conn.QueryRowContext(ctx,
+		`SELECT id, metric_name, labels, is_active, last_collected_at 
+		 FROM metrics 
+		 WHERE id = $1`,
+		id,
+	).Scan(&m.ID, &m.MetricName, &labels, &m.IsActive, &m.LastCollectedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Labels, err = decodeLabels(labels)
+	return &m, err
+}
+
+func decodeLabels(raw []byte) (map[string]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var labels map[string]string
+	if err := json.Unmarshal(raw, &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+func (db *DB) GetMetricByName(ctx context.Context, name string) (*Metric, error) {
+	var m Metric
+	var labels []byte
+	err := db.conn.QueryRowContext(ctx,
+		`SELECT id, metric_name, labels, is_active, last_collected_at
+		 FROM metrics
+		 WHERE metric_name = $1`,
+		name,
+	).Scan(&m.ID, &m.MetricName, &labels, &m.IsActive, &m.LastCollectedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Labels, err = decodeLabels(labels)
+	return &m, err
+}
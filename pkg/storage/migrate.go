@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjrtuhin/argus/migrations"
+)
+
+// migrationLockID is the advisory lock key held while migrating, so two
+// instances starting together don't apply the same migration twice.
+const migrationLockID = 0x61726775 // "argu"
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is one embedded migration and when it was applied, if ever.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// LoadMigrations reads NNN_name.up.sql and NNN_name.down.sql pairs from fsys,
+// sorted by version.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base, direction, ok := cutDirection(file)
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNN_name.up.sql or NNN_name.down.sql", file)
+		}
+		num, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", file, num)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d used by both %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %03d_%s has no up file", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+func cutDirection(file string) (string, string, bool) {
+	for _, direction := range []string{"up", "down"} {
+		if base, ok := strings.CutSuffix(file, "."+direction+".sql"); ok {
+			return base, direction, true
+		}
+	}
+	return "", "", false
+}
+
+// Migrate applies every embedded migration that hasn't been applied yet and
+// returns the ones it ran. Each migration runs in its own transaction.
+func (db *DB) Migrate(ctx context.Context) ([]Migration, error) {
+	all, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range all {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, m.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+				return err
+			}
+			ran = append(ran, m)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and
+// returns the ones it reverted.
+func (db *DB) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	all, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(all) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := all[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %03d_%s has no down file", m.Version, m.Name)
+			}
+			if err := runMigration(ctx, conn, m, m.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+				return err
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus lists every embedded migration with its applied time.
+func (db *DB) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	all, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, len(all))
+	for i, m := range all {
+		states[i] = MigrationState{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			states[i].AppliedAt = &at
+		}
+	}
+	return states, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock. Session-level advisory locks belong to one connection, so
+// everything has to go through conn rather than the pool.
+func (db *DB) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func ensureMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+		     version INT PRIMARY KEY,
+		     name VARCHAR(255) NOT NULL,
+		     applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		 )`)
+	return err
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes script and the schema_migrations bookkeeping in one
+// transaction, so a failed migration leaves no trace.
+func runMigration(ctx context.Context, conn *sql.Conn, m Migration, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
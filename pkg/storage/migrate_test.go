@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mjrtuhin/argus/migrations"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	all, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range all {
+		if m.Version != i+1 {
+			t.Errorf("migration %03d_%s: want version %d, versions must not skip", m.Version, m.Name, i+1)
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %03d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []int
+		wantErr string
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"010_ten.up.sql":   file("SELECT 10"),
+				"002_two.up.sql":   file("SELECT 2"),
+				"002_two.down.sql": file("SELECT -2"),
+				"README.md":        file("ignored"),
+			},
+			want: []int{2, 10},
+		},
+		{
+			name:    "bad suffix",
+			fsys:    fstest.MapFS{"001_one.sql": file("SELECT 1")},
+			wantErr: "expected NNN_name.up.sql",
+		},
+		{
+			name:    "bad version",
+			fsys:    fstest.MapFS{"one_first.up.sql": file("SELECT 1")},
+			wantErr: "invalid version",
+		},
+		{
+			name: "version reused",
+			fsys: fstest.MapFS{
+				"001_one.up.sql":   file("SELECT 1"),
+				"001_uno.down.sql": file("SELECT -1"),
+			},
+			wantErr: "used by both",
+		},
+		{
+			name:    "down only",
+			fsys:    fstest.MapFS{"001_one.down.sql": file("SELECT -1")},
+			wantErr: "has no up file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadMigrations(tt.fsys)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadMigrations() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var versions []int
+			for _, m := range got {
+				versions = append(versions, m.Version)
+			}
+			if len(versions) != len(tt.want) {
+				t.Fatalf("LoadMigrations() versions = %v, want %v", versions, tt.want)
+			}
+			for i := range versions {
+				if versions[i] != tt.want[i] {
+					t.Fatalf("LoadMigrations() versions = %v, want %v", versions, tt.want)
+				}
+			}
+		})
+	}
+}
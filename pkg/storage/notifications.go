@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Notification is one message waiting in (or delivered from) the outbox.
+type Notification struct {
+	ID            int
+	Receiver      string
+	AnomalyID     *int
+	Payload       json.RawMessage
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	SentAt        *time.Time
+}
+
+// ReceiverDeliveryStats summarises the outbox for one receiver.
+type ReceiverDeliveryStats struct {
+	Receiver   string
+	Pending    int
+	Sent       int
+	Failed     int
+	Dead       int
+	LastSentAt *time.Time
+	LastError  string
+}
+
+type NotificationFilter struct {
+	Receiver string
+	Status   string
+	Limit    int
+}
+
+const notificationColumns = `id, receiver, anomaly_id, payload, status, attempts, next_attempt_at,
+		        last_error, created_at, updated_at, sent_at`
+
+func scanNotification(row rowScanner) (Notification, error) {
+	var n Notification
+	var payload []byte
+	err := row.Scan(&n.ID, &n.Receiver, &n.AnomalyID, &payload, &n.Status, &n.Attempts,
+		&n.NextAttemptAt, &n.LastError, &n.CreatedAt, &n.UpdatedAt, &n.SentAt)
+	n.Payload = payload
+	return n, err
+}
+
+func scanNotifications(rows interface {
+	rowScanner
+	Next() bool
+	Err() error
+}) ([]Notification, error) {
+	var notifications []Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (db *DB) EnqueueNotification(ctx context.Context, n *Notification) error {
+	return db.conn.QueryRowContext(ctx,
+		`INSERT INTO notifications (receiver, anomaly_id, payload, status, next_attempt_at)
+		 VALUES ($1, $2, $3, 'pending', NOW())
+		 RETURNING id, status, next_attempt_at, created_at, updated_at`,
+		n.Receiver, n.AnomalyID, []byte(n.Payload),
+	).Scan(&n.ID, &n.Status, &n.NextAttemptAt, &n.CreatedAt, &n.UpdatedAt)
+}
+
+// ClaimNotifications marks up to limit due notifications as sending and
+// returns them. The claim expires after lease, so deliveries interrupted by
+// a crash are picked up again. SKIP LOCKED keeps concurrent workers from
+// claiming the same rows.
+func (db *DB) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]Notification, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`UPDATE notifications
+		 SET status = 'sending', next_attempt_at = $2, updated_at = NOW()
+		 WHERE id IN (
+		     SELECT id FROM notifications
+		     WHERE status IN ('pending', 'failed', 'sending') AND next_attempt_at <= NOW()
+		     ORDER BY next_attempt_at ASC
+		     LIMIT $1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+notificationColumns,
+		limit, time.Now().Add(lease),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanNotifications(rows)
+}
+
+func (db *DB) MarkNotificationSent(ctx context.Context, id int) error {
+	_, err := db.conn.ExecContext(ctx,
+		`UPDATE notifications
+		 SET status = 'sent', attempts = attempts + 1, last_error = '', sent_at = NOW(), updated_at = NOW()
+		 WHERE id = $1`,
+		id,
+	)
+	return err
+}
+
+// MarkNotificationFailed records a failed attempt. A nil retryAt moves the
+// notification to the dead letter state.
+func (db *DB) MarkNotificationFailed(ctx context.Context, id int, deliveryErr string, retryAt *time.Time) error {
+	status := "failed"
+	if retryAt == nil {
+		status = "dead"
+	}
+
+	_, err := db.conn.ExecContext(ctx,
+		`UPDATE notifications
+		 SET status = $2, attempts = attempts + 1, last_error = $3,
+		     next_attempt_at = COALESCE($4, next_attempt_at), updated_at = NOW()
+		 WHERE id = $1`,
+		id, status, deliveryErr, retryAt,
+	)
+	return err
+}
+
+// RetryNotification requeues a failed or dead notification for immediate
+// delivery with a fresh attempt budget.
+func (db *DB) RetryNotification(ctx context.Context, id int) (*Notification, error) {
+	n, err := scanNotification(db.conn.QueryRowContext(ctx,
+		`UPDATE notifications
+		 SET status = 'pending', attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		 WHERE id = $1 AND status IN ('failed', 'dead')
+		 RETURNING `+notificationColumns,
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (db *DB) GetNotification(ctx context.Context, id int) (*Notification, error) {
+	n, err := scanNotification(db.conn.QueryRowContext(ctx,
+		`SELECT `+notificationColumns+`
+		 FROM notifications
+		 WHERE id = $1`,
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (db *DB) ListNotifications(ctx context.Context, filter NotificationFilter) ([]Notification, error) {
+	var where []string
+	var args []interface{}
+	if filter.Receiver != "" {
+		args = append(args, filter.Receiver)
+		where = append(where, fmt.Sprintf("receiver = $%d", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, pq.Array(strings.Split(filter.Status, ",")))
+		where = append(where, fmt.Sprintf("status = ANY($%d)", len(args)))
+	}
+
+	query := `SELECT ` + notificationColumns + ` FROM notifications`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(` ORDER BY created_at DESC, id DESC LIMIT $%d`, len(args))
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanNotifications(rows)
+}
+
+func (db *DB) GetDeliveryStats(ctx context.Context) ([]ReceiverDeliveryStats, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT receiver,
+		        COUNT(*) FILTER (WHERE status IN ('pending', 'sending')),
+		        COUNT(*) FILTER (WHERE status = 'sent'),
+		        COUNT(*) FILTER (WHERE status = 'failed'),
+		        COUNT(*) FILTER (WHERE status = 'dead'),
+		        MAX(sent_at),
+		        COALESCE((ARRAY_AGG(last_error ORDER BY updated_at DESC) FILTER (WHERE last_error <> ''))[1], '')
+		 FROM notifications
+		 GROUP BY receiver
+		 ORDER BY receiver`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []ReceiverDeliveryStats
+	for rows.Next() {
+		var s ReceiverDeliveryStats
+		if err := rows.Scan(&s.Receiver, &s.Pending, &s.Sent, &s.Failed, &s.Dead, &s.LastSentAt, &s.LastError); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
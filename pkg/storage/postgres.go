@@ -1,34 +1,166 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type DB struct {
 	conn *sql.DB
+	dsn  string
+
+	// Set by ConfigureRetention; rollups is empty until then and series
+	// are read from raw data only.
+	retention RetentionConfig
+	rollups   string
+}
+
+// DBConfig describes how to reach PostgreSQL and size the connection pool.
+// DSN, when set, is used as is and the connection fields are ignored.
+type DBConfig struct {
+	DSN string
+
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+	// SSLMode is a libpq sslmode: disable, require, verify-ca or
+	// verify-full. Default disable.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	// Zero values use the defaults below.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
+const (
+	defaultMaxOpenConns    = 20
+	defaultMaxIdleConns    = 10
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+	connectTimeout         = 10 * time.Second
+)
+
 func NewDB(host, port, user, password, dbname string) (*DB, error) {
-	connStr := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname,
-	)
+	return OpenDB(DBConfig{Host: host, Port: port, User: user, Password: password, Name: dbname})
+}
 
-	conn, err := sql.Open("postgres", connStr)
+// OpenDB connects to PostgreSQL and checks the connection.
+func OpenDB(cfg DBConfig) (*DB, error) {
+	dsn := cfg.connString()
+	conn, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := conn.Ping(); err != nil {
+	conn.SetMaxOpenConns(orDefault(cfg.MaxOpenConns, defaultMaxOpenConns))
+	conn.SetMaxIdleConns(orDefault(cfg.MaxIdleConns, defaultMaxIdleConns))
+	conn.SetConnMaxLifetime(orDefault(cfg.ConnMaxLifetime, defaultConnMaxLifetime))
+	conn.SetConnMaxIdleTime(orDefault(cfg.ConnMaxIdleTime, defaultConnMaxIdleTime))
+
+	db := &DB{conn: conn, dsn: dsn}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := db.Ping(ctx); err != nil {
+		conn.Close()
 		return nil, err
 	}
+	return db, nil
+}
+
+func (cfg DBConfig) connString() string {
+	if cfg.DSN != "" {
+		return cfg.DSN
+	}
+
+	params := map[string]string{
+		"host":            cfg.Host,
+		"port":            cfg.Port,
+		"user":            cfg.User,
+		"password":        cfg.Password,
+		"dbname":          cfg.Name,
+		"sslmode":         orDefault(cfg.SSLMode, "disable"),
+		"sslrootcert":     cfg.SSLRootCert,
+		"sslcert":         cfg.SSLCert,
+		"sslkey":          cfg.SSLKey,
+		"connect_timeout": fmt.Sprint(int(connectTimeout.Seconds())),
+	}
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
 
-	return &DB{conn: conn}, nil
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s='%s'", k, quote.Replace(params[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func orDefault[T comparable](v, fallback T) T {
+	var zero T
+	if v == zero {
+		return fallback
+	}
+	return v
 }
 
 func (db *DB) Close() error {
 	return db.conn.Close()
 }
+
+// Ping checks that the database accepts queries.
+func (db *DB) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+// Stats returns connection pool statistics.
+func (db *DB) Stats() sql.DBStats {
+	return db.conn.Stats()
+}
+
+// IsUnavailable reports whether err means the database could not be reached
+// or dropped the connection, as opposed to rejecting a query.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+		return pqErr.Code.Class() == "08" // connection_exception
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Replica is an Argus instance sharing this database.
+type Replica struct {
+	ID          string
+	StartedAt   time.Time
+	HeartbeatAt time.Time
+}
+
+const replicaColumns = `id, started_at, heartbeat_at`
+
+// Heartbeat records that the replica is alive, registering it on first use.
+func (db *DB) Heartbeat(ctx context.Context, id string) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO replicas (id) VALUES ($1)
+		 ON CONFLICT (id) DO UPDATE SET heartbeat_at = NOW()`, id)
+	return err
+}
+
+// ListReplicas returns the replicas that sent a heartbeat within ttl,
+// ordered by ID. Ages are measured by the database clock, so replicas with
+// skewed clocks agree on who is alive.
+func (db *DB) ListReplicas(ctx context.Context, ttl time.Duration) ([]Replica, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT `+replicaColumns+` FROM replicas
+		 WHERE heartbeat_at > NOW() - $1 * INTERVAL '1 second'
+		 ORDER BY id`, ttl.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replicas []Replica
+	for rows.Next() {
+		var r Replica
+		if err := rows.Scan(&r.ID, &r.StartedAt, &r.HeartbeatAt); err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+	return replicas, rows.Err()
+}
+
+// RemoveReplica deregisters a replica that is shutting down, along with
+// any that stopped sending heartbeats a day ago.
+func (db *DB) RemoveReplica(ctx context.Context, id string) error {
+	_, err := db.conn.ExecContext(ctx,
+		`DELETE FROM replicas WHERE id = $1 OR heartbeat_at < NOW() - INTERVAL '1 day'`, id)
+	return err
+}
+
+// Lock is a PostgreSQL session advisory lock held on a dedicated
+// connection. It is released when the connection closes, so a replica that
+// dies or loses its connection gives it up.
+type Lock struct {
+	key  int64
+	conn *sql.Conn
+}
+
+// TryLock takes the advisory lock key without waiting. It returns nil if
+// another session holds it.
+func (db *DB) TryLock(ctx context.Context, key int64) (*Lock, error) {
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !locked {
+		conn.Close()
+		return nil, nil
+	}
+	return &Lock{key: key, conn: conn}, nil
+}
+
+// Check verifies that the session holding the lock is still alive.
+func (l *Lock) Check(ctx context.Context) error {
+	return l.conn.PingContext(ctx)
+}
+
+// Release unlocks and returns the connection to the pool.
+func (l *Lock) Release(ctx context.Context) error {
+	_, err := l.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, l.key)
+	if closeErr := l.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Notify sends payload to every session listening on channel. PostgreSQL
+// limits payloads to 8000 bytes.
+func (db *DB) Notify(ctx context.Context, channel, payload string) error {
+	_, err := db.conn.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, payload)
+	return err
+}
+
+// Listen calls handle with every payload sent to channel until ctx ends. It
+// reconnects on its own after connection failures; notifications sent
+// while disconnected are lost.
+func (db *DB) Listen(ctx context.Context, channel string, handle func(payload string)) error {
+	listener := pq.NewListener(db.dsn, time.Second, time.Minute, nil)
+	defer listener.Close()
+
+	if err := listener.Listen(channel); err != nil {
+		return fmt.Errorf("listen on %s: %w", channel, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// nil after a reconnect
+			if n != nil {
+				handle(n.Extra)
+			}
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
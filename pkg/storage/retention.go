@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetentionConfig sets how long each resolution of metric data is kept.
+// Zero keeps data forever.
+type RetentionConfig struct {
+	Raw      time.Duration
+	Rollup5m time.Duration
+	Rollup1h time.Duration
+}
+
+// How rollups are maintained, as returned by ConfigureRetention.
+const (
+	RollupsContinuous = "continuous_aggregates" // TimescaleDB jobs refresh and expire data
+	RollupsCompaction = "compaction"            // CompactRollups and ApplyRetention must be run
+)
+
+// DetectionWindow is how much recent data the detector reads.
+const DetectionWindow = 24 * time.Hour
+
+// MinRawRetention keeps the detector's window in raw data, with a day to
+// spare for rollups to be refreshed before raw data expires.
+const MinRawRetention = DetectionWindow + 24*time.Hour
+
+// rollupLag is how far behind now rollups may be. Newer data is always read
+// from metric_data.
+const rollupLag = 2 * time.Hour
+
+type rollup struct {
+	table     string
+	step      time.Duration
+	retention func(RetentionConfig) time.Duration
+}
+
+var rollups = []rollup{
+	{"metric_rollup_5m", 5 * time.Minute, func(c RetentionConfig) time.Duration { return c.Rollup5m }},
+	{"metric_rollup_1h", time.Hour, func(c RetentionConfig) time.Duration { return c.Rollup1h }},
+}
+
+// ConfigureRetention records cfg for series reads and sets up rollup
+// maintenance. With continuous aggregates it (re)creates the TimescaleDB
+// refresh and retention policies; otherwise the caller must run
+// CompactRollups and ApplyRetention periodically.
+func (db *DB) ConfigureRetention(ctx context.Context, cfg RetentionConfig) (string, error) {
+	if cfg.Raw > 0 && cfg.Raw < MinRawRetention {
+		return "", fmt.Errorf("raw retention must be at least %v", MinRawRetention)
+	}
+
+	var continuous bool
+	err := db.conn.QueryRowContext(ctx,
+		`SELECT EXISTS (
+		     SELECT 1 FROM pg_extension WHERE extname = 'timescaledb'
+		 ) AND EXISTS (
+		     SELECT 1 FROM timescaledb_information.continuous_aggregates
+		     WHERE view_name = 'metric_rollup_5m'
+		 )`,
+	).Scan(&continuous)
+	if err != nil {
+		return "", err
+	}
+
+	mode := RollupsCompaction
+	if continuous {
+		mode = RollupsContinuous
+		if err := db.configurePolicies(ctx, cfg); err != nil {
+			return "", err
+		}
+	}
+
+	db.retention = cfg
+	db.rollups = mode
+	return mode, nil
+}
+
+func (db *DB) configurePolicies(ctx context.Context, cfg RetentionConfig) error {
+	refresh := []struct {
+		view                 string
+		start, end, schedule string
+	}{
+		{"metric_rollup_5m", "3 hours", "5 minutes", "5 minutes"},
+		{"metric_rollup_1h", "6 hours", "1 hour", "30 minutes"},
+	}
+	for _, p := range refresh {
+		if _, err := db.conn.ExecContext(ctx,
+			`SELECT remove_continuous_aggregate_policy($1, if_exists => true)`, p.view); err != nil {
+			return err
+		}
+		if _, err := db.conn.ExecContext(ctx,
+			`SELECT add_continuous_aggregate_policy($1,
+			     start_offset => $2::interval, end_offset => $3::interval, schedule_interval => $4::interval)`,
+			p.view, p.start, p.end, p.schedule); err != nil {
+			return fmt.Errorf("refresh policy for %s: %w", p.view, err)
+		}
+	}
+
+	retention := []struct {
+		relation string
+		keep     time.Duration
+	}{
+		{"metric_data", cfg.Raw},
+		{"metric_rollup_5m", cfg.Rollup5m},
+		{"metric_rollup_1h", cfg.Rollup1h},
+	}
+	for _, p := range retention {
+		if _, err := db.conn.ExecContext(ctx,
+			`SELECT remove_retention_policy($1, if_exists => true)`, p.relation); err != nil {
+			return err
+		}
+		if p.keep > 0 {
+			if _, err := db.conn.ExecContext(ctx,
+				`SELECT add_retention_policy($1, drop_after => make_interval(secs => $2))`,
+				p.relation, p.keep.Seconds()); err != nil {
+				return fmt.Errorf("retention policy for %s: %w", p.relation, err)
+			}
+		}
+	}
+
+	// Policies only refresh recent buckets, so materialize whatever raw data
+	// is already there. Buckets that are up to date are skipped.
+	for _, p := range refresh {
+		if _, err := db.conn.ExecContext(ctx,
+			`CALL refresh_continuous_aggregate($1, NULL, NOW() - $2::interval)`, p.view, p.start); err != nil {
+			return fmt.Errorf("refresh %s: %w", p.view, err)
+		}
+	}
+	return nil
+}
+
+// CompactRollups aggregates raw data since the given time into the rollup
+// tables, replacing buckets that were already written. Only used when
+// rollups are plain tables.
+func (db *DB) CompactRollups(ctx context.Context, since time.Time) error {
+	if db.rollups != RollupsCompaction {
+		return errors.New("rollups are not maintained by compaction")
+	}
+
+	since = since.Truncate(time.Hour)
+	now := time.Now()
+
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO metric_rollup_5m (metric_id, bucket, avg_value, min_value, max_value, sample_count)
+		 SELECT metric_id, to_timestamp(floor(extract(epoch FROM timestamp) / 300) * 300) AS bucket,
+		        AVG(value), MIN(value), MAX(value), COUNT(*)
+		 FROM metric_data
+		 WHERE timestamp >= $1 AND timestamp < $2
+		 GROUP BY metric_id, bucket
+		 ON CONFLICT (metric_id, bucket) DO UPDATE
+		 SET avg_value = EXCLUDED.avg_value, min_value = EXCLUDED.min_value,
+		     max_value = EXCLUDED.max_value, sample_count = EXCLUDED.sample_count`,
+		since, now.Truncate(5*time.Minute),
+	)
+	if err != nil {
+		return fmt.Errorf("compact 5m rollups: %w", err)
+	}
+
+	_, err = db.conn.ExecContext(ctx,
+		`INSERT INTO metric_rollup_1h (metric_id, bucket, avg_value, min_value, max_value, sample_count)
+		 SELECT metric_id, to_timestamp(floor(extract(epoch FROM bucket) / 3600) * 3600) AS hour,
+		        SUM(avg_value * sample_count) / SUM(sample_count), MIN(min_value), MAX(max_value), SUM(sample_count)
+		 FROM metric_rollup_5m
+		 WHERE bucket >= $1 AND bucket < $2
+		 GROUP BY metric_id, hour
+		 ON CONFLICT (metric_id, bucket) DO UPDATE
+		 SET avg_value = EXCLUDED.avg_value, min_value = EXCLUDED.min_value,
+		     max_value = EXCLUDED.max_value, sample_count = EXCLUDED.sample_count`,
+		since, now.Truncate(time.Hour),
+	)
+	if err != nil {
+		return fmt.Errorf("compact 1h rollups: %w", err)
+	}
+	return nil
+}
+
+// ApplyRetention deletes data older than the configured retention that no
+// TimescaleDB policy expires: baselines, which are kept as long as raw data,
+// and, when rollups are plain tables, raw data and rollups.
+func (db *DB) ApplyRetention(ctx context.Context) error {
+	now := time.Now()
+	if keep := db.retention.Raw; keep > 0 {
+		if _, err := db.conn.ExecContext(ctx,
+			`DELETE FROM metric_baselines WHERE timestamp < $1`, now.Add(-keep)); err != nil {
+			return fmt.Errorf("expire baselines: %w", err)
+		}
+	}
+	if db.rollups != RollupsCompaction {
+		return nil
+	}
+
+	if keep := db.retention.Raw; keep > 0 {
+		if _, err := db.conn.ExecContext(ctx,
+			`SELECT drop_chunks('metric_data', older_than => $1::timestamptz)`, now.Add(-keep)); err != nil {
+			return fmt.Errorf("expire raw data: %w", err)
+		}
+	}
+	for _, r := range rollups {
+		if keep := r.retention(db.retention); keep > 0 {
+			if _, err := db.conn.ExecContext(ctx,
+				`DELETE FROM `+r.table+` WHERE bucket < $1`, now.Add(-keep)); err != nil {
+				return fmt.Errorf("expire %s: %w", r.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RawRetention returns how long raw data is kept, zero for forever.
+func (db *DB) RawRetention() time.Duration {
+	return db.retention.Raw
+}
+
+// RollupMode returns how rollups are maintained, as ConfigureRetention
+// returned it.
+func (db *DB) RollupMode() string {
+	return db.rollups
+}
+
+// seriesRollup picks the rollup to read for a window starting at from with
+// buckets of step: the coarsest one no wider than step, or a coarser one if
+// finer data has already expired. ok is false when raw data should be read.
+//
+// A resolution still counts as covering the window when less than one step
+// of it has expired, so that a window exactly as long as the retention,
+// which starts a moment before the oldest data kept, is not read coarser.
+func (db *DB) seriesRollup(from time.Time, step time.Duration) (rollup, bool) {
+	if db.rollups == "" {
+		return rollup{}, false
+	}
+
+	now := time.Now()
+	covers := func(keep, slack time.Duration) bool {
+		return keep <= 0 || !from.Before(now.Add(-keep-slack))
+	}
+
+	idx := -1
+	for i, r := range rollups {
+		if r.step <= step {
+			idx = i
+		}
+	}
+	if idx < 0 && covers(db.retention.Raw, rollups[0].step) {
+		return rollup{}, false
+	}
+	idx = max(idx, 0)
+	for idx < len(rollups)-1 && !covers(rollups[idx].retention(db.retention), rollups[idx].step) {
+		idx++
+	}
+	return rollups[idx], true
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSeriesRollup(t *testing.T) {
+	const day = 24 * time.Hour
+	cfg := RetentionConfig{Raw: 7 * day, Rollup5m: 90 * day, Rollup1h: 730 * day}
+
+	tests := []struct {
+		name string
+		cfg  RetentionConfig
+		// ago is how long before now the window starts.
+		ago       time.Duration
+		step      time.Duration
+		wantTable string // empty for raw data
+	}{
+		{"recent raw", cfg, time.Hour, 0, ""},
+		{"detection window at the minimum retention", RetentionConfig{Raw: MinRawRetention}, DetectionWindow, 0, ""},
+		{"exactly the raw retention", cfg, 7 * day, 0, ""},
+		{"just past the raw retention", cfg, 7*day + time.Millisecond, 0, ""},
+		{"a step past the raw retention", cfg, 7*day + 5*time.Minute + time.Second, 0, "metric_rollup_5m"},
+		{"5m step", cfg, time.Hour, 5 * time.Minute, "metric_rollup_5m"},
+		{"step between rollups", cfg, time.Hour, 30 * time.Minute, "metric_rollup_5m"},
+		{"1h step", cfg, time.Hour, 6 * time.Hour, "metric_rollup_1h"},
+		{"exactly the 5m retention", cfg, 90 * day, 5 * time.Minute, "metric_rollup_5m"},
+		{"a step past the 5m retention", cfg, 90*day + 5*time.Minute + time.Second, 5 * time.Minute, "metric_rollup_1h"},
+		{"past every retention", cfg, 1000 * day, 0, "metric_rollup_1h"},
+		{"raw kept forever", RetentionConfig{}, 1000 * day, 0, ""},
+		{"5m kept forever", RetentionConfig{Raw: 7 * day}, 1000 * day, 0, "metric_rollup_5m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &DB{rollups: RollupsCompaction, retention: tt.cfg}
+			r, ok := db.seriesRollup(time.Now().Add(-tt.ago), tt.step)
+			if ok != (tt.wantTable != "") || r.table != tt.wantTable {
+				t.Fatalf("seriesRollup() = %q, %v, want %q", r.table, ok, tt.wantTable)
+			}
+		})
+	}
+
+	t.Run("no rollups", func(t *testing.T) {
+		db := &DB{retention: cfg}
+		if r, ok := db.seriesRollup(time.Now().Add(-1000*day), time.Hour); ok {
+			t.Fatalf("seriesRollup() without rollups = %q, want raw data", r.table)
+		}
+	})
+}
+
+func TestConfigureRetentionMinimum(t *testing.T) {
+	if MinRawRetention <= DetectionWindow {
+		t.Fatalf("MinRawRetention %v does not exceed DetectionWindow %v", MinRawRetention, DetectionWindow)
+	}
+	db := &DB{}
+	if _, err := db.ConfigureRetention(context.Background(), RetentionConfig{Raw: DetectionWindow}); err == nil {
+		t.Fatal("ConfigureRetention() accepted raw retention as short as the detection window")
+	}
+}
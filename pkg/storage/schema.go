@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// schemaQueries select every column the storage code reads or writes, per
+// table. Entity tables reuse the column lists their queries scan, so a
+// column added to a query without a migration shows up here. Add new tables
+// and columns when queries change.
+var schemaQueries = map[string]string{
+	"metrics":           `id, metric_name, labels, is_active, created_at, last_collected_at`,
+	"metric_data":       `metric_id, timestamp, value`,
+	"metric_rollup_5m":  rollupColumns,
+	"metric_rollup_1h":  rollupColumns,
+	"metric_baselines":  `metric_id, timestamp, expected, lower_bound, upper_bound`,
+	"anomalies":         anomalyColumns,
+	"incidents":         incidentColumns,
+	"escalations":       escalationColumns,
+	"escalation_events": `id, escalation_id, anomaly_id, step, receiver, event, detail, created_at`,
+	"notifications":     notificationColumns,
+	"api_keys":          apiKeyColumns,
+	"replicas":          replicaColumns,
+	"hub_events":        eventColumns,
+}
+
+// CheckSchema verifies that the database has every table and column the
+// storage queries use. Run it after migrating; it reports all mismatches at
+// once rather than failing on the first query that hits one.
+func (db *DB) CheckSchema(ctx context.Context) error {
+	var problems []string
+	for _, table := range sortedKeys(schemaQueries) {
+		rows, err := db.conn.QueryContext(ctx,
+			`SELECT `+schemaQueries[table]+` FROM `+table+` LIMIT 0`)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", table, err))
+			continue
+		}
+		rows.Close()
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("schema does not match storage queries:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
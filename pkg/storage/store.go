@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Repository interfaces implemented by the PostgreSQL backend (*DB) and the
+// embedded backend in pkg/storage/embedded. Lookups of missing rows and
+// invalid state transitions return sql.ErrNoRows in both.
+
+type MetricStore interface {
+	CreateMetric(ctx context.Context, metricName string, labels map[string]string) (*Metric, bool, error)
+	GetMetrics(ctx context.Context) ([]Metric, error)
+	GetMetric(ctx context.Context, id int) (*Metric, error)
+	GetMetricByName(ctx context.Context, name string) (*Metric, error)
+}
+
+// SeriesStore holds collected data points and the detector's baselines.
+type SeriesStore interface {
+	InsertMetricData(ctx context.Context, points []MetricDataPoint) error
+	GetMetricData(ctx context.Context, metricID int, since time.Time) ([]MetricDataPoint, error)
+	GetMetricSeries(ctx context.Context, metricID int, from, to time.Time, step time.Duration) ([]SeriesPoint, error)
+	UpsertBaseline(ctx context.Context, metricID int, points []ExpectedPoint) error
+	GetBaseline(ctx context.Context, metricID int, from, to time.Time, step time.Duration) ([]ExpectedPoint, error)
+}
+
+// AnomalyStore holds anomalies and the incidents that group them.
+type AnomalyStore interface {
+	CreateAnomaly(ctx context.Context, anomaly *Anomaly) error
+	GetAnomaly(ctx context.Context, id int) (*Anomaly, error)
+	AcknowledgeAnomaly(ctx context.Context, id int, by string) (*Anomaly, error)
+	UpdateAnomalyStatus(ctx context.Context, id int, update StatusUpdate) (*Anomaly, error)
+	ReopenExpiredSnoozes(ctx context.Context) ([]Anomaly, error)
+	CountOpenAnomalies(ctx context.Context) ([]OpenAnomalyCount, error)
+	QueryAnomalies(ctx context.Context, q AnomalyQuery) (*AnomalyPage, error)
+
+	AttachToIncident(ctx context.Context, anomaly *Anomaly) (*Incident, error)
+	GetIncident(ctx context.Context, id int) (*Incident, error)
+	AcknowledgeIncident(ctx context.Context, id int, by, onBehalfOf string) (*Incident, []int, error)
+	ResolveIdleIncidents(ctx context.Context, idle time.Duration) ([]Incident, error)
+	ListIncidents(ctx context.Context, f IncidentFilter) ([]IncidentResult, error)
+}
+
+type EscalationStore interface {
+	CreateEscalation(ctx context.Context, anomalyID int, policy string, nextAt time.Time) (*Escalation, error)
+	GetDueEscalations(ctx context.Context, now time.Time) ([]Escalation, error)
+	UpdateEscalation(ctx context.Context, id, nextStep int, nextAt *time.Time, state string) error
+	StopEscalations(ctx context.Context, anomalyID int, state, detail string) error
+	AddEscalationEvent(ctx context.Context, ev *EscalationEvent) error
+	GetEscalationTimeline(ctx context.Context, anomalyID int) ([]EscalationEvent, error)
+}
+
+// NotificationStore is the alert delivery outbox.
+type NotificationStore interface {
+	EnqueueNotification(ctx context.Context, n *Notification) error
+	ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]Notification, error)
+	MarkNotificationSent(ctx context.Context, id int) error
+	MarkNotificationFailed(ctx context.Context, id int, deliveryErr string, retryAt *time.Time) error
+	RetryNotification(ctx context.Context, id int) (*Notification, error)
+	GetNotification(ctx context.Context, id int) (*Notification, error)
+	ListNotifications(ctx context.Context, filter NotificationFilter) ([]Notification, error)
+	GetDeliveryStats(ctx context.Context) ([]ReceiverDeliveryStats, error)
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, k *APIKey) error
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+	TouchAPIKey(ctx context.Context, id int) error
+}
+
+// EventLog keeps broadcast events for replay. Only the PostgreSQL backend
+// implements it; without one the API keeps recent events in memory.
+type EventLog interface {
+	AppendEvent(ctx context.Context, e *Event) error
+	EventsSince(ctx context.Context, seq int64, limit int) ([]Event, error)
+	PruneEvents(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// Store is everything Argus needs from a storage backend.
+type Store interface {
+	MetricStore
+	SeriesStore
+	AnomalyStore
+	EscalationStore
+	NotificationStore
+	APIKeyStore
+	// Ping checks that the backend can serve requests.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+var (
+	_ Store    = (*DB)(nil)
+	_ EventLog = (*DB)(nil)
+)
@@ -0,0 +1,284 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ExportConfig bounds the per-series gauges served on /metrics/anomalies.
+// Every exported series carries a metric label plus the source metric's own
+// labels, so these controls keep Argus from multiplying the cardinality of
+// whatever it watches.
+type ExportConfig struct {
+	// MaxSeries caps the number of source series exported per gauge.
+	// Series beyond the cap are dropped, highest anomaly score kept first.
+	MaxSeries int
+	// IncludeLabels, when set, is the only source labels copied.
+	IncludeLabels []string
+	// ExcludeLabels are never copied.
+	ExcludeLabels []string
+	// TTL drops series the detector hasn't updated for this long.
+	TTL time.Duration
+}
+
+const (
+	defaultExportMaxSeries = 10000
+	defaultExportTTL       = 30 * time.Minute
+)
+
+// reservedLabels are set by Argus and can't be overridden by source labels.
+var reservedLabels = map[string]bool{"metric": true, "severity": true, "status": true}
+
+// SeriesUpdate is the detector's latest view of one source series.
+type SeriesUpdate struct {
+	MetricID   int
+	MetricName string
+	Labels     map[string]string
+	// Score is the highest score of the anomalies found this cycle, or 0.
+	Score    float64
+	Expected *ExpectedValue
+}
+
+type ExpectedValue struct {
+	Value float64
+	Lower float64
+	Upper float64
+}
+
+// OpenAnomalyCounter reports unresolved anomalies at scrape time, so the
+// argus_open_anomalies gauge reflects acknowledgements immediately.
+type OpenAnomalyCounter interface {
+	CountOpenAnomalies(ctx context.Context) ([]storage.OpenAnomalyCount, error)
+}
+
+type exportedSeries struct {
+	SeriesUpdate
+	updatedAt time.Time
+}
+
+// SeriesExporter is a Prometheus collector for anomaly scores and baselines.
+type SeriesExporter struct {
+	mu       sync.Mutex
+	cfg      ExportConfig
+	include  map[string]bool
+	exclude  map[string]bool
+	open     OpenAnomalyCounter
+	series   map[int]*exportedSeries
+	registry *prometheus.Registry
+}
+
+const (
+	anomalyScoreHelp  = "Highest anomaly score Argus found for the series in its last detection cycle, 0 if none."
+	expectedValueHelp = "Value the detector expected for the series."
+	expectedLowerHelp = "Lower bound of the detector's expected band."
+	expectedUpperHelp = "Upper bound of the detector's expected band."
+	openAnomaliesHelp = "Unresolved (open, acknowledged or snoozed) anomalies for the series."
+)
+
+var (
+	ExportedSeries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argus_export_series",
+		Help: "Source series exported on /metrics/anomalies after cardinality limits.",
+	})
+	ExportedSeriesDropped = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argus_export_series_dropped",
+		Help: "Source series left off /metrics/anomalies by the max series limit at the last scrape.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(ExportedSeries, ExportedSeriesDropped)
+}
+
+// Exports is the exporter fed by the detector. Configure it at startup.
+var Exports = NewSeriesExporter(ExportConfig{}, nil)
+
+func NewSeriesExporter(cfg ExportConfig, open OpenAnomalyCounter) *SeriesExporter {
+	e := &SeriesExporter{
+		series:   make(map[int]*exportedSeries),
+		registry: prometheus.NewRegistry(),
+	}
+	e.Configure(cfg, open)
+	e.registry.MustRegister(e)
+	return e
+}
+
+func (e *SeriesExporter) Configure(cfg ExportConfig, open OpenAnomalyCounter) {
+	if cfg.MaxSeries <= 0 {
+		cfg.MaxSeries = defaultExportMaxSeries
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultExportTTL
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cfg = cfg
+	e.open = open
+	e.include = toSet(cfg.IncludeLabels)
+	e.exclude = toSet(cfg.ExcludeLabels)
+}
+
+// Update records the latest score and baseline for a series.
+func (e *SeriesExporter) Update(u SeriesUpdate) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.series[u.MetricID] = &exportedSeries{SeriesUpdate: u, updatedAt: time.Now()}
+}
+
+// Handler serves the exported gauges in the Prometheus exposition format.
+func (e *SeriesExporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+}
+
+// Describe sends nothing: label names depend on the source series, so this
+// is an unchecked collector.
+func (e *SeriesExporter) Describe(ch chan<- *prometheus.Desc) {}
+
+func (e *SeriesExporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	now := time.Now()
+	var live []*exportedSeries
+	for id, s := range e.series {
+		if now.Sub(s.updatedAt) > e.cfg.TTL {
+			delete(e.series, id)
+			continue
+		}
+		live = append(live, s)
+	}
+	cfg, open := e.cfg, e.open
+	filter := labelFilter{include: e.include, exclude: e.exclude}
+	e.mu.Unlock()
+
+	// Keep the most interesting series when over the limit.
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].Score != live[j].Score {
+			return live[i].Score > live[j].Score
+		}
+		return live[i].MetricID < live[j].MetricID
+	})
+	dropped := 0
+	if len(live) > cfg.MaxSeries {
+		dropped = len(live) - cfg.MaxSeries
+		live = live[:cfg.MaxSeries]
+	}
+
+	for _, s := range live {
+		names, values := filter.labels(s.MetricName, s.Labels)
+		gauge(ch, "argus_anomaly_score", anomalyScoreHelp, names, values, s.Score)
+		if s.Expected != nil {
+			gauge(ch, "argus_expected_value", expectedValueHelp, names, values, s.Expected.Value)
+			gauge(ch, "argus_expected_lower", expectedLowerHelp, names, values, s.Expected.Lower)
+			gauge(ch, "argus_expected_upper", expectedUpperHelp, names, values, s.Expected.Upper)
+		}
+	}
+
+	if open != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		counts, err := open.CountOpenAnomalies(ctx)
+		if err != nil {
+			log.Printf("⚠️  Failed to count open anomalies for export: %v", err)
+		}
+		series := make(map[int]bool)
+		for _, c := range counts {
+			if !series[c.MetricID] && len(series) >= cfg.MaxSeries {
+				continue
+			}
+			series[c.MetricID] = true
+			names, values := filter.labels(c.MetricName, c.Labels)
+			names = append(names, "severity", "status")
+			values = append(values, c.Severity, c.Status)
+			gauge(ch, "argus_open_anomalies", openAnomaliesHelp, names, values, float64(c.Count))
+		}
+	}
+
+	ExportedSeries.Set(float64(len(live)))
+	ExportedSeriesDropped.Set(float64(dropped))
+}
+
+type labelFilter struct {
+	include map[string]bool
+	exclude map[string]bool
+}
+
+// labels returns the label names and values for a series: metric plus the
+// source labels that pass the include and exclude lists.
+//
+// Labels whose names are already valid keep them. The rest are sanitized in
+// name order, and one that collides with a name already taken is numbered:
+// te-am and te.am become te_am and te_am_1, so neither value is lost.
+func (f labelFilter) labels(metricName string, source map[string]string) ([]string, []string) {
+	names := []string{"metric"}
+	values := []string{metricName}
+
+	keys := make([]string, 0, len(source))
+	for k := range source {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return sanitizeLabelName(keys[i]) == keys[i] && sanitizeLabelName(keys[j]) != keys[j]
+	})
+
+	seen := map[string]bool{"metric": true}
+	for _, k := range keys {
+		if f.exclude[k] || len(f.include) > 0 && !f.include[k] {
+			continue
+		}
+		name := sanitizeLabelName(k)
+		if name == "" || reservedLabels[name] || strings.HasPrefix(name, "__") {
+			continue
+		}
+		for i := 1; seen[name]; i++ {
+			name = fmt.Sprintf("%s_%d", sanitizeLabelName(k), i)
+		}
+		seen[name] = true
+		names = append(names, name)
+		values = append(values, source[k])
+	}
+	return names, values
+}
+
+func gauge(ch chan<- prometheus.Metric, name, help string, names, values []string, v float64) {
+	desc := prometheus.NewDesc(name, help, names, nil)
+	m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, v, values...)
+	if err != nil {
+		return
+	}
+	ch <- m
+}
+
+// sanitizeLabelName maps a source label to a valid Prometheus label name.
+func sanitizeLabelName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			b.WriteRune(r)
+		case r >= '0' && r <= '9' && i > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
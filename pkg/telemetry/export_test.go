@@ -0,0 +1,163 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestLabelFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		filter     labelFilter
+		source     map[string]string
+		wantNames  []string
+		wantValues []string
+	}{
+		{
+			"copies source labels in name order",
+			labelFilter{},
+			map[string]string{"team": "payments", "job": "api"},
+			[]string{"metric", "job", "team"},
+			[]string{"cpu", "api", "payments"},
+		},
+		{
+			"include and exclude",
+			labelFilter{include: toSet([]string{"job", "team"}), exclude: toSet([]string{"team"})},
+			map[string]string{"team": "payments", "job": "api", "pod": "api-7f9c"},
+			[]string{"metric", "job"},
+			[]string{"cpu", "api"},
+		},
+		{
+			"reserved and internal labels are dropped",
+			labelFilter{},
+			map[string]string{"metric": "x", "severity": "high", "status": "open", "__name__": "cpu", "job": "api"},
+			[]string{"metric", "job"},
+			[]string{"cpu", "api"},
+		},
+		{
+			"invalid names are sanitized",
+			labelFilter{},
+			map[string]string{"k8s.pod": "api-7f9c", "2xx": "ok"},
+			[]string{"metric", "_xx", "k8s_pod"},
+			[]string{"cpu", "ok", "api-7f9c"},
+		},
+		{
+			"colliding names are numbered",
+			labelFilter{},
+			map[string]string{"te.am": "payments", "te-am": "search"},
+			[]string{"metric", "te_am", "te_am_1"},
+			[]string{"cpu", "search", "payments"},
+		},
+		{
+			"a valid name keeps its place",
+			labelFilter{},
+			map[string]string{"te-am": "search", "te_am": "payments", "te_am_1": "checkout"},
+			[]string{"metric", "te_am", "te_am_1", "te_am_2"},
+			[]string{"cpu", "payments", "checkout", "search"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, values := tt.filter.labels("cpu", tt.source)
+			if !reflect.DeepEqual(names, tt.wantNames) || !reflect.DeepEqual(values, tt.wantValues) {
+				t.Fatalf("labels() = %v, %v, want %v, %v", names, values, tt.wantNames, tt.wantValues)
+			}
+		})
+	}
+}
+
+type fakeOpenCounter []storage.OpenAnomalyCount
+
+func (c fakeOpenCounter) CountOpenAnomalies(ctx context.Context) ([]storage.OpenAnomalyCount, error) {
+	return c, nil
+}
+
+// gather scrapes e and returns each sample as "name{labels} value", sorted.
+func gather(t *testing.T, e *SeriesExporter) []string {
+	t.Helper()
+	families, err := e.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []string
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			samples = append(samples, sample(mf.GetName(), m))
+		}
+	}
+	sort.Strings(samples)
+	return samples
+}
+
+func sample(name string, m *dto.Metric) string {
+	var labels []string
+	for _, l := range m.GetLabel() {
+		labels = append(labels, l.GetName()+"="+l.GetValue())
+	}
+	return fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), m.GetGauge().GetValue())
+}
+
+func TestSeriesExporterCollect(t *testing.T) {
+	e := NewSeriesExporter(ExportConfig{MaxSeries: 2, ExcludeLabels: []string{"pod"}}, fakeOpenCounter{
+		{MetricID: 1, MetricName: "latency", Labels: map[string]string{"team": "payments"}, Severity: "high", Status: "open", Count: 2},
+		{MetricID: 1, MetricName: "latency", Labels: map[string]string{"team": "payments"}, Severity: "low", Status: "acknowledged", Count: 1},
+		{MetricID: 2, MetricName: "errors", Labels: map[string]string{"team": "search"}, Severity: "critical", Status: "open", Count: 1},
+		{MetricID: 3, MetricName: "cpu", Labels: map[string]string{"team": "search"}, Severity: "low", Status: "open", Count: 4},
+	})
+	e.Update(SeriesUpdate{MetricID: 1, MetricName: "latency", Labels: map[string]string{"team": "payments", "pod": "api-1"}, Score: 0.9,
+		Expected: &ExpectedValue{Value: 120, Lower: 80, Upper: 160}})
+	e.Update(SeriesUpdate{MetricID: 2, MetricName: "errors", Labels: map[string]string{"team": "search"}, Score: 0.4})
+	e.Update(SeriesUpdate{MetricID: 3, MetricName: "cpu", Labels: map[string]string{"team": "search"}, Score: 0.1})
+
+	// The lowest score is over the cap, and open anomaly counts stop at
+	// the same number of series.
+	want := []string{
+		"argus_anomaly_score{metric=errors,team=search} 0.4",
+		"argus_anomaly_score{metric=latency,team=payments} 0.9",
+		"argus_expected_lower{metric=latency,team=payments} 80",
+		"argus_expected_upper{metric=latency,team=payments} 160",
+		"argus_expected_value{metric=latency,team=payments} 120",
+		"argus_open_anomalies{metric=errors,severity=critical,status=open,team=search} 1",
+		"argus_open_anomalies{metric=latency,severity=high,status=open,team=payments} 2",
+		"argus_open_anomalies{metric=latency,severity=low,status=acknowledged,team=payments} 1",
+	}
+	if got := gather(t, e); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scrape =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if got := testGauge(ExportedSeriesDropped); got != 1 {
+		t.Fatalf("argus_export_series_dropped = %v, want 1", got)
+	}
+
+	// Series the detector stops updating expire after the TTL.
+	e.Configure(ExportConfig{MaxSeries: 2, TTL: time.Minute}, nil)
+	e.mu.Lock()
+	e.series[1].updatedAt = time.Now().Add(-2 * time.Minute)
+	e.mu.Unlock()
+	want = []string{
+		"argus_anomaly_score{metric=cpu,team=search} 0.1",
+		"argus_anomaly_score{metric=errors,team=search} 0.4",
+	}
+	if got := gather(t, e); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scrape after the TTL =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if got := testGauge(ExportedSeries); got != 2 {
+		t.Fatalf("argus_export_series = %v, want 2", got)
+	}
+}
+
+func testGauge(g prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		return -1
+	}
+	return m.GetGauge().GetValue()
+}
@@ -0,0 +1,177 @@
+// Package telemetry holds the Prometheus metrics Argus exports about itself
+// on /metrics. Components update the package-level collectors directly.
+package telemetry
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every Argus metric plus the Go runtime and process
+// collectors. It is separate from the global registry so libraries can't add
+// to /metrics by accident.
+var Registry = prometheus.NewRegistry()
+
+var (
+	CollectorCycleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "argus_collector_cycle_duration_seconds",
+		Help:    "Time taken by one metric collection cycle.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+	SeriesCollected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argus_collector_series_collected_total",
+		Help: "Series read from Prometheus and stored.",
+	})
+	SeriesFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argus_collector_series_failed_total",
+		Help: "Metrics the collector failed to query or store.",
+	})
+
+	IngestBufferedPoints = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argus_ingest_buffered_points",
+		Help: "Collected points waiting to be written to storage.",
+	})
+	IngestFlushDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "argus_ingest_flush_duration_seconds",
+		Help:    "Time taken to write one batch of buffered points.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+	IngestFlushErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argus_ingest_flush_errors_total",
+		Help: "Failed writes of buffered points.",
+	})
+	IngestDroppedPoints = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argus_ingest_dropped_points_total",
+		Help: "Buffered points discarded because storage rejected the batch holding them.",
+	})
+	IngestStalls = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argus_ingest_stalls_total",
+		Help: "Times the collector waited because the write buffer was full.",
+	})
+
+	WorkerPaused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argus_worker_paused",
+		Help: "1 while a worker is paused because the database is unavailable.",
+	}, []string{"worker"})
+
+	DetectorCycleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "argus_detector_cycle_duration_seconds",
+		Help:    "Time taken by one anomaly detection cycle.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+	})
+	AnomaliesDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argus_anomalies_detected_total",
+		Help: "Anomalies stored by the detector, by severity.",
+	}, []string{"severity"})
+
+	PrometheusQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "argus_prometheus_request_duration_seconds",
+		Help:    "Latency of requests to the Prometheus API.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+	PrometheusQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argus_prometheus_request_errors_total",
+		Help: "Failed requests to the Prometheus API.",
+	}, []string{"endpoint"})
+
+	MLRequestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "argus_ml_request_duration_seconds",
+		Help:    "Latency of detection requests to the ML service.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+	MLRequestErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argus_ml_request_errors_total",
+		Help: "Failed detection requests to the ML service.",
+	})
+
+	NotificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argus_notifications_sent_total",
+		Help: "Notifications delivered, by receiver.",
+	}, []string{"receiver"})
+	NotificationsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argus_notifications_failed_total",
+		Help: "Failed delivery attempts, by receiver.",
+	}, []string{"receiver"})
+	NotificationsDead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argus_notifications_dead_total",
+		Help: "Notifications that ran out of attempts, by receiver.",
+	}, []string{"receiver"})
+	NotificationsSuppressed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argus_notifications_suppressed_total",
+		Help: "Alerts held back by rate limiting and folded into digests, by receiver.",
+	}, []string{"receiver"})
+
+	WebSocketClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argus_websocket_clients",
+		Help: "Connected WebSocket clients.",
+	})
+	WebSocketDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argus_websocket_dropped_messages_total",
+		Help: "Messages not delivered to a WebSocket or SSE client because it was too slow.",
+	})
+	SlowClientsDisconnected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argus_websocket_slow_clients_disconnected_total",
+		Help: "WebSocket and SSE clients disconnected for falling behind.",
+	})
+	HubEventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argus_hub_events_dropped_total",
+		Help: "Events dropped because the hub was too far behind, by stage (publish, relay).",
+	}, []string{"stage"})
+	WebSocketReplayed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argus_websocket_replayed_events_total",
+		Help: "Missed events sent to WebSocket clients that resumed after reconnecting.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		CollectorCycleDuration, SeriesCollected, SeriesFailed,
+		IngestBufferedPoints, IngestFlushDuration, IngestFlushErrors, IngestDroppedPoints, IngestStalls,
+		WorkerPaused,
+		DetectorCycleDuration, AnomaliesDetected,
+		PrometheusQueryDuration, PrometheusQueryErrors,
+		MLRequestDuration, MLRequestErrors,
+		NotificationsSent, NotificationsFailed, NotificationsDead, NotificationsSuppressed,
+		WebSocketClients, WebSocketDropped, WebSocketReplayed,
+		SlowClientsDisconnected, HubEventsDropped,
+	)
+}
+
+// RegisterDBStats exports connection pool statistics read from stats on
+// every scrape.
+func RegisterDBStats(stats func() sql.DBStats) {
+	gauge := func(name, help string, value func(sql.DBStats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help},
+			func() float64 { return value(stats()) })
+	}
+	counter := func(name, help string, value func(sql.DBStats) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help},
+			func() float64 { return value(stats()) })
+	}
+
+	Registry.MustRegister(
+		gauge("argus_db_open_connections", "Open database connections.",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }),
+		gauge("argus_db_in_use_connections", "Database connections in use.",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }),
+		gauge("argus_db_idle_connections", "Idle database connections.",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }),
+		gauge("argus_db_max_open_connections", "Maximum open database connections.",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }),
+		counter("argus_db_wait_count_total", "Connections waited for.",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }),
+		counter("argus_db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }),
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
+)
+
+// Time allowed for writing what is still buffered on shutdown.
+const finalFlushTimeout = 10 * time.Second
+
+type BufferConfig struct {
+	// BatchSize is the most points written at once; a full batch is
+	// flushed without waiting for the interval. Default 5000.
+	BatchSize int
+	// FlushInterval is the longest a point waits to be written. Default 5s.
+	FlushInterval time.Duration
+	// MaxPending is how many unwritten points are held before Add blocks.
+	// Default 100000.
+	MaxPending int
+}
+
+// MetricBuffer batches collected points in memory and writes them to
+// storage by size or time. When storage falls behind or is down, Add blocks
+// once MaxPending points are waiting, which slows the collector down instead
+// of growing memory without bound.
+type MetricBuffer struct {
+	db   storage.Store
+	cfg  BufferConfig
+	gate *dbGate
+
+	flushMu sync.Mutex // serializes Flush
+	mu      sync.Mutex
+	points  []storage.MetricDataPoint
+	writing int           // points taken by a Flush but not yet written
+	written chan struct{} // closed and replaced whenever a batch is written
+	full    chan struct{}
+}
+
+func NewMetricBuffer(db storage.Store, cfg BufferConfig) *MetricBuffer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 5000
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = 100000
+	}
+	cfg.MaxPending = max(cfg.MaxPending, cfg.BatchSize)
+	return &MetricBuffer{
+		db:      db,
+		cfg:     cfg,
+		gate:    newDBGate("Write buffer", db),
+		written: make(chan struct{}),
+		full:    make(chan struct{}, 1),
+	}
+}
+
+// Add buffers points, waiting for room while the buffer is full. It returns
+// ctx.Err() if ctx ends first; the points are not buffered then.
+func (b *MetricBuffer) Add(ctx context.Context, points []storage.MetricDataPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	for {
+		b.mu.Lock()
+		pending := len(b.points) + b.writing
+		// An oversized call is let in on its own rather than blocking forever.
+		if pending == 0 || pending+len(points) <= b.cfg.MaxPending {
+			b.points = append(b.points, points...)
+			buffered := len(b.points)
+			telemetry.IngestBufferedPoints.Set(float64(buffered + b.writing))
+			b.mu.Unlock()
+
+			if buffered >= b.cfg.BatchSize {
+				b.requestFlush()
+			}
+			return nil
+		}
+		written := b.written
+		b.mu.Unlock()
+
+		telemetry.IngestStalls.Inc()
+		b.requestFlush()
+		select {
+		case <-written:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Pending returns how many points are waiting to be written.
+func (b *MetricBuffer) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.points) + b.writing
+}
+
+func (b *MetricBuffer) requestFlush() {
+	select {
+	case b.full <- struct{}{}:
+	default:
+	}
+}
+
+// Flush writes everything buffered so far in batches of BatchSize. When the
+// database is unreachable, or ctx ends, the unwritten points stay buffered
+// for the next attempt; storage ignores points it already has, so retrying a partly
+// written batch is safe. A batch the database rejects would fail the same
+// way every time and hold up the points behind it, so it is dropped and
+// counted, and Flush carries on with the rest and returns the error.
+func (b *MetricBuffer) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	points := b.points
+	b.points = nil
+	b.writing = len(points)
+	b.mu.Unlock()
+
+	var dropped int
+	var rejected error
+	for len(points) > 0 {
+		n := min(len(points), b.cfg.BatchSize)
+		start := time.Now()
+		err := b.db.InsertMetricData(ctx, points[:n])
+		telemetry.IngestFlushDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			telemetry.IngestFlushErrors.Inc()
+		}
+
+		b.mu.Lock()
+		if err != nil && (storage.IsUnavailable(err) || ctx.Err() != nil) {
+			// Put the unwritten points back ahead of anything added since.
+			b.points = append(points[:len(points):len(points)], b.points...)
+			b.writing = 0
+			telemetry.IngestBufferedPoints.Set(float64(len(b.points)))
+			b.mu.Unlock()
+			return err
+		}
+		if err != nil {
+			telemetry.IngestDroppedPoints.Add(float64(n))
+			dropped += n
+			if rejected == nil {
+				rejected = err
+			}
+		}
+		points = points[n:]
+		b.writing = len(points)
+		telemetry.IngestBufferedPoints.Set(float64(len(b.points) + b.writing))
+		close(b.written)
+		b.written = make(chan struct{})
+		b.mu.Unlock()
+	}
+	if dropped > 0 {
+		return fmt.Errorf("dropped %d points storage rejected: %w", dropped, rejected)
+	}
+	return nil
+}
+
+// Run flushes the buffer every FlushInterval, and sooner when a full batch
+// is waiting, until ctx ends. It then writes what is left and returns.
+func (b *MetricBuffer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	log.Printf("📥 Write buffer started (batch: %d, interval: %v, max pending: %d)",
+		b.cfg.BatchSize, b.cfg.FlushInterval, b.cfg.MaxPending)
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+			defer cancel()
+			if err := b.Flush(flushCtx); err != nil {
+				log.Printf("❌ Failed to write buffered points on shutdown: %v", err)
+			}
+			log.Println("🛑 Write buffer stopped")
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+
+		if b.gate.paused.Load() && !b.gate.ready(ctx) {
+			continue
+		}
+		if err := b.Flush(ctx); err != nil && ctx.Err() == nil && !b.gate.unavailable(ctx, err) {
+			log.Printf("❌ Failed to write buffered points: %v", err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package worker
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/storage/embedded"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
+)
+
+func openStore(t testing.TB) *embedded.Store {
+	t.Helper()
+	db, err := embedded.Open(t.TempDir(), embedded.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testPoints(t testing.TB, db storage.Store, n int) []storage.MetricDataPoint {
+	t.Helper()
+	m, _, err := db.CreateMetric(context.Background(), "http_requests_total", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	points := make([]storage.MetricDataPoint, n)
+	for i := range points {
+		points[i] = storage.MetricDataPoint{MetricID: m.ID, Timestamp: start.Add(time.Duration(i) * time.Second), Value: float64(i)}
+	}
+	return points
+}
+
+func storedPoints(t *testing.T, db storage.Store) int {
+	t.Helper()
+	data, err := db.GetMetricData(context.Background(), 1, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(data)
+}
+
+func TestMetricBufferFlush(t *testing.T) {
+	db := openStore(t)
+	points := testPoints(t, db, 25)
+	b := NewMetricBuffer(db, BufferConfig{BatchSize: 10})
+	ctx := context.Background()
+
+	if err := b.Add(ctx, points[:20]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(ctx, points[15:]); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Pending(); got != 30 {
+		t.Fatalf("Pending() = %d, want 30", got)
+	}
+	if err := b.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Pending(); got != 0 {
+		t.Fatalf("Pending() after Flush() = %d, want 0", got)
+	}
+	// The five points added twice are stored once.
+	if got := storedPoints(t, db); got != 25 {
+		t.Fatalf("stored %d points, want 25", got)
+	}
+}
+
+func TestMetricBufferAddWaitsForRoom(t *testing.T) {
+	db := openStore(t)
+	points := testPoints(t, db, 15)
+	b := NewMetricBuffer(db, BufferConfig{BatchSize: 10, MaxPending: 10})
+	ctx := context.Background()
+
+	if err := b.Add(ctx, points[:10]); err != nil {
+		t.Fatal(err)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := b.Add(timeout, points[10:]); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Add() to a full buffer = %v, want context.DeadlineExceeded", err)
+	}
+	if got := b.Pending(); got != 10 {
+		t.Fatalf("Pending() = %d, want 10; points from a cancelled Add() must not be buffered", got)
+	}
+
+	added := make(chan error, 1)
+	go func() { added <- b.Add(ctx, points[10:]) }()
+	if err := b.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Add() still waiting after Flush() made room")
+	}
+	if got := b.Pending(); got != 5 {
+		t.Fatalf("Pending() = %d, want 5", got)
+	}
+}
+
+func TestMetricBufferRun(t *testing.T) {
+	db := openStore(t)
+	points := testPoints(t, db, 15)
+	b := NewMetricBuffer(db, BufferConfig{BatchSize: 10, FlushInterval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+
+	// A full batch is written without waiting for the interval.
+	if err := b.Add(ctx, points[:10]); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Pending() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("full batch not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// What is left is written on shutdown.
+	if err := b.Add(ctx, points[10:]); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	<-done
+	if got := storedPoints(t, db); got != 15 {
+		t.Fatalf("stored %d points, want 15", got)
+	}
+}
+
+// flakyStore fails InsertMetricData with errs, one per call, then writes
+// through to the embedded store.
+type flakyStore struct {
+	storage.Store
+	errs []error
+}
+
+func (s *flakyStore) InsertMetricData(ctx context.Context, points []storage.MetricDataPoint) error {
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	return s.Store.InsertMetricData(ctx, points)
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestMetricBufferFlushErrors(t *testing.T) {
+	rejected := errors.New(`pq: value out of range for type double precision`)
+
+	tests := []struct {
+		name        string
+		errs        []error
+		wantErr     error
+		wantStored  int
+		wantPending int
+		wantDropped int
+	}{
+		{"database down", []error{driver.ErrBadConn}, driver.ErrBadConn, 0, 25, 0},
+		{"database lost mid flush", []error{nil, syscall.ECONNRESET}, syscall.ECONNRESET, 10, 15, 0},
+		{"batch rejected", []error{rejected}, rejected, 15, 0, 10},
+		{"every batch rejected", []error{rejected, rejected, rejected}, rejected, 0, 0, 25},
+		{"rejected then down", []error{rejected, driver.ErrBadConn}, driver.ErrBadConn, 0, 15, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openStore(t)
+			points := testPoints(t, db, 25)
+			b := NewMetricBuffer(&flakyStore{Store: db, errs: tt.errs}, BufferConfig{BatchSize: 10})
+			ctx := context.Background()
+			if err := b.Add(ctx, points); err != nil {
+				t.Fatal(err)
+			}
+
+			dropped := counterValue(t, telemetry.IngestDroppedPoints)
+			if err := b.Flush(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Flush() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := storedPoints(t, db); got != tt.wantStored {
+				t.Errorf("stored %d points, want %d", got, tt.wantStored)
+			}
+			if got := b.Pending(); got != tt.wantPending {
+				t.Errorf("Pending() = %d, want %d", got, tt.wantPending)
+			}
+			if got := counterValue(t, telemetry.IngestDroppedPoints) - dropped; got != float64(tt.wantDropped) {
+				t.Errorf("dropped points counter rose by %v, want %d", got, tt.wantDropped)
+			}
+
+			// Whatever was kept is written once the database is back.
+			if err := b.Flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if got := storedPoints(t, db); got != 25-tt.wantDropped {
+				t.Errorf("stored %d points after recovering, want %d", got, 25-tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestMetricBufferFlushCancelled(t *testing.T) {
+	db := openStore(t)
+	points := testPoints(t, db, 25)
+	b := NewMetricBuffer(&flakyStore{Store: db, errs: []error{context.Canceled}}, BufferConfig{BatchSize: 10})
+	if err := b.Add(context.Background(), points); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Flush(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Flush() error = %v, want context.Canceled", err)
+	}
+	if got := b.Pending(); got != 25 {
+		t.Fatalf("Pending() = %d, want 25; a cancelled write must not drop points", got)
+	}
+}
+
+// BenchmarkBufferFlush measures buffering and writing one collector cycle's
+// worth of points to the embedded store.
+func BenchmarkBufferFlush(b *testing.B) {
+	for _, batch := range []int{500, 5000} {
+		b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+			const perCycle = 10000
+			db := openStore(b)
+			m, _, err := db.CreateMetric(context.Background(), "http_requests_total", nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			buf := NewMetricBuffer(db, BufferConfig{BatchSize: batch, MaxPending: perCycle})
+			ctx := context.Background()
+			start := time.Now()
+			points := make([]storage.MetricDataPoint, perCycle)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := range points {
+					points[j] = storage.MetricDataPoint{MetricID: m.ID, Timestamp: start.Add(time.Duration(i*perCycle+j) * time.Millisecond), Value: float64(j)}
+				}
+				if err := buf.Add(ctx, points); err != nil {
+					b.Fatal(err)
+				}
+				if err := buf.Flush(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*perCycle)/b.Elapsed().Seconds(), "points/s")
+		})
+	}
+}
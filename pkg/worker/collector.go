@@ -8,19 +8,35 @@ import (
 
 	"github.com/mjrtuhin/argus/pkg/prometheus"
 	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
 )
 
+// collectorMetricLimit caps how many metrics are collected. For now only
+// the first few are, to avoid overwhelming the system.
+const collectorMetricLimit = 5
+
 type MetricCollector struct {
 	promClient *prometheus.Client
-	db         *storage.DB
+	db         storage.Store
+	buffer     *MetricBuffer
+	events     Events
+	shard      interface{ Owns(key string) bool }
 	interval   time.Duration
+	gate       *dbGate
+	cycles     cycleTracker
 }
 
-func NewMetricCollector(promClient *prometheus.Client, db *storage.DB, interval time.Duration) *MetricCollector {
+// NewMetricCollector creates a collector for the metrics shard owns; pass
+// cluster.Standalone to collect everything.
+func NewMetricCollector(promClient *prometheus.Client, db storage.Store, buffer *MetricBuffer, events Events, shard interface{ Owns(key string) bool }, interval time.Duration) *MetricCollector {
 	return &MetricCollector{
 		promClient: promClient,
 		db:         db,
+		buffer:     buffer,
+		events:     events,
+		shard:      shard,
 		interval:   interval,
+		gate:       newDBGate("Metric collector", db),
 	}
 }
 
@@ -45,6 +61,15 @@ func (mc *MetricCollector) Start(ctx context.Context) {
 }
 
 func (mc *MetricCollector) collectMetrics(ctx context.Context) {
+	start := time.Now()
+	defer func() {
+		telemetry.CollectorCycleDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	if !mc.gate.ready(ctx) {
+		return
+	}
+
 	// Fetch list of all metrics
 	metricNames, err := mc.promClient.ListMetrics(ctx)
 	if err != nil {
@@ -52,41 +77,79 @@ func (mc *MetricCollector) collectMetrics(ctx context.Context) {
 		return
 	}
 
-	log.Printf("📊 Found %d metrics, collecting first 5...", len(metricNames))
-
-	// For now, collect first 5 metrics to avoid overwhelming the system
-	collected := 0
-	for _, metricName := range metricNames {
-		if collected >= 5 {
-			break
-		}
-
-		if err := mc.collectSingleMetric(ctx, metricName); err != nil {
+	owned := ownedMetrics(metricNames, mc.shard)
+	log.Printf("📊 Found %d metrics, collecting %d of the first %d...", len(metricNames), len(owned), collectorMetricLimit)
+
+	collected, failed, points := 0, 0, 0
+	for _, metricName := range owned {
+		n, err := mc.collectSingleMetric(ctx, metricName)
+		if err != nil {
+			if mc.gate.unavailable(ctx, err) {
+				return
+			}
+			telemetry.SeriesFailed.Inc()
 			log.Printf("❌ Failed to collect %s: %v", metricName, err)
+			failed++
 			continue
 		}
 
 		collected++
+		points += n
 	}
 
+	mc.cycles.succeeded(start)
+	if mc.events != nil {
+		mc.events.BroadcastCycle("collector", time.Since(start), map[string]int{
+			"metrics": collected,
+			"failed":  failed,
+			"points":  points,
+		})
+	}
 	log.Printf("✅ Collected %d metrics at %s", collected, time.Now().Format("15:04:05"))
 }
 
-func (mc *MetricCollector) collectSingleMetric(ctx context.Context, metricName string) error {
+// ownedMetrics returns the metrics among the first collectorMetricLimit of
+// names that shard owns. The limit applies before sharding, so a cluster
+// collects the same metrics as a single replica, each on one replica.
+// Prometheus lists names sorted, so every replica sees the same first ones.
+func ownedMetrics(names []string, shard interface{ Owns(key string) bool }) []string {
+	if len(names) > collectorMetricLimit {
+		names = names[:collectorMetricLimit]
+	}
+	var owned []string
+	for _, name := range names {
+		if shard.Owns(name) {
+			owned = append(owned, name)
+		}
+	}
+	return owned
+}
+
+// Status reports the collector's last completed cycle.
+func (mc *MetricCollector) Status() CycleStatus {
+	return mc.cycles.get(mc.gate)
+}
+
+// collectSingleMetric buffers the current values of a metric and returns
+// how many there were.
+func (mc *MetricCollector) collectSingleMetric(ctx context.Context, metricName string) (int, error) {
 	// Query the metric from Prometheus
 	result, err := mc.promClient.Query(ctx, metricName)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if len(result.Data.Result) == 0 {
-		return nil
+		return 0, nil
 	}
 
 	// Create or get metric in database
-	metric, err := mc.db.CreateMetric(ctx, metricName)
+	metric, created, err := mc.db.CreateMetric(ctx, metricName, commonLabels(result))
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if created && mc.events != nil {
+		mc.events.BroadcastMetricDiscovered(*metric)
 	}
 
 	// Collect all data points from this metric
@@ -115,10 +178,40 @@ func (mc *MetricCollector) collectSingleMetric(ctx context.Context, metricName s
 		})
 	}
 
-	// Store data points
+	// Hand data points to the write buffer
 	if len(points) > 0 {
-		return mc.db.InsertMetricData(ctx, points)
+		if err := mc.buffer.Add(ctx, points); err != nil {
+			return 0, err
+		}
+		telemetry.SeriesCollected.Add(float64(len(points)))
 	}
 
-	return nil
+	return len(points), nil
+}
+
+// commonLabels returns the labels every series of a result shares, such as
+// the team that owns the metric. Argus keeps one metric per name, so labels
+// that differ between series are left out.
+func commonLabels(result *prometheus.QueryResult) map[string]string {
+	var labels map[string]string
+	for i, r := range result.Data.Result {
+		if i == 0 {
+			labels = make(map[string]string, len(r.Metric))
+			for k, v := range r.Metric {
+				if k != "__name__" {
+					labels[k] = v
+				}
+			}
+			continue
+		}
+		for k, v := range labels {
+			if r.Metric[k] != v {
+				delete(labels, k)
+			}
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
 }
@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/mjrtuhin/argus/pkg/prometheus"
+)
+
+func TestCommonLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		want   map[string]string
+	}{
+		{"no series", `{"data":{"result":[]}}`, nil},
+		{"one series", `{"data":{"result":[
+			{"metric":{"__name__":"up","job":"api","team":"payments"}}]}}`,
+			map[string]string{"job": "api", "team": "payments"}},
+		{"shared team", `{"data":{"result":[
+			{"metric":{"__name__":"up","instance":"a:9090","team":"payments"}},
+			{"metric":{"__name__":"up","instance":"b:9090","team":"payments"}}]}}`,
+			map[string]string{"team": "payments"}},
+		{"teams differ", `{"data":{"result":[
+			{"metric":{"__name__":"up","team":"payments"}},
+			{"metric":{"__name__":"up","team":"search"}}]}}`,
+			nil},
+		{"label missing on one series", `{"data":{"result":[
+			{"metric":{"job":"api","team":"payments"}},
+			{"metric":{"job":"api"}}]}}`,
+			map[string]string{"job": "api"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result prometheus.QueryResult
+			if err := json.Unmarshal([]byte(tt.result), &result); err != nil {
+				t.Fatal(err)
+			}
+			if got := commonLabels(&result); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("commonLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// shardOf owns the metrics mapped to one replica.
+type shardOf struct {
+	owners  map[string]string
+	replica string
+}
+
+func (s shardOf) Owns(key string) bool { return s.owners[key] == s.replica }
+
+func TestOwnedMetrics(t *testing.T) {
+	names := []string{"cpu", "disk", "errors", "latency", "memory", "requests", "threads"}
+	owners := map[string]string{
+		"cpu": "a", "disk": "b", "errors": "a", "latency": "c",
+		"memory": "b", "requests": "a", "threads": "c",
+	}
+
+	// Between them the replicas collect the first five, once each.
+	var got []string
+	for _, replica := range []string{"a", "b", "c"} {
+		got = append(got, ownedMetrics(names, shardOf{owners, replica})...)
+	}
+	sort.Strings(got)
+	if want := names[:collectorMetricLimit]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ownedMetrics() across replicas = %v, want %v", got, want)
+	}
+
+	if got := ownedMetrics(names[:2], shardOf{owners, "a"}); !reflect.DeepEqual(got, []string{"cpu"}) {
+		t.Fatalf("ownedMetrics() of two = %v, want [cpu]", got)
+	}
+}
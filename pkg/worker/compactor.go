@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// Buckets this far back are recompacted every cycle to pick up late points.
+const compactionLookback = 2 * time.Hour
+
+// RollupCompactor maintains the 5m and 1h rollup tables and applies
+// retention when TimescaleDB continuous aggregates aren't available. With
+// them, it only expires what their policies don't.
+type RollupCompactor struct {
+	db       *storage.DB
+	interval time.Duration
+}
+
+func NewRollupCompactor(db *storage.DB, interval time.Duration) *RollupCompactor {
+	return &RollupCompactor{
+		db:       db,
+		interval: interval,
+	}
+}
+
+func (rc *RollupCompactor) Start(ctx context.Context) {
+	ticker := time.NewTicker(rc.interval)
+	defer ticker.Stop()
+
+	log.Printf("🗜️  Rollup compactor started (interval: %v, rollups: %s)", rc.interval, rc.db.RollupMode())
+
+	// The first run backfills all raw data still retained.
+	var since time.Time
+	if keep := rc.db.RawRetention(); keep > 0 {
+		since = time.Now().Add(-keep)
+	}
+	rc.compact(ctx, since)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("🛑 Rollup compactor stopped")
+			return
+		case <-ticker.C:
+			rc.compact(ctx, time.Now().Add(-compactionLookback))
+		}
+	}
+}
+
+func (rc *RollupCompactor) compact(ctx context.Context, since time.Time) {
+	if rc.db.RollupMode() == storage.RollupsCompaction {
+		if err := rc.db.CompactRollups(ctx, since); err != nil {
+			log.Printf("❌ Failed to compact rollups: %v", err)
+			// Expiring raw data that wasn't rolled up would lose it.
+			return
+		}
+	}
+	if err := rc.db.ApplyRetention(ctx); err != nil {
+		log.Printf("❌ Failed to apply retention: %v", err)
+	}
+}
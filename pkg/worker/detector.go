@@ -8,22 +8,34 @@ import (
 	"github.com/mjrtuhin/argus/pkg/alerting"
 	"github.com/mjrtuhin/argus/pkg/detector"
 	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
 )
 
+// Incidents with no new anomaly for this long are closed automatically.
+const incidentIdleTimeout = time.Hour
+
+// Number of trailing data points included in alerts for context.
+const alertRecentPoints = 10
+
 type AnomalyDetector struct {
-	mlClient    *detector.MLClient
-	db          *storage.DB
-	slackSender *alerting.SlackSender
-	hub         interface{ BroadcastAnomaly(storage.Anomaly, string) }
-	interval    time.Duration
+	mlClient *detector.MLClient
+	db       storage.Store
+	notifier *alerting.Notifier
+	hub      Events
+	shard    interface{ Owns(key string) bool }
+	interval time.Duration
+	gate     *dbGate
+	cycles   cycleTracker
 }
-func NewAnomalyDetector(mlClient *detector.MLClient, db *storage.DB, slackSender *alerting.SlackSender, hub interface{ BroadcastAnomaly(storage.Anomaly, string) }, interval time.Duration) *AnomalyDetector {
+func NewAnomalyDetector(mlClient *detector.MLClient, db storage.Store, notifier *alerting.Notifier, hub Events, shard interface{ Owns(key string) bool }, interval time.Duration) *AnomalyDetector {
 	return &AnomalyDetector{
-		mlClient:    mlClient,
-		db:          db,
-		slackSender: slackSender,
-		hub:         hub,
-		interval:    interval,
+		mlClient: mlClient,
+		db:       db,
+		notifier: notifier,
+		hub:      hub,
+		shard:    shard,
+		interval: interval,
+		gate:     newDBGate("Anomaly detector", db),
 	}
 }
 func (ad *AnomalyDetector) Start(ctx context.Context) {
@@ -47,32 +59,79 @@ func (ad *AnomalyDetector) Start(ctx context.Context) {
 }
 
 func (ad *AnomalyDetector) runDetection(ctx context.Context) {
+	start := time.Now()
+	defer func() {
+		telemetry.DetectorCycleDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	if !ad.gate.ready(ctx) {
+		return
+	}
+
 	// Get all active metrics
 	metrics, err := ad.db.GetMetrics(ctx)
 	if err != nil {
-		log.Printf("❌ Failed to get metrics: %v", err)
+		if !ad.gate.unavailable(ctx, err) {
+			log.Printf("❌ Failed to get metrics: %v", err)
+		}
 		return
 	}
 
 	log.Printf("🔍 Running detection on %d metrics...", len(metrics))
 
-	detectedCount := 0
+	detectedCount, checked, failed := 0, 0, 0
+	byID := make(map[int]storage.Metric, len(metrics))
 	for _, metric := range metrics {
+		byID[metric.ID] = metric
+		if !ad.shard.Owns(metric.MetricName) {
+			continue
+		}
 		count, err := ad.detectForMetric(ctx, metric)
+		if ad.gate.unavailable(ctx, err) {
+			return
+		}
 		if err != nil {
 			log.Printf("❌ Detection failed for metric %s: %v", metric.MetricName, err)
+			failed++
 			continue
 		}
+		checked++
 		detectedCount += count
 	}
 
+	resolved, err := ad.db.ResolveIdleIncidents(ctx, incidentIdleTimeout)
+	if ad.gate.unavailable(ctx, err) {
+		return
+	} else if err != nil {
+		log.Printf("⚠️  Failed to resolve idle incidents: %v", err)
+	} else if len(resolved) > 0 {
+		log.Printf("✅ Resolved %d idle incidents", len(resolved))
+	}
+
+	ad.cycles.succeeded(start)
+	if ad.hub != nil {
+		for _, incident := range resolved {
+			ad.hub.BroadcastIncident(incident, byID[incident.MetricID])
+		}
+		ad.hub.BroadcastCycle("detector", time.Since(start), map[string]int{
+			"metrics":            checked,
+			"failed":             failed,
+			"anomalies":          detectedCount,
+			"incidents_resolved": len(resolved),
+		})
+	}
 	log.Printf("✅ Detection complete: %d new anomalies found at %s",
 		detectedCount, time.Now().Format("15:04:05"))
 }
 
+// Status reports the detector's last completed cycle.
+func (ad *AnomalyDetector) Status() CycleStatus {
+	return ad.cycles.get(ad.gate)
+}
+
 func (ad *AnomalyDetector) detectForMetric(ctx context.Context, metric storage.Metric) (int, error) {
-	// Get data from last 24 hours
-	since := time.Now().Add(-24 * time.Hour)
+	// Raw retention is never shorter than the window, so this is raw data
+	since := time.Now().Add(-storage.DetectionWindow)
 	points, err := ad.db.GetMetricData(ctx, metric.ID, since)
 	if err != nil {
 		return 0, err
@@ -102,8 +161,30 @@ func (ad *AnomalyDetector) detectForMetric(ctx context.Context, metric storage.M
 		return 0, err
 	}
 
+	if len(result.Expected) > 0 {
+		baseline := make([]storage.ExpectedPoint, len(result.Expected))
+		for i, e := range result.Expected {
+			baseline[i] = storage.ExpectedPoint{
+				MetricID:  metric.ID,
+				Timestamp: time.Unix(e.Timestamp, 0),
+				Value:     e.Value,
+				Lower:     e.Lower,
+				Upper:     e.Upper,
+			}
+		}
+		if err := ad.db.UpsertBaseline(ctx, metric.ID, baseline); err != nil {
+			log.Printf("⚠️  Failed to store baseline for %s: %v", metric.MetricName, err)
+		}
+	}
+
+	recent := points
+	if len(recent) > alertRecentPoints {
+		recent = recent[len(recent)-alertRecentPoints:]
+	}
+
 	// Store and alert on new anomalies
 	newAnomalies := 0
+	var topScore float64
 	for _, a := range result.Anomalies {
 		severity := classifySeverity(a.Score)
 
@@ -126,18 +207,46 @@ func (ad *AnomalyDetector) detectForMetric(ctx context.Context, metric storage.M
 		}
 
 		newAnomalies++
+		topScore = max(topScore, anomaly.AnomalyScore)
+		telemetry.AnomaliesDetected.WithLabelValues(anomaly.Severity).Inc()
+
+		incident, err := ad.db.AttachToIncident(ctx, anomaly)
+		if err != nil {
+			log.Printf("⚠️  Failed to attach anomaly %d to incident: %v", anomaly.ID, err)
+		}
 
 		// Send alert
-		if err := ad.slackSender.SendAlert(ctx, metric.MetricName, a.Value, a.Score, severity); err != nil {
+		alert := &alerting.Alert{
+			Anomaly:  *anomaly,
+			Metric:   metric,
+			Recent:   recent,
+			Incident: incident,
+		}
+		if err := ad.notifier.Notify(ctx, alert); err != nil {
 			log.Printf("⚠️  Failed to send alert: %v", err)
 		}
 
 		// Broadcast via WebSocket
 		if ad.hub != nil {
-			ad.hub.BroadcastAnomaly(*anomaly, metric.MetricName)
+			ad.hub.BroadcastAnomaly(*anomaly, metric)
+			if incident != nil {
+				ad.hub.BroadcastIncident(*incident, metric)
+			}
 		}
 	}
 
+	export := telemetry.SeriesUpdate{
+		MetricID:   metric.ID,
+		MetricName: metric.MetricName,
+		Labels:     metric.Labels,
+		Score:      topScore,
+	}
+	if n := len(result.Expected); n > 0 {
+		last := result.Expected[n-1]
+		export.Expected = &telemetry.ExpectedValue{Value: last.Value, Lower: last.Lower, Upper: last.Upper}
+	}
+	telemetry.Exports.Update(export)
+
 	return newAnomalies, nil
 }
 
@@ -0,0 +1,16 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+)
+
+// Events receives what the workers report to live clients. *api.Hub
+// implements it; workers given nil report nothing.
+type Events interface {
+	BroadcastAnomaly(anomaly storage.Anomaly, metric storage.Metric)
+	BroadcastIncident(incident storage.Incident, metric storage.Metric)
+	BroadcastMetricDiscovered(metric storage.Metric)
+	BroadcastCycle(worker string, duration time.Duration, stats map[string]int)
+}
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/mjrtuhin/argus/pkg/storage"
+	"github.com/mjrtuhin/argus/pkg/telemetry"
+)
+
+const pingTimeout = 5 * time.Second
+
+// dbGate pauses a worker while the database is unreachable. It logs once
+// when the worker pauses and once when it resumes, instead of an error per
+// metric on every cycle. A gate belongs to a single worker goroutine;
+// paused may be read from others.
+type dbGate struct {
+	worker string
+	db     interface{ Ping(context.Context) error }
+	paused atomic.Bool
+}
+
+func newDBGate(worker string, db interface{ Ping(context.Context) error }) *dbGate {
+	telemetry.WorkerPaused.WithLabelValues(worker).Set(0)
+	return &dbGate{worker: worker, db: db}
+}
+
+// ready pings the database and reports whether the worker should run.
+func (g *dbGate) ready(ctx context.Context) bool {
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := g.db.Ping(pingCtx); err != nil {
+		if ctx.Err() == nil {
+			g.pause(err)
+		}
+		return false
+	}
+	if g.paused.Swap(false) {
+		telemetry.WorkerPaused.WithLabelValues(g.worker).Set(0)
+		log.Printf("▶️  %s resumed, database is available again", g.worker)
+	}
+	return true
+}
+
+// unavailable reports whether err means the database went away, pausing
+// the worker if so. The caller should end its cycle when it returns true.
+// Connection errors can also come from Prometheus or the ML service, so the
+// database is pinged to tell them apart.
+func (g *dbGate) unavailable(ctx context.Context, err error) bool {
+	return storage.IsUnavailable(err) && !g.ready(ctx)
+}
+
+func (g *dbGate) pause(err error) {
+	if g.paused.Swap(true) {
+		return
+	}
+	telemetry.WorkerPaused.WithLabelValues(g.worker).Set(1)
+	log.Printf("⏸️  %s paused, database unavailable: %v", g.worker, err)
+}
@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// CycleStatus describes how a worker is doing, for the status API.
+type CycleStatus struct {
+	// LastSuccess is when the last complete cycle finished; zero until one
+	// has.
+	LastSuccess  time.Time
+	LastDuration time.Duration
+	// Paused is true while the worker waits for the database.
+	Paused bool
+}
+
+type cycleTracker struct {
+	mu     sync.Mutex
+	status CycleStatus
+}
+
+func (t *cycleTracker) succeeded(start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.LastSuccess = time.Now()
+	t.status.LastDuration = t.status.LastSuccess.Sub(start)
+}
+
+func (t *cycleTracker) get(gate *dbGate) CycleStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.status
+	s.Paused = gate.paused.Load()
+	return s
+}
@@ -8,6 +8,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/mjrtuhin/argus/pkg/cluster"
 	"github.com/mjrtuhin/argus/pkg/prometheus"
 	"github.com/mjrtuhin/argus/pkg/storage"
 	"github.com/mjrtuhin/argus/pkg/worker"
@@ -23,11 +24,17 @@ func main() {
 	defer db.Close()
 
 	promClient := prometheus.NewClient("http://localhost:9090")
-	collector := worker.NewMetricCollector(promClient, db, 60*time.Second)
+	buffer := worker.NewMetricBuffer(db, worker.BufferConfig{})
+	collector := worker.NewMetricCollector(promClient, db, buffer, nil, cluster.Standalone, 60*time.Second)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	flushed := make(chan struct{})
+	go func() {
+		buffer.Run(ctx)
+		close(flushed)
+	}()
 	go collector.Start(ctx)
 
 	log.Println("📊 Collecting metrics every 60 seconds... Press Ctrl+C to stop")
@@ -36,5 +43,7 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
+	cancel()
+	<-flushed
 	log.Println("🛑 Stopped")
 }